@@ -0,0 +1,78 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+
+	"visualmath/internal/handlers"
+	"visualmath/internal/pii"
+)
+
+// runCommand выполняет административную команду и возвращает код выхода
+func runCommand(db *sql.DB, name string, args []string) int {
+	switch name {
+	case "rollover":
+		return rolloverCommand(db, args)
+	default:
+		fmt.Fprintf(os.Stderr, "Неизвестная команда %q. Доступные команды: rollover, pii-keygen\n", name)
+		return 2
+	}
+}
+
+// rolloverCommand переносит курс на новый семестр, старый курс уходит в архив
+func rolloverCommand(db *sql.DB, args []string) int {
+	fs := flag.NewFlagSet("rollover", flag.ContinueOnError)
+	courseID := fs.Int("course", 0, "ID курса, который переносится")
+	name := fs.String("name", "", "название нового курса (по умолчанию - старое с семестром)")
+	term := fs.String("term", "", "новый семестр, например \"Весна 2027\"")
+	offset := fs.Int("offset-days", 0, "на сколько дней сдвинуть сроки заданий")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *courseID == 0 {
+		fmt.Fprintln(os.Stderr, "Укажите курс: -course ID")
+		return 2
+	}
+
+	id, err := handlers.RolloverCourse(db, *courseID, handlers.RolloverOptions{
+		Name:       *name,
+		Term:       *term,
+		OffsetDays: *offset,
+	})
+	if err == sql.ErrNoRows {
+		fmt.Fprintf(os.Stderr, "Курс %d не найден\n", *courseID)
+		return 1
+	} else if err != nil {
+		fmt.Fprintln(os.Stderr, "Ошибка переноса:", err)
+		return 1
+	}
+
+	fmt.Printf("✅ Курс %d перенесен в архив, новый курс: %d\n", *courseID, id)
+	return 0
+}
+
+// keygenCommand печатает новый ключ шифрования персональных данных. Для смены
+// ключа его ставят первым в PII_KEYS или файле ключей, прежний оставляют ниже:
+// при следующем запуске сервер перешифрует им все учетные записи.
+func keygenCommand(args []string) int {
+	fs := flag.NewFlagSet("pii-keygen", flag.ContinueOnError)
+	id := fs.String("id", "", "идентификатор ключа, например 2027a")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *id == "" {
+		fmt.Fprintln(os.Stderr, "Укажите идентификатор ключа: -id 2027a")
+		return 2
+	}
+
+	key, err := pii.GenerateKey()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Ошибка генерации ключа:", err)
+		return 1
+	}
+	fmt.Printf("PII_KEYS / PII_INDEX_KEY: %s:%s\n", *id, key)
+	fmt.Printf("Файл ключей:              data %s %s\n", *id, key)
+	return 0
+}
@@ -4,12 +4,21 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"os"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/joho/godotenv"
 
+	"visualmath/internal/auth"
 	"visualmath/internal/handlers"
+	"visualmath/internal/ldap"
+	"visualmath/internal/live"
+	"visualmath/internal/loginlimit"
+	"visualmath/internal/mail"
+	"visualmath/internal/pii"
+	"visualmath/internal/privacy"
+	"visualmath/internal/storage"
 )
 
 func main() {
@@ -17,9 +26,99 @@ func main() {
 
 	godotenv.Load()
 
+	// Новый ключ шифрования выдается без открытия базы: server pii-keygen -id 2027a
+	if len(os.Args) > 1 && os.Args[1] == "pii-keygen" {
+		os.Exit(keygenCommand(os.Args[2:]))
+	}
+
+	// Ключи шифрования ФИО, логинов и почты: PII_KEY_FILE или PII_KEYS и PII_INDEX_KEY
+	keys, err := pii.FromEnv()
+	if err != nil {
+		log.Fatalf("Неверная настройка ключей шифрования: %v", err)
+	}
+	db := storage.InitSQLite(keys)
+	defer db.Close()
+
+	// Административные команды, например: server rollover -course 5 -term "Весна 2027"
+	if len(os.Args) > 1 {
+		code := runCommand(db, os.Args[1], os.Args[2:])
+		db.Close()
+		os.Exit(code)
+	}
+
+	// Подписывает JWT и токены отметки по QR-коду: с пустым ключом их может подделать кто угодно
+	jwtSecret := os.Getenv("JWT_SECRET")
+	if jwtSecret == "" {
+		log.Fatal("Не задан JWT_SECRET")
+	}
+
+	// Вход через каталог университета, если задан LDAP_URL
+	directory, err := ldap.FromEnv()
+	if err != nil {
+		log.Fatalf("Неверная настройка LDAP: %v", err)
+	}
+
+	// Ограничение попыток входа; LOGIN_LIMIT_STORE=sqlite сохраняет их между перезапусками
+	limitConfig, err := loginlimit.ConfigFromEnv()
+	if err != nil {
+		log.Fatalf("Неверная настройка ограничения входа: %v", err)
+	}
+	var limitStore loginlimit.Store = loginlimit.NewMemoryStore(limitConfig.Retention())
+	if os.Getenv("LOGIN_LIMIT_STORE") == "sqlite" {
+		limitStore = loginlimit.NewSQLStore(db, limitConfig.Retention())
+	}
+	limiter := loginlimit.New(limitConfig, limitStore)
+	mailer := mail.FromEnv()
+
+	// Обезличивание неактивных учетных записей: RETENTION_INACTIVE_YEARS, 0 - отключено
+	retention, err := privacy.RetentionFromEnv()
+	if err != nil {
+		log.Fatalf("Неверная настройка срока хранения: %v", err)
+	}
+	go privacy.RunRetention(db, retention)
+
+	authHandler := &handlers.AuthHandler{
+		DB:        db,
+		JWTSecret: jwtSecret,
+		LDAP:      directory,
+		Limiter:   limiter,
+		Mailer:    mailer,
+	}
+
 	// Создаем обработчик модулей
-	moduleHandler := &handlers.ModuleHandler{}
-	lectureHandler := &handlers.LectureHandler{}
+	moduleHandler := &handlers.ModuleHandler{DB: db}
+	lectureHandler := &handlers.LectureHandler{DB: db}
+	gradebookHandler := &handlers.GradebookHandler{DB: db}
+	assessmentHandler := &handlers.AssessmentHandler{DB: db}
+	reviewHandler := &handlers.ReviewHandler{DB: db}
+	skillHandler := &handlers.SkillHandler{DB: db}
+	gradingHandler := &handlers.GradingHandler{DB: db}
+	assignmentHandler := &handlers.AssignmentHandler{DB: db}
+	groupHandler := &handlers.GroupHandler{DB: db}
+	courseHandler := &handlers.CourseHandler{DB: db}
+	enrollmentHandler := &handlers.EnrollmentHandler{DB: db, BaseURL: getEnv("BASE_URL", "http://localhost:8080")}
+	passwordHandler := &handlers.PasswordHandler{DB: db}
+	tokenHandler := &handlers.TokenHandler{DB: db}
+	profileHandler := &handlers.ProfileHandler{
+		DB:      db,
+		Mailer:  mailer,
+		BaseURL: getEnv("BASE_URL", "http://localhost:8080"),
+	}
+	adminHandler := &handlers.AdminHandler{
+		DB:        db,
+		Mailer:    mailer,
+		BaseURL:   getEnv("BASE_URL", "http://localhost:8080"),
+		Limiter:   limiter,
+		Retention: retention,
+	}
+	liveHub := live.NewHub()
+	liveHandler := &handlers.LiveHandler{DB: db, Hub: liveHub}
+	attendanceHandler := &handlers.AttendanceHandler{
+		DB:      db,
+		Hub:     liveHub,
+		Secret:  jwtSecret,
+		BaseURL: getEnv("BASE_URL", "http://localhost:8080"),
+	}
 	r := chi.NewRouter()
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
@@ -31,55 +130,223 @@ func main() {
 	r.Get("/", homeHandler)
 	r.Get("/login", loginPageHandler)
 	r.Get("/register", registerPageHandler)
-	r.Get("/dashboard", dashboardHandler)
 	r.Get("/test", testHandler)
+	r.Get("/api/groups", groupHandler.ListGroups) // нужен странице регистрации
+
+	// Страницы требуют входа: без cookie сессии перенаправляют на /login
+	r.Group(func(r chi.Router) {
+		r.Use(auth.RequireSession(db))
+		r.Get("/dashboard", dashboardHandler)
+
+		// Маршруты модулей
+		r.Get("/modules", moduleHandler.ListModules)              // Список модулей
+		r.Get("/modules/create", moduleHandler.CreateModulePage)  // Страница создания
+		r.Get("/modules/view/{id}", moduleHandler.ViewModulePage) // Просмотр модуля
+		r.Get("/modules/edit/{id}", moduleHandler.EditModulePage) // Редактирование модуля
+		r.Get("/modules/analysis/{id}", assessmentHandler.ItemAnalysisPage)
+		r.Get("/review", reviewHandler.ReviewPage) // Повторение вопросов
+		r.Get("/skills/heatmap", skillHandler.SkillHeatmapPage)
+		r.Get("/grading", gradingHandler.GradingPage) // Проверка развернутых ответов
 
-	// Маршруты модулей
-	r.Get("/modules", moduleHandler.ListModules)              // Список модулей
-	r.Get("/modules/create", moduleHandler.CreateModulePage)  // Страница создания
-	r.Get("/modules/view/{id}", moduleHandler.ViewModulePage) // Просмотр модуля
-	r.Get("/modules/edit/{id}", moduleHandler.EditModulePage) // Редактирование модуля
-
-	// API endpoints для модулей
-	r.Get("/api/modules/list", moduleHandler.ListModulesAPI)  // API: список модулей
-	r.Post("/api/modules", moduleHandler.CreateModule)        // API: создание модуля
-	r.Get("/api/modules/{id}", moduleHandler.GetModule)       // API: получить модуль
-	r.Put("/api/modules/{id}", moduleHandler.UpdateModule)    // API: обновить модуль
-	r.Delete("/api/modules/{id}", moduleHandler.DeleteModule) // API: удалить модуль
-
-	// Маршруты лекций
-	r.Get("/lectures", lecturesPageHandler)              // страница списка лекций
-	r.Get("/lectures/create", createLecturePageHandler)  // страница создания лекции
-	r.Get("/lectures/edit/{id}", editLecturePageHandler) // страница редактирования лекции
-	r.Get("/lectures/view/{id}", viewLecturePageHandler) // страница просмотра лекции
-
-	// API endpoints для лекций
-	r.Get("/api/lectures", lectureHandler.ListLectures)
-	r.Post("/api/lectures", lectureHandler.CreateLecture)
-	r.Get("/api/lectures/{id}", lectureHandler.GetLecture)
-	r.Put("/api/lectures/{id}", lectureHandler.UpdateLecture)
-	r.Delete("/api/lectures/{id}", lectureHandler.DeleteLecture)
-	r.Get("/api/modules/available", lectureHandler.GetAvailableModules)
-	r.Post("/api/lectures/start", lectureHandler.StartLecture)
-	r.Post("/api/lectures/complete", lectureHandler.CompleteModule)
-	r.Get("/api/lectures/progress", lectureHandler.GetStudentProgress)
-
-	// API заглушки
-	r.Post("/api/register", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		fmt.Fprintf(w, `{"message": "Register endpoint"}`)
+		// Маршруты лекций
+		r.Get("/lectures", lecturesPageHandler)              // страница списка лекций
+		r.Get("/lectures/create", createLecturePageHandler)  // страница создания лекции
+		r.Get("/lectures/edit/{id}", editLecturePageHandler) // страница редактирования лекции
+		r.Get("/lectures/view/{id}", viewLecturePageHandler) // страница просмотра лекции
+
+		r.Get("/attendance/checkin", attendanceHandler.CheckInPage)
+		r.Get("/enroll", enrollmentHandler.EnrollPage) // ссылка-приглашение на курс
 	})
 
-	r.Post("/api/login", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		fmt.Fprintf(w, `{"message": "Login endpoint"}`)
+	r.Group(func(r chi.Router) {
+		r.Use(auth.AuthMiddleware(db, jwtSecret))
+		editContent := auth.RequirePermission(db, auth.PermModuleEdit) // ассистенты контент не меняют
+
+		// API endpoints для модулей
+		r.Get("/api/modules/list", moduleHandler.ListModulesAPI)                    // API: список модулей
+		r.With(editContent).Post("/api/modules", moduleHandler.CreateModule)        // API: создание модуля
+		r.Get("/api/modules/{id}", moduleHandler.GetModule)                         // API: получить модуль
+		r.With(editContent).Put("/api/modules/{id}", moduleHandler.UpdateModule)    // API: обновить модуль
+		r.With(editContent).Delete("/api/modules/{id}", moduleHandler.DeleteModule) // API: удалить модуль
+
+		// API endpoints для лекций
+		r.Get("/api/lectures", lectureHandler.ListLectures)
+		r.With(editContent).Post("/api/lectures", lectureHandler.CreateLecture)
+		r.Get("/api/lectures/{id}", lectureHandler.GetLecture)
+		r.With(editContent).Put("/api/lectures/{id}", lectureHandler.UpdateLecture)
+		r.With(editContent).Delete("/api/lectures/{id}", lectureHandler.DeleteLecture)
+		r.Get("/api/modules/available", lectureHandler.GetAvailableModules)
+		r.Post("/api/lectures/start", lectureHandler.StartLecture)
+		r.Post("/api/lectures/complete", lectureHandler.CompleteModule)
+		r.Get("/api/lectures/progress", lectureHandler.GetStudentProgress)
+
+		// Ответы на вопросники и тесты, анализ заданий
+		r.Post("/api/modules/{id}/attempts", assessmentHandler.SubmitAttempt)
+		r.Get("/api/modules/{id}/analysis", assessmentHandler.ItemAnalysis)
+
+		// Адаптивные тесты: калибровка 2PL-модели и прохождение по одному вопросу
+		r.Post("/api/modules/{id}/calibrate", assessmentHandler.Calibrate)
+		r.Post("/api/modules/{id}/adaptive", assessmentHandler.StartAdaptive)
+		r.Get("/api/attempts/{attemptID}", assessmentHandler.GetAttempt)
+		r.Post("/api/attempts/{attemptID}/answer", assessmentHandler.AnswerAdaptive)
+
+		// Интервальное повторение вопросов (SM-2)
+		r.Get("/api/review/due", reviewHandler.DueReviews)
+		r.Post("/api/review/{id}/grade", reviewHandler.GradeReview)
+
+		// Навыки и владение ими (BKT)
+		r.Get("/api/skills", skillHandler.ListSkills)
+		r.Post("/api/skills", skillHandler.CreateSkill)
+		r.Put("/api/skills/{id}", skillHandler.UpdateSkill)
+		r.Get("/api/skills/heatmap", skillHandler.SkillHeatmap)
+		r.Get("/api/skills/mine", skillHandler.MySkills)
+
+		// Ручная проверка развернутых ответов
+		r.Get("/api/grading/queue", gradingHandler.GradingQueue)
+		r.Get("/api/grading/mine", gradingHandler.MyFreeResponses)
+		r.Get("/api/grading/responses/{id}", gradingHandler.GetFreeResponse)
+		r.Put("/api/grading/responses/{id}", gradingHandler.GradeFreeResponse)
+		r.Post("/api/grading/release", gradingHandler.ReleaseGrades)
+
+		// Курсы: преподаватели и порядок лекций
+		r.Get("/api/courses", courseHandler.ListCourses)
+		r.Post("/api/courses", courseHandler.CreateCourse)
+		r.Get("/api/courses/{id}", courseHandler.GetCourse)
+		r.Put("/api/courses/{id}", courseHandler.UpdateCourse)
+		r.Delete("/api/courses/{id}", courseHandler.DeleteCourse)
+		r.With(editContent).Put("/api/courses/{id}/lectures", courseHandler.ReorderLectures)
+		r.Post("/api/courses/{id}/rollover", courseHandler.Rollover) // перенос на новый семестр
+
+		// Запись на курсы
+		r.Post("/api/enroll", enrollmentHandler.Enroll)
+		r.Get("/api/enrollments/mine", enrollmentHandler.MyEnrollments)
+		r.Get("/api/courses/{id}/invites", enrollmentHandler.ListInvites)
+		r.Post("/api/courses/{id}/invites", enrollmentHandler.CreateInvite)
+		r.Delete("/api/courses/{id}/invites/{inviteID}", enrollmentHandler.RevokeInvite)
+		r.Get("/api/courses/{id}/enrollments", enrollmentHandler.ListEnrollments)
+		r.Post("/api/courses/{id}/enrollments/bulk", enrollmentHandler.BulkEnroll)
+		r.Post("/api/courses/{id}/enrollments/{studentID}/approve", enrollmentHandler.ApproveEnrollment)
+		r.Delete("/api/courses/{id}/enrollments/{studentID}", enrollmentHandler.RemoveEnrollment)
+
+		// Учебные группы и их состав
+		r.Post("/api/groups", groupHandler.CreateGroup)
+		r.Get("/api/groups/{id}", groupHandler.GetGroup)
+		r.Put("/api/groups/{id}", groupHandler.UpdateGroup)
+		r.Delete("/api/groups/{id}", groupHandler.DeleteGroup)
+		r.Post("/api/groups/{id}/members", groupHandler.AddGroupMembers)
+		r.Delete("/api/groups/{id}/members/{userID}", groupHandler.RemoveGroupMember)
+		r.Post("/api/groups/{id}/merge", groupHandler.MergeGroup)
+
+		// Домашние задания
+		r.Get("/api/assignments/mine", assignmentHandler.MyAssignments)
+		r.Get("/api/assignments", assignmentHandler.ListAssignments)
+		r.Post("/api/assignments", assignmentHandler.CreateAssignment)
+		r.Get("/api/assignments/{id}", assignmentHandler.GetAssignment)
+		r.Put("/api/assignments/{id}", assignmentHandler.UpdateAssignment)
+		r.Delete("/api/assignments/{id}", assignmentHandler.DeleteAssignment)
+
+		// Журнал оценок (?format=csv|xlsx для выгрузки)
+		r.Get("/api/gradebook", gradebookHandler.GetGradebook)
+
+		// Живые лекции: преподаватель ведет, студенты следуют за ним
+		r.Post("/api/live/sessions", liveHandler.StartSession)
+		r.Post("/api/live/join", liveHandler.JoinSession)
+		r.Get("/api/live/sessions/{id}", liveHandler.GetSession)
+		r.Get("/api/live/sessions/{id}/events", liveHandler.Events)
+		r.Post("/api/live/sessions/{id}/module", liveHandler.SetModule)
+		r.Post("/api/live/sessions/{id}/end", liveHandler.EndSession)
+
+		// Опросы по вопросам из модулей question
+		r.Get("/api/live/sessions/{id}/polls", liveHandler.ListPolls)
+		r.Post("/api/live/sessions/{id}/polls", liveHandler.OpenPoll)
+		r.Post("/api/polls/{pollID}/answer", liveHandler.AnswerPoll)
+		r.Post("/api/polls/{pollID}/close", liveHandler.ClosePoll)
+		r.Post("/api/polls/{pollID}/reveal", liveHandler.RevealPoll)
+		r.Get("/api/polls/{pollID}/results", liveHandler.GetPollResults)
+
+		// Посещаемость: отметка по QR-коду и отчеты
+		r.Get("/api/live/sessions/{id}/checkin", attendanceHandler.CheckInToken)
+		r.Get("/api/live/sessions/{id}/checkin/qr", attendanceHandler.CheckInQR)
+		r.Get("/api/live/sessions/{id}/attendance", attendanceHandler.SessionReport)
+		r.Post("/api/attendance/checkin", attendanceHandler.CheckIn)
+		r.Get("/api/attendance/report", attendanceHandler.GroupReport)
+
+		// Администрирование пользователей
+		r.Get("/api/admin/users", adminHandler.ListUsers)
+		r.Post("/api/admin/users/import", adminHandler.ImportUsers)
+		r.Get("/api/admin/users/{id}", adminHandler.GetUser)
+		r.Put("/api/admin/users/{id}/type", adminHandler.SetUserType)
+		r.Post("/api/admin/users/{id}/deactivate", adminHandler.DeactivateUser)
+		r.Post("/api/admin/users/{id}/activate", adminHandler.ActivateUser)
+		r.Post("/api/admin/users/{id}/reset-password", adminHandler.ResetPassword)
+		r.Post("/api/admin/users/{id}/merge", adminHandler.MergeUsers)
+		r.Get("/api/admin/audit", adminHandler.ListAudit)
+		r.Get("/api/admin/users/{id}/scopes", adminHandler.ListScopes)
+		r.Post("/api/admin/users/{id}/scopes", adminHandler.AddScope)
+		r.Delete("/api/admin/users/{id}/scopes/{scopeID}", adminHandler.RemoveScope)
+		r.Get("/api/admin/permissions", adminHandler.ListPermissions)
+		r.Put("/api/admin/permissions/{role}", adminHandler.SetRolePermissions)
+		r.Get("/api/admin/users/{id}/tokens", adminHandler.ListUserTokens)
+		r.Delete("/api/admin/users/{id}/tokens/{tokenID}", adminHandler.RevokeUserToken)
+
+		// Персональные токены API для скриптов
+		r.Get("/api/tokens", tokenHandler.ListTokens)
+		r.Post("/api/tokens", tokenHandler.CreateToken)
+		r.Delete("/api/tokens/{id}", tokenHandler.RevokeToken)
+
+		// Двухфакторная аутентификация
+		r.Get("/api/user/profile", profileHandler.GetProfile)
+		r.Put("/api/user/profile", profileHandler.UpdateProfile)
+		r.Post("/api/user/password", profileHandler.ChangePassword)
+		r.Post("/api/user/avatar", profileHandler.UploadAvatar)
+		r.Delete("/api/user/avatar", profileHandler.DeleteAvatar)
+		r.Get("/api/users/{id}/avatar", profileHandler.GetAvatar)
+		r.Get("/api/user/export", profileHandler.ExportData)
+		r.Get("/api/user/deletion", profileHandler.GetDeletionRequest)
+		r.Post("/api/user/deletion", profileHandler.RequestDeletion)
+		r.Delete("/api/user/deletion", profileHandler.CancelDeletion)
+		r.Get("/api/user/sessions", profileHandler.ListSessions)
+		r.Delete("/api/user/sessions/{id}", profileHandler.RevokeSession)
+		r.Get("/api/user/2fa", authHandler.TwoFactorStatus)
+		r.Post("/api/user/2fa/setup", authHandler.SetupTwoFactor)
+		r.Get("/api/user/2fa/qr", authHandler.TwoFactorQR)
+		r.Post("/api/user/2fa/enable", authHandler.EnableTwoFactor)
+		r.Post("/api/user/2fa/disable", authHandler.DisableTwoFactor)
+		r.Post("/api/user/2fa/recovery-codes", authHandler.RegenerateRecoveryCodes)
+		r.Get("/api/admin/2fa/roles", adminHandler.GetTwoFactorRoles)
+		r.Put("/api/admin/2fa/roles", adminHandler.SetTwoFactorRoles)
+		r.Post("/api/admin/users/{id}/2fa/reset", adminHandler.ResetTwoFactor)
+		r.Get("/api/admin/login-events", adminHandler.ListLoginEvents)
+		r.Post("/api/admin/users/{id}/unlock", adminHandler.UnlockLogin)
+		r.Get("/api/admin/deletion-requests", adminHandler.ListDeletionRequests)
+		r.Post("/api/admin/deletion-requests/{id}/approve", adminHandler.ApproveDeletion)
+		r.Post("/api/admin/deletion-requests/{id}/reject", adminHandler.RejectDeletion)
+		r.Get("/api/admin/retention", adminHandler.RetentionStatus)
+		r.Post("/api/logout", authHandler.Logout)
 	})
+	r.Get("/set-password", passwordHandler.SetPasswordPage) // ссылка из письма-приглашения
+	r.Get("/verify-email", profileHandler.VerifyEmail)      // ссылка подтверждения нового адреса
+	r.Post("/api/password/set", passwordHandler.SetPassword)
+
+	r.Post("/api/register", authHandler.Register) // попытки считаются тем же ограничителем, что и вход
+	r.Post("/api/login", authHandler.Login)
+	r.Post("/api/login/2fa", authHandler.LoginSecondFactor)           // код после пароля
+	r.Post("/api/login/2fa/setup", authHandler.LoginSetupTwoFactor)   // обязательная для роли 2FA
+	r.Post("/api/login/2fa/enable", authHandler.LoginEnableTwoFactor) // ее подтверждение
 
 	port := "8080"
 	fmt.Printf("✅ Сервер запущен на http://localhost:%s\n", port)
 	log.Fatal(http.ListenAndServe(":"+port, r))
 }
 
+// getEnv возвращает значение переменной окружения или значение по умолчанию
+func getEnv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
 // homeHandler обрабатывает главную страницу
 func homeHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
@@ -208,9 +475,8 @@ func homeHandler(w http.ResponseWriter, r *http.Request) {
                 alert('✅ OAuth авторизация успешна! Пожалуйста, войдите снова для подтверждения.');
             }
             
-            // Проверяем, авторизован ли пользователь
-            const token = localStorage.getItem('token');
-            if (token) {
+            // Проверяем, авторизован ли пользователь (cookie vm_csrf ставится вместе с сессией)
+            if (document.cookie.split('; ').some(c => c.startsWith('vm_csrf='))) {
                 setTimeout(() => {
                     window.location.href = '/dashboard';
                 }, 1000);
@@ -220,7 +486,7 @@ func homeHandler(w http.ResponseWriter, r *http.Request) {
 </body>
 </html>`
 
-	fmt.Fprintf(w, html)
+	fmt.Fprint(w, html)
 }
 
 // loginPageHandler обрабатывает страницу входа
@@ -442,7 +708,8 @@ func loginPageHandler(w http.ResponseWriter, r *http.Request) {
             
             const formData = {
                 login: document.getElementById('login').value,
-                password: document.getElementById('password').value
+                password: document.getElementById('password').value,
+                session: 'cookie'
             };
             
             try {
@@ -454,20 +721,24 @@ func loginPageHandler(w http.ResponseWriter, r *http.Request) {
                     body: JSON.stringify(formData)
                 });
                 
-                const result = await response.json();
+                let result = await response.json();
                 
-                if (response.ok) {
+                if (response.ok && (result.two_factor_required || result.two_factor_setup_required)) {
+                    result = await secondFactor(result, messageDiv);
+                }
+                
+                if (response.ok && result.csrf_token) {
                     messageDiv.className = 'message success';
                     messageDiv.textContent = '✅ Вход выполнен успешно! Перенаправление...';
                     messageDiv.style.display = 'block';
                     
-                    // Сохраняем токен
-                    localStorage.setItem('token', result.token);
+                    // Сессия хранится в cookie, здесь только данные для интерфейса
+                    localStorage.removeItem('token');
                     localStorage.setItem('user', JSON.stringify(result.user));
                     
-                    // Перенаправляем на dashboard
+                    // Возвращаемся на страницу, с которой перенаправили на вход
                     setTimeout(() => {
-                        window.location.href = '/dashboard';
+                        window.location.href = nextPage();
                     }, 1500);
                     
                 } else {
@@ -482,17 +753,67 @@ func loginPageHandler(w http.ResponseWriter, r *http.Request) {
             }
         });
         
-        // Проверяем, авторизован ли пользователь
+        // Второй шаг входа: код из приложения-аутентификатора или его подключение,
+        // если для роли 2FA обязательна
+        async function secondFactor(result, messageDiv) {
+            const post = async (url, body) => {
+                const response = await fetch(url, {
+                    method: 'POST',
+                    headers: { 'Content-Type': 'application/json' },
+                    body: JSON.stringify(body)
+                });
+                if (!response.ok) {
+                    return { message: (await response.text()).trim() };
+                }
+                return response.json();
+            };
+            
+            if (result.two_factor_required) {
+                const code = prompt('Код из приложения-аутентификатора или код восстановления:');
+                if (!code) {
+                    return { message: 'Вход отменен' };
+                }
+                return post('/api/login/2fa', { mfa_token: result.mfa_token, code: code });
+            }
+            
+            const setup = await post('/api/login/2fa/setup', { mfa_token: result.mfa_token });
+            if (!setup.secret) {
+                return setup;
+            }
+            messageDiv.className = 'message';
+            messageDiv.innerHTML = '<p>' + result.message + '. Отсканируйте QR-код или введите ключ ' +
+                setup.secret + '</p>' + setup.qr_svg;
+            messageDiv.style.display = 'block';
+            await new Promise(resolve => setTimeout(resolve, 100));
+            
+            const code = prompt('Введите код из приложения, чтобы подтвердить подключение:');
+            if (!code) {
+                return { message: 'Вход отменен' };
+            }
+            const session = await post('/api/login/2fa/enable', { mfa_token: result.mfa_token, code: code });
+            if (session.recovery_codes) {
+                alert('Сохраните коды восстановления, они показываются один раз:\n\n' +
+                    session.recovery_codes.join('\n'));
+            }
+            return session;
+        }
+        
+        // Страница после входа: ?next= принимается только как путь этого сайта
+        function nextPage() {
+            const next = new URLSearchParams(window.location.search).get('next') || '';
+            return next.startsWith('/') && !next.startsWith('//') ? next : '/dashboard';
+        }
+        
+        // Проверяем, авторизован ли пользователь (cookie vm_csrf ставится вместе с сессией)
         window.addEventListener('DOMContentLoaded', function() {
-            const token = localStorage.getItem('token');
-            if (token) {
+            if (document.cookie.split('; ').some(c => c.startsWith('vm_csrf='))) {
                 const messageDiv = document.getElementById('message');
                 messageDiv.className = 'message success';
                 messageDiv.textContent = '✅ Вы уже авторизованы. Перенаправление...';
                 messageDiv.style.display = 'block';
                 
                 setTimeout(() => {
-                    window.location.href = '/dashboard';
+                    window.location.href = nextPage();
                 }, 1000);
             }
         });
@@ -500,7 +821,7 @@ func loginPageHandler(w http.ResponseWriter, r *http.Request) {
 </body>
 </html>`
 
-	fmt.Fprintf(w, html)
+	fmt.Fprint(w, html)
 }
 
 // registerPageHandler обрабатывает страницу регистрации
@@ -734,9 +1055,11 @@ func registerPageHandler(w http.ResponseWriter, r *http.Request) {
             </div>
             
             <div class="form-group">
-                <label for="group_number">Номер группы *</label>
-                <input type="text" id="group_number" name="group_number" placeholder="Например: ИУ6-32Б" required>
-                <div class="info-text">Укажите вашу учебную группу</div>
+                <label for="group_id">Группа *</label>
+                <select id="group_id" name="group_id" required>
+                    <option value="">Выберите группу</option>
+                </select>
+                <div class="info-text">Если вашей группы нет в списке, обратитесь к преподавателю</div>
             </div>
             
             <button type="submit" class="submit-btn">Создать аккаунт</button>
@@ -749,6 +1072,14 @@ func registerPageHandler(w http.ResponseWriter, r *http.Request) {
     </div>
 
     <script>
+        // Список групп для выбора
+        fetch('/api/groups')
+            .then(response => response.json())
+            .then(groups => {
+                const select = document.getElementById('group_id');
+                groups.forEach(g => select.add(new Option(g.faculty ? g.name + ' (' + g.faculty + ')' : g.name, g.id)));
+            });
+
         // Обработка формы регистрации
         document.getElementById('registerForm').addEventListener('submit', async function(e) {
             e.preventDefault();
@@ -762,7 +1093,7 @@ func registerPageHandler(w http.ResponseWriter, r *http.Request) {
                 full_name: document.getElementById('full_name').value,
                 email: document.getElementById('email').value,
                 user_type: document.getElementById('user_type').value,
-                group_number: document.getElementById('group_number').value
+                group_id: parseInt(document.getElementById('group_id').value)
             };
             
             // Проверка пароля
@@ -812,7 +1143,7 @@ func registerPageHandler(w http.ResponseWriter, r *http.Request) {
 </body>
 </html>`
 
-	fmt.Fprintf(w, html)
+	fmt.Fprint(w, html)
 }
 
 // dashboardHandler показывает личный кабинет
@@ -823,6 +1154,7 @@ func dashboardHandler(w http.ResponseWriter, r *http.Request) {
 <html>
 <head>
     <title>Личный кабинет - VisualMath</title>
+    <script src="/static/js/csrf.js"></script>
     <style>
         .dashboard-container {
             display: flex;
@@ -891,7 +1223,7 @@ func dashboardHandler(w http.ResponseWriter, r *http.Request) {
             <div class="user-info">
                 <h3>👤 Тестовый пользователь</h3>
                 <p>Тип: Преподаватель</p>
-                <a href="/" style="color: #e74c3c; margin-top: 15px; display: inline-block;">🚪 Выйти</a>
+                <a href="/" onclick="logout(event)" style="color: #e74c3c; margin-top: 15px; display: inline-block;">🚪 Выйти</a>
             </div>
             <div class="menu-section">
                 <h3>👨‍🏫 Преподаватель</h3>
@@ -901,6 +1233,8 @@ func dashboardHandler(w http.ResponseWriter, r *http.Request) {
                     <li><a href="#">🚀 Начать лекцию</a></li>
                     <li><a href="#">➕ Создать модуль</a></li>
                     <li><a href="#">➕ Создать лекцию</a></li>
+                    <li><a href="/skills/heatmap">🎯 Навыки группы</a></li>
+                    <li><a href="/grading">📝 Проверка работ</a></li>
                 </ul>
             </div>
         </aside>
@@ -910,12 +1244,104 @@ func dashboardHandler(w http.ResponseWriter, r *http.Request) {
                 <h1>👋 Добро пожаловать!</h1>
                 <p>Личный кабинет VisualMath. Выберите действие в меню слева.</p>
             </div>
+            <div class="welcome-card">
+                <h2>🔁 Повторение</h2>
+                <p id="reviewDue">Загрузка...</p>
+                <a href="/review">Перейти к повторению →</a>
+            </div>
+            <div class="welcome-card">
+                <h2>🎯 Слабые навыки</h2>
+                <ul id="weakSkills"><li>Загрузка...</li></ul>
+            </div>
+            <div class="welcome-card">
+                <h2>📅 Мои задания</h2>
+                <ul id="myAssignments"><li>Загрузка...</li></ul>
+            </div>
+            <div class="welcome-card">
+                <h2>🎓 Мои курсы</h2>
+                <ul id="myCourses"><li>Загрузка...</li></ul>
+                <a href="/enroll">Записаться по коду →</a>
+            </div>
         </main>
     </div>
+    <script>
+        function logout(event) {
+            event.preventDefault();
+            fetch('/api/logout', { method: 'POST' }).finally(() => {
+                localStorage.removeItem('token');
+                localStorage.removeItem('user');
+                window.location.href = '/login';
+            });
+        }
+
+        fetch('/api/review/due?limit=0')
+            .then(response => response.json())
+            .then(data => {
+                document.getElementById('reviewDue').textContent = data.due_today > 0
+                    ? 'Вопросов к повторению сегодня: ' + data.due_today
+                    : 'На сегодня повторять нечего';
+            })
+            .catch(() => { document.getElementById('reviewDue').textContent = 'Не удалось загрузить очередь'; });
+
+        function escapeHTML(s) {
+            const div = document.createElement('div');
+            div.textContent = s;
+            return div.innerHTML;
+        }
+
+        fetch('/api/skills/mine')
+            .then(response => response.json())
+            .then(skills => {
+                const list = document.getElementById('weakSkills');
+                if (skills.length === 0) {
+                    list.innerHTML = '<li>Все отработанные навыки освоены</li>';
+                    return;
+                }
+                list.innerHTML = skills.map(s =>
+                    '<li><b>' + escapeHTML(s.name) + '</b> - ' + Math.round(s.p_known * 100) + '%' +
+                    (s.modules.length ? ': ' + s.modules.map(m =>
+                        '<a href="/modules/view/' + m.id + '">' + escapeHTML(m.title) + '</a>').join(', ') : '') +
+                    '</li>').join('');
+            })
+            .catch(() => { document.getElementById('weakSkills').innerHTML = '<li>Не удалось загрузить навыки</li>'; });
+
+        const assignmentStatus = {
+            upcoming: 'скоро', open: 'открыто', overdue: 'просрочено', pending_review: 'на проверке',
+            completed: 'сдано', late: 'сдано с опозданием', missed: 'не сдано'
+        };
+        fetch('/api/assignments/mine')
+            .then(response => response.json())
+            .then(assignments => {
+                const list = document.getElementById('myAssignments');
+                if (assignments.length === 0) {
+                    list.innerHTML = '<li>Заданий нет</li>';
+                    return;
+                }
+                list.innerHTML = assignments.map(a =>
+                    '<li><b>' + escapeHTML(a.title) + '</b> (' + escapeHTML(a.target_title) + ') - до ' +
+                    new Date(a.due_at).toLocaleString('ru-RU') + ', ' + assignmentStatus[a.status] +
+                    (a.score !== undefined ? ', ' + Math.round(a.score) + '%' : '') + '</li>').join('');
+            })
+            .catch(() => { document.getElementById('myAssignments').innerHTML = '<li>Не удалось загрузить задания</li>'; });
+
+        fetch('/api/enrollments/mine')
+            .then(response => response.json())
+            .then(enrollments => {
+                const list = document.getElementById('myCourses');
+                if (enrollments.length === 0) {
+                    list.innerHTML = '<li>Вы не записаны ни на один курс</li>';
+                    return;
+                }
+                list.innerHTML = enrollments.map(e =>
+                    '<li><b>' + escapeHTML(e.course_name) + '</b>' +
+                    (e.status === 'pending' ? ' - ждет подтверждения' : '') + '</li>').join('');
+            })
+            .catch(() => { document.getElementById('myCourses').innerHTML = '<li>Не удалось загрузить курсы</li>'; });
+    </script>
 </body>
 </html>`
 
-	fmt.Fprintf(w, html)
+	fmt.Fprint(w, html)
 }
 
 // testHandler для проверки работы
@@ -930,7 +1356,7 @@ func testHandler(w http.ResponseWriter, r *http.Request) {
     <p><a href="/">Вернуться на главную</a></p>
 </body>
 </html>`
-	fmt.Fprintf(w, html)
+	fmt.Fprint(w, html)
 }
 
 // lecturesPageHandler показывает список лекций
@@ -941,6 +1367,7 @@ func lecturesPageHandler(w http.ResponseWriter, r *http.Request) {
 <html>
 <head>
     <title>Лекции - VisualMath</title>
+    <script src="/static/js/csrf.js"></script>
     <link rel="stylesheet" href="/static/css/style.css">
     <style>
         .lectures-container { max-width: 1200px; margin: 30px auto; padding: 0 20px; }
@@ -972,7 +1399,7 @@ func lecturesPageHandler(w http.ResponseWriter, r *http.Request) {
 </body>
 </html>`
 
-	fmt.Fprintf(w, html)
+	fmt.Fprint(w, html)
 }
 
 // createLecturePageHandler показывает страницу создания лекции
@@ -983,6 +1410,7 @@ func createLecturePageHandler(w http.ResponseWriter, r *http.Request) {
 <html>
 <head>
     <title>Создать лекцию - VisualMath</title>
+    <script src="/static/js/csrf.js"></script>
     <link rel="stylesheet" href="/static/css/style.css">
     <!-- MathJax для предпросмотра -->
     <script src="https://polyfill.io/v3/polyfill.min.js?features=es6"></script>
@@ -1287,13 +1715,6 @@ func createLecturePageHandler(w http.ResponseWriter, r *http.Request) {
                         <label for="lectureCourse">Предмет *</label>
                         <select id="lectureCourse" name="course" required>
                             <option value="">Выберите предмет</option>
-                            <option value="Математический анализ">Математический анализ</option>
-                            <option value="Линейная алгебра">Линейная алгебра</option>
-                            <option value="Дискретная математика">Дискретная математика</option>
-                            <option value="Теория вероятностей">Теория вероятностей</option>
-                            <option value="Дифференциальные уравнения">Дифференциальные уравнения</option>
-                            <option value="Экономика">Экономика</option>
-                            <option value="Физика">Физика</option>
                         </select>
                     </div>
                     
@@ -1360,6 +1781,14 @@ func createLecturePageHandler(w http.ResponseWriter, r *http.Request) {
     <script>
         let selectedModules = [];
         let allModules = [];
+
+        // Предметы из справочника курсов
+        fetch('/api/courses')
+            .then(response => response.json())
+            .then(courses => {
+                const select = document.getElementById('lectureCourse');
+                courses.forEach(c => select.add(new Option(c.name, c.id)));
+            });
         let currentFilter = 'all';
         
         // Загрузка доступных модулей
@@ -1554,7 +1983,7 @@ func createLecturePageHandler(w http.ResponseWriter, r *http.Request) {
             
             const lectureData = {
                 title: title,
-                course_name: course,
+                course_id: parseInt(course),
                 description: description,
                 module_ids: moduleIds,
                 allow_back: allowBack,
@@ -1604,7 +2033,7 @@ func createLecturePageHandler(w http.ResponseWriter, r *http.Request) {
 </body>
 </html>`
 
-	fmt.Fprintf(w, html)
+	fmt.Fprint(w, html)
 }
 
 // editLecturePageHandler показывает страницу редактирования лекции
@@ -1615,6 +2044,7 @@ func editLecturePageHandler(w http.ResponseWriter, r *http.Request) {
 <html>
 <head>
     <title>Редактировать лекцию - VisualMath</title>
+    <script src="/static/js/csrf.js"></script>
     <link rel="stylesheet" href="/static/css/style.css">
     <!-- MathJax для предпросмотра -->
     <script src="https://polyfill.io/v3/polyfill.min.js?features=es6"></script>
@@ -1681,9 +2111,7 @@ func editLecturePageHandler(w http.ResponseWriter, r *http.Request) {
                     <div class="form-group">
                         <label for="lectureCourse">Предмет *</label>
                         <select id="lectureCourse" name="course" required>
-                            <option value="Математический анализ" selected>Математический анализ</option>
-                            <option value="Линейная алгебра">Линейная алгебра</option>
-                            <option value="Дискретная математика">Дискретная математика</option>
+                            <option value="">Выберите предмет</option>
                         </select>
                     </div>
                     
@@ -1750,6 +2178,14 @@ func editLecturePageHandler(w http.ResponseWriter, r *http.Request) {
         ];
         
         let allModules = [];
+
+        // Предметы из справочника курсов
+        fetch('/api/courses')
+            .then(response => response.json())
+            .then(courses => {
+                const select = document.getElementById('lectureCourse');
+                courses.forEach(c => select.add(new Option(c.name, c.id)));
+            });
         let currentFilter = 'all';
         
         // Инициализация
@@ -1814,7 +2250,7 @@ func editLecturePageHandler(w http.ResponseWriter, r *http.Request) {
             
             const lectureData = {
                 title: title,
-                course_name: course,
+                course_id: parseInt(course),
                 description: description,
                 module_ids: moduleIds,
                 allow_back: allowBack,
@@ -1964,7 +2400,7 @@ func editLecturePageHandler(w http.ResponseWriter, r *http.Request) {
 </body>
 </html>`
 
-	fmt.Fprintf(w, html)
+	fmt.Fprint(w, html)
 }
 
 // viewLecturePageHandler показывает лекцию как единый документ для преподавателя
@@ -1975,6 +2411,7 @@ func viewLecturePageHandler(w http.ResponseWriter, r *http.Request) {
 <html>
 <head>
     <title>Лекция: Введение в матанализ - VisualMath</title>
+    <script src="/static/js/csrf.js"></script>
     <link rel="stylesheet" href="/static/css/style.css">
     <!-- MathJax для LaTeX -->
     <script src="https://polyfill.io/v3/polyfill.min.js?features=es6"></script>
@@ -2167,6 +2604,99 @@ func viewLecturePageHandler(w http.ResponseWriter, r *http.Request) {
         .nav-btn:hover {
             background: #e9ecef;
         }
+        .btn-live {
+            background: #e74c3c;
+            color: white;
+        }
+        .live-panel {
+            display: none;
+            margin-top: 20px;
+            padding: 15px 20px;
+            background: #fdf2f2;
+            border: 1px solid #f5c6cb;
+            border-radius: 8px;
+            align-items: center;
+            justify-content: center;
+            gap: 15px;
+            flex-wrap: wrap;
+        }
+        .live-panel.active {
+            display: flex;
+        }
+        .live-code {
+            font-family: monospace;
+            font-size: 24px;
+            font-weight: bold;
+            letter-spacing: 4px;
+            color: #c0392b;
+        }
+        .live-join input {
+            padding: 8px 12px;
+            border: 1px solid #ddd;
+            border-radius: 6px;
+            font-family: monospace;
+            text-transform: uppercase;
+            width: 120px;
+        }
+        .module-container.live-current {
+            border-left-color: #e74c3c;
+            background: #fffaf0;
+        }
+        .module-container.live-hidden {
+            display: none;
+        }
+        .poll-btn {
+            display: none;
+            margin-top: 10px;
+            padding: 6px 14px;
+            background: #f39c12;
+            color: white;
+            border: none;
+            border-radius: 6px;
+            cursor: pointer;
+        }
+        .live-teacher .poll-btn {
+            display: inline-block;
+        }
+        .poll-panel {
+            display: none;
+            position: fixed;
+            right: 20px;
+            bottom: 20px;
+            width: 360px;
+            background: white;
+            border: 1px solid #ddd;
+            border-radius: 10px;
+            box-shadow: 0 4px 20px rgba(0,0,0,0.15);
+            padding: 20px;
+            z-index: 100;
+        }
+        .poll-panel.active {
+            display: block;
+        }
+        .poll-answer {
+            display: block;
+            width: 100%;
+            margin: 8px 0;
+            padding: 10px;
+            text-align: left;
+            background: #f8f9fa;
+            border: 1px solid #ddd;
+            border-radius: 6px;
+            cursor: pointer;
+        }
+        .poll-bar {
+            margin: 6px 0;
+        }
+        .poll-bar-fill {
+            height: 18px;
+            background: #3498db;
+            border-radius: 4px;
+            transition: width 0.3s;
+        }
+        .poll-bar.correct .poll-bar-fill {
+            background: #2ecc71;
+        }
     </style>
 </head>
 <body style="background: #f8f9fa;">
@@ -2188,11 +2718,44 @@ func viewLecturePageHandler(w http.ResponseWriter, r *http.Request) {
                 <a href="/lectures/edit/1" class="btn btn-edit">✏️ Редактировать лекцию</a>
                 <a href="/lectures" class="btn btn-back">← Назад к списку</a>
                 <button class="btn btn-share" onclick="shareLecture()">🔗 Поделиться</button>
+                <button class="btn btn-live" onclick="startLiveSession()">🔴 Начать живую лекцию</button>
+            </div>
+            <div class="lecture-actions live-join">
+                <input type="text" id="liveCode" placeholder="Код" maxlength="6">
+                <button class="btn btn-edit" onclick="joinLiveSession()">Подключиться к живой лекции</button>
+            </div>
+
+            <!-- Панель живой лекции -->
+            <div class="live-panel" id="livePanel">
+                <span>🔴 Живая лекция</span>
+                <span class="live-code" id="liveCodeLabel"></span>
+                <span id="liveStatus"></span>
+                <span id="livePresence"></span>
+                <span id="liveControls" style="display: none;">
+                    <button class="btn btn-back" onclick="moveLiveModule(-1)">← Назад</button>
+                    <button class="btn btn-edit" onclick="moveLiveModule(1)">Вперед →</button>
+                    <button class="btn btn-share" onclick="toggleCheckInQR()">📋 QR для отметки</button>
+                    <button class="btn btn-live" onclick="endLiveSession()">Завершить</button>
+                </span>
+            </div>
+            <div class="live-panel" id="checkInPanel" style="flex-direction: column;">
+                <img id="checkInQR" alt="QR-код для отметки" width="280" height="280">
+                <span id="checkInCount">Отметились: 0</span>
+            </div>
+        </div>
+
+        <!-- Панель опроса -->
+        <div class="poll-panel" id="pollPanel">
+            <div class="question-text" id="pollQuestion"></div>
+            <div id="pollBody"></div>
+            <div id="pollControls" style="display: none; margin-top: 10px;">
+                <button class="btn btn-back" onclick="pollAction('close')">Закрыть опрос</button>
+                <button class="btn btn-share" onclick="pollAction('reveal')">Показать результаты</button>
             </div>
         </div>
         
         <!-- Модуль 1: Пределы -->
-        <div class="module-container">
+        <div class="module-container" data-module-id="1">
             <div class="module-number">1</div>
             <div class="module-header">
                 <h2 class="module-title">Понятие предела функции</h2>
@@ -2216,7 +2779,7 @@ func viewLecturePageHandler(w http.ResponseWriter, r *http.Request) {
         </div>
         
         <!-- Модуль 2: Производные -->
-        <div class="module-container">
+        <div class="module-container" data-module-id="2">
             <div class="module-number">2</div>
             <div class="module-header">
                 <h2 class="module-title">Производная функции</h2>
@@ -2244,7 +2807,7 @@ func viewLecturePageHandler(w http.ResponseWriter, r *http.Request) {
         </div>
         
         <!-- Модуль 3: Вопросник -->
-        <div class="module-container">
+        <div class="module-container" data-module-id="3">
             <div class="module-number">3</div>
             <div class="module-header">
                 <h2 class="module-title">Проверка понимания производных</h2>
@@ -2257,6 +2820,7 @@ func viewLecturePageHandler(w http.ResponseWriter, r *http.Request) {
                     <div class="answer-option correct-answer">B) $10x + 3$</div>
                     <div class="answer-option">C) $5x + 3$</div>
                     <div class="answer-option">D) $10x$</div>
+                    <button class="poll-btn" onclick="openPoll(this)">📊 Запустить опрос</button>
                 </div>
                 
                 <div class="question-block">
@@ -2265,6 +2829,7 @@ func viewLecturePageHandler(w http.ResponseWriter, r *http.Request) {
                     <div class="answer-option correct-answer">B) Скорость изменения функции</div>
                     <div class="answer-option">C) Максимальное значение</div>
                     <div class="answer-option">D) Корень уравнения</div>
+                    <button class="poll-btn" onclick="openPoll(this)">📊 Запустить опрос</button>
                 </div>
                 
                 <div class="question-block">
@@ -2273,12 +2838,13 @@ func viewLecturePageHandler(w http.ResponseWriter, r *http.Request) {
                     <div class="answer-option">B) 1</div>
                     <div class="answer-option">C) $c$</div>
                     <div class="answer-option">D) Не существует</div>
+                    <button class="poll-btn" onclick="openPoll(this)">📊 Запустить опрос</button>
                 </div>
             </div>
         </div>
         
         <!-- Модуль 4: Визуализация -->
-        <div class="module-container">
+        <div class="module-container" data-module-id="4">
             <div class="module-number">4</div>
             <div class="module-header">
                 <h2 class="module-title">Графическое представление производной</h2>
@@ -2306,7 +2872,7 @@ func viewLecturePageHandler(w http.ResponseWriter, r *http.Request) {
         </div>
         
         <!-- Модуль 5: Тест -->
-        <div class="module-container">
+        <div class="module-container" data-module-id="5">
             <div class="module-number">5</div>
             <div class="module-header">
                 <h2 class="module-title">Итоговый тест по теме</h2>
@@ -2362,6 +2928,223 @@ func viewLecturePageHandler(w http.ResponseWriter, r *http.Request) {
                 .catch(err => console.error('Ошибка копирования:', err));
         }
         
+        // Живая лекция: преподаватель переключает модули, студенты следуют за ним
+        const live = { session: null, isTeacher: false, source: null, moduleId: 0 };
+        const lectureId = parseInt(window.location.pathname.split('/').pop()) || 1;
+
+        function liveHeaders() {
+            return { 'Content-Type': 'application/json' };
+        }
+
+        function liveModules() {
+            return Array.from(document.querySelectorAll('.module-container[data-module-id]'))
+                .map(el => parseInt(el.dataset.moduleId));
+        }
+
+        async function startLiveSession() {
+            const first = liveModules()[0] || 0;
+            const response = await fetch('/api/live/sessions', {
+                method: 'POST',
+                headers: liveHeaders(),
+                body: JSON.stringify({ lecture_id: lectureId, module_id: first })
+            });
+            if (!response.ok) {
+                alert('❌ ' + await response.text());
+                return;
+            }
+            const result = await response.json();
+            live.isTeacher = true;
+            connectLive(result.session);
+        }
+
+        async function joinLiveSession() {
+            const code = document.getElementById('liveCode').value.trim();
+            if (!code) return;
+            const response = await fetch('/api/live/join', {
+                method: 'POST',
+                headers: liveHeaders(),
+                body: JSON.stringify({ code: code })
+            });
+            if (!response.ok) {
+                alert('❌ ' + await response.text());
+                return;
+            }
+            const result = await response.json();
+            live.isTeacher = false;
+            connectLive(result.session);
+        }
+
+        function connectLive(session) {
+            live.session = session;
+            document.getElementById('livePanel').classList.add('active');
+            document.getElementById('liveCodeLabel').textContent = session.join_code;
+            document.getElementById('liveControls').style.display = live.isTeacher ? 'inline' : 'none';
+
+            // EventSource сам переподключается и получает текущий модуль заново
+            live.source = new EventSource('/api/live/sessions/' + session.id + '/events');
+            live.source.onopen = () => {
+                document.getElementById('liveStatus').textContent = '🟢 подключено';
+            };
+            live.source.onerror = () => {
+                document.getElementById('liveStatus').textContent = '🟡 переподключение...';
+            };
+            live.source.addEventListener('module', e => showLiveModule(JSON.parse(e.data).module_id));
+            live.source.addEventListener('presence', e => {
+                const p = JSON.parse(e.data);
+                document.getElementById('livePresence').textContent = '👥 ' + p.users;
+            });
+            live.source.addEventListener('poll', e => showPoll(JSON.parse(e.data)));
+            live.source.addEventListener('poll_results', e => showPollResults(JSON.parse(e.data)));
+            live.source.addEventListener('checkin', e => {
+                document.getElementById('checkInCount').textContent = 'Отметились: ' + JSON.parse(e.data).present;
+            });
+            live.source.addEventListener('end', () => leaveLive('Живая лекция завершена'));
+            document.body.classList.toggle('live-teacher', live.isTeacher);
+        }
+
+        // QR-код отметки меняется каждые 30 секунд, обновляем его чаще
+        let checkInTimer = null;
+
+        function toggleCheckInQR() {
+            const panel = document.getElementById('checkInPanel');
+            if (checkInTimer) {
+                clearInterval(checkInTimer);
+                checkInTimer = null;
+                panel.classList.remove('active');
+                return;
+            }
+            const refresh = () => {
+                document.getElementById('checkInQR').src =
+                    '/api/live/sessions/' + live.session.id + '/checkin/qr?format=svg&t=' + Date.now();
+            };
+            refresh();
+            checkInTimer = setInterval(refresh, 10000);
+            panel.classList.add('active');
+        }
+
+        // Опросы: преподаватель запускает вопрос, студенты отвечают, гистограмма заполняется по мере ответов
+        let currentPoll = null;
+
+        async function openPoll(button) {
+            const block = button.closest('.question-block');
+            const container = button.closest('.module-container');
+            const blocks = Array.from(container.querySelectorAll('.question-block'));
+            const response = await fetch('/api/live/sessions/' + live.session.id + '/polls', {
+                method: 'POST',
+                headers: liveHeaders(),
+                body: JSON.stringify({
+                    module_id: parseInt(container.dataset.moduleId),
+                    question_index: blocks.indexOf(block)
+                })
+            });
+            if (!response.ok) alert('❌ ' + await response.text());
+        }
+
+        function showPoll(poll) {
+            currentPoll = poll;
+            document.getElementById('pollPanel').classList.add('active');
+            document.getElementById('pollQuestion').textContent = poll.question;
+            document.getElementById('pollControls').style.display = live.isTeacher && poll.open ? 'block' : 'none';
+
+            const body = document.getElementById('pollBody');
+            if (poll.revealed) {
+                renderPollBars(poll.answers, poll.results);
+            } else if (!live.isTeacher) {
+                body.innerHTML = poll.open
+                    ? poll.answers.map((a, i) => '<button class="poll-answer" onclick="answerPoll(' + i + ')"></button>').join('')
+                    : '<p>Опрос закрыт, ждем результатов...</p>';
+                body.querySelectorAll('.poll-answer').forEach((b, i) => b.textContent = poll.answers[i]);
+            }
+            if (window.MathJax) MathJax.typesetPromise();
+        }
+
+        function showPollResults(results) {
+            if (currentPoll && currentPoll.id === results.poll_id) {
+                renderPollBars(currentPoll.answers, results);
+            }
+        }
+
+        function renderPollBars(answers, results) {
+            const body = document.getElementById('pollBody');
+            const max = Math.max(1, ...results.counts);
+            body.innerHTML = '';
+            answers.forEach((answer, i) => {
+                const bar = document.createElement('div');
+                bar.className = 'poll-bar' + (currentPoll.revealed && i === results.correct ? ' correct' : '');
+                bar.innerHTML = '<div></div><div class="poll-bar-fill"></div>';
+                bar.firstChild.textContent = answer + ' — ' + results.counts[i];
+                bar.lastChild.style.width = (results.counts[i] / max * 100) + '%';
+                body.appendChild(bar);
+            });
+            const total = document.createElement('p');
+            total.textContent = 'Ответов: ' + results.total;
+            body.appendChild(total);
+            if (window.MathJax) MathJax.typesetPromise();
+        }
+
+        async function answerPoll(index) {
+            const response = await fetch('/api/polls/' + currentPoll.id + '/answer', {
+                method: 'POST',
+                headers: liveHeaders(),
+                body: JSON.stringify({ answer: index })
+            });
+            const body = document.getElementById('pollBody');
+            body.innerHTML = response.ok
+                ? '<p>✅ Ответ принят</p>'
+                : '<p>❌ ' + (await response.text()) + '</p>';
+        }
+
+        async function pollAction(action) {
+            await fetch('/api/polls/' + currentPoll.id + '/' + action, {
+                method: 'POST',
+                headers: liveHeaders()
+            });
+        }
+
+        function showLiveModule(moduleId) {
+            live.moduleId = moduleId;
+            document.querySelectorAll('.module-container[data-module-id]').forEach(el => {
+                const current = parseInt(el.dataset.moduleId) === moduleId;
+                el.classList.toggle('live-current', current);
+                // Студент видит только текущий модуль, преподаватель - всю лекцию
+                el.classList.toggle('live-hidden', !current && !live.isTeacher);
+                if (current) el.scrollIntoView({ behavior: 'smooth', block: 'start' });
+            });
+        }
+
+        async function moveLiveModule(step) {
+            const modules = liveModules();
+            const index = modules.indexOf(live.moduleId) + step;
+            if (index < 0 || index >= modules.length) return;
+            await fetch('/api/live/sessions/' + live.session.id + '/module', {
+                method: 'POST',
+                headers: liveHeaders(),
+                body: JSON.stringify({ module_id: modules[index] })
+            });
+        }
+
+        async function endLiveSession() {
+            if (!confirm('Завершить живую лекцию?')) return;
+            await fetch('/api/live/sessions/' + live.session.id + '/end', {
+                method: 'POST',
+                headers: liveHeaders()
+            });
+        }
+
+        function leaveLive(message) {
+            if (live.source) live.source.close();
+            live.source = null;
+            document.getElementById('livePanel').classList.remove('active');
+            document.getElementById('pollPanel').classList.remove('active');
+            document.getElementById('checkInPanel').classList.remove('active');
+            if (checkInTimer) clearInterval(checkInTimer);
+            checkInTimer = null;
+            document.querySelectorAll('.module-container').forEach(el => {
+                el.classList.remove('live-current', 'live-hidden');
+            });
+            alert(message);
+        }
+
         // Автоматическое обновление MathJax после загрузки
         window.addEventListener('DOMContentLoaded', function() {
             if (window.MathJax) {
@@ -2372,5 +3155,5 @@ func viewLecturePageHandler(w http.ResponseWriter, r *http.Request) {
 </body>
 </html>`
 
-	fmt.Fprintf(w, html)
+	fmt.Fprint(w, html)
 }
@@ -1,9 +1,13 @@
 package auth
 
 import (
-    "context"
-    "net/http"
-   //"strings"
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v4"
 )
 
 type contextKey string
@@ -11,33 +15,146 @@ type contextKey string
 const UserContextKey contextKey = "user"
 
 type UserClaims struct {
-    UserID   int    `json:"user_id"`
-    Login    string `json:"login"`
-    UserType string `json:"user_type"`
+	UserID   int    `json:"user_id"`
+	Login    string `json:"login"`
+	UserType string `json:"user_type"`
+
+	// Заполнены при входе по персональному токену API
+	TokenID     int      `json:"-"`
+	TokenScopes []string `json:"-"`
+
+	// Заполнен при входе через cookie сессии
+	SessionID int `json:"-"`
 }
 
-func AuthMiddleware(next http.Handler) http.Handler {
-    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-        userClaims := UserClaims{
-            UserID:   1,
-            Login:    "test_user",
-            UserType: "teacher", // Или "student", "admin"
-        }
-        
-        ctx := context.WithValue(r.Context(), UserContextKey, userClaims)
-        next.ServeHTTP(w, r.WithContext(ctx))
-    })
+// AuthMiddleware определяет пользователя запроса; без учетных данных - 401.
+// Отключенные администратором учетные записи тоже получают 401. Скрипты передают
+// персональный токен в заголовке Authorization: Bearer vm_pat_...; запрос вне
+// областей токена получает 403. Клиенты, вошедшие с session=jwt, передают JWT
+// в том же заголовке; неверный токен - 401, даже если есть cookie сессии.
+// Страницы браузера входят по cookie сессии и заголовок Authorization не шлют,
+// а изменяющие запросы из них должны нести заголовок X-CSRF-Token, иначе 403.
+func AuthMiddleware(db *sql.DB, jwtSecret string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var userClaims *UserClaims
+			header := r.Header.Get("Authorization")
+			bearer := strings.TrimPrefix(header, "Bearer ")
+
+			if strings.HasPrefix(bearer, TokenPrefix) {
+				claims, err := tokenUser(db, bearer)
+				switch {
+				case err == errTokenInvalid:
+					http.Error(w, "Токен недействителен или отозван", http.StatusUnauthorized)
+					return
+				case err == errTokenExpired:
+					http.Error(w, "Срок действия токена истек", http.StatusUnauthorized)
+					return
+				case err != nil:
+					http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+					return
+				}
+				scope := requiredScope(r)
+				if scope == "" || !claims.HasTokenScope(scope) {
+					http.Error(w, "Токен не дает доступа к этому разделу", http.StatusForbidden)
+					return
+				}
+				userClaims = claims
+			} else if header != "" {
+				// неверный заголовок не подменяется cookie: клиент должен узнать, что токен не принят
+				claims, err := jwtUser(db, jwtSecret, bearer)
+				if err == errTokenInvalid {
+					http.Error(w, "Токен недействителен", http.StatusUnauthorized)
+					return
+				} else if err != nil {
+					http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+					return
+				}
+				userClaims = claims
+			} else if claims, csrf, err := sessionUser(db, r); err == nil {
+				if !safeMethod(r) && !validCSRF(r, csrf) {
+					http.Error(w, "Неверный CSRF-токен, обновите страницу", http.StatusForbidden)
+					return
+				}
+				userClaims = claims
+			} else if err != errNoSession {
+				http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+				return
+			}
+
+			if userClaims == nil {
+				http.Error(w, "Требуется авторизация", http.StatusUnauthorized)
+				return
+			}
+
+			var active bool
+			err := db.QueryRow(`SELECT active FROM users WHERE id = $1`, userClaims.UserID).Scan(&active)
+			if err == sql.ErrNoRows || err == nil && !active {
+				http.Error(w, "Учетная запись отключена", http.StatusUnauthorized)
+				return
+			} else if err != nil {
+				http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), UserContextKey, *userClaims)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
 }
 
+// jwtUser проверяет JWT, выданный при входе с session=jwt (HS256, подпись JWT_SECRET),
+// и загружает пользователя. Роль берется из базы, а не из токена, чтобы смена роли
+// администратором действовала сразу. Временные токены второго шага входа с полем
+// purpose сессией не считаются.
+func jwtUser(db *sql.DB, secret, tokenString string) (*UserClaims, error) {
+	if tokenString == "" || secret == "" {
+		return nil, errTokenInvalid
+	}
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (interface{}, error) {
+		if t.Method != jwt.SigningMethodHS256 {
+			return nil, fmt.Errorf("unexpected signing method %v", t.Header["alg"])
+		}
+		return []byte(secret), nil
+	})
+	userID, _ := claims["user_id"].(float64)
+	if _, twoFactor := claims["purpose"]; err != nil || twoFactor || userID == 0 {
+		return nil, errTokenInvalid
+	}
+
+	user := UserClaims{UserID: int(userID)}
+	err = db.QueryRow(`
+        SELECT pii_decrypt(login), user_type FROM users WHERE id = $1
+    `, user.UserID).Scan(&user.Login, &user.UserType)
+	if err == sql.ErrNoRows {
+		return nil, errTokenInvalid
+	} else if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// RequireRole пропускает только пользователей с одной из ролей. Для проверок
+// по смыслу действия лучше RequirePermission.
 func RequireRole(allowedRoles ...string) func(http.Handler) http.Handler {
-    return func(next http.Handler) http.Handler {
-        return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-            next.ServeHTTP(w, r)
-        })
-    }
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, ok := GetUserFromContext(r.Context())
+			if ok {
+				for _, role := range allowedRoles {
+					if user.UserType == role {
+						next.ServeHTTP(w, r)
+						return
+					}
+				}
+			}
+			http.Error(w, "Доступ запрещен", http.StatusForbidden)
+		})
+	}
 }
 
 func GetUserFromContext(ctx context.Context) (*UserClaims, bool) {
-    user, ok := ctx.Value(UserContextKey).(UserClaims)
-    return &user, ok
-}
\ No newline at end of file
+	user, ok := ctx.Value(UserContextKey).(UserClaims)
+	return &user, ok
+}
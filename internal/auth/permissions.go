@@ -0,0 +1,147 @@
+package auth
+
+import (
+	"database/sql"
+	"net/http"
+)
+
+// Права. Какие из них есть у роли, хранится в role_permissions.
+const (
+	PermModuleEdit       = "module.edit"       // модули, лекции и навыки
+	PermCourseManage     = "course.manage"     // создание курсов
+	PermAssignmentManage = "assignment.manage" // задания со сроками
+	PermGroupManage      = "group.manage"      // группы и их состав
+	PermLiveRun          = "live.run"          // живые сессии
+	PermGradeWrite       = "grade.write"       // проверка развернутых ответов
+	PermProgressRead     = "progress.read"     // журнал, аналитика, карта навыков
+)
+
+// Permissions - все права с описанием для страницы администратора
+var Permissions = map[string]string{
+	PermModuleEdit:       "Создание и редактирование модулей, лекций и навыков",
+	PermCourseManage:     "Создание курсов",
+	PermAssignmentManage: "Задания и сроки сдачи",
+	PermGroupManage:      "Группы и их состав",
+	PermLiveRun:          "Проведение живых сессий",
+	PermGradeWrite:       "Проверка и оценка работ",
+	PermProgressRead:     "Просмотр журнала, аналитики и прогресса студентов",
+}
+
+// Roles - допустимые значения users.user_type
+var Roles = []string{"student", "ta", "teacher", "admin"}
+
+// ValidRole - роль из списка Roles
+func ValidRole(role string) bool {
+	for _, r := range Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// IsStaff - роль сотрудника: видит все лекции без записи на курс
+func IsStaff(role string) bool {
+	return role == "ta" || role == "teacher" || role == "admin"
+}
+
+// IsAdmin - администратору разрешено все, в том числе курсы чужого состава
+func IsAdmin(user *UserClaims) bool {
+	return user.UserType == "admin"
+}
+
+// Scope - где проверяется право. Нулевое поле - курс или группа не важны.
+type Scope struct {
+	CourseID int
+	GroupID  int
+}
+
+// HasPermission проверяет право пользователя. Администратору разрешено все.
+// Если у пользователя есть области в permission_scopes, права роли действуют
+// только в них; ассистент без областей прав не имеет. Пустой Scope подходит
+// к любой области - так проверяется, что право есть хоть где-нибудь.
+// Ошибка базы данных означает отказ.
+func HasPermission(db *sql.DB, user *UserClaims, permission string, scope Scope) bool {
+	if IsAdmin(user) {
+		return true
+	}
+
+	var n int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM role_permissions WHERE role = $1 AND permission = $2`,
+		user.UserType, permission).Scan(&n); err != nil || n == 0 {
+		return false
+	}
+
+	var scopes int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM permission_scopes WHERE user_id = $1`,
+		user.UserID).Scan(&scopes); err != nil {
+		return false
+	}
+	if scopes == 0 {
+		return user.UserType != "ta"
+	}
+
+	if err := db.QueryRow(`
+        SELECT COUNT(*) FROM permission_scopes
+        WHERE user_id = $1
+          AND ($2 = 0 OR course_id IS NULL OR course_id = $2)
+          AND ($3 = 0 OR group_id IS NULL OR group_id = $3)
+    `, user.UserID, scope.CourseID, scope.GroupID).Scan(&n); err != nil {
+		return false
+	}
+	return n > 0
+}
+
+// ScopedGroups возвращает группы, в которых действует право пользователя в курсе
+// courseID (0 - курс не выбран). all = true - ограничений по группам нет.
+// Область на весь курс учитывается только при выбранном курсе.
+func ScopedGroups(db *sql.DB, user *UserClaims, permission string, courseID int) (groups []int, all bool, err error) {
+	if !HasPermission(db, user, permission, Scope{CourseID: courseID}) {
+		return nil, false, nil
+	}
+	if IsAdmin(user) {
+		return nil, true, nil
+	}
+
+	rows, err := db.Query(`SELECT course_id, group_id FROM permission_scopes WHERE user_id = $1`, user.UserID)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	scoped := false
+	for rows.Next() {
+		var course, group sql.NullInt64
+		if err := rows.Scan(&course, &group); err != nil {
+			return nil, false, err
+		}
+		scoped = true
+		if course.Valid && int(course.Int64) != courseID {
+			continue
+		}
+		if !group.Valid {
+			all = true
+			continue
+		}
+		groups = append(groups, int(group.Int64))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, err
+	}
+	return groups, all || !scoped, nil
+}
+
+// RequirePermission пропускает запрос, если у пользователя есть право хотя бы
+// в одной области. Проверку конкретного курса или группы делает обработчик.
+func RequirePermission(db *sql.DB, permission string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, ok := GetUserFromContext(r.Context())
+			if !ok || !HasPermission(db, user, permission, Scope{}) {
+				http.Error(w, "Доступ запрещен", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Cookie сессии браузера. vm_session недоступна скриптам; vm_csrf скрипты страниц
+// читают и отправляют в заголовке X-CSRF-Token. Токен сверяется с сохраненным
+// в сессии, поэтому чужой сайт не подделает изменяющий запрос.
+const (
+	SessionCookie = "vm_session"
+	CSRFCookie    = "vm_csrf"
+	CSRFHeader    = "X-CSRF-Token"
+	SessionTTL    = 7 * 24 * time.Hour
+)
+
+var errNoSession = errors.New("no session")
+
+// randomToken - 32 случайных байта в base64 без паддинга
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// secureRequest - запрос пришел по HTTPS, напрямую или через прокси
+func secureRequest(r *http.Request) bool {
+	return r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https"
+}
+
+// requestIP - адрес клиента для списка сессий
+func requestIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// StartSession создает сессию пользователя, ставит cookie и возвращает CSRF-токен
+func StartSession(db *sql.DB, w http.ResponseWriter, r *http.Request, userID int) (string, error) {
+	token, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	csrf, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now().UTC()
+	expires := now.Add(SessionTTL)
+	if _, err := db.Exec(`
+        INSERT INTO sessions (user_id, token_hash, csrf_token, user_agent, ip, last_seen_at, expires_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7)
+    `, userID, HashToken(token), csrf, r.UserAgent(), requestIP(r), now, expires); err != nil {
+		return "", err
+	}
+
+	secure := secureRequest(r)
+	http.SetCookie(w, &http.Cookie{
+		Name: SessionCookie, Value: token, Path: "/", Expires: expires,
+		HttpOnly: true, Secure: secure, SameSite: http.SameSiteLaxMode,
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name: CSRFCookie, Value: csrf, Path: "/", Expires: expires,
+		Secure: secure, SameSite: http.SameSiteStrictMode,
+	})
+	return csrf, nil
+}
+
+// EndSession отзывает сессию запроса и удаляет cookie
+func EndSession(db *sql.DB, w http.ResponseWriter, r *http.Request) error {
+	var err error
+	if c, cookieErr := r.Cookie(SessionCookie); cookieErr == nil {
+		_, err = db.Exec(`
+            UPDATE sessions SET revoked_at = $1 WHERE token_hash = $2 AND revoked_at IS NULL
+        `, time.Now().UTC(), HashToken(c.Value))
+	}
+	clearSessionCookies(w)
+	return err
+}
+
+func clearSessionCookies(w http.ResponseWriter) {
+	for _, name := range []string{SessionCookie, CSRFCookie} {
+		http.SetCookie(w, &http.Cookie{Name: name, Value: "", Path: "/", MaxAge: -1})
+	}
+}
+
+// sessionUser находит пользователя по cookie сессии; возвращает также CSRF-токен сессии
+func sessionUser(db *sql.DB, r *http.Request) (*UserClaims, string, error) {
+	c, err := r.Cookie(SessionCookie)
+	if err != nil || c.Value == "" {
+		return nil, "", errNoSession
+	}
+
+	var claims UserClaims
+	var sessionID int
+	var csrf string
+	var lastSeen time.Time
+	now := time.Now().UTC()
+	err = db.QueryRow(`
+        SELECT s.id, s.csrf_token, s.last_seen_at, u.id, pii_decrypt(u.login), u.user_type
+        FROM sessions s
+        JOIN users u ON u.id = s.user_id
+        WHERE s.token_hash = $1 AND s.revoked_at IS NULL AND s.expires_at > $2 AND u.active = 1
+    `, HashToken(c.Value), now).Scan(&sessionID, &csrf, &lastSeen, &claims.UserID, &claims.Login, &claims.UserType)
+	if err == sql.ErrNoRows {
+		return nil, "", errNoSession
+	} else if err != nil {
+		return nil, "", err
+	}
+	claims.SessionID = sessionID
+
+	// как и для токенов, время отмечается не чаще раза в минуту
+	if now.Sub(lastSeen) > time.Minute {
+		db.Exec(`UPDATE sessions SET last_seen_at = $1 WHERE id = $2`, now, sessionID)
+	}
+	return &claims, csrf, nil
+}
+
+// validCSRF - заголовок X-CSRF-Token совпадает с токеном сессии
+func validCSRF(r *http.Request, csrf string) bool {
+	header := r.Header.Get(CSRFHeader)
+	return header != "" && subtle.ConstantTimeCompare([]byte(header), []byte(csrf)) == 1
+}
+
+// safeMethod - запрос не меняет данные и не требует CSRF-токена
+func safeMethod(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+// RequireSession - для HTML-страниц: без действующей сессии перенаправляет
+// на /login?next=<страница>, после входа страница откроется снова
+func RequireSession(db *sql.DB) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, _, err := sessionUser(db, r)
+			if err == errNoSession {
+				// истекшая или отозванная сессия: страница входа не должна считать ее действующей
+				clearSessionCookies(w)
+				http.Redirect(w, r, "/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+				return
+			} else if err != nil {
+				http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+				return
+			}
+			ctx := context.WithValue(r.Context(), UserContextKey, *claims)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// TokenPrefix - начало персонального токена API, по нему токен отличается от JWT
+const TokenPrefix = "vm_pat_"
+
+// TokenResources - разделы API, доступ к которым выдается токену. Область токена -
+// раздел и вид доступа: "modules:read" - GET-запросы, "modules:write" - остальные.
+// Администрирование и сами токены через токен недоступны.
+var TokenResources = map[string]string{
+	"modules":     "Модули",
+	"lectures":    "Лекции",
+	"courses":     "Курсы",
+	"skills":      "Навыки",
+	"assignments": "Задания",
+	"groups":      "Группы",
+	"gradebook":   "Журнал",
+	"grading":     "Проверка работ",
+	"attempts":    "Попытки тестов",
+}
+
+var (
+	errTokenInvalid = errors.New("invalid token")
+	errTokenExpired = errors.New("token expired")
+)
+
+// ValidTokenScope - область вида раздел:read или раздел:write
+func ValidTokenScope(scope string) bool {
+	resource, access, ok := strings.Cut(scope, ":")
+	if _, known := TokenResources[resource]; !ok || !known {
+		return false
+	}
+	return access == "read" || access == "write"
+}
+
+// NewToken создает токен. Пользователю показывается token, в базе хранится hash.
+func NewToken() (token, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token = TokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+	return token, HashToken(token), nil
+}
+
+// HashToken - SHA-256 токена: токен случайный, соль не нужна
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// requiredScope - область, нужная запросу. Пустая строка - раздел токенам закрыт.
+func requiredScope(r *http.Request) string {
+	path := strings.TrimPrefix(r.URL.Path, "/api/")
+	if path == r.URL.Path {
+		return ""
+	}
+	resource := strings.SplitN(path, "/", 2)[0]
+	if _, ok := TokenResources[resource]; !ok {
+		return ""
+	}
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return resource + ":read"
+	}
+	return resource + ":write"
+}
+
+// tokenUser находит владельца действующего токена и отмечает время использования
+func tokenUser(db *sql.DB, token string) (*UserClaims, error) {
+	var claims UserClaims
+	var scopes string
+	var expiresAt sql.NullTime
+	err := db.QueryRow(`
+        SELECT t.id, t.scopes, t.expires_at, u.id, pii_decrypt(u.login), u.user_type
+        FROM api_tokens t
+        JOIN users u ON u.id = t.user_id
+        WHERE t.token_hash = $1 AND t.revoked_at IS NULL
+    `, HashToken(token)).Scan(&claims.TokenID, &scopes, &expiresAt, &claims.UserID, &claims.Login, &claims.UserType)
+	if err == sql.ErrNoRows {
+		return nil, errTokenInvalid
+	} else if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	if expiresAt.Valid && !expiresAt.Time.After(now) {
+		return nil, errTokenExpired
+	}
+	claims.TokenScopes = strings.Fields(scopes)
+
+	// не чаще раза в минуту, чтобы скрипт не писал в базу на каждый запрос
+	db.Exec(`
+        UPDATE api_tokens SET last_used_at = $1
+        WHERE id = $2 AND (last_used_at IS NULL OR last_used_at < $3)
+    `, now, claims.TokenID, now.Add(-time.Minute))
+	return &claims, nil
+}
+
+// HasTokenScope - запрос по токену разрешен его областями. Для входа по паролю всегда true.
+func (c *UserClaims) HasTokenScope(scope string) bool {
+	if c.TokenID == 0 {
+		return true
+	}
+	for _, s := range c.TokenScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequiredScope(t *testing.T) {
+	tests := []struct {
+		method, path string
+		want         string
+	}{
+		{"GET", "/api/modules/5", "modules:read"},
+		{"HEAD", "/api/modules/list", "modules:read"},
+		{"POST", "/api/modules", "modules:write"},
+		{"PUT", "/api/lectures/3", "lectures:write"},
+		{"DELETE", "/api/groups/2", "groups:write"},
+		{"GET", "/api/gradebook/courses/1", "gradebook:read"},
+		{"POST", "/api/attempts/7/answer", "attempts:write"},
+
+		// токенам закрыты сами токены, администрирование и все вне разделов
+		{"GET", "/api/tokens", ""},
+		{"POST", "/api/tokens", ""},
+		{"DELETE", "/api/tokens/4", ""},
+		{"GET", "/api/admin/users", ""},
+		{"POST", "/api/admin/users/2/unlock", ""},
+		{"GET", "/api/user/profile", ""},
+		{"POST", "/api/logout", ""},
+		{"GET", "/dashboard", ""},
+		{"GET", "/api", ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.path, nil)
+		if got := requiredScope(r); got != tt.want {
+			t.Errorf("requiredScope(%s %s) = %q, want %q", tt.method, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestHasTokenScope(t *testing.T) {
+	password := UserClaims{UserID: 1}
+	token := UserClaims{UserID: 1, TokenID: 9, TokenScopes: []string{"modules:read", "lectures:write"}}
+	empty := UserClaims{UserID: 1, TokenID: 10}
+
+	tests := []struct {
+		name   string
+		claims UserClaims
+		scope  string
+		want   bool
+	}{
+		{"password login has every scope", password, "modules:write", true},
+		{"granted read", token, "modules:read", true},
+		{"granted write", token, "lectures:write", true},
+		{"read does not imply write", token, "modules:write", false},
+		{"write does not imply read", token, "lectures:read", false},
+		{"other resource", token, "gradebook:read", false},
+		{"token without scopes", empty, "modules:read", false},
+	}
+	for _, tt := range tests {
+		if got := tt.claims.HasTokenScope(tt.scope); got != tt.want {
+			t.Errorf("%s: HasTokenScope(%q) = %v, want %v", tt.name, tt.scope, got, tt.want)
+		}
+	}
+}
+
+func TestTokenResourcesAreClosedToAdminAndTokens(t *testing.T) {
+	for _, resource := range []string{"tokens", "admin", "user"} {
+		if _, ok := TokenResources[resource]; ok {
+			t.Errorf("TokenResources must not include %q", resource)
+		}
+		for _, access := range []string{"read", "write"} {
+			if ValidTokenScope(resource + ":" + access) {
+				t.Errorf("ValidTokenScope(%q) = true", resource+":"+access)
+			}
+		}
+	}
+}
@@ -0,0 +1,48 @@
+// Package bkt реализует байесовское отслеживание знаний (Bayesian Knowledge
+// Tracing): после каждого ответа пересчитывается вероятность того, что студент
+// владеет навыком.
+package bkt
+
+import "errors"
+
+// MasteredThreshold - вероятность, начиная с которой навык считается освоенным
+const MasteredThreshold = 0.95
+
+// Params - параметры модели для одного навыка
+type Params struct {
+	Init  float64 `json:"p_init"`  // навык освоен до первого ответа
+	Learn float64 `json:"p_learn"` // навык осваивается после очередного ответа
+	Slip  float64 `json:"p_slip"`  // ошибка, хотя навык освоен
+	Guess float64 `json:"p_guess"` // верный ответ, хотя навык не освоен
+}
+
+// DefaultParams используются для новых навыков
+var DefaultParams = Params{Init: 0.2, Learn: 0.15, Slip: 0.1, Guess: 0.2}
+
+// Validate проверяет, что параметры - вероятности, а угадывание и ошибка
+// не настолько велики, чтобы верный ответ говорил против владения навыком
+func (p Params) Validate() error {
+	for _, v := range []float64{p.Init, p.Learn, p.Slip, p.Guess} {
+		if v < 0 || v > 1 {
+			return errors.New("параметры должны быть от 0 до 1")
+		}
+	}
+	if p.Slip+p.Guess >= 1 {
+		return errors.New("p_slip + p_guess должно быть меньше 1")
+	}
+	return nil
+}
+
+// Update возвращает вероятность владения навыком после ответа: сначала
+// апостериорная вероятность по ответу, затем шанс освоить навык на этом шаге
+func Update(known float64, p Params, correct bool) float64 {
+	likelyKnown, likelyUnknown := 1-p.Slip, p.Guess
+	if !correct {
+		likelyKnown, likelyUnknown = p.Slip, 1-p.Guess
+	}
+	posterior := known
+	if d := known*likelyKnown + (1-known)*likelyUnknown; d > 0 {
+		posterior = known * likelyKnown / d
+	}
+	return posterior + (1-posterior)*p.Learn
+}
@@ -0,0 +1,595 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"visualmath/internal/auth"
+	"visualmath/internal/irt"
+	"visualmath/internal/models"
+)
+
+const (
+	// defaultStopSE - точность оценки способности, при которой тест заканчивается
+	defaultStopSE = 0.3
+	// calibrationMinResponses - с меньшим числом ответов вопрос остается с параметрами по умолчанию
+	calibrationMinResponses = 5
+)
+
+// Calibrate пересчитывает параметры 2PL-модели для вопросов модуля по всем
+// сохраненным ответам на них, в том числе из других тестов
+func (h *AssessmentHandler) Calibrate(w http.ResponseWriter, r *http.Request) {
+	module, ok := assessmentModule(h.DB, w, r)
+	if !ok || !canEditCourses(h.DB, w, r, auth.PermModuleEdit, module.CourseID) {
+		return
+	}
+	items, _, err := autoItems(h.DB, module)
+	if err != nil {
+		http.Error(w, "В модуле нет вопросов", http.StatusUnprocessableEntity)
+		return
+	}
+
+	params, err := h.calibrate(items)
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"message": "Параметры вопросов обновлены",
+		"items":   params,
+	})
+}
+
+// StartAdaptive начинает адаптивную попытку или продолжает незаконченную
+// и возвращает первый вопрос
+func (h *AssessmentHandler) StartAdaptive(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.GetUserFromContext(r.Context())
+	module, ok := assessmentModule(h.DB, w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		LectureID int `json:"lecture_id"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Неверный формат JSON", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if !canAttempt(h.DB, w, user, module, req.LectureID) {
+		return
+	}
+
+	items, config, err := autoItems(h.DB, module)
+	if err != nil {
+		http.Error(w, "В модуле нет вопросов", http.StatusUnprocessableEntity)
+		return
+	}
+	if config == nil || !config.Adaptive {
+		http.Error(w, "Тест не адаптивный", http.StatusBadRequest)
+		return
+	}
+
+	var attemptID int
+	err = h.DB.QueryRow(`
+        SELECT id FROM test_attempts
+        WHERE module_id = $1 AND student_id = $2 AND mode = 'adaptive' AND finished_at IS NULL
+        ORDER BY id DESC LIMIT 1
+    `, module.ID, user.UserID).Scan(&attemptID)
+	if err == nil {
+		h.respondAdaptive(w, attemptID)
+		return
+	} else if err != sql.ErrNoRows {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	if !attemptAllowed(h.DB, w, module, config, req.LectureID, user.UserID) {
+		return
+	}
+	assignmentID := 0
+	assignment, ok := assignmentGate(h.DB, w, user.UserID, req.LectureID, module.ID, time.Now().UTC())
+	if !ok {
+		return
+	} else if assignment != nil {
+		assignmentID = assignment.ID
+	}
+
+	params, calibrated, err := h.itemParameters(items)
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+	// Первый запуск теста: калибруем по тем ответам, что уже есть
+	if !calibrated {
+		if _, err := h.calibrate(items); err != nil {
+			http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+			return
+		}
+		if params, _, err = h.itemParameters(items); err != nil {
+			http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	first := nextItem(params, map[int]bool{}, 0)
+	err = h.DB.QueryRow(`
+        INSERT INTO test_attempts (student_id, lecture_id, module_id, mode, ability, ability_se,
+                                   current_source_module_id, current_question_index, started_at, assignment_id)
+        VALUES ($1, $2, $3, 'adaptive', 0, 1, $4, $5, $6, $7)
+        RETURNING id
+    `, user.UserID, req.LectureID, module.ID, items[first].SourceModuleID, items[first].Index,
+		time.Now().UTC(), assignmentID).Scan(&attemptID)
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	h.respondAdaptive(w, attemptID)
+}
+
+// AnswerAdaptive принимает ответ на текущий вопрос, обновляет оценку способности
+// и выдает следующий вопрос или итог
+func (h *AssessmentHandler) AnswerAdaptive(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.GetUserFromContext(r.Context())
+	attempt, current, ok := h.attemptFromURL(w, r)
+	if !ok {
+		return
+	}
+	if attempt.StudentID != user.UserID {
+		http.Error(w, "Доступ запрещен", http.StatusForbidden)
+		return
+	}
+	if attempt.Mode != "adaptive" {
+		http.Error(w, "Попытка не адаптивная", http.StatusBadRequest)
+		return
+	}
+	if attempt.FinishedAt != nil {
+		http.Error(w, "Попытка уже завершена", http.StatusConflict)
+		return
+	}
+
+	var req struct {
+		Answer     int `json:"answer"`     // -1 - пропустить вопрос
+		Confidence int `json:"confidence"` // 1-5, необязательно
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Неверный формат JSON", http.StatusBadRequest)
+		return
+	}
+
+	module, err := loadModule(h.DB, attempt.ModuleID)
+	if err != nil {
+		http.Error(w, "Модуль не найден", http.StatusNotFound)
+		return
+	}
+	items, config, err := autoItems(h.DB, module)
+	if err != nil || config == nil {
+		http.Error(w, "В модуле нет вопросов", http.StatusUnprocessableEntity)
+		return
+	}
+	params, _, err := h.itemParameters(items)
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	position := -1
+	for i, item := range items {
+		if item.SourceModuleID == current.sourceModuleID && item.Index == current.index {
+			position = i
+		}
+	}
+	if position < 0 {
+		http.Error(w, "Вопрос больше не входит в тест", http.StatusConflict)
+		return
+	}
+	item := items[position]
+	if req.Answer < -1 || req.Answer >= len(item.Question.Answers) {
+		http.Error(w, "Неверный вариант ответа", http.StatusBadRequest)
+		return
+	}
+
+	previous, err := h.attemptResponses(attempt.ID)
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	// Оценка способности по всем ответам попытки, включая текущий
+	answered := map[int]bool{position: true}
+	responses := []irt.Response{{Item: params[position], Correct: req.Answer == item.Question.Correct}}
+	score, maxScore := 0.0, item.Points
+	if req.Answer == item.Question.Correct {
+		score += item.Points
+	}
+	for i, it := range items {
+		resp, ok := previous[itemKey{it.SourceModuleID, it.Index}]
+		if !ok {
+			continue
+		}
+		answered[i] = true
+		responses = append(responses, irt.Response{Item: params[i], Correct: resp.IsCorrect})
+		maxScore += it.Points
+		if resp.IsCorrect {
+			score += it.Points
+		}
+	}
+	theta, se := irt.EstimateAbility(responses)
+
+	finished := adaptiveFinished(config, se, len(answered), maxQuestions(config, len(items)))
+
+	now := time.Now().UTC()
+	if finished {
+		attempt.FinishedAt = &now
+		if err := markLate(h.DB, attempt); err != nil {
+			http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+        INSERT INTO question_responses (attempt_id, student_id, source_module_id, question_index, answer, is_correct, confidence, answered_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+    `, attempt.ID, attempt.StudentID, item.SourceModuleID, item.Index, req.Answer, req.Answer == item.Question.Correct,
+		validConfidence(req.Confidence), now)
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+	if req.Answer >= 0 {
+		if err := updateMastery(tx, attempt.StudentID, item.SourceModuleID, item.Question, req.Answer == item.Question.Correct, now); err != nil {
+			http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if finished {
+		attempt.Score = score
+		attempt.MaxScore = maxScore
+		attempt.Percent = percentOf(score, maxScore)
+		attempt.FinishedAt = &now
+		_, err = tx.Exec(`
+            UPDATE test_attempts
+            SET score = $1, max_score = $2, ability = $3, ability_se = $4, finished_at = $5,
+                current_source_module_id = 0, current_question_index = -1, late_days = $6, penalty = $7
+            WHERE id = $8
+        `, score, maxScore, theta, se, now, attempt.LateDays, attempt.Penalty, attempt.ID)
+		if err == nil {
+			err = insertProgress(tx, attempt)
+		}
+	} else {
+		next := items[nextItem(params, answered, theta)]
+		_, err = tx.Exec(`
+            UPDATE test_attempts
+            SET score = $1, max_score = $2, ability = $3, ability_se = $4,
+                current_source_module_id = $5, current_question_index = $6
+            WHERE id = $7
+        `, score, maxScore, theta, se, next.SourceModuleID, next.Index, attempt.ID)
+	}
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	h.respondAdaptive(w, attempt.ID)
+}
+
+// GetAttempt возвращает состояние попытки студенту или преподавателю
+func (h *AssessmentHandler) GetAttempt(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.GetUserFromContext(r.Context())
+	attempt, _, ok := h.attemptFromURL(w, r)
+	if !ok {
+		return
+	}
+	if attempt.StudentID != user.UserID &&
+		!auth.HasPermission(h.DB, user, auth.PermProgressRead, studentScope(h.DB, attempt.LectureID, attempt.StudentID)) {
+		http.Error(w, "Доступ запрещен", http.StatusForbidden)
+		return
+	}
+	h.respondAdaptive(w, attempt.ID)
+}
+
+// respondAdaptive отдает попытку, число заданных вопросов и текущий вопрос без ответа
+func (h *AssessmentHandler) respondAdaptive(w http.ResponseWriter, attemptID int) {
+	attempt, current, err := loadAttempt(h.DB, attemptID)
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+	module, err := loadModule(h.DB, attempt.ModuleID)
+	if err != nil {
+		http.Error(w, "Модуль не найден", http.StatusNotFound)
+		return
+	}
+	items, config, err := autoItems(h.DB, module)
+	if err != nil {
+		http.Error(w, "В модуле нет вопросов", http.StatusUnprocessableEntity)
+		return
+	}
+	previous, err := h.attemptResponses(attempt.ID)
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"success":       true,
+		"attempt":       attempt,
+		"answered":      len(previous),
+		"max_questions": maxQuestions(config, len(items)),
+		"finished":      attempt.FinishedAt != nil,
+	}
+
+	if attempt.FinishedAt == nil {
+		for _, item := range items {
+			if item.SourceModuleID == current.sourceModuleID && item.Index == current.index {
+				response["question"] = map[string]interface{}{
+					"number":   len(previous) + 1,
+					"question": item.Question.Question,
+					"answers":  item.Question.Answers,
+				}
+			}
+		}
+	} else if config == nil || config.ShowResults {
+		results := []map[string]interface{}{}
+		for _, item := range items {
+			resp, ok := previous[itemKey{item.SourceModuleID, item.Index}]
+			if !ok {
+				continue
+			}
+			results = append(results, map[string]interface{}{
+				"question":    item.Question.Question,
+				"answer":      resp.Answer,
+				"is_correct":  resp.IsCorrect,
+				"correct":     item.Question.Correct,
+				"explanation": item.Question.Explanation,
+			})
+		}
+		response["results"] = results
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// maxQuestions - сколько вопросов задается в адаптивном тесте: QuestionsCount, но не больше, чем есть
+func maxQuestions(config *models.TestConfig, items int) int {
+	if config != nil && config.QuestionsCount > 0 && config.QuestionsCount < items {
+		return config.QuestionsCount
+	}
+	return items
+}
+
+// adaptiveFinished - правило остановки: способность оценена точнее StopSE
+// (по умолчанию defaultStopSE) или задано limit вопросов
+func adaptiveFinished(config *models.TestConfig, se float64, answered, limit int) bool {
+	stopSE := defaultStopSE
+	if config != nil && config.StopSE > 0 {
+		stopSE = config.StopSE
+	}
+	return se < stopSE || answered >= limit
+}
+
+// nextItem выбирает среди незаданных вопросов тот, что дает больше всего информации при theta
+func nextItem(params []irt.Item, answered map[int]bool, theta float64) int {
+	best, bestInfo := -1, -1.0
+	for i, item := range params {
+		if answered[i] {
+			continue
+		}
+		if info := irt.Information(item, theta); info > bestInfo {
+			best, bestInfo = i, info
+		}
+	}
+	return best
+}
+
+// calibrate оценивает параметры вопросов и сохраняет те, по которым хватает ответов
+func (h *AssessmentHandler) calibrate(items []testItem) ([]models.ItemParameters, error) {
+	position := make(map[itemKey]int, len(items))
+	sources := map[int]bool{}
+	for i, item := range items {
+		position[itemKey{item.SourceModuleID, item.Index}] = i
+		sources[item.SourceModuleID] = true
+	}
+
+	placeholders := []string{}
+	args := []interface{}{}
+	for id := range sources {
+		args = append(args, id)
+		placeholders = append(placeholders, fmt.Sprintf("$%d", len(args)))
+	}
+
+	rows, err := h.DB.Query(`
+        SELECT attempt_id, source_module_id, question_index, is_correct
+        FROM question_responses
+        WHERE source_module_id IN (`+strings.Join(placeholders, ", ")+`)
+        ORDER BY attempt_id
+    `, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	// Строки матрицы - попытки, столбцы - вопросы
+	var data [][]int
+	counts := make([]int, len(items))
+	lastAttempt := 0
+	for rows.Next() {
+		var attemptID int
+		var key itemKey
+		var correct bool
+		if err := rows.Scan(&attemptID, &key.sourceModuleID, &key.index, &correct); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		i, ok := position[key]
+		if !ok {
+			continue
+		}
+		if attemptID != lastAttempt {
+			row := make([]int, len(items))
+			for k := range row {
+				row[k] = -1
+			}
+			data = append(data, row)
+			lastAttempt = attemptID
+		}
+		data[len(data)-1][i] = 0
+		if correct {
+			data[len(data)-1][i] = 1
+		}
+		counts[i]++
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	estimated := irt.Calibrate(data, len(items), calibrationMinResponses)
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	params := make([]models.ItemParameters, len(items))
+	for i, item := range items {
+		params[i] = models.ItemParameters{
+			SourceModuleID: item.SourceModuleID,
+			QuestionIndex:  item.Index,
+			Discrimination: estimated[i].A,
+			Difficulty:     estimated[i].B,
+			Responses:      counts[i],
+		}
+		if counts[i] < calibrationMinResponses {
+			continue
+		}
+		_, err := tx.Exec(`
+            INSERT INTO item_parameters (source_module_id, question_index, discrimination, difficulty, responses, calibrated_at)
+            VALUES ($1, $2, $3, $4, $5, $6)
+            ON CONFLICT (source_module_id, question_index) DO UPDATE SET
+                discrimination = excluded.discrimination,
+                difficulty = excluded.difficulty,
+                responses = excluded.responses,
+                calibrated_at = excluded.calibrated_at
+        `, item.SourceModuleID, item.Index, estimated[i].A, estimated[i].B, counts[i], time.Now().UTC())
+		if err != nil {
+			return nil, err
+		}
+	}
+	return params, tx.Commit()
+}
+
+// itemParameters загружает параметры вопросов; calibrated равно false,
+// если ни один вопрос еще не калибровался
+func (h *AssessmentHandler) itemParameters(items []testItem) (params []irt.Item, calibrated bool, err error) {
+	params = make([]irt.Item, len(items))
+	for i, item := range items {
+		params[i] = irt.DefaultItem
+		err := h.DB.QueryRow(`
+            SELECT discrimination, difficulty FROM item_parameters
+            WHERE source_module_id = $1 AND question_index = $2
+        `, item.SourceModuleID, item.Index).Scan(&params[i].A, &params[i].B)
+		if err == nil {
+			calibrated = true
+		} else if err != sql.ErrNoRows {
+			return nil, false, err
+		}
+	}
+	return params, calibrated, nil
+}
+
+// attemptResponses - ответы попытки по вопросам
+func (h *AssessmentHandler) attemptResponses(attemptID int) (map[itemKey]models.QuestionResponse, error) {
+	rows, err := h.DB.Query(`
+        SELECT source_module_id, question_index, answer, is_correct
+        FROM question_responses WHERE attempt_id = $1
+    `, attemptID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	responses := make(map[itemKey]models.QuestionResponse)
+	for rows.Next() {
+		var resp models.QuestionResponse
+		if err := rows.Scan(&resp.SourceModuleID, &resp.QuestionIndex, &resp.Answer, &resp.IsCorrect); err != nil {
+			return nil, err
+		}
+		responses[itemKey{resp.SourceModuleID, resp.QuestionIndex}] = resp
+	}
+	return responses, rows.Err()
+}
+
+func (h *AssessmentHandler) attemptFromURL(w http.ResponseWriter, r *http.Request) (*models.TestAttempt, itemKey, bool) {
+	attemptID, err := strconv.Atoi(chi.URLParam(r, "attemptID"))
+	if err != nil {
+		http.Error(w, "Неверный ID попытки", http.StatusBadRequest)
+		return nil, itemKey{}, false
+	}
+	attempt, current, err := loadAttempt(h.DB, attemptID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Попытка не найдена", http.StatusNotFound)
+		return nil, itemKey{}, false
+	} else if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return nil, itemKey{}, false
+	}
+	return attempt, current, true
+}
+
+// loadAttempt загружает попытку и вопрос, который сейчас задан в адаптивном режиме
+func loadAttempt(db *sql.DB, id int) (*models.TestAttempt, itemKey, error) {
+	var a models.TestAttempt
+	var current itemKey
+	var ability, abilitySE sql.NullFloat64
+	var finishedAt sql.NullTime
+	err := db.QueryRow(`
+        SELECT id, student_id, lecture_id, module_id, score, max_score, mode, status, ability, ability_se,
+               started_at, finished_at, current_source_module_id, current_question_index,
+               assignment_id, late_days, penalty
+        FROM test_attempts WHERE id = $1
+    `, id).Scan(&a.ID, &a.StudentID, &a.LectureID, &a.ModuleID, &a.Score, &a.MaxScore, &a.Mode, &a.Status,
+		&ability, &abilitySE, &a.StartedAt, &finishedAt, &current.sourceModuleID, &current.index,
+		&a.AssignmentID, &a.LateDays, &a.Penalty)
+	if err != nil {
+		return nil, current, err
+	}
+	a.Percent = percentOf(a.Score, a.MaxScore)
+	if ability.Valid {
+		a.Ability = &ability.Float64
+	}
+	if abilitySE.Valid {
+		a.AbilitySE = &abilitySE.Float64
+	}
+	if finishedAt.Valid {
+		a.FinishedAt = &finishedAt.Time
+	}
+	return &a, current, nil
+}
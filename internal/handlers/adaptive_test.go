@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"testing"
+
+	"visualmath/internal/models"
+)
+
+func TestMaxQuestions(t *testing.T) {
+	cases := []struct {
+		name   string
+		config *models.TestConfig
+		items  int
+		want   int
+	}{
+		{"no config", nil, 12, 12},
+		{"count not set", &models.TestConfig{}, 12, 12},
+		{"count below items", &models.TestConfig{QuestionsCount: 5}, 12, 5},
+		{"count above items", &models.TestConfig{QuestionsCount: 20}, 12, 12},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := maxQuestions(tc.config, tc.items); got != tc.want {
+				t.Errorf("maxQuestions = %d, want %d", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestAdaptiveFinished(t *testing.T) {
+	cases := []struct {
+		name     string
+		config   *models.TestConfig
+		se       float64
+		answered int
+		limit    int
+		want     bool
+	}{
+		{"imprecise, questions left", &models.TestConfig{StopSE: 0.4}, 0.5, 3, 10, false},
+		{"precise enough", &models.TestConfig{StopSE: 0.4}, 0.39, 3, 10, true},
+		{"se equal to threshold", &models.TestConfig{StopSE: 0.4}, 0.4, 3, 10, false},
+		{"limit reached", &models.TestConfig{StopSE: 0.4}, 0.9, 10, 10, true},
+		{"default threshold", &models.TestConfig{}, defaultStopSE - 0.01, 1, 10, true},
+		{"default threshold not reached", nil, defaultStopSE + 0.01, 1, 10, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := adaptiveFinished(tc.config, tc.se, tc.answered, tc.limit); got != tc.want {
+				t.Errorf("adaptiveFinished(se=%v, answered=%d, limit=%d) = %v, want %v",
+					tc.se, tc.answered, tc.limit, got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,651 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/go-chi/chi/v5"
+	"golang.org/x/crypto/bcrypt"
+
+	"visualmath/internal/auth"
+	"visualmath/internal/models"
+)
+
+const (
+	defaultPerPage = 50
+	maxPerPage     = 200
+	resetTokenTTL  = 24 * time.Hour
+)
+
+// userRefs - столбцы, которые при слиянии учетных записей просто переходят к основной
+var userRefs = []struct{ table, column string }{
+	{"modules", "author_id"},
+	{"lectures", "author_id"},
+	{"student_progress", "student_id"},
+	{"live_sessions", "teacher_id"},
+	{"test_attempts", "student_id"},
+	{"question_responses", "student_id"},
+	{"response_reviews", "grader_id"},
+	{"assignments", "author_id"},
+	{"groups", "curator_id"},
+	{"course_invites", "created_by"},
+	{"enrollments", "approved_by"},
+	{"permission_scopes", "created_by"},
+	{"two_factor_roles", "created_by"},
+	{"deletion_requests", "user_id"},
+	{"deletion_requests", "decided_by"},
+	{"login_events", "user_id"},
+}
+
+// uniqueUserRefs - столбцы из ключей уникальности: если у основной учетной записи
+// уже есть такая строка, строка дубликата удаляется
+var uniqueUserRefs = []struct{ table, column string }{
+	{"live_participants", "user_id"},
+	{"poll_answers", "student_id"},
+	{"attendance", "user_id"},
+	{"review_items", "student_id"},
+	{"skill_mastery", "student_id"},
+	{"course_teachers", "teacher_id"},
+	{"enrollments", "student_id"},
+	{"permission_scopes", "user_id"},
+}
+
+// sourceCredentials - таблицы с учетными данными по user_id. У дубликата они не
+// переносятся, а удаляются: войти в основную учетную запись его сессиями,
+// токенами, кодами восстановления и привязками VK или Google нельзя.
+// Журнал audit_log - история, ссылки в нем не меняются.
+var sourceCredentials = []string{
+	"password_tokens",
+	"api_tokens",
+	"sessions",
+	"recovery_codes",
+	"oauth_connections",
+	"user_avatars",
+}
+
+// ListUsers - список пользователей с поиском и постраничным выводом.
+// Параметры: search (логин, ФИО, email), user_type, active, group_id, page, per_page.
+func (h *AdminHandler) ListUsers(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireAdmin(w, r); !ok {
+		return
+	}
+
+	q := r.URL.Query()
+	var conds []string
+	var args []interface{}
+	if search := strings.TrimSpace(q.Get("search")); search != "" {
+		// LIKE в SQLite не различает регистр только для латиницы,
+		// поэтому ФИО дополнительно ищется с заглавной буквы: "иванов" -> "Иванов"
+		args = append(args, "%"+search+"%", "%"+capitalize(search)+"%")
+		n := len(args) - 1
+		conds = append(conds, fmt.Sprintf("(pii_decrypt(u.login) LIKE $%d OR pii_decrypt(u.email) LIKE $%d OR pii_decrypt(u.full_name) LIKE $%d OR pii_decrypt(u.full_name) LIKE $%d)",
+			n, n, n, n+1))
+	}
+	if userType := q.Get("user_type"); userType != "" {
+		args = append(args, userType)
+		conds = append(conds, fmt.Sprintf("u.user_type = $%d", len(args)))
+	}
+	if active := q.Get("active"); active != "" {
+		value, err := strconv.ParseBool(active)
+		if err != nil {
+			http.Error(w, "Неверное значение active", http.StatusBadRequest)
+			return
+		}
+		args = append(args, value)
+		conds = append(conds, fmt.Sprintf("u.active = $%d", len(args)))
+	}
+	if groupID := q.Get("group_id"); groupID != "" {
+		id, err := strconv.Atoi(groupID)
+		if err != nil {
+			http.Error(w, "Неверный ID группы", http.StatusBadRequest)
+			return
+		}
+		args = append(args, id)
+		conds = append(conds, fmt.Sprintf("u.group_id = $%d", len(args)))
+	}
+	where := ""
+	if len(conds) > 0 {
+		where = " WHERE " + strings.Join(conds, " AND ")
+	}
+
+	page, perPage, ok := pageParams(w, r)
+	if !ok {
+		return
+	}
+
+	var total int
+	if err := h.DB.QueryRow(`SELECT COUNT(*) FROM users u`+where, args...).Scan(&total); err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	args = append(args, perPage, (page-1)*perPage)
+	rows, err := h.DB.Query(adminUserQuery+where+
+		fmt.Sprintf(" ORDER BY pii_decrypt(u.full_name), u.id LIMIT $%d OFFSET $%d", len(args)-1, len(args)), args...)
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	users := []models.User{}
+	for rows.Next() {
+		u, err := scanAdminUser(rows)
+		if err != nil {
+			http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+			return
+		}
+		users = append(users, *u)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"users":    users,
+		"total":    total,
+		"page":     page,
+		"per_page": perPage,
+	})
+}
+
+// GetUser возвращает пользователя
+func (h *AdminHandler) GetUser(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireAdmin(w, r); !ok {
+		return
+	}
+	target, ok := h.userFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(target)
+}
+
+// SetUserType меняет роль пользователя {user_type}. У преподавателей и администраторов
+// сбрасывается группа.
+func (h *AdminHandler) SetUserType(w http.ResponseWriter, r *http.Request) {
+	admin, ok := requireAdmin(w, r)
+	if !ok {
+		return
+	}
+	target, ok := h.userFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		UserType string `json:"user_type"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Неверный формат JSON", http.StatusBadRequest)
+		return
+	}
+	if !auth.ValidRole(req.UserType) {
+		http.Error(w, "Роль должна быть student, ta, teacher или admin", http.StatusBadRequest)
+		return
+	}
+	if target.ID == admin.UserID {
+		http.Error(w, "Нельзя изменить собственную роль", http.StatusBadRequest)
+		return
+	}
+	if req.UserType == target.UserType {
+		h.respondUser(w, target.ID)
+		return
+	}
+
+	err := h.inTx(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+            UPDATE users SET user_type = $1, group_id = CASE WHEN $1 = 'student' THEN group_id END
+            WHERE id = $2
+        `, req.UserType, target.ID)
+		if err != nil {
+			return err
+		}
+		return audit(tx, admin.UserID, "user.type", target.ID, map[string]interface{}{
+			"from": target.UserType,
+			"to":   req.UserType,
+		})
+	})
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	h.respondUser(w, target.ID)
+}
+
+// DeactivateUser отключает учетную запись: вход и запросы к API отклоняются
+func (h *AdminHandler) DeactivateUser(w http.ResponseWriter, r *http.Request) {
+	h.setActive(w, r, false)
+}
+
+// ActivateUser снова включает учетную запись
+func (h *AdminHandler) ActivateUser(w http.ResponseWriter, r *http.Request) {
+	h.setActive(w, r, true)
+}
+
+func (h *AdminHandler) setActive(w http.ResponseWriter, r *http.Request, active bool) {
+	admin, ok := requireAdmin(w, r)
+	if !ok {
+		return
+	}
+	target, ok := h.userFromURL(w, r)
+	if !ok {
+		return
+	}
+	if !active && target.ID == admin.UserID {
+		http.Error(w, "Нельзя отключить собственную учетную запись", http.StatusBadRequest)
+		return
+	}
+	if target.Active == active {
+		h.respondUser(w, target.ID)
+		return
+	}
+
+	action := "user.activate"
+	var deactivatedAt interface{}
+	if !active {
+		action = "user.deactivate"
+		deactivatedAt = time.Now().UTC()
+	}
+	err := h.inTx(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`UPDATE users SET active = $1, deactivated_at = $2 WHERE id = $3`,
+			active, deactivatedAt, target.ID)
+		if err != nil {
+			return err
+		}
+		return audit(tx, admin.UserID, action, target.ID, nil)
+	})
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	h.respondUser(w, target.ID)
+}
+
+// ResetPassword сбрасывает пароль и отправляет пользователю ссылку для установки нового.
+// Старый пароль перестает действовать сразу. Если письмо отправить не удалось,
+// ссылка возвращается в ответе, чтобы администратор передал ее сам.
+func (h *AdminHandler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	admin, ok := requireAdmin(w, r)
+	if !ok {
+		return
+	}
+	target, ok := h.userFromURL(w, r)
+	if !ok {
+		return
+	}
+	if target.AuthSource == "ldap" {
+		http.Error(w, "Пароль учетной записи каталога меняется в каталоге университета", http.StatusBadRequest)
+		return
+	}
+
+	// случайный пароль, который никто не узнает
+	password, err := generatePassword()
+	if err != nil {
+		http.Error(w, "Ошибка при сбросе пароля", http.StatusInternalServerError)
+		return
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		http.Error(w, "Ошибка при сбросе пароля", http.StatusInternalServerError)
+		return
+	}
+
+	var token string
+	err = h.inTx(func(tx *sql.Tx) error {
+		now := time.Now().UTC()
+		if _, err := tx.Exec(`UPDATE users SET password_hash = $1 WHERE id = $2`, string(hash), target.ID); err != nil {
+			return err
+		}
+		// прежние ссылки больше не нужны
+		if _, err := tx.Exec(`
+            UPDATE password_tokens SET used_at = $1 WHERE user_id = $2 AND used_at IS NULL
+        `, now, target.ID); err != nil {
+			return err
+		}
+		if token, err = issuePasswordToken(tx, target.ID, "reset", resetTokenTTL); err != nil {
+			return err
+		}
+		return audit(tx, admin.UserID, "user.reset_password", target.ID, nil)
+	})
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	link := setPasswordURL(h.BaseURL, token)
+	body := fmt.Sprintf("Здравствуйте, %s!\n\nАдминистратор сбросил пароль вашей учетной записи VisualMath (логин: %s).\n"+
+		"Чтобы задать новый пароль, перейдите по ссылке (действует 24 часа):\n%s\n",
+		target.FullName, target.Login, link)
+	resp := map[string]interface{}{
+		"success":    true,
+		"email_sent": true,
+	}
+	if err := h.Mailer.Send(target.Email, "Сброс пароля VisualMath", body); err != nil {
+		log.Printf("Не удалось отправить ссылку сброса пароля %s: %v", target.Email, err)
+		resp["email_sent"] = false
+		resp["link"] = link
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// MergeUsers переносит все данные учетной записи source_id в эту и удаляет source_id.
+// Нужен для дубликатов, например созданных импортом и самостоятельной регистрацией.
+func (h *AdminHandler) MergeUsers(w http.ResponseWriter, r *http.Request) {
+	admin, ok := requireAdmin(w, r)
+	if !ok {
+		return
+	}
+	target, ok := h.userFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		SourceID int `json:"source_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Неверный формат JSON", http.StatusBadRequest)
+		return
+	}
+	if req.SourceID == target.ID {
+		http.Error(w, "Нельзя слить учетную запись саму с собой", http.StatusBadRequest)
+		return
+	}
+	if req.SourceID == admin.UserID {
+		http.Error(w, "Нельзя удалить собственную учетную запись", http.StatusBadRequest)
+		return
+	}
+	source, err := loadAdminUser(h.DB, req.SourceID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Пользователь source_id не найден", http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+	if source.UserType != target.UserType {
+		http.Error(w, "Сливать можно только учетные записи с одинаковой ролью", http.StatusBadRequest)
+		return
+	}
+
+	err = h.inTx(func(tx *sql.Tx) error {
+		// пустые курс и группа не попадают под UNIQUE области, поэтому ее повтор
+		// ищется так же, как при назначении (AddScope)
+		if _, err := tx.Exec(`
+            DELETE FROM permission_scopes WHERE user_id = $2 AND EXISTS (
+                SELECT 1 FROM permission_scopes p WHERE p.user_id = $1
+                  AND COALESCE(p.course_id, 0) = COALESCE(permission_scopes.course_id, 0)
+                  AND COALESCE(p.group_id, 0) = COALESCE(permission_scopes.group_id, 0))
+        `, target.ID, source.ID); err != nil {
+			return err
+		}
+		for _, ref := range userRefs {
+			if _, err := tx.Exec(fmt.Sprintf(`UPDATE %s SET %s = $1 WHERE %s = $2`,
+				ref.table, ref.column, ref.column), target.ID, source.ID); err != nil {
+				return err
+			}
+		}
+		for _, ref := range uniqueUserRefs {
+			if _, err := tx.Exec(fmt.Sprintf(`UPDATE OR IGNORE %s SET %s = $1 WHERE %s = $2`,
+				ref.table, ref.column, ref.column), target.ID, source.ID); err != nil {
+				return err
+			}
+			if _, err := tx.Exec(fmt.Sprintf(`DELETE FROM %s WHERE %s = $1`,
+				ref.table, ref.column), source.ID); err != nil {
+				return err
+			}
+		}
+		for _, table := range sourceCredentials {
+			if _, err := tx.Exec(`DELETE FROM `+table+` WHERE user_id = $1`, source.ID); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.Exec(`
+            UPDATE users SET group_id = (SELECT group_id FROM users WHERE id = $1)
+            WHERE id = $2 AND group_id IS NULL
+        `, source.ID, target.ID); err != nil {
+			return err
+		}
+		// в журнал попадают слепые индексы: логин и почту в нем не хранят открытым текстом
+		var sourceLogin, sourceEmail string
+		if err := tx.QueryRow(`
+            SELECT COALESCE(login_index, ''), COALESCE(email_index, '') FROM users WHERE id = $1
+        `, source.ID).Scan(&sourceLogin, &sourceEmail); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`DELETE FROM users WHERE id = $1`, source.ID); err != nil {
+			return err
+		}
+		return audit(tx, admin.UserID, "user.merge", target.ID, map[string]interface{}{
+			"source_id":          source.ID,
+			"source_login_index": sourceLogin,
+			"source_email_index": sourceEmail,
+		})
+	})
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	h.respondUser(w, target.ID)
+}
+
+// ListAudit - журнал действий администраторов, новые записи первыми.
+// Параметры: user_id (над кем выполнено действие), action, page, per_page.
+func (h *AdminHandler) ListAudit(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireAdmin(w, r); !ok {
+		return
+	}
+
+	q := r.URL.Query()
+	var conds []string
+	var args []interface{}
+	if userID := q.Get("user_id"); userID != "" {
+		id, err := strconv.Atoi(userID)
+		if err != nil {
+			http.Error(w, "Неверный ID пользователя", http.StatusBadRequest)
+			return
+		}
+		args = append(args, id)
+		conds = append(conds, fmt.Sprintf("a.target_user_id = $%d", len(args)))
+	}
+	if action := q.Get("action"); action != "" {
+		args = append(args, action)
+		conds = append(conds, fmt.Sprintf("a.action = $%d", len(args)))
+	}
+	where := ""
+	if len(conds) > 0 {
+		where = " WHERE " + strings.Join(conds, " AND ")
+	}
+
+	page, perPage, ok := pageParams(w, r)
+	if !ok {
+		return
+	}
+
+	var total int
+	if err := h.DB.QueryRow(`SELECT COUNT(*) FROM audit_log a`+where, args...).Scan(&total); err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	args = append(args, perPage, (page-1)*perPage)
+	rows, err := h.DB.Query(`
+        SELECT a.id, a.actor_id, COALESCE(pii_decrypt(actor.full_name), ''), a.action, COALESCE(a.target_user_id, 0),
+               COALESCE(pii_decrypt(target.full_name), ''), a.details, a.created_at
+        FROM audit_log a
+        LEFT JOIN users actor ON actor.id = a.actor_id
+        LEFT JOIN users target ON target.id = a.target_user_id`+where+
+		fmt.Sprintf(" ORDER BY a.id DESC LIMIT $%d OFFSET $%d", len(args)-1, len(args)), args...)
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	entries := []models.AuditEntry{}
+	for rows.Next() {
+		var e models.AuditEntry
+		var details string
+		if err := rows.Scan(&e.ID, &e.ActorID, &e.ActorName, &e.Action, &e.TargetUserID,
+			&e.TargetName, &details, &e.CreatedAt); err != nil {
+			http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+			return
+		}
+		e.Details = json.RawMessage(details)
+		entries = append(entries, e)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"entries":  entries,
+		"total":    total,
+		"page":     page,
+		"per_page": perPage,
+	})
+}
+
+// audit записывает действие администратора в журнал. targetID = 0 - действие
+// не относится к одному пользователю.
+func audit(tx *sql.Tx, actorID int, action string, targetID int, details map[string]interface{}) error {
+	if details == nil {
+		details = map[string]interface{}{}
+	}
+	data, err := json.Marshal(details)
+	if err != nil {
+		return err
+	}
+	var target interface{}
+	if targetID != 0 {
+		target = targetID
+	}
+	_, err = tx.Exec(`
+        INSERT INTO audit_log (actor_id, action, target_user_id, details) VALUES ($1, $2, $3, $4)
+    `, actorID, action, target, string(data))
+	return err
+}
+
+// capitalize делает первую букву заглавной
+func capitalize(s string) string {
+	runes := []rune(s)
+	runes[0] = unicode.ToUpper(runes[0])
+	return string(runes)
+}
+
+func requireAdmin(w http.ResponseWriter, r *http.Request) (*auth.UserClaims, bool) {
+	user, _ := auth.GetUserFromContext(r.Context())
+	if !auth.IsAdmin(user) {
+		http.Error(w, "Доступ запрещен", http.StatusForbidden)
+		return nil, false
+	}
+	return user, true
+}
+
+// pageParams разбирает ?page= (с 1) и ?per_page=
+func pageParams(w http.ResponseWriter, r *http.Request) (int, int, bool) {
+	page, perPage := 1, defaultPerPage
+	if v := r.URL.Query().Get("page"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			http.Error(w, "Неверный номер страницы", http.StatusBadRequest)
+			return 0, 0, false
+		}
+		page = n
+	}
+	if v := r.URL.Query().Get("per_page"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			http.Error(w, "Неверный размер страницы", http.StatusBadRequest)
+			return 0, 0, false
+		}
+		if n > maxPerPage {
+			n = maxPerPage
+		}
+		perPage = n
+	}
+	return page, perPage, true
+}
+
+func (h *AdminHandler) inTx(fn func(tx *sql.Tx) error) error {
+	return inTx(h.DB, fn)
+}
+
+// inTx выполняет fn в транзакции и фиксирует ее, если fn не вернула ошибку
+func inTx(db *sql.DB, fn func(tx *sql.Tx) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (h *AdminHandler) respondUser(w http.ResponseWriter, id int) {
+	u, err := loadAdminUser(h.DB, id)
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"user":    u,
+	})
+}
+
+func (h *AdminHandler) userFromURL(w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Неверный ID", http.StatusBadRequest)
+		return nil, false
+	}
+	u, err := loadAdminUser(h.DB, id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Пользователь не найден", http.StatusNotFound)
+		return nil, false
+	} else if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return nil, false
+	}
+	return u, true
+}
+
+const adminUserQuery = `
+    SELECT u.id, pii_decrypt(u.login), pii_decrypt(u.full_name), u.user_type, COALESCE(u.group_id, 0), COALESCE(g.name, ''),
+           pii_decrypt(u.email), COALESCE(u.email_verified, FALSE), u.active, u.deactivated_at,
+           u.auth_source, u.totp_enabled, u.created_at
+    FROM users u
+    LEFT JOIN groups g ON g.id = u.group_id`
+
+func scanAdminUser(row interface{ Scan(...interface{}) error }) (*models.User, error) {
+	var u models.User
+	var deactivatedAt sql.NullTime
+	if err := row.Scan(&u.ID, &u.Login, &u.FullName, &u.UserType, &u.GroupID, &u.GroupNumber,
+		&u.Email, &u.EmailVerified, &u.Active, &deactivatedAt, &u.AuthSource, &u.TOTPEnabled, &u.CreatedAt); err != nil {
+		return nil, err
+	}
+	if deactivatedAt.Valid {
+		u.DeactivatedAt = &deactivatedAt.Time
+	}
+	return &u, nil
+}
+
+func loadAdminUser(db *sql.DB, id int) (*models.User, error) {
+	return scanAdminUser(db.QueryRow(adminUserQuery+` WHERE u.id = $1`, id))
+}
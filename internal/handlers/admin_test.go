@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+// userColumns - столбцы всех таблиц с внешним ключом на users
+func userColumns(t *testing.T, db *sql.DB) [][2]string {
+	t.Helper()
+	rows, err := db.Query(`
+        SELECT m.name, f."from" FROM sqlite_master m, pragma_foreign_key_list(m.name) f
+        WHERE m.type = 'table' AND f."table" = 'users'
+    `)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var columns [][2]string
+	for rows.Next() {
+		var c [2]string
+		if err := rows.Scan(&c[0], &c[1]); err != nil {
+			t.Fatal(err)
+		}
+		columns = append(columns, c)
+	}
+	return columns
+}
+
+// Новая таблица со ссылкой на пользователя должна попасть в один из списков слияния
+func TestMergeCoversUserReferences(t *testing.T) {
+	db := testDB(t)
+	known := map[[2]string]bool{}
+	for _, ref := range userRefs {
+		known[[2]string{ref.table, ref.column}] = true
+	}
+	for _, ref := range uniqueUserRefs {
+		known[[2]string{ref.table, ref.column}] = true
+	}
+	for _, table := range sourceCredentials {
+		known[[2]string{table, "user_id"}] = true
+	}
+	for _, c := range userColumns(t, db) {
+		if !known[c] {
+			t.Errorf("%s.%s is not handled by MergeUsers", c[0], c[1])
+		}
+	}
+}
+
+func TestMergeUsersMovesScopesAndDropsCredentials(t *testing.T) {
+	db := testDB(t)
+	h := &AdminHandler{DB: db}
+	admin := addUser(t, db, "admin", "admin")
+	target := addUser(t, db, "ivanov", "teacher")
+	source := addUser(t, db, "ivanov2", "teacher")
+
+	for _, stmt := range []string{
+		`INSERT INTO permission_scopes (user_id, course_id) VALUES ($1, 1)`,
+		`INSERT INTO permission_scopes (user_id, course_id) VALUES ($1, 2)`,
+		`INSERT INTO sessions (user_id, token_hash, csrf_token, last_seen_at, expires_at)
+         VALUES ($1, 'hash' || $1, 'csrf', CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)`,
+		`INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, 'code')`,
+		`INSERT INTO oauth_connections (user_id, provider, provider_user_id) VALUES ($1, 'vk', 'vk' || $1)`,
+		`INSERT INTO user_avatars (user_id, content_type, data, updated_at) VALUES ($1, 'image/png', x'00', CURRENT_TIMESTAMP)`,
+	} {
+		for _, id := range []int{target.UserID, source.UserID} {
+			if _, err := db.Exec(stmt, id); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	// у дубликата есть еще и своя область
+	if _, err := db.Exec(`INSERT INTO permission_scopes (user_id, course_id) VALUES ($1, 3)`, source.UserID); err != nil {
+		t.Fatal(err)
+	}
+
+	w := serve(h.MergeUsers, admin, "POST", `{"source_id": `+strconv.Itoa(source.UserID)+`}`,
+		"id", strconv.Itoa(target.UserID))
+	if w.Code != http.StatusOK {
+		t.Fatalf("merge: %d %s", w.Code, w.Body)
+	}
+
+	var scopes int
+	db.QueryRow(`SELECT COUNT(*) FROM permission_scopes WHERE user_id = $1`, target.UserID).Scan(&scopes)
+	if scopes != 3 {
+		t.Errorf("target has %d scopes, want 3", scopes)
+	}
+	for _, table := range []string{"sessions", "recovery_codes", "oauth_connections", "user_avatars"} {
+		var n int
+		db.QueryRow(`SELECT COUNT(*) FROM `+table+` WHERE user_id = $1`, target.UserID).Scan(&n)
+		if n != 1 {
+			t.Errorf("%s: target has %d rows, want its own 1", table, n)
+		}
+	}
+	for _, c := range userColumns(t, db) {
+		var n int
+		db.QueryRow(`SELECT COUNT(*) FROM `+c[0]+` WHERE `+c[1]+` = $1`, source.UserID).Scan(&n)
+		if n != 0 {
+			t.Errorf("%s.%s still references the merged account (%d rows)", c[0], c[1], n)
+		}
+	}
+}
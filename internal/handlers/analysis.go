@@ -0,0 +1,496 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+
+	"visualmath/internal/auth"
+	"visualmath/internal/models"
+)
+
+const (
+	// analysisMinResponses - меньше ответов на вопрос не хватает, чтобы делать выводы
+	analysisMinResponses = 10
+	// analysisGroupShare - доля сильных и слабых студентов для сравнения групп
+	analysisGroupShare = 0.27
+)
+
+// itemKey определяет вопрос независимо от теста, в который он входит
+type itemKey struct {
+	sourceModuleID, index int
+}
+
+// ItemAnalysis считает по первой завершенной попытке каждого студента трудность,
+// точечно-бисериальную корреляцию и выбор дистракторов для каждого вопроса,
+// а также альфу Кронбаха для модуля в целом
+func (h *AssessmentHandler) ItemAnalysis(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.GetUserFromContext(r.Context())
+	if !auth.HasPermission(h.DB, user, auth.PermProgressRead, auth.Scope{}) {
+		http.Error(w, "Доступ запрещен", http.StatusForbidden)
+		return
+	}
+	module, ok := assessmentModule(h.DB, w, r)
+	if !ok {
+		return
+	}
+
+	items, _, err := autoItems(h.DB, module)
+	if err != nil {
+		http.Error(w, "В модуле нет вопросов", http.StatusUnprocessableEntity)
+		return
+	}
+
+	answers, err := h.firstAttemptAnswers(module.ID, items)
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	analysis := analyzeItems(items, answers)
+	if err := h.addPollAnswers(analysis, items); err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+	analysis.ModuleID = module.ID
+	analysis.Title = module.Title
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(analysis)
+}
+
+// firstAttemptAnswers возвращает по первой завершенной попытке каждого студента
+// выбранные варианты: answers[попытка][вопрос], -1 - пропуск, -2 - вопрос не задавался.
+// Попытки без единого ответа не учитываются: пустая отправка вопросника ничего
+// не говорит о вопросах и только занижала бы их трудность.
+func (h *AssessmentHandler) firstAttemptAnswers(moduleID int, items []testItem) ([][]int, error) {
+	position := make(map[itemKey]int, len(items))
+	for i, item := range items {
+		position[itemKey{item.SourceModuleID, item.Index}] = i
+	}
+
+	rows, err := h.DB.Query(`
+        SELECT r.attempt_id, r.source_module_id, r.question_index, r.answer
+        FROM question_responses r
+        JOIN test_attempts a ON a.id = r.attempt_id
+        WHERE a.module_id = $1 AND a.finished_at IS NOT NULL
+          AND a.id = (
+              SELECT MIN(b.id) FROM test_attempts b
+              WHERE b.module_id = a.module_id AND b.student_id = a.student_id AND b.finished_at IS NOT NULL
+                AND EXISTS (
+                    SELECT 1 FROM question_responses q
+                    WHERE q.attempt_id = b.id AND (q.answer >= 0 OR COALESCE(q.text, '') <> '')
+                )
+          )
+        ORDER BY r.attempt_id
+    `, moduleID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var answers [][]int
+	lastAttempt := 0
+	for rows.Next() {
+		var attemptID, answer int
+		var key itemKey
+		if err := rows.Scan(&attemptID, &key.sourceModuleID, &key.index, &answer); err != nil {
+			return nil, err
+		}
+		if attemptID != lastAttempt {
+			row := make([]int, len(items))
+			for i := range row {
+				row[i] = -2
+			}
+			answers = append(answers, row)
+			lastAttempt = attemptID
+		}
+		// Вопрос могли удалить из теста после попытки
+		if i, ok := position[key]; ok {
+			answers[len(answers)-1][i] = answer
+		}
+	}
+	return answers, rows.Err()
+}
+
+// addPollAnswers добавляет к статистике вопросов ответы из опросов живых лекций
+func (h *AssessmentHandler) addPollAnswers(analysis *models.TestAnalysis, items []testItem) error {
+	position := make(map[itemKey]int, len(items))
+	for i, item := range items {
+		position[itemKey{item.SourceModuleID, item.Index}] = i
+		analysis.Questions[i].PollCounts = make([]int, len(item.Question.Answers))
+	}
+
+	var sources []int
+	seen := map[int]bool{}
+	for _, item := range items {
+		if !seen[item.SourceModuleID] {
+			seen[item.SourceModuleID] = true
+			sources = append(sources, item.SourceModuleID)
+		}
+	}
+	list, args := inList(nil, sources)
+	rows, err := h.DB.Query(`
+        SELECT p.module_id, p.question_index, a.answer, a.is_correct
+        FROM poll_answers a JOIN polls p ON p.id = a.poll_id
+        WHERE p.module_id IN (`+list+`)
+    `, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	correct := make([]int, len(items))
+	for rows.Next() {
+		var key itemKey
+		var answer int
+		var isCorrect bool
+		if err := rows.Scan(&key.sourceModuleID, &key.index, &answer, &isCorrect); err != nil {
+			return err
+		}
+		i, ok := position[key]
+		if !ok {
+			continue
+		}
+		stats := &analysis.Questions[i]
+		stats.PollResponses++
+		if answer >= 0 && answer < len(stats.PollCounts) {
+			stats.PollCounts[answer]++
+		}
+		if isCorrect {
+			correct[i]++
+		}
+	}
+	for i := range analysis.Questions {
+		if n := analysis.Questions[i].PollResponses; n > 0 {
+			analysis.Questions[i].PollDifficulty = float64(correct[i]) / float64(n)
+		}
+	}
+	return rows.Err()
+}
+
+// analyzeItems считает статистику по матрице ответов
+func analyzeItems(items []testItem, answers [][]int) *models.TestAnalysis {
+	n := len(answers)
+	analysis := &models.TestAnalysis{
+		Students:  n,
+		Questions: make([]models.QuestionStats, len(items)),
+	}
+
+	// Верность ответов и суммарный балл каждой попытки
+	correct := make([][]float64, n)
+	totals := make([]float64, n)
+	var percentSum float64
+	for a, row := range answers {
+		correct[a] = make([]float64, len(items))
+		presented := 0
+		for i, answer := range row {
+			if answer == -2 {
+				continue
+			}
+			presented++
+			if answer == items[i].Question.Correct {
+				correct[a][i] = 1
+				totals[a]++
+			}
+		}
+		percentSum += percentOf(totals[a], float64(presented))
+	}
+	if n > 0 {
+		analysis.MeanPercent = percentSum / float64(n)
+	}
+
+	upper, lower := extremeGroups(totals)
+
+	for i, item := range items {
+		stats := models.QuestionStats{
+			SourceModuleID: item.SourceModuleID,
+			QuestionIndex:  item.Index,
+			Question:       item.Question,
+			AnswerCounts:   make([]int, len(item.Question.Answers)),
+			AnswerShares:   make([]float64, len(item.Question.Answers)),
+			Flags:          []string{},
+		}
+
+		var xs, rest []float64
+		for a, row := range answers {
+			answer := row[i]
+			if answer == -2 {
+				continue
+			}
+			stats.Responses++
+			if answer >= 0 && answer < len(stats.AnswerCounts) {
+				stats.AnswerCounts[answer]++
+			} else {
+				stats.Skipped++
+			}
+			xs = append(xs, correct[a][i])
+			rest = append(rest, totals[a]-correct[a][i])
+		}
+
+		if stats.Responses > 0 {
+			stats.Difficulty = mean(xs)
+			for k, c := range stats.AnswerCounts {
+				stats.AnswerShares[k] = float64(c) / float64(stats.Responses)
+			}
+		}
+		stats.PointBiserial = correlation(xs, rest)
+		stats.UpperCorrect = groupCorrect(answers, correct, upper, i)
+		stats.LowerCorrect = groupCorrect(answers, correct, lower, i)
+		stats.Flags = itemFlags(stats, item.Question.Correct)
+
+		analysis.Questions[i] = stats
+	}
+
+	analysis.CronbachAlpha = cronbachAlpha(answers, correct)
+	return analysis
+}
+
+// itemFlags помечает вопросы, которые стоит переписать
+func itemFlags(stats models.QuestionStats, correctAnswer int) []string {
+	flags := []string{}
+	if stats.Responses < analysisMinResponses {
+		return append(flags, "few_responses")
+	}
+	for k, c := range stats.AnswerCounts {
+		if k != correctAnswer && c == 0 {
+			flags = append(flags, "unused_distractor")
+			break
+		}
+	}
+	if stats.UpperCorrect < stats.LowerCorrect || stats.PointBiserial < 0 {
+		flags = append(flags, "top_students_wrong")
+	} else if stats.PointBiserial < 0.2 {
+		flags = append(flags, "low_discrimination")
+	}
+	if stats.Difficulty > 0.9 {
+		flags = append(flags, "too_easy")
+	} else if stats.Difficulty < 0.2 {
+		flags = append(flags, "too_hard")
+	}
+	return flags
+}
+
+// extremeGroups возвращает номера попыток из верхних и нижних 27% по баллу
+func extremeGroups(totals []float64) (upper, lower []int) {
+	order := make([]int, len(totals))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return totals[order[a]] > totals[order[b]] })
+
+	size := int(math.Ceil(float64(len(totals)) * analysisGroupShare))
+	if size*2 > len(totals) {
+		size = len(totals) / 2
+	}
+	return order[:size], order[len(order)-size:]
+}
+
+func groupCorrect(answers [][]int, correct [][]float64, group []int, item int) float64 {
+	var sum float64
+	count := 0
+	for _, a := range group {
+		if answers[a][item] == -2 {
+			continue
+		}
+		sum += correct[a][item]
+		count++
+	}
+	if count == 0 {
+		return 0
+	}
+	return sum / float64(count)
+}
+
+// cronbachAlpha считается по попыткам, в которых были заданы все вопросы
+func cronbachAlpha(answers [][]int, correct [][]float64) *float64 {
+	var complete [][]float64
+	for a, row := range answers {
+		full := true
+		for _, answer := range row {
+			if answer == -2 {
+				full = false
+				break
+			}
+		}
+		if full {
+			complete = append(complete, correct[a])
+		}
+	}
+	if len(complete) < 2 || len(complete[0]) < 2 {
+		return nil
+	}
+
+	k := len(complete[0])
+	totals := make([]float64, len(complete))
+	var itemVariance float64
+	for i := 0; i < k; i++ {
+		column := make([]float64, len(complete))
+		for a, row := range complete {
+			column[a] = row[i]
+			totals[a] += row[i]
+		}
+		itemVariance += variance(column)
+	}
+	totalVariance := variance(totals)
+	if totalVariance == 0 {
+		return nil
+	}
+
+	alpha := float64(k) / float64(k-1) * (1 - itemVariance/totalVariance)
+	return &alpha
+}
+
+func mean(xs []float64) float64 {
+	if len(xs) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, x := range xs {
+		sum += x
+	}
+	return sum / float64(len(xs))
+}
+
+func variance(xs []float64) float64 {
+	m := mean(xs)
+	var sum float64
+	for _, x := range xs {
+		sum += (x - m) * (x - m)
+	}
+	if len(xs) == 0 {
+		return 0
+	}
+	return sum / float64(len(xs))
+}
+
+// correlation - коэффициент Пирсона, 0 если у одной из величин нет разброса
+func correlation(xs, ys []float64) float64 {
+	mx, my := mean(xs), mean(ys)
+	var cov, vx, vy float64
+	for i := range xs {
+		cov += (xs[i] - mx) * (ys[i] - my)
+		vx += (xs[i] - mx) * (xs[i] - mx)
+		vy += (ys[i] - my) * (ys[i] - my)
+	}
+	if vx == 0 || vy == 0 {
+		return 0
+	}
+	return cov / math.Sqrt(vx*vy)
+}
+
+// ItemAnalysisPage - страница анализа заданий для преподавателя
+func (h *AssessmentHandler) ItemAnalysisPage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+	html := `<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="UTF-8">
+    <title>Анализ заданий - VisualMath</title>
+    <script src="/static/js/csrf.js"></script>
+    <link rel="stylesheet" href="/static/css/style.css">
+    <style>
+        .analysis-container { max-width: 1200px; margin: 30px auto; padding: 0 20px; }
+        .summary { display: flex; gap: 20px; margin: 20px 0; }
+        .summary-card { background: white; border-radius: 10px; padding: 15px 25px; box-shadow: 0 2px 10px rgba(0,0,0,0.08); }
+        .summary-card b { display: block; font-size: 24px; color: #2c3e50; }
+        table { width: 100%; border-collapse: collapse; background: white; box-shadow: 0 2px 10px rgba(0,0,0,0.08); }
+        th, td { padding: 10px; border-bottom: 1px solid #eee; text-align: left; vertical-align: top; }
+        th { background: #f8f9fa; color: #2c3e50; }
+        tr.flagged { background: #fff5f5; }
+        .flag { display: inline-block; margin: 2px; padding: 2px 8px; border-radius: 10px; font-size: 12px; background: #f8d7da; color: #721c24; }
+        .flag.info { background: #e2e3e5; color: #383d41; }
+        .distractor { font-size: 13px; }
+        .distractor .bar { display: inline-block; height: 8px; background: #3498db; border-radius: 4px; vertical-align: middle; }
+        .distractor.correct .bar { background: #27ae60; }
+        .distractor.unused { color: #c0392b; }
+    </style>
+</head>
+<body>
+    <div class="analysis-container">
+        <a href="/modules">← К модулям</a>
+        <h1 id="title">Анализ заданий</h1>
+        <div class="summary" id="summary"></div>
+        <table>
+            <thead>
+                <tr><th>#</th><th>Вопрос</th><th>Ответов</th><th>Трудность (p)</th><th>r<sub>pb</sub></th>
+                    <th>Сильные / слабые</th><th>Выбор вариантов</th><th>Опросы на лекциях</th><th>Замечания</th></tr>
+            </thead>
+            <tbody id="questions"></tbody>
+        </table>
+    </div>
+    <script>
+        const flagLabels = {
+            few_responses: 'Мало ответов',
+            unused_distractor: 'Дистрактор не выбирают',
+            top_students_wrong: 'Сильные ошибаются чаще слабых',
+            low_discrimination: 'Слабо различает студентов',
+            too_easy: 'Слишком легкий',
+            too_hard: 'Слишком трудный'
+        };
+
+        function escapeHTML(s) {
+            const div = document.createElement('div');
+            div.textContent = s;
+            return div.innerHTML;
+        }
+
+        async function loadAnalysis() {
+            const id = window.location.pathname.split('/').pop();
+            const response = await fetch('/api/modules/' + id + '/analysis');
+            if (!response.ok) {
+                document.getElementById('title').textContent = 'Ошибка: ' + await response.text();
+                return;
+            }
+            const data = await response.json();
+
+            document.getElementById('title').textContent = 'Анализ заданий: ' + data.title;
+            const alpha = data.cronbach_alpha === null ? '—' : data.cronbach_alpha.toFixed(2);
+            document.getElementById('summary').innerHTML =
+                '<div class="summary-card">Студентов<b>' + data.students + '</b></div>' +
+                '<div class="summary-card">Средний результат<b>' + data.mean_percent.toFixed(1) + '%</b></div>' +
+                '<div class="summary-card">α Кронбаха<b>' + alpha + '</b></div>';
+
+            let html = '';
+            data.questions.forEach((q, i) => {
+                const serious = q.flags.filter(f => f !== 'few_responses');
+                let options = '';
+                q.question.answers.forEach((answer, k) => {
+                    const share = q.answer_shares[k] || 0;
+                    const cls = k === q.question.correct ? 'correct' : (q.answer_counts[k] === 0 ? 'unused' : '');
+                    options += '<div class="distractor ' + cls + '">' +
+                        '<span class="bar" style="width:' + Math.round(share * 80) + 'px"></span> ' +
+                        escapeHTML(answer) + ' — ' + q.answer_counts[k] + ' (' + Math.round(share * 100) + '%)</div>';
+                });
+                if (q.skipped > 0) {
+                    options += '<div class="distractor">Пропустили — ' + q.skipped + '</div>';
+                }
+                const flags = q.flags.map(f =>
+                    '<span class="flag' + (f === 'few_responses' ? ' info' : '') + '">' + (flagLabels[f] || f) + '</span>'
+                ).join('');
+
+                html += '<tr class="' + (serious.length ? 'flagged' : '') + '">' +
+                    '<td>' + (i + 1) + '</td>' +
+                    '<td>' + escapeHTML(q.question.question) + '</td>' +
+                    '<td>' + q.responses + '</td>' +
+                    '<td>' + q.difficulty.toFixed(2) + '</td>' +
+                    '<td>' + q.point_biserial.toFixed(2) + '</td>' +
+                    '<td>' + Math.round(q.upper_correct * 100) + '% / ' + Math.round(q.lower_correct * 100) + '%</td>' +
+                    '<td>' + options + '</td>' +
+                    '<td>' + (q.poll_responses ? 'p = ' + q.poll_difficulty.toFixed(2) + ' (' + q.poll_responses + ')' : '—') + '</td>' +
+                    '<td>' + flags + '</td>' +
+                    '</tr>';
+            });
+            document.getElementById('questions').innerHTML = html;
+        }
+
+        window.addEventListener('DOMContentLoaded', loadAnalysis);
+    </script>
+</body>
+</html>`
+
+	fmt.Fprint(w, html)
+}
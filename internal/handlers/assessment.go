@@ -0,0 +1,452 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"visualmath/internal/auth"
+	"visualmath/internal/models"
+)
+
+// AssessmentHandler - прохождение вопросников и тестов и анализ ответов
+type AssessmentHandler struct {
+	DB *sql.DB
+}
+
+// testItem - вопрос теста вместе с модулем-источником и весом
+type testItem struct {
+	SourceModuleID int
+	Index          int
+	Points         float64
+	Question       models.Question
+}
+
+var errNoQuestions = errors.New("module has no questions")
+
+// SubmitAttempt принимает ответы на все вопросы модуля question или test,
+// проверяет их и сохраняет попытку. answers[i] - номер выбранного варианта, -1 - пропуск,
+// texts[i] - развернутый ответ. Попытка с развернутыми ответами ждет проверки преподавателем.
+func (h *AssessmentHandler) SubmitAttempt(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.GetUserFromContext(r.Context())
+	module, ok := assessmentModule(h.DB, w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		LectureID   int       `json:"lecture_id"`
+		Answers     []int     `json:"answers"`
+		Confidences []int     `json:"confidences"` // уверенность 1-5 по каждому вопросу, необязательно
+		Texts       []string  `json:"texts"`       // ответы на вопросы типа free
+		StartedAt   time.Time `json:"started_at"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Неверный формат JSON", http.StatusBadRequest)
+		return
+	}
+
+	items, config, err := moduleItems(h.DB, module)
+	if err != nil {
+		http.Error(w, "В модуле нет вопросов", http.StatusUnprocessableEntity)
+		return
+	}
+	if config != nil && config.Adaptive {
+		http.Error(w, "Адаптивный тест проходится по одному вопросу", http.StatusBadRequest)
+		return
+	}
+	if len(req.Answers) > len(items) || len(req.Texts) > len(items) {
+		http.Error(w, "Ответов больше, чем вопросов", http.StatusBadRequest)
+		return
+	}
+	if !canAttempt(h.DB, w, user, module, req.LectureID) || !attemptAllowed(h.DB, w, module, config, req.LectureID, user.UserID) {
+		return
+	}
+	now := time.Now().UTC()
+	assignment, ok := assignmentGate(h.DB, w, user.UserID, req.LectureID, module.ID, now)
+	if !ok {
+		return
+	}
+
+	responses := make([]models.QuestionResponse, len(items))
+	var score, maxScore float64
+	status := "graded"
+	for i, item := range items {
+		maxScore += item.Points
+		if freeResponse(item.Question) {
+			responses[i] = models.QuestionResponse{
+				SourceModuleID: item.SourceModuleID,
+				QuestionIndex:  item.Index,
+				Answer:         -1,
+			}
+			if i < len(req.Texts) {
+				responses[i].Text = req.Texts[i]
+			}
+			status = "pending_review"
+			continue
+		}
+
+		answer := -1
+		if i < len(req.Answers) && req.Answers[i] >= 0 && req.Answers[i] < len(item.Question.Answers) {
+			answer = req.Answers[i]
+		}
+		correct := answer >= 0 && answer == item.Question.Correct
+		responses[i] = models.QuestionResponse{
+			SourceModuleID: item.SourceModuleID,
+			QuestionIndex:  item.Index,
+			Answer:         answer,
+			IsCorrect:      correct,
+		}
+		if i < len(req.Confidences) {
+			responses[i].Confidence = validConfidence(req.Confidences[i])
+		}
+		if correct {
+			score += item.Points
+		}
+	}
+
+	if req.StartedAt.IsZero() || req.StartedAt.After(now) {
+		req.StartedAt = now
+	}
+	attempt := models.TestAttempt{
+		StudentID:  user.UserID,
+		LectureID:  req.LectureID,
+		ModuleID:   module.ID,
+		Score:      score,
+		MaxScore:   maxScore,
+		Percent:    percentOf(score, maxScore),
+		Mode:       "fixed",
+		Status:     status,
+		StartedAt:  req.StartedAt.UTC(),
+		FinishedAt: &now,
+	}
+	if assignment != nil {
+		attempt.AssignmentID = assignment.ID
+		attempt.LateDays, attempt.Penalty = lateness(assignment, now)
+	}
+
+	if err := saveAttempt(h.DB, &attempt, items, responses); err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"success": true,
+		"message": "Ответы сохранены",
+		"attempt": attempt,
+	}
+	// Для тестов разбор ответов показываем, только если это разрешено в настройках
+	if status == "pending_review" {
+		response["message"] = "Ответы сохранены, развернутые ответы ждут проверки преподавателем"
+	}
+	showResults := config == nil || config.ShowResults
+	if config == nil && !auth.IsStaff(user.UserType) {
+		hidden, err := answersHiddenByTest(h.DB, module.ID)
+		if err != nil {
+			http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+			return
+		}
+		showResults = !hidden
+	}
+	if showResults {
+		results := make([]map[string]interface{}, len(items))
+		for i, item := range items {
+			if freeResponse(item.Question) {
+				results[i] = map[string]interface{}{"status": "pending"}
+				continue
+			}
+			results[i] = map[string]interface{}{
+				"answer":      responses[i].Answer,
+				"is_correct":  responses[i].IsCorrect,
+				"correct":     item.Question.Correct,
+				"explanation": item.Question.Explanation,
+			}
+		}
+		response["results"] = results
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// answersHiddenByTest - вопросник входит в тест, который не показывает разбор
+// (show_results = false). Разбор самого вопросника тогда тоже скрыт от студентов,
+// иначе ключ к тесту можно собрать, отправляя пустые ответы на его вопросники.
+func answersHiddenByTest(db *sql.DB, moduleID int) (bool, error) {
+	rows, err := db.Query(`SELECT content FROM modules WHERE module_type = 'test'`)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var content string
+		if err := rows.Scan(&content); err != nil {
+			return false, err
+		}
+		var config models.TestConfig
+		if json.Unmarshal([]byte(content), &config) != nil || config.ShowResults {
+			continue
+		}
+		for _, q := range config.Questions {
+			if q.ID == moduleID {
+				return true, nil
+			}
+		}
+	}
+	return false, rows.Err()
+}
+
+// canAttempt - студент может отвечать на модуль: в рамках лекции нужен доступ
+// к лекции (canViewLecture), без лекции - к самому модулю (canViewModule)
+func canAttempt(db *sql.DB, w http.ResponseWriter, user *auth.UserClaims, module *models.Module, lectureID int) bool {
+	if lectureID == 0 {
+		return canViewModule(db, w, user, module)
+	}
+	lecture, err := loadLecture(db, lectureID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Лекция не найдена", http.StatusNotFound)
+		return false
+	} else if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return false
+	}
+	return canViewLecture(db, w, user, lecture)
+}
+
+// attemptAllowed проверяет, что модуль входит в лекцию и что тест можно пройти еще раз
+func attemptAllowed(db *sql.DB, w http.ResponseWriter, module *models.Module, config *models.TestConfig, lectureID, studentID int) bool {
+	if lectureID != 0 {
+		var inLecture int
+		db.QueryRow(`
+            SELECT COUNT(*) FROM lecture_modules WHERE lecture_id = $1 AND module_id = $2
+        `, lectureID, module.ID).Scan(&inLecture)
+		if inLecture == 0 {
+			http.Error(w, "Модуль не входит в лекцию", http.StatusBadRequest)
+			return false
+		}
+	}
+
+	if config != nil && !config.AllowRetake {
+		var finished int
+		db.QueryRow(`
+            SELECT COUNT(*) FROM test_attempts
+            WHERE module_id = $1 AND student_id = $2 AND finished_at IS NOT NULL
+        `, module.ID, studentID).Scan(&finished)
+		if finished > 0 {
+			http.Error(w, "Тест уже пройден, повторная попытка не разрешена", http.StatusConflict)
+			return false
+		}
+	}
+	return true
+}
+
+// saveAttempt сохраняет завершенную попытку, ответы, владение навыками и, если попытка
+// сделана в рамках лекции, запись о прохождении модуля для журнала. responses[i] - ответ на items[i].
+func saveAttempt(db *sql.DB, attempt *models.TestAttempt, items []testItem, responses []models.QuestionResponse) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow(`
+        INSERT INTO test_attempts (student_id, lecture_id, module_id, score, max_score, status, started_at, finished_at,
+                                   assignment_id, late_days, penalty)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+        RETURNING id
+    `, attempt.StudentID, attempt.LectureID, attempt.ModuleID, attempt.Score, attempt.MaxScore,
+		attempt.Status, attempt.StartedAt, attempt.FinishedAt, attempt.AssignmentID, attempt.LateDays,
+		attempt.Penalty).Scan(&attempt.ID)
+	if err != nil {
+		return err
+	}
+
+	for i, resp := range responses {
+		var responseID int
+		err = tx.QueryRow(`
+            INSERT INTO question_responses (attempt_id, student_id, source_module_id, question_index, answer, is_correct, confidence, text, answered_at)
+            VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+            RETURNING id
+        `, attempt.ID, attempt.StudentID, resp.SourceModuleID, resp.QuestionIndex, resp.Answer, resp.IsCorrect,
+			resp.Confidence, resp.Text, attempt.FinishedAt).Scan(&responseID)
+		if err != nil {
+			return err
+		}
+		if freeResponse(items[i].Question) {
+			_, err = tx.Exec(`
+                INSERT INTO response_reviews (response_id, points, max_score) VALUES ($1, $2, $3)
+            `, responseID, items[i].Points, rubricMax(items[i]))
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		// Пропущенный вопрос ничего не говорит о владении навыком
+		if resp.Answer >= 0 {
+			err = updateMastery(tx, attempt.StudentID, resp.SourceModuleID, items[i].Question, resp.IsCorrect, *attempt.FinishedAt)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := insertProgress(tx, attempt); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// insertProgress отмечает модуль пройденным, если попытка сделана в рамках лекции или
+// задания. Попытка, ожидающая проверки, записывается незавершенной со статусом pending_review.
+// В журнал идет балл за вычетом штрафа за опоздание.
+func insertProgress(tx *sql.Tx, attempt *models.TestAttempt) error {
+	if attempt.LectureID == 0 && attempt.AssignmentID == 0 {
+		return nil
+	}
+	if attempt.Status == "pending_review" {
+		_, err := tx.Exec(`
+            INSERT INTO student_progress (student_id, lecture_id, module_id, completed, score, started_at, status,
+                                          attempt_id, assignment_id, late_days, penalty)
+            VALUES ($1, $2, $3, FALSE, 0, $4, 'pending_review', $5, $6, $7, $8)
+        `, attempt.StudentID, attempt.LectureID, attempt.ModuleID, attempt.StartedAt, attempt.ID,
+			attempt.AssignmentID, attempt.LateDays, attempt.Penalty)
+		return err
+	}
+	_, err := tx.Exec(`
+        INSERT INTO student_progress (student_id, lecture_id, module_id, completed, score, started_at, completed_at,
+                                      attempt_id, assignment_id, late_days, penalty)
+        VALUES ($1, $2, $3, TRUE, $4, $5, $6, $7, $8, $9, $10)
+    `, attempt.StudentID, attempt.LectureID, attempt.ModuleID, attempt.Percent*(1-attempt.Penalty), attempt.StartedAt,
+		attempt.FinishedAt, attempt.ID, attempt.AssignmentID, attempt.LateDays, attempt.Penalty)
+	return err
+}
+
+// assessmentModule - модуль question или test из {id} в URL
+func assessmentModule(db *sql.DB, w http.ResponseWriter, r *http.Request) (*models.Module, bool) {
+	moduleID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Неверный ID модуля", http.StatusBadRequest)
+		return nil, false
+	}
+	module, err := loadModule(db, moduleID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Модуль не найден", http.StatusNotFound)
+		return nil, false
+	} else if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return nil, false
+	}
+	if module.ModuleType != "question" && module.ModuleType != "test" {
+		http.Error(w, "Модуль не содержит вопросов", http.StatusBadRequest)
+		return nil, false
+	}
+	return module, true
+}
+
+// moduleItems раскрывает модуль в список вопросов. Вопросник содержит вопросы сам,
+// тест ссылается на вопросники через questions[].id. Для вопросника config равен nil.
+func moduleItems(db *sql.DB, module *models.Module) ([]testItem, *models.TestConfig, error) {
+	if module.ModuleType == "question" {
+		items, err := questionItems(module, 1)
+		return items, nil, err
+	}
+
+	var config models.TestConfig
+	if err := json.Unmarshal(module.Content, &config); err != nil {
+		return nil, nil, err
+	}
+
+	var items []testItem
+	for _, ref := range config.Questions {
+		source, err := loadModule(db, ref.ID)
+		if err == sql.ErrNoRows {
+			continue
+		} else if err != nil {
+			return nil, nil, err
+		}
+		if source.ModuleType != "question" {
+			continue
+		}
+		points := ref.Points
+		if points <= 0 {
+			points = 1
+		}
+		sourceItems, err := questionItems(source, points)
+		if err != nil && err != errNoQuestions {
+			return nil, nil, err
+		}
+		items = append(items, sourceItems...)
+	}
+	if len(items) == 0 {
+		return nil, nil, errNoQuestions
+	}
+	return items, &config, nil
+}
+
+func questionItems(module *models.Module, points float64) ([]testItem, error) {
+	var questions []models.Question
+	if err := json.Unmarshal(module.Content, &questions); err != nil || len(questions) == 0 {
+		return nil, errNoQuestions
+	}
+	items := make([]testItem, len(questions))
+	for i, q := range questions {
+		items[i] = testItem{SourceModuleID: module.ID, Index: i, Points: points, Question: q}
+	}
+	return items, nil
+}
+
+// autoItems - вопросы модуля с выбором ответа. Развернутые ответы проверяет
+// преподаватель, поэтому в адаптивных тестах и анализе заданий они не участвуют.
+func autoItems(db *sql.DB, module *models.Module) ([]testItem, *models.TestConfig, error) {
+	items, config, err := moduleItems(db, module)
+	if err != nil {
+		return nil, nil, err
+	}
+	auto := items[:0]
+	for _, item := range items {
+		if !freeResponse(item.Question) {
+			auto = append(auto, item)
+		}
+	}
+	if len(auto) == 0 {
+		return nil, nil, errNoQuestions
+	}
+	return auto, config, nil
+}
+
+func freeResponse(q models.Question) bool {
+	return q.Type == "free"
+}
+
+// rubricMax - максимум баллов за развернутый ответ: сумма критериев рубрики
+// или вес вопроса, если рубрики нет
+func rubricMax(item testItem) float64 {
+	var total float64
+	for _, c := range item.Question.Rubric {
+		total += c.Points
+	}
+	if total <= 0 {
+		return item.Points
+	}
+	return total
+}
+
+// validConfidence приводит уверенность к шкале 1-5, 0 - не указана
+func validConfidence(c int) int {
+	if c < 1 || c > 5 {
+		return 0
+	}
+	return c
+}
+
+func percentOf(part, total float64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return part * 100 / total
+}
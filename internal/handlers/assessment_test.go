@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+// addModule создает опубликованный модуль курса 1 и возвращает его ID
+func addModule(t *testing.T, db *sql.DB, moduleType, content string) int {
+	t.Helper()
+	var id int
+	err := db.QueryRow(`
+        INSERT INTO modules (title, course_id, module_type, content, published)
+        VALUES ('Модуль', 1, $1, $2, TRUE)
+        RETURNING id
+    `, moduleType, content).Scan(&id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+const questions = `[{"question": "2+2", "answers": ["3", "4"], "correct": 1, "explanation": "..."}]`
+
+func TestQuestionResultsHiddenByTest(t *testing.T) {
+	db := testDB(t)
+	h := &AssessmentHandler{DB: db}
+	student := addUser(t, db, "student", "student")
+	teacher := addUser(t, db, "teacher", "teacher")
+	db.Exec(`INSERT INTO enrollments (course_id, student_id) VALUES (1, $1)`, student.UserID)
+
+	open := addModule(t, db, "question", questions)
+	hidden := addModule(t, db, "question", questions)
+	addModule(t, db, "test", `{"show_results": true, "questions": [{"id": `+strconv.Itoa(open)+`}]}`)
+	addModule(t, db, "test", `{"show_results": false, "questions": [{"id": `+strconv.Itoa(hidden)+`}]}`)
+
+	cases := []struct {
+		name    string
+		module  int
+		user    string
+		results bool
+	}{
+		{"question outside hidden tests", open, "student", true},
+		{"question in a test without results", hidden, "student", false},
+		{"staff see results", hidden, "teacher", true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			user := student
+			if tc.user == "teacher" {
+				user = teacher
+			}
+			w := serve(h.SubmitAttempt, user, "POST", `{"answers": [-1]}`, "id", strconv.Itoa(tc.module))
+			if w.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", w.Code, w.Body)
+			}
+			var resp map[string]json.RawMessage
+			json.Unmarshal(w.Body.Bytes(), &resp)
+			if _, ok := resp["results"]; ok != tc.results {
+				t.Errorf("results present = %v, want %v: %s", ok, tc.results, w.Body)
+			}
+		})
+	}
+}
+
+func TestItemAnalysisSkipsBlankAttempts(t *testing.T) {
+	db := testDB(t)
+	h := &AssessmentHandler{DB: db}
+	student := addUser(t, db, "student", "student")
+	db.Exec(`INSERT INTO enrollments (course_id, student_id) VALUES (1, $1)`, student.UserID)
+	module := addModule(t, db, "question", questions)
+	id := strconv.Itoa(module)
+
+	// сначала пустая отправка, затем настоящий ответ
+	for _, body := range []string{`{"answers": [-1]}`, `{"answers": [1]}`} {
+		if w := serve(h.SubmitAttempt, student, "POST", body, "id", id); w.Code != http.StatusOK {
+			t.Fatalf("submit %s: %d %s", body, w.Code, w.Body)
+		}
+	}
+
+	m, err := loadModule(db, module)
+	if err != nil {
+		t.Fatal(err)
+	}
+	items, _, err := autoItems(db, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	answers, err := h.firstAttemptAnswers(module, items)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(answers) != 1 || answers[0][0] != 1 {
+		t.Errorf("first attempt answers = %v, want [[1]]", answers)
+	}
+}
@@ -0,0 +1,592 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"visualmath/internal/auth"
+	"visualmath/internal/models"
+)
+
+// AssignmentHandler - домашние задания для групп
+type AssignmentHandler struct {
+	DB *sql.DB
+}
+
+type assignmentRequest struct {
+	Title       string     `json:"title"`
+	Description string     `json:"description"`
+	LectureID   int        `json:"lecture_id"`
+	ModuleID    int        `json:"module_id"`
+	GroupIDs    []int      `json:"group_ids"`
+	OpensAt     time.Time  `json:"opens_at"`
+	DueAt       time.Time  `json:"due_at"`
+	ClosesAt    *time.Time `json:"closes_at"`
+	LatePenalty float64    `json:"late_penalty"`
+	MaxAttempts int        `json:"max_attempts"`
+}
+
+// CreateAssignment создает задание. Задание ссылается либо на лекцию, либо на тест.
+func (h *AssignmentHandler) CreateAssignment(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.GetUserFromContext(r.Context())
+	if !auth.HasPermission(h.DB, user, auth.PermAssignmentManage, auth.Scope{}) {
+		http.Error(w, "Доступ запрещен", http.StatusForbidden)
+		return
+	}
+
+	var req assignmentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Неверный формат JSON", http.StatusBadRequest)
+		return
+	}
+	if !h.validAssignment(w, &req) {
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var id int
+	err = tx.QueryRow(`
+        INSERT INTO assignments (title, description, lecture_id, module_id, opens_at, due_at, closes_at,
+                                 late_penalty, max_attempts, author_id)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+        RETURNING id
+    `, req.Title, req.Description, req.LectureID, req.ModuleID, req.OpensAt, req.DueAt, req.ClosesAt,
+		req.LatePenalty, req.MaxAttempts, user.UserID).Scan(&id)
+	if err == nil {
+		err = saveAssignmentGroups(tx, id, req.GroupIDs)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	assignment, err := loadAssignment(h.DB, id)
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":    true,
+		"assignment": assignment,
+	})
+}
+
+// ListAssignments - задания для преподавателя. Фильтры: ?lecture_id=, ?module_id=, ?group_id=
+func (h *AssignmentHandler) ListAssignments(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.GetUserFromContext(r.Context())
+	if !auth.HasPermission(h.DB, user, auth.PermAssignmentManage, auth.Scope{}) {
+		http.Error(w, "Доступ запрещен", http.StatusForbidden)
+		return
+	}
+
+	query := r.URL.Query()
+	lectureID, _ := strconv.Atoi(query.Get("lecture_id"))
+	moduleID, _ := strconv.Atoi(query.Get("module_id"))
+	groupID, _ := strconv.Atoi(query.Get("group_id"))
+
+	assignments, err := listAssignments(h.DB, `
+        WHERE ($1 = 0 OR a.lecture_id = $1) AND ($2 = 0 OR a.module_id = $2)
+          AND ($3 = 0 OR a.id IN (SELECT assignment_id FROM assignment_groups WHERE group_id = $3))
+        ORDER BY a.due_at, a.id
+    `, lectureID, moduleID, groupID)
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(assignments)
+}
+
+// GetAssignment возвращает задание
+func (h *AssignmentHandler) GetAssignment(w http.ResponseWriter, r *http.Request) {
+	assignment, ok := h.assignmentFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(assignment)
+}
+
+// UpdateAssignment меняет задание. Уже записанные опоздания не пересчитываются.
+func (h *AssignmentHandler) UpdateAssignment(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.GetUserFromContext(r.Context())
+	if !auth.HasPermission(h.DB, user, auth.PermAssignmentManage, auth.Scope{}) {
+		http.Error(w, "Доступ запрещен", http.StatusForbidden)
+		return
+	}
+	assignment, ok := h.assignmentFromURL(w, r)
+	if !ok {
+		return
+	}
+	if assignment.Archived {
+		http.Error(w, "Задание курса в архиве, изменения запрещены", http.StatusConflict)
+		return
+	}
+
+	var req assignmentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Неверный формат JSON", http.StatusBadRequest)
+		return
+	}
+	if !h.validAssignment(w, &req) {
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+        UPDATE assignments
+        SET title = $1, description = $2, lecture_id = $3, module_id = $4, opens_at = $5, due_at = $6,
+            closes_at = $7, late_penalty = $8, max_attempts = $9
+        WHERE id = $10
+    `, req.Title, req.Description, req.LectureID, req.ModuleID, req.OpensAt, req.DueAt, req.ClosesAt,
+		req.LatePenalty, req.MaxAttempts, assignment.ID)
+	if err == nil {
+		_, err = tx.Exec(`DELETE FROM assignment_groups WHERE assignment_id = $1`, assignment.ID)
+	}
+	if err == nil {
+		err = saveAssignmentGroups(tx, assignment.ID, req.GroupIDs)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	updated, err := loadAssignment(h.DB, assignment.ID)
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":    true,
+		"assignment": updated,
+	})
+}
+
+// DeleteAssignment удаляет задание. Попытки и прогресс студентов остаются.
+func (h *AssignmentHandler) DeleteAssignment(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.GetUserFromContext(r.Context())
+	if !auth.HasPermission(h.DB, user, auth.PermAssignmentManage, auth.Scope{}) {
+		http.Error(w, "Доступ запрещен", http.StatusForbidden)
+		return
+	}
+	assignment, ok := h.assignmentFromURL(w, r)
+	if !ok {
+		return
+	}
+	if assignment.Archived {
+		http.Error(w, "Задание курса в архиве, изменения запрещены", http.StatusConflict)
+		return
+	}
+
+	if _, err := h.DB.Exec(`DELETE FROM assignments WHERE id = $1`, assignment.ID); err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+	h.DB.Exec(`DELETE FROM assignment_groups WHERE assignment_id = $1`, assignment.ID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":       true,
+		"assignment_id": assignment.ID,
+	})
+}
+
+// MyAssignments - задания группы студента, начиная с самых срочных: просроченные, но еще
+// открытые; открытые по сроку сдачи; будущие; затем выполненные и пропущенные.
+func (h *AssignmentHandler) MyAssignments(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.GetUserFromContext(r.Context())
+
+	assignments, err := listAssignments(h.DB, `
+        WHERE a.id IN (
+            SELECT ag.assignment_id FROM assignment_groups ag
+            JOIN users u ON u.group_id = ag.group_id
+            WHERE u.id = $1) AND NOT a.archived
+        ORDER BY a.due_at, a.id
+    `, user.UserID)
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now().UTC()
+	result := make([]models.StudentAssignment, 0, len(assignments))
+	for _, a := range assignments {
+		sa, err := h.studentAssignment(a, user.UserID, now)
+		if err != nil {
+			http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+			return
+		}
+		result = append(result, sa)
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		ri, rj := urgency(result[i].Status), urgency(result[j].Status)
+		if ri != rj {
+			return ri < rj
+		}
+		if result[i].Status == "upcoming" {
+			return result[i].OpensAt.Before(result[j].OpensAt)
+		}
+		return result[i].DueAt.Before(result[j].DueAt)
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func urgency(status string) int {
+	switch status {
+	case "overdue":
+		return 0
+	case "open":
+		return 1
+	case "upcoming":
+		return 2
+	case "pending_review":
+		return 3
+	case "completed", "late":
+		return 4
+	default: // missed
+		return 5
+	}
+}
+
+// studentAssignment определяет состояние задания для студента по записям прогресса,
+// сделанным в рамках задания
+func (h *AssignmentHandler) studentAssignment(a models.Assignment, studentID int, now time.Time) (models.StudentAssignment, error) {
+	sa := models.StudentAssignment{Assignment: a}
+
+	err := h.DB.QueryRow(`
+        SELECT COUNT(*) FROM test_attempts WHERE assignment_id = $1 AND student_id = $2
+    `, a.ID, studentID).Scan(&sa.AttemptsUsed)
+	if err != nil {
+		return sa, err
+	}
+
+	// Лучший результат по каждому модулю задания
+	rows, err := h.DB.Query(`
+        SELECT module_id, MAX(score), MIN(late_days), MAX(completed), MAX(status = 'pending_review')
+        FROM student_progress
+        WHERE assignment_id = $1 AND student_id = $2
+        GROUP BY module_id
+    `, a.ID, studentID)
+	if err != nil {
+		return sa, err
+	}
+	var done, pending, lateDays int
+	var sum float64
+	for rows.Next() {
+		var moduleID, late int
+		var score float64
+		var completed, waiting bool
+		if err := rows.Scan(&moduleID, &score, &late, &completed, &waiting); err != nil {
+			rows.Close()
+			return sa, err
+		}
+		if completed {
+			done++
+			sum += score
+			if late > lateDays {
+				lateDays = late
+			}
+		} else if waiting {
+			pending++
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return sa, err
+	}
+
+	required := 1
+	if a.LectureID != 0 {
+		if err := h.DB.QueryRow(`
+            SELECT COUNT(*) FROM lecture_modules WHERE lecture_id = $1
+        `, a.LectureID).Scan(&required); err != nil {
+			return sa, err
+		}
+	}
+	if done > 0 {
+		score := sum / float64(done)
+		sa.Score = &score
+	}
+
+	switch {
+	case done >= required && required > 0:
+		sa.Status = "completed"
+		if lateDays > 0 {
+			sa.Status = "late"
+			sa.LateDays = lateDays
+		}
+	case pending > 0 && done+pending >= required:
+		sa.Status = "pending_review"
+	case now.Before(a.OpensAt):
+		sa.Status = "upcoming"
+	case a.ClosesAt != nil && !now.Before(*a.ClosesAt):
+		sa.Status = "missed"
+	case now.After(a.DueAt):
+		sa.Status = "overdue"
+	default:
+		sa.Status = "open"
+	}
+	return sa, nil
+}
+
+func (h *AssignmentHandler) validAssignment(w http.ResponseWriter, req *assignmentRequest) bool {
+	req.Title = strings.TrimSpace(req.Title)
+	if req.Title == "" {
+		http.Error(w, "Укажите название задания", http.StatusBadRequest)
+		return false
+	}
+	if (req.LectureID == 0) == (req.ModuleID == 0) {
+		http.Error(w, "Укажите лекцию или тест", http.StatusBadRequest)
+		return false
+	}
+	if req.LectureID != 0 {
+		var exists int
+		h.DB.QueryRow(`SELECT COUNT(*) FROM lectures WHERE id = $1`, req.LectureID).Scan(&exists)
+		if exists == 0 {
+			http.Error(w, "Лекция не найдена", http.StatusBadRequest)
+			return false
+		}
+		if lectureArchived(h.DB, req.LectureID) {
+			http.Error(w, "Лекция относится к курсу в архиве", http.StatusBadRequest)
+			return false
+		}
+	} else {
+		var moduleType string
+		h.DB.QueryRow(`SELECT module_type FROM modules WHERE id = $1`, req.ModuleID).Scan(&moduleType)
+		if moduleType != "test" && moduleType != "question" {
+			http.Error(w, "Тест не найден", http.StatusBadRequest)
+			return false
+		}
+	}
+
+	if len(req.GroupIDs) == 0 {
+		http.Error(w, "Укажите хотя бы одну группу", http.StatusBadRequest)
+		return false
+	}
+	for _, id := range req.GroupIDs {
+		if _, err := loadGroup(h.DB, id); err != nil {
+			http.Error(w, fmt.Sprintf("Группа %d не найдена", id), http.StatusBadRequest)
+			return false
+		}
+	}
+
+	if req.OpensAt.IsZero() {
+		req.OpensAt = time.Now()
+	}
+	req.OpensAt = req.OpensAt.UTC()
+	req.DueAt = req.DueAt.UTC()
+	if !req.DueAt.After(req.OpensAt) {
+		http.Error(w, "Срок сдачи должен быть позже открытия", http.StatusBadRequest)
+		return false
+	}
+	if req.ClosesAt != nil {
+		closes := req.ClosesAt.UTC()
+		if closes.Before(req.DueAt) {
+			http.Error(w, "Закрытие не может быть раньше срока сдачи", http.StatusBadRequest)
+			return false
+		}
+		req.ClosesAt = &closes
+	}
+	if req.LatePenalty < 0 || req.LatePenalty > 100 {
+		http.Error(w, "Штраф за день должен быть от 0 до 100%", http.StatusBadRequest)
+		return false
+	}
+	if req.MaxAttempts < 0 {
+		http.Error(w, "Число попыток не может быть отрицательным", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func saveAssignmentGroups(tx *sql.Tx, assignmentID int, groups []int) error {
+	for _, g := range groups {
+		if _, err := tx.Exec(`
+            INSERT OR IGNORE INTO assignment_groups (assignment_id, group_id) VALUES ($1, $2)
+        `, assignmentID, g); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// assignmentGate находит задание студента на модуль или на лекцию, в рамках которой он
+// проходится, и проверяет сроки и число попыток. Если задания нет, ограничений тоже нет.
+// Лекции архивных курсов пройти уже нельзя.
+func assignmentGate(db *sql.DB, w http.ResponseWriter, studentID, lectureID, moduleID int, now time.Time) (*models.Assignment, bool) {
+	if lectureID != 0 && lectureArchived(db, lectureID) {
+		http.Error(w, "Курс перенесен в архив, изменения запрещены", http.StatusConflict)
+		return nil, false
+	}
+
+	var id int
+	err := db.QueryRow(`
+        SELECT a.id FROM assignments a
+        JOIN assignment_groups ag ON ag.assignment_id = a.id
+        JOIN users u ON u.group_id = ag.group_id
+        WHERE u.id = $1 AND NOT a.archived
+          AND ((a.module_id != 0 AND a.module_id = $2) OR (a.lecture_id != 0 AND a.lecture_id = $3))
+        ORDER BY (a.opens_at <= $4 AND (a.closes_at IS NULL OR a.closes_at > $4)) DESC, a.due_at
+        LIMIT 1
+    `, studentID, moduleID, lectureID, now).Scan(&id)
+	if err == sql.ErrNoRows {
+		return nil, true
+	} else if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	a, err := loadAssignment(db, id)
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return nil, false
+	}
+	if now.Before(a.OpensAt) {
+		http.Error(w, "Задание еще не открыто", http.StatusForbidden)
+		return nil, false
+	}
+	if a.ClosesAt != nil && !now.Before(*a.ClosesAt) {
+		http.Error(w, "Прием работ по заданию закрыт", http.StatusForbidden)
+		return nil, false
+	}
+	if a.MaxAttempts > 0 {
+		var used int
+		db.QueryRow(`
+            SELECT COUNT(*) FROM test_attempts WHERE assignment_id = $1 AND student_id = $2 AND module_id = $3
+        `, a.ID, studentID, moduleID).Scan(&used)
+		if used >= a.MaxAttempts {
+			http.Error(w, "Попытки по заданию исчерпаны", http.StatusConflict)
+			return nil, false
+		}
+	}
+	return a, true
+}
+
+// lateness - число начатых суток опоздания и доля балла, снимаемая за них
+func lateness(a *models.Assignment, completedAt time.Time) (int, float64) {
+	if a == nil || !completedAt.After(a.DueAt) {
+		return 0, 0
+	}
+	days := int(math.Ceil(completedAt.Sub(a.DueAt).Hours() / 24))
+	penalty := float64(days) * a.LatePenalty / 100
+	if penalty > 1 {
+		penalty = 1
+	}
+	return days, penalty
+}
+
+// markLate отмечает опоздание завершенной попытки по сроку ее задания
+func markLate(db *sql.DB, attempt *models.TestAttempt) error {
+	if attempt.AssignmentID == 0 || attempt.FinishedAt == nil {
+		return nil
+	}
+	a, err := loadAssignment(db, attempt.AssignmentID)
+	if err == sql.ErrNoRows {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	attempt.LateDays, attempt.Penalty = lateness(a, *attempt.FinishedAt)
+	return nil
+}
+
+func (h *AssignmentHandler) assignmentFromURL(w http.ResponseWriter, r *http.Request) (*models.Assignment, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Неверный ID", http.StatusBadRequest)
+		return nil, false
+	}
+	assignment, err := loadAssignment(h.DB, id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Задание не найдено", http.StatusNotFound)
+		return nil, false
+	} else if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return nil, false
+	}
+	return assignment, true
+}
+
+const assignmentQuery = `
+    SELECT a.id, a.title, a.description, a.lecture_id, a.module_id,
+           COALESCE(l.title, m.title, ''), a.opens_at, a.due_at, a.closes_at, a.late_penalty,
+           a.max_attempts, COALESCE(a.author_id, 0), a.created_at, a.archived,
+           (SELECT json_group_array(json_object('id', id, 'name', name)) FROM (
+                SELECT g.id, g.name FROM assignment_groups ag JOIN groups g ON g.id = ag.group_id
+                WHERE ag.assignment_id = a.id ORDER BY g.name))
+    FROM assignments a
+    LEFT JOIN lectures l ON a.lecture_id != 0 AND l.id = a.lecture_id
+    LEFT JOIN modules m ON a.module_id != 0 AND m.id = a.module_id`
+
+func scanAssignment(row interface{ Scan(...interface{}) error }) (*models.Assignment, error) {
+	var a models.Assignment
+	var closesAt sql.NullTime
+	var groups string
+	err := row.Scan(&a.ID, &a.Title, &a.Description, &a.LectureID, &a.ModuleID, &a.TargetTitle,
+		&a.OpensAt, &a.DueAt, &closesAt, &a.LatePenalty, &a.MaxAttempts, &a.AuthorID, &a.CreatedAt, &a.Archived, &groups)
+	if err != nil {
+		return nil, err
+	}
+	if closesAt.Valid {
+		a.ClosesAt = &closesAt.Time
+	}
+	json.Unmarshal([]byte(groups), &a.Groups)
+	return &a, nil
+}
+
+func loadAssignment(db *sql.DB, id int) (*models.Assignment, error) {
+	return scanAssignment(db.QueryRow(assignmentQuery+` WHERE a.id = $1`, id))
+}
+
+func listAssignments(db *sql.DB, where string, args ...interface{}) ([]models.Assignment, error) {
+	rows, err := db.Query(assignmentQuery+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	assignments := []models.Assignment{}
+	for rows.Next() {
+		a, err := scanAssignment(rows)
+		if err != nil {
+			return nil, err
+		}
+		assignments = append(assignments, *a)
+	}
+	return assignments, rows.Err()
+}
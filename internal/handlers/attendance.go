@@ -0,0 +1,566 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"visualmath/internal/auth"
+	"visualmath/internal/live"
+	"visualmath/internal/models"
+	"visualmath/internal/qrcode"
+)
+
+// checkInWindow - период смены токена отметки. Принимается текущий и предыдущий
+// токен, так что QR-код действует не дольше двух периодов.
+const checkInWindow = 30 * time.Second
+
+// utf8BOM нужен Excel, чтобы правильно открыть CSV с кириллицей
+const utf8BOM = "\ufeff"
+
+type AttendanceHandler struct {
+	DB      *sql.DB
+	Hub     *live.Hub
+	Secret  string
+	BaseURL string
+}
+
+// CheckInToken возвращает текущий токен отметки и ссылку для QR-кода
+func (h *AttendanceHandler) CheckInToken(w http.ResponseWriter, r *http.Request) {
+	session, ok := h.teacherSession(w, r)
+	if !ok {
+		return
+	}
+
+	window := time.Now().Unix() / int64(checkInWindow.Seconds())
+	token := h.checkInToken(session.ID, window)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"token":      token,
+		"url":        h.checkInURL(token),
+		"expires_at": time.Unix((window+2)*int64(checkInWindow.Seconds()), 0),
+	})
+}
+
+// CheckInQR рисует QR-код со ссылкой отметки в PNG или SVG (?format=svg)
+func (h *AttendanceHandler) CheckInQR(w http.ResponseWriter, r *http.Request) {
+	session, ok := h.teacherSession(w, r)
+	if !ok {
+		return
+	}
+
+	window := time.Now().Unix() / int64(checkInWindow.Seconds())
+	code, err := qrcode.Encode(h.checkInURL(h.checkInToken(session.ID, window)))
+	if err != nil {
+		http.Error(w, "Ошибка генерации QR-кода", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	if r.URL.Query().Get("format") == "svg" {
+		w.Header().Set("Content-Type", "image/svg+xml")
+		fmt.Fprint(w, code.SVG(8))
+		return
+	}
+
+	image, err := code.PNG(8)
+	if err != nil {
+		http.Error(w, "Ошибка генерации QR-кода", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Write(image)
+}
+
+// CheckIn отмечает текущего пользователя на живой лекции по токену из QR-кода
+func (h *AttendanceHandler) CheckIn(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.GetUserFromContext(r.Context())
+
+	var req struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Неверный формат JSON", http.StatusBadRequest)
+		return
+	}
+
+	sessionID, ok := h.verifyCheckInToken(req.Token)
+	if !ok {
+		http.Error(w, "QR-код устарел, отсканируйте его еще раз", http.StatusBadRequest)
+		return
+	}
+
+	session, err := loadLiveSession(h.DB, sessionID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Сессия не найдена", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+	if session.EndedAt != nil {
+		http.Error(w, "Лекция уже завершена", http.StatusGone)
+		return
+	}
+
+	result, err := h.DB.Exec(`
+        INSERT OR IGNORE INTO attendance (session_id, user_id) VALUES ($1, $2)
+    `, session.ID, user.UserID)
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	if n, _ := result.RowsAffected(); n > 0 {
+		var present int
+		h.DB.QueryRow(`SELECT COUNT(*) FROM attendance WHERE session_id = $1`, session.ID).Scan(&present)
+		h.Hub.Broadcast(session.ID, live.Event{
+			Type: "checkin",
+			Data: map[string]int{"user_id": user.UserID, "present": present},
+			Role: "teacher",
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":    true,
+		"message":    "Вы отмечены на лекции",
+		"session_id": session.ID,
+		"lecture_id": session.LectureID,
+	})
+}
+
+// SessionReport - посещаемость одной живой лекции. Ожидаемый список берется из групп
+// (?group_id=, можно несколько), по умолчанию - из групп отметившихся студентов.
+// ?format=csv отдает файл для Excel.
+func (h *AttendanceHandler) SessionReport(w http.ResponseWriter, r *http.Request) {
+	session, ok := h.ownedSession(w, r)
+	if !ok {
+		return
+	}
+
+	groups, err := groupIDs(r.URL.Query()["group_id"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(groups) == 0 {
+		groups, err = h.attendeeGroups(session.ID)
+		if err != nil {
+			http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	records, err := h.sessionRecords(session.ID, groups)
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	if r.URL.Query().Get("format") == "csv" {
+		rows := [][]string{{"ФИО", "Логин", "Группа", "Статус", "Время отметки"}}
+		for _, rec := range records {
+			status, checkedIn := "отсутствовал", ""
+			if rec.Present {
+				status = "присутствовал"
+				checkedIn = rec.CheckedInAt.Local().Format("02.01.2006 15:04:05")
+			}
+			rows = append(rows, []string{rec.FullName, rec.Login, rec.GroupNumber, status, checkedIn})
+		}
+		writeCSV(w, fmt.Sprintf("attendance-session-%d.csv", session.ID), rows)
+		return
+	}
+
+	present := 0
+	for _, rec := range records {
+		if rec.Present {
+			present++
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"session": session,
+		"groups":  groups,
+		"present": present,
+		"total":   len(records),
+		"records": records,
+	})
+}
+
+// GroupReport - посещаемость группы (?group_id=) по всем живым лекциям,
+// на которых отмечался кто-то из группы. ?lecture_id= ограничивает одной лекцией.
+func (h *AttendanceHandler) GroupReport(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.GetUserFromContext(r.Context())
+	groupID, err := strconv.Atoi(r.URL.Query().Get("group_id"))
+	if err != nil {
+		http.Error(w, "Не указана группа", http.StatusBadRequest)
+		return
+	}
+	group, err := loadGroup(h.DB, groupID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Группа не найдена", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+	if !auth.HasPermission(h.DB, user, auth.PermProgressRead, auth.Scope{GroupID: group.ID}) {
+		http.Error(w, "Доступ запрещен", http.StatusForbidden)
+		return
+	}
+	lectureID, _ := strconv.Atoi(r.URL.Query().Get("lecture_id"))
+
+	students, err := h.groupStudents([]int{group.ID})
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	rows, err := h.DB.Query(`
+        SELECT DISTINCT s.id, s.lecture_id, s.started_at
+        FROM live_sessions s
+        JOIN attendance a ON a.session_id = s.id
+        JOIN users u ON u.id = a.user_id
+        WHERE u.group_id = $1 AND ($2 = 0 OR s.lecture_id = $2)
+        ORDER BY s.started_at
+    `, group.ID, lectureID)
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+	type sessionColumn struct {
+		ID        int       `json:"id"`
+		LectureID int       `json:"lecture_id"`
+		StartedAt time.Time `json:"started_at"`
+	}
+	sessions := []sessionColumn{}
+	for rows.Next() {
+		var s sessionColumn
+		if err := rows.Scan(&s.ID, &s.LectureID, &s.StartedAt); err != nil {
+			rows.Close()
+			http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+			return
+		}
+		sessions = append(sessions, s)
+	}
+	rows.Close()
+
+	// present[userID][sessionID]
+	present := make(map[int]map[int]bool)
+	rows, err = h.DB.Query(`
+        SELECT a.user_id, a.session_id
+        FROM attendance a JOIN users u ON u.id = a.user_id
+        WHERE u.group_id = $1
+    `, group.ID)
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+	for rows.Next() {
+		var userID, sessionID int
+		if err := rows.Scan(&userID, &sessionID); err != nil {
+			rows.Close()
+			http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+			return
+		}
+		if present[userID] == nil {
+			present[userID] = make(map[int]bool)
+		}
+		present[userID][sessionID] = true
+	}
+	rows.Close()
+
+	if r.URL.Query().Get("format") == "csv" {
+		header := []string{"ФИО", "Логин"}
+		for _, s := range sessions {
+			header = append(header, fmt.Sprintf("%s (лекция %d)", s.StartedAt.Local().Format("02.01.2006"), s.LectureID))
+		}
+		header = append(header, "Посещено", "%")
+		table := [][]string{header}
+
+		for _, st := range students {
+			row := []string{st.FullName, st.Login}
+			attended := 0
+			for _, s := range sessions {
+				mark := "-"
+				if present[st.UserID][s.ID] {
+					mark = "+"
+					attended++
+				}
+				row = append(row, mark)
+			}
+			row = append(row, fmt.Sprintf("%d/%d", attended, len(sessions)), percent(attended, len(sessions)))
+			table = append(table, row)
+		}
+		writeCSV(w, "attendance-group.csv", table)
+		return
+	}
+
+	type studentRow struct {
+		models.AttendanceRecord
+		Sessions []int `json:"sessions"`
+		Attended int   `json:"attended"`
+	}
+	result := []studentRow{}
+	for _, st := range students {
+		row := studentRow{AttendanceRecord: st, Sessions: []int{}}
+		for _, s := range sessions {
+			if present[st.UserID][s.ID] {
+				row.Sessions = append(row.Sessions, s.ID)
+				row.Attended++
+			}
+		}
+		row.Present = row.Attended > 0
+		result = append(result, row)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"group":    group,
+		"sessions": sessions,
+		"students": result,
+	})
+}
+
+// CheckInPage открывается после сканирования QR-кода и отправляет токен от имени студента.
+// Без входа RequireSession отправляет на /login и возвращает сюда с тем же ?t=.
+func (h *AttendanceHandler) CheckInPage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+	html := `<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>Отметка на лекции - VisualMath</title>
+    <script src="/static/js/csrf.js"></script>
+    <link rel="stylesheet" href="/static/css/style.css">
+</head>
+<body style="text-align: center; padding: 50px;">
+    <h1 id="title">⏳ Отмечаем...</h1>
+    <p id="message"></p>
+    <script>
+        (async function() {
+            // вход проверил сервер перед выдачей страницы, запрос идет с cookie сессии
+            const token = new URLSearchParams(window.location.search).get('t');
+            const response = await fetch('/api/attendance/checkin', {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({ token: token })
+            });
+            if (response.ok) {
+                const result = await response.json();
+                document.getElementById('title').textContent = '✅ ' + result.message;
+            } else {
+                document.getElementById('title').textContent = '❌ Не удалось отметиться';
+                document.getElementById('message').textContent = await response.text();
+            }
+        })();
+    </script>
+</body>
+</html>`
+
+	fmt.Fprint(w, html)
+}
+
+// checkInToken - идентификатор сессии, номер периода и обрезанная подпись HMAC
+func (h *AttendanceHandler) checkInToken(sessionID int, window int64) string {
+	mac := hmac.New(sha256.New, []byte(h.Secret))
+	fmt.Fprintf(mac, "checkin:%d:%d", sessionID, window)
+	return fmt.Sprintf("%d.%d.%s", sessionID, window, hex.EncodeToString(mac.Sum(nil)[:10]))
+}
+
+func (h *AttendanceHandler) verifyCheckInToken(token string) (int, bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return 0, false
+	}
+	sessionID, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, false
+	}
+	window, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	current := time.Now().Unix() / int64(checkInWindow.Seconds())
+	if window != current && window != current-1 {
+		return 0, false
+	}
+	if !hmac.Equal([]byte(token), []byte(h.checkInToken(sessionID, window))) {
+		return 0, false
+	}
+	return sessionID, true
+}
+
+func (h *AttendanceHandler) checkInURL(token string) string {
+	return strings.TrimRight(h.BaseURL, "/") + "/attendance/checkin?t=" + url.QueryEscape(token)
+}
+
+// teacherSession - активная сессия текущего преподавателя
+func (h *AttendanceHandler) teacherSession(w http.ResponseWriter, r *http.Request) (*models.LiveSession, bool) {
+	session, ok := h.ownedSession(w, r)
+	if !ok {
+		return nil, false
+	}
+	if session.EndedAt != nil {
+		http.Error(w, "Сессия завершена", http.StatusGone)
+		return nil, false
+	}
+	return session, true
+}
+
+// ownedSession - сессия текущего преподавателя, в том числе завершенная
+func (h *AttendanceHandler) ownedSession(w http.ResponseWriter, r *http.Request) (*models.LiveSession, bool) {
+	user, _ := auth.GetUserFromContext(r.Context())
+	session, ok := liveSessionFromURL(h.DB, w, r)
+	if !ok {
+		return nil, false
+	}
+	if session.TeacherID != user.UserID && !auth.IsAdmin(user) {
+		http.Error(w, "Доступ запрещен", http.StatusForbidden)
+		return nil, false
+	}
+	return session, true
+}
+
+func (h *AttendanceHandler) attendeeGroups(sessionID int) ([]int, error) {
+	rows, err := h.DB.Query(`
+        SELECT DISTINCT u.group_id
+        FROM attendance a JOIN users u ON u.id = a.user_id
+        WHERE a.session_id = $1 AND u.group_id IS NOT NULL
+        ORDER BY u.group_id
+    `, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	groups := []int{}
+	for rows.Next() {
+		var g int
+		if err := rows.Scan(&g); err != nil {
+			return nil, err
+		}
+		groups = append(groups, g)
+	}
+	return groups, rows.Err()
+}
+
+// groupStudents возвращает студентов групп, отсортированных по группе и ФИО
+func (h *AttendanceHandler) groupStudents(groups []int) ([]models.AttendanceRecord, error) {
+	students := []models.AttendanceRecord{}
+	if len(groups) == 0 {
+		return students, nil
+	}
+
+	list, args := inList(nil, groups)
+	rows, err := h.DB.Query(studentQuery+`
+        WHERE u.user_type = 'student' AND u.group_id IN (`+list+`)
+        ORDER BY g.name, pii_decrypt(u.full_name)
+    `, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rec models.AttendanceRecord
+		if err := scanStudent(rows, &rec); err != nil {
+			return nil, err
+		}
+		students = append(students, rec)
+	}
+	return students, rows.Err()
+}
+
+// sessionRecords объединяет ожидаемый список групп с фактически отметившимися
+func (h *AttendanceHandler) sessionRecords(sessionID int, groups []int) ([]models.AttendanceRecord, error) {
+	records, err := h.groupStudents(groups)
+	if err != nil {
+		return nil, err
+	}
+	index := make(map[int]int, len(records))
+	for i, rec := range records {
+		index[rec.UserID] = i
+	}
+
+	rows, err := h.DB.Query(`
+        SELECT a.user_id, COALESCE(pii_decrypt(u.full_name), ''), COALESCE(pii_decrypt(u.login), ''),
+               COALESCE(u.group_id, 0), COALESCE(g.name, ''), a.checked_in_at
+        FROM attendance a
+        LEFT JOIN users u ON u.id = a.user_id
+        LEFT JOIN groups g ON g.id = u.group_id
+        WHERE a.session_id = $1
+        ORDER BY a.checked_in_at
+    `, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rec models.AttendanceRecord
+		var checkedIn time.Time
+		if err := rows.Scan(&rec.UserID, &rec.FullName, &rec.Login, &rec.GroupID, &rec.GroupNumber, &checkedIn); err != nil {
+			return nil, err
+		}
+		if i, ok := index[rec.UserID]; ok {
+			records[i].Present = true
+			records[i].CheckedInAt = &checkedIn
+			continue
+		}
+		// Отметился студент не из ожидаемых групп - тоже показываем
+		rec.Present = true
+		rec.CheckedInAt = &checkedIn
+		records = append(records, rec)
+	}
+	return records, rows.Err()
+}
+
+// writeCSV отдает таблицу как CSV-файл с BOM для Excel
+func writeCSV(w http.ResponseWriter, filename string, rows [][]string) {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	fmt.Fprint(w, utf8BOM)
+
+	cw := csv.NewWriter(w)
+	for _, row := range rows {
+		escaped := make([]string, len(row))
+		for i, cell := range row {
+			escaped[i] = csvCell(cell)
+		}
+		cw.Write(escaped)
+	}
+	cw.Flush()
+}
+
+// csvCell не дает Excel выполнить ячейку как формулу: ФИО или логин,
+// начинающиеся с =, +, - или @, получают в начале апостроф. Одиночные
+// отметки посещаемости "+" и "-" формулой не станут и остаются как есть.
+func csvCell(s string) string {
+	if len(s) > 1 && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+func percent(part, total int) string {
+	if total == 0 {
+		return "0"
+	}
+	return strconv.Itoa(part * 100 / total)
+}
@@ -3,17 +3,26 @@ package handlers
 import (
 	"database/sql"
 	"encoding/json"
+	"log"
 	"net/http"
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
 	"golang.org/x/crypto/bcrypt"
+
+	"visualmath/internal/auth"
+	"visualmath/internal/ldap"
+	"visualmath/internal/loginlimit"
+	"visualmath/internal/mail"
 	//"visualmath/internal/models"
 )
 
 type AuthHandler struct {
 	DB        *sql.DB
 	JWTSecret string
+	LDAP      *ldap.Config        // nil - вход через каталог университета отключен
+	Limiter   *loginlimit.Limiter // nil - попытки входа не ограничены
+	Mailer    *mail.Mailer        // уведомления о блокировке входа
 }
 
 // RegisterRequest структура для регистрации
@@ -21,8 +30,8 @@ type RegisterRequest struct {
 	Login       string `json:"login"`
 	Password    string `json:"password"`
 	FullName    string `json:"full_name"`
-	UserType    string `json:"user_type"`
-	GroupNumber string `json:"group_number"`
+	UserType    string `json:"user_type"` // не учитывается: самостоятельно регистрируются только студенты
+	GroupID     int    `json:"group_id"` // группа из списка GET /api/groups
 	Email       string `json:"email"`
 }
 
@@ -30,6 +39,7 @@ type RegisterRequest struct {
 type LoginRequest struct {
 	Login    string `json:"login"`
 	Password string `json:"password"`
+	Session  string `json:"session"` // "cookie" - сессия в cookie вместо JWT, для страниц сайта
 }
 
 // Register обрабатывает регистрацию нового пользователя
@@ -42,22 +52,56 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Регистрация с одного адреса ограничена так же, как попытки входа
+	index, err := loginIndex(h.DB, req.Login)
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+	if !h.allowAttempt(w, r, accountKey(0, index)) {
+		return
+	}
+
 	// Проверяем обязательные поля
-	if req.Login == "" || req.Password == "" || req.FullName == "" ||
-		req.UserType == "" || req.Email == "" {
+	if req.Login == "" || req.Password == "" || req.FullName == "" || req.Email == "" {
 		http.Error(w, "Все обязательные поля должны быть заполнены", http.StatusBadRequest)
 		return
 	}
 
-	// Проверяем валидность типа пользователя
-	if req.UserType != "student" && req.UserType != "teacher" && req.UserType != "admin" {
-		http.Error(w, "Неверный тип пользователя", http.StatusBadRequest)
+	// Самостоятельно регистрируются только студенты. Преподавателей, ассистентов
+	// и администраторов назначает администратор через /api/admin/users.
+	req.UserType = "student"
+
+	// Студент выбирает одну из существующих групп
+	if req.GroupID == 0 {
+		http.Error(w, "Для студентов группа обязательна", http.StatusBadRequest)
 		return
 	}
+	if _, err := loadGroup(h.DB, req.GroupID); err == sql.ErrNoRows {
+		http.Error(w, "Группа не найдена", http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+	groupID := sql.NullInt64{Int64: int64(req.GroupID), Valid: true}
 
-	// Для студентов проверяем наличие номера группы
-	if req.UserType == "student" && req.GroupNumber == "" {
-		http.Error(w, "Для студентов номер группы обязателен", http.StatusBadRequest)
+	// Занятость логина и почты проверяется по слепым индексам, как и при входе
+	var loginTaken, emailTaken bool
+	err = h.DB.QueryRow(`
+        SELECT EXISTS (SELECT 1 FROM users WHERE login_index = pii_index($1)),
+               EXISTS (SELECT 1 FROM users WHERE email_index = pii_index($2))
+    `, req.Login, req.Email).Scan(&loginTaken, &emailTaken)
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+	if loginTaken {
+		http.Error(w, "Пользователь с таким логином уже существует", http.StatusConflict)
+		return
+	}
+	if emailTaken {
+		http.Error(w, "Пользователь с таким email уже существует", http.StatusConflict)
 		return
 	}
 
@@ -70,8 +114,8 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 
 	// Сохраняем пользователя в базу данных
 	query := `
-        INSERT INTO users (login, password_hash, full_name, user_type, group_number, email)
-        VALUES ($1, $2, $3, $4, $5, $6)
+        INSERT INTO users (login, password_hash, full_name, user_type, group_id, email, login_index, email_index)
+        VALUES (pii_encrypt($1), $2, pii_encrypt($3), $4, $5, pii_encrypt($6), pii_index($1), pii_index($6))
         RETURNING id
     `
 
@@ -81,17 +125,12 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 		string(hashedPassword),
 		req.FullName,
 		req.UserType,
-		req.GroupNumber,
+		groupID,
 		req.Email,
 	).Scan(&userID)
 
 	if err != nil {
-		// Проверяем, если пользователь уже существует
-		if err.Error() == "pq: duplicate key value violates unique constraint" {
-			http.Error(w, "Пользователь с таким логином или email уже существует", http.StatusConflict)
-			return
-		}
-		http.Error(w, "Ошибка базы данных: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
 		return
 	}
 
@@ -119,78 +158,289 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Логин и пароль обязательны", http.StatusBadRequest)
 		return
 	}
+	if req.Session != "" && req.Session != "cookie" && req.Session != "jwt" {
+		http.Error(w, "Поле session: cookie или jwt", http.StatusBadRequest)
+		return
+	}
 
 	// Ищем пользователя в базе данных
-	query := `
-        SELECT id, login, password_hash, full_name, user_type, group_number, email
-        FROM users 
-        WHERE login = $1 OR email = $1
-    `
+	user, err := h.loadLoginUser(req.Login)
 
-	var user struct {
-		ID           int
-		Login        string
-		PasswordHash string
-		FullName     string
-		UserType     string
-		GroupNumber  sql.NullString
-		Email        string
+	// Ограничение попыток проверяется до bcrypt и запроса к каталогу
+	index, indexErr := loginIndex(h.DB, req.Login)
+	if indexErr != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+	account := accountKey(0, index)
+	if err == nil {
+		account = accountKey(user.ID, "")
+	}
+	if !h.allowAttempt(w, r, account) {
+		return
 	}
 
-	err := h.DB.QueryRow(query, req.Login).Scan(
-		&user.ID,
-		&user.Login,
-		&user.PasswordHash,
-		&user.FullName,
-		&user.UserType,
-		&user.GroupNumber,
-		&user.Email,
-	)
-
-	if err == sql.ErrNoRows {
+	switch {
+	case err == sql.ErrNoRows && h.LDAP != nil:
+		// Первый вход по учетной записи каталога: создаем пользователя
+		entry, err := h.LDAP.Authenticate(req.Login, req.Password)
+		if err != nil {
+			if err == ldap.ErrInvalidCredentials {
+				h.attemptFailed(r, account, nil, index)
+			}
+			respondLDAPError(w, req.Login, err)
+			return
+		}
+		if !h.provisionLDAPUser(w, entry) {
+			return
+		}
+		if user, err = h.loadLoginUser(entry.Login); err != nil {
+			http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+			return
+		}
+	case err == sql.ErrNoRows:
+		h.attemptFailed(r, account, nil, index)
 		http.Error(w, "Неверный логин или пароль", http.StatusUnauthorized)
 		return
-	} else if err != nil {
+	case err != nil:
 		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
 		return
+	case user.AuthSource == "ldap":
+		if h.LDAP == nil {
+			http.Error(w, "Вход через каталог университета отключен", http.StatusServiceUnavailable)
+			return
+		}
+		entry, err := h.LDAP.Authenticate(user.Login, req.Password)
+		if err != nil {
+			if err == ldap.ErrInvalidCredentials {
+				h.attemptFailed(r, account, user, "")
+			}
+			respondLDAPError(w, user.Login, err)
+			return
+		}
+		// ФИО и почта берутся из каталога при каждом входе
+		fullName, email := nonEmpty(entry.FullName, user.FullName), nonEmpty(entry.Email, user.Email)
+		if _, err := h.DB.Exec(`UPDATE users SET full_name = pii_encrypt($1), email = pii_encrypt($2), email_index = pii_index($2) WHERE id = $3`,
+			fullName, email, user.ID); err == nil {
+			user.FullName, user.Email = fullName, email
+		}
+	default:
+		// Проверяем пароль
+		err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password))
+		if err != nil {
+			h.attemptFailed(r, account, user, "")
+			http.Error(w, "Неверный логин или пароль", http.StatusUnauthorized)
+			return
+		}
 	}
 
-	// Проверяем пароль
-	err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password))
-	if err != nil {
-		http.Error(w, "Неверный логин или пароль", http.StatusUnauthorized)
+	if !user.Active {
+		http.Error(w, "Учетная запись отключена", http.StatusForbidden)
 		return
 	}
+	user.CookieSession = req.Session == "cookie"
 
-	// Генерируем JWT токен
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"user_id":   user.ID,
-		"login":     user.Login,
-		"user_type": user.UserType,
-		"exp":       time.Now().Add(24 * time.Hour).Unix(),
-	})
-
-	tokenString, err := token.SignedString([]byte(h.JWTSecret))
+	// Включенная или обязательная для роли двухфакторная аутентификация -
+	// вместо сессии выдается временный токен для второго шага
+	if user.TOTPEnabled {
+		h.respondSecondFactor(w, user, mfaVerify)
+		return
+	}
+	required, err := twoFactorRequired(h.DB, user.UserType)
 	if err != nil {
-		http.Error(w, "Ошибка генерации токена", http.StatusInternalServerError)
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+	if required {
+		h.respondSecondFactor(w, user, mfaSetup)
 		return
 	}
 
+	h.respondSession(w, r, user, nil)
+}
+
+// respondSession выдает JWT на 24 часа или, если вход запрошен с session=cookie,
+// ставит cookie сессии и возвращает CSRF-токен. extra добавляется в ответ,
+// например коды восстановления после подключения 2FA при входе.
+func (h *AuthHandler) respondSession(w http.ResponseWriter, r *http.Request, user *loginUser, extra map[string]interface{}) {
+	// вход завершен - неудачные попытки пароля и кода больше не считаются
+	h.attemptSucceeded(user.ID)
+	if _, err := h.DB.Exec(`UPDATE users SET last_login_at = $1 WHERE id = $2`, time.Now().UTC(), user.ID); err != nil {
+		log.Printf("Не удалось отметить вход пользователя %d: %v", user.ID, err)
+	}
+
 	// Формируем ответ
 	response := map[string]interface{}{
 		"success": true,
 		"message": "Вход выполнен успешно",
-		"token":   tokenString,
 		"user": map[string]interface{}{
 			"id":           user.ID,
 			"login":        user.Login,
 			"full_name":    user.FullName,
 			"user_type":    user.UserType,
+			"group_id":     user.GroupID.Int64,
 			"group_number": user.GroupNumber.String,
 			"email":        user.Email,
 		},
 	}
+	for k, v := range extra {
+		response[k] = v
+	}
+
+	if user.CookieSession {
+		csrf, err := auth.StartSession(h.DB, w, r, user.ID)
+		if err != nil {
+			http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+			return
+		}
+		response["csrf_token"] = csrf
+	} else {
+		// Генерируем JWT токен
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+			"user_id":   user.ID,
+			"login":     user.Login,
+			"user_type": user.UserType,
+			"exp":       time.Now().Add(24 * time.Hour).Unix(),
+		})
+		tokenString, err := token.SignedString([]byte(h.JWTSecret))
+		if err != nil {
+			http.Error(w, "Ошибка генерации токена", http.StatusInternalServerError)
+			return
+		}
+		response["token"] = tokenString
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
+
+// loginUser - учетная запись, найденная при входе
+type loginUser struct {
+	ID           int
+	Login        string
+	PasswordHash string
+	FullName     string
+	UserType     string
+	GroupID      sql.NullInt64
+	GroupNumber  sql.NullString
+	Email        string
+	Active       bool
+	AuthSource   string // local или ldap
+	TOTPEnabled  bool
+
+	CookieSession bool // не из базы: вход запрошен с session=cookie
+}
+
+const loginUserQuery = `
+    SELECT u.id, pii_decrypt(u.login), u.password_hash, pii_decrypt(u.full_name), u.user_type, u.group_id, g.name, pii_decrypt(u.email),
+           u.active, u.auth_source, u.totp_enabled
+    FROM users u
+    LEFT JOIN groups g ON g.id = u.group_id`
+
+func (h *AuthHandler) loadLoginUser(login string) (*loginUser, error) {
+	return scanLoginUser(h.DB.QueryRow(loginUserQuery+` WHERE u.login_index = pii_index($1) OR u.email_index = pii_index($1)`, login))
+}
+
+func (h *AuthHandler) loadLoginUserByID(id int) (*loginUser, error) {
+	return scanLoginUser(h.DB.QueryRow(loginUserQuery+` WHERE u.id = $1`, id))
+}
+
+func scanLoginUser(row *sql.Row) (*loginUser, error) {
+	var user loginUser
+	err := row.Scan(
+		&user.ID,
+		&user.Login,
+		&user.PasswordHash,
+		&user.FullName,
+		&user.UserType,
+		&user.GroupID,
+		&user.GroupNumber,
+		&user.Email,
+		&user.Active,
+		&user.AuthSource,
+		&user.TOTPEnabled,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// respondLDAPError отвечает на ошибку проверки пароля по каталогу
+func respondLDAPError(w http.ResponseWriter, login string, err error) {
+	switch err {
+	case ldap.ErrInvalidCredentials:
+		http.Error(w, "Неверный логин или пароль", http.StatusUnauthorized)
+	case ldap.ErrAmbiguous:
+		log.Printf("LDAP: логину %q соответствует несколько записей каталога", login)
+		http.Error(w, "Неверный логин или пароль", http.StatusUnauthorized)
+	default:
+		log.Printf("LDAP: %v", err)
+		http.Error(w, "Каталог университета недоступен, попробуйте позже", http.StatusBadGateway)
+	}
+}
+
+// provisionLDAPUser создает пользователя по записи каталога. Роль берется из групп
+// каталога, пароль VisualMath не задается - вход только через каталог.
+func (h *AuthHandler) provisionLDAPUser(w http.ResponseWriter, entry *ldap.Entry) bool {
+	if entry.Email == "" {
+		http.Error(w, "В каталоге университета не указан email, обратитесь к администратору", http.StatusUnprocessableEntity)
+		return false
+	}
+
+	var source string
+	err := h.DB.QueryRow(`SELECT auth_source FROM users WHERE login_index = pii_index($1) OR email_index = pii_index($2)`,
+		entry.Login, entry.Email).Scan(&source)
+	if err == nil {
+		if source == "ldap" {
+			return true // вход по почте вместо логина
+		}
+		http.Error(w, "Логин или email уже заняты учетной записью VisualMath", http.StatusConflict)
+		return false
+	} else if err != sql.ErrNoRows {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return false
+	}
+
+	// случайный пароль, который никто не узнает
+	password, err := generatePassword()
+	if err != nil {
+		http.Error(w, "Ошибка при создании пользователя", http.StatusInternalServerError)
+		return false
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		http.Error(w, "Ошибка при создании пользователя", http.StatusInternalServerError)
+		return false
+	}
+
+	_, err = h.DB.Exec(`
+        INSERT INTO users (login, password_hash, full_name, user_type, email, auth_source, login_index, email_index)
+        VALUES (pii_encrypt($1), $2, pii_encrypt($3), $4, pii_encrypt($5), 'ldap', pii_index($1), pii_index($5))
+    `, entry.Login, string(hash), nonEmpty(entry.FullName, entry.Login), entry.Role, entry.Email)
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return false
+	}
+	log.Printf("LDAP: создан пользователь %s (%s), роль %s", entry.Login, entry.DN, entry.Role)
+	return true
+}
+
+func nonEmpty(value, fallback string) string {
+	if value != "" {
+		return value
+	}
+	return fallback
+}
+
+// Logout завершает сессию браузера и удаляет ее cookie
+func (h *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	if err := auth.EndSession(h.DB, w, r); err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+	})
+}
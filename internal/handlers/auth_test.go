@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegisterRejectsTakenLoginAndEmail(t *testing.T) {
+	db := testDB(t)
+	h := &AuthHandler{DB: db}
+	addUser(t, db, "ivanov", "student")
+	if _, err := db.Exec(`INSERT INTO groups (id, name) VALUES (1, 'М-101')`); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name  string
+		login string
+		email string
+		want  int
+		text  string
+	}{
+		{"taken login", "ivanov", "new@uni.ru", http.StatusConflict, "логином"},
+		// индекс нормализован, поэтому регистр и пробелы не помогают
+		{"taken login other case", " Ivanov ", "new@uni.ru", http.StatusConflict, "логином"},
+		{"taken email", "petrov", "ivanov@uni.ru", http.StatusConflict, "email"},
+		{"free", "petrov", "petrov@uni.ru", http.StatusOK, ""},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			body := `{"login": "` + tc.login + `", "password": "secret", "full_name": "Петров",
+				"group_id": 1, "email": "` + tc.email + `"}`
+			w := httptest.NewRecorder()
+			h.Register(w, httptest.NewRequest("POST", "/api/register", strings.NewReader(body)))
+			if w.Code != tc.want {
+				t.Fatalf("status %d, want %d: %s", w.Code, tc.want, w.Body)
+			}
+			if !strings.Contains(w.Body.String(), tc.text) {
+				t.Errorf("body %q does not mention %q", w.Body, tc.text)
+			}
+		})
+	}
+}
@@ -0,0 +1,429 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"visualmath/internal/auth"
+	"visualmath/internal/models"
+)
+
+// CourseHandler - курсы, их преподаватели и порядок лекций
+type CourseHandler struct {
+	DB *sql.DB
+}
+
+type courseRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Term        string `json:"term"`
+	TeacherIDs  []int  `json:"teacher_ids"`
+}
+
+// ListCourses возвращает действующие курсы. По нему заполняются списки предметов в редакторах.
+// ?archived=true - вместе с архивными.
+func (h *CourseHandler) ListCourses(w http.ResponseWriter, r *http.Request) {
+	archived := r.URL.Query().Get("archived") == "true"
+	courses, err := listCourses(h.DB, ` WHERE $1 OR NOT c.archived ORDER BY c.name, c.id`, archived)
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(courses)
+}
+
+// GetCourse возвращает курс с лекциями в порядке программы
+func (h *CourseHandler) GetCourse(w http.ResponseWriter, r *http.Request) {
+	course, ok := h.courseFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(course)
+}
+
+// CreateCourse создает курс. Если преподаватели не указаны, им становится автор.
+func (h *CourseHandler) CreateCourse(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.GetUserFromContext(r.Context())
+	if !auth.HasPermission(h.DB, user, auth.PermCourseManage, auth.Scope{}) {
+		http.Error(w, "Доступ запрещен", http.StatusForbidden)
+		return
+	}
+
+	var req courseRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Неверный формат JSON", http.StatusBadRequest)
+		return
+	}
+	if len(req.TeacherIDs) == 0 && !auth.IsAdmin(user) {
+		req.TeacherIDs = []int{user.UserID}
+	}
+	if !h.validCourse(w, &req) {
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var id int
+	err = tx.QueryRow(`
+        INSERT INTO courses (name, description, term) VALUES ($1, $2, $3)
+        ON CONFLICT (name) DO NOTHING
+        RETURNING id
+    `, req.Name, req.Description, req.Term).Scan(&id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Курс с таким названием уже есть", http.StatusConflict)
+		return
+	}
+	if err == nil {
+		err = saveCourseTeachers(tx, id, req.TeacherIDs)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	h.respondCourse(w, id)
+}
+
+// UpdateCourse меняет название, описание и состав преподавателей курса
+func (h *CourseHandler) UpdateCourse(w http.ResponseWriter, r *http.Request) {
+	course, ok := h.courseFromURL(w, r)
+	if !ok || !canManageCourse(h.DB, w, r, course) || !notArchived(w, course) {
+		return
+	}
+
+	req := courseRequest{Name: course.Name, Description: course.Description, Term: course.Term}
+	for _, t := range course.Teachers {
+		req.TeacherIDs = append(req.TeacherIDs, t.ID)
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Неверный формат JSON", http.StatusBadRequest)
+		return
+	}
+	if !h.validCourse(w, &req) {
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`UPDATE courses SET name = $1, description = $2, term = $3 WHERE id = $4`,
+		req.Name, req.Description, req.Term, course.ID)
+	if err != nil && strings.Contains(err.Error(), "UNIQUE") {
+		http.Error(w, "Курс с таким названием уже есть", http.StatusConflict)
+		return
+	}
+	if err == nil {
+		_, err = tx.Exec(`DELETE FROM course_teachers WHERE course_id = $1`, course.ID)
+	}
+	if err == nil {
+		err = saveCourseTeachers(tx, course.ID, req.TeacherIDs)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	h.respondCourse(w, course.ID)
+}
+
+// DeleteCourse удаляет курс без лекций и модулей
+func (h *CourseHandler) DeleteCourse(w http.ResponseWriter, r *http.Request) {
+	course, ok := h.courseFromURL(w, r)
+	if !ok || !canManageCourse(h.DB, w, r, course) || !notArchived(w, course) {
+		return
+	}
+	if course.LectureCount > 0 || course.ModuleCount > 0 {
+		http.Error(w, "В курсе есть лекции или модули", http.StatusConflict)
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`DELETE FROM course_teachers WHERE course_id = $1`, course.ID)
+	if err == nil {
+		_, err = tx.Exec(`DELETE FROM enrollments WHERE course_id = $1`, course.ID)
+	}
+	if err == nil {
+		_, err = tx.Exec(`DELETE FROM course_invites WHERE course_id = $1`, course.ID)
+	}
+	if err == nil {
+		_, err = tx.Exec(`DELETE FROM courses WHERE id = $1`, course.ID)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":   true,
+		"course_id": course.ID,
+	})
+}
+
+// ReorderLectures задает порядок лекций курса. lecture_ids должен содержать все лекции курса.
+func (h *CourseHandler) ReorderLectures(w http.ResponseWriter, r *http.Request) {
+	course, ok := h.courseFromURL(w, r)
+	if !ok || !canManageCourse(h.DB, w, r, course) || !notArchived(w, course) {
+		return
+	}
+
+	var req struct {
+		LectureIDs []int `json:"lecture_ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Неверный формат JSON", http.StatusBadRequest)
+		return
+	}
+
+	inCourse := make(map[int]bool, len(course.Lectures))
+	for _, l := range course.Lectures {
+		inCourse[l.ID] = true
+	}
+	if len(req.LectureIDs) != len(inCourse) {
+		http.Error(w, "Укажите все лекции курса", http.StatusBadRequest)
+		return
+	}
+	for _, id := range req.LectureIDs {
+		if !inCourse[id] {
+			http.Error(w, fmt.Sprintf("Лекция %d не относится к курсу или повторяется", id), http.StatusBadRequest)
+			return
+		}
+		delete(inCourse, id)
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	for i, id := range req.LectureIDs {
+		if _, err := tx.Exec(`UPDATE lectures SET course_position = $1 WHERE id = $2`, i+1, id); err != nil {
+			http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	h.respondCourse(w, course.ID)
+}
+
+func (h *CourseHandler) validCourse(w http.ResponseWriter, req *courseRequest) bool {
+	req.Name = strings.TrimSpace(req.Name)
+	req.Description = strings.TrimSpace(req.Description)
+	req.Term = strings.TrimSpace(req.Term)
+	if req.Name == "" {
+		http.Error(w, "Укажите название курса", http.StatusBadRequest)
+		return false
+	}
+	for _, id := range req.TeacherIDs {
+		var userType string
+		h.DB.QueryRow(`SELECT user_type FROM users WHERE id = $1`, id).Scan(&userType)
+		if userType != "teacher" && userType != "admin" {
+			http.Error(w, fmt.Sprintf("Пользователь %d не преподаватель", id), http.StatusBadRequest)
+			return false
+		}
+	}
+	return true
+}
+
+func (h *CourseHandler) respondCourse(w http.ResponseWriter, id int) {
+	course, err := loadCourse(h.DB, id)
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"course":  course,
+	})
+}
+
+func (h *CourseHandler) courseFromURL(w http.ResponseWriter, r *http.Request) (*models.Course, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Неверный ID", http.StatusBadRequest)
+		return nil, false
+	}
+	course, err := loadCourse(h.DB, id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Курс не найден", http.StatusNotFound)
+		return nil, false
+	} else if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return nil, false
+	}
+	return course, true
+}
+
+// canManageCourse - курс меняют те, у кого есть право course.manage в этом курсе.
+// Курс с составом преподавателей меняет только состав (и администраторы); курс без
+// преподавателей, например созданный миграцией, может взять любой с этим правом.
+func canManageCourse(db *sql.DB, w http.ResponseWriter, r *http.Request, course *models.Course) bool {
+	user, _ := auth.GetUserFromContext(r.Context())
+	if !auth.HasPermission(db, user, auth.PermCourseManage, auth.Scope{CourseID: course.ID}) {
+		http.Error(w, "Доступ запрещен", http.StatusForbidden)
+		return false
+	}
+	if auth.IsAdmin(user) || len(course.Teachers) == 0 {
+		return true
+	}
+	for _, t := range course.Teachers {
+		if t.ID == user.UserID {
+			return true
+		}
+	}
+	http.Error(w, "Доступ запрещен", http.StatusForbidden)
+	return false
+}
+
+// canEditCourses - у пользователя есть право permission в каждом из курсов.
+// При переносе лекции или модуля проверяются и прежний курс, и новый: право
+// в одном курсе не позволяет забрать или подменить контент другого.
+func canEditCourses(db *sql.DB, w http.ResponseWriter, r *http.Request, permission string, courseIDs ...int) bool {
+	user, _ := auth.GetUserFromContext(r.Context())
+	for _, id := range courseIDs {
+		if !auth.HasPermission(db, user, permission, auth.Scope{CourseID: id}) {
+			http.Error(w, "Доступ запрещен", http.StatusForbidden)
+			return false
+		}
+	}
+	return true
+}
+
+func saveCourseTeachers(tx *sql.Tx, courseID int, teacherIDs []int) error {
+	for _, id := range teacherIDs {
+		if _, err := tx.Exec(`
+            INSERT OR IGNORE INTO course_teachers (course_id, teacher_id) VALUES ($1, $2)
+        `, courseID, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// notArchived - курсы прошлых семестров вместе с результатами доступны только для чтения
+func notArchived(w http.ResponseWriter, course *models.Course) bool {
+	if course.Archived {
+		http.Error(w, "Курс перенесен в архив, изменения запрещены", http.StatusConflict)
+		return false
+	}
+	return true
+}
+
+// courseExists проверяет ссылку модуля или лекции на курс
+func courseExists(db *sql.DB, id int) bool {
+	var n int
+	db.QueryRow(`SELECT COUNT(*) FROM courses WHERE id = $1`, id).Scan(&n)
+	return n > 0
+}
+
+const courseQuery = `
+    SELECT c.id, c.name, c.description, c.term, c.archived, c.archived_at, c.previous_course_id,
+           (SELECT json_group_array(json_object('id', id, 'full_name', full_name)) FROM (
+                SELECT u.id, pii_decrypt(u.full_name) AS full_name FROM course_teachers ct JOIN users u ON u.id = ct.teacher_id
+                WHERE ct.course_id = c.id ORDER BY 2)),
+           (SELECT COUNT(*) FROM lectures l WHERE l.course_id = c.id),
+           (SELECT COUNT(*) FROM modules m WHERE m.course_id = c.id)
+    FROM courses c`
+
+func scanCourse(row interface{ Scan(...interface{}) error }) (*models.Course, error) {
+	var c models.Course
+	var teachers string
+	var archivedAt sql.NullTime
+	if err := row.Scan(&c.ID, &c.Name, &c.Description, &c.Term, &c.Archived, &archivedAt, &c.PreviousID,
+		&teachers, &c.LectureCount, &c.ModuleCount); err != nil {
+		return nil, err
+	}
+	if archivedAt.Valid {
+		c.ArchivedAt = &archivedAt.Time
+	}
+	json.Unmarshal([]byte(teachers), &c.Teachers)
+	return &c, nil
+}
+
+// loadCourse загружает курс вместе с лекциями в порядке программы
+func loadCourse(db *sql.DB, id int) (*models.Course, error) {
+	course, err := scanCourse(db.QueryRow(courseQuery+` WHERE c.id = $1`, id))
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`
+        SELECT id, title, course_position, published FROM lectures
+        WHERE course_id = $1
+        ORDER BY course_position, id
+    `, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	course.Lectures = []models.CourseLecture{}
+	for rows.Next() {
+		var l models.CourseLecture
+		if err := rows.Scan(&l.ID, &l.Title, &l.Position, &l.Published); err != nil {
+			return nil, err
+		}
+		course.Lectures = append(course.Lectures, l)
+	}
+	return course, rows.Err()
+}
+
+func listCourses(db *sql.DB, where string, args ...interface{}) ([]models.Course, error) {
+	rows, err := db.Query(courseQuery+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	courses := []models.Course{}
+	for rows.Next() {
+		c, err := scanCourse(rows)
+		if err != nil {
+			return nil, err
+		}
+		courses = append(courses, *c)
+	}
+	return courses, rows.Err()
+}
@@ -0,0 +1,614 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"visualmath/internal/auth"
+	"visualmath/internal/models"
+)
+
+// EnrollmentHandler - приглашения на курсы и записи студентов
+type EnrollmentHandler struct {
+	DB      *sql.DB
+	BaseURL string // для ссылок-приглашений
+}
+
+type inviteRequest struct {
+	GroupID          int        `json:"group_id"`
+	ExpiresAt        *time.Time `json:"expires_at"`
+	MaxUses          int        `json:"max_uses"`
+	RequiresApproval bool       `json:"requires_approval"`
+}
+
+// CreateInvite выдает код приглашения на курс. Код можно ограничить сроком,
+// числом использований и группой.
+func (h *EnrollmentHandler) CreateInvite(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.GetUserFromContext(r.Context())
+	course, ok := h.managedCourse(w, r)
+	if !ok || !notArchived(w, course) {
+		return
+	}
+
+	var req inviteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Неверный формат JSON", http.StatusBadRequest)
+		return
+	}
+	if req.MaxUses < 0 {
+		http.Error(w, "Число использований не может быть отрицательным", http.StatusBadRequest)
+		return
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		http.Error(w, "Срок действия уже истек", http.StatusBadRequest)
+		return
+	}
+	var groupID interface{}
+	if req.GroupID != 0 {
+		if _, err := loadGroup(h.DB, req.GroupID); err != nil {
+			http.Error(w, "Группа не найдена", http.StatusBadRequest)
+			return
+		}
+		groupID = req.GroupID
+	}
+	var expiresAt interface{}
+	if req.ExpiresAt != nil {
+		expiresAt = req.ExpiresAt.UTC()
+	}
+
+	// Код может совпасть с уже выданным, поэтому пробуем несколько раз
+	var id int
+	for attempt := 0; ; attempt++ {
+		code, err := generateJoinCode()
+		if err != nil {
+			http.Error(w, "Ошибка генерации кода", http.StatusInternalServerError)
+			return
+		}
+
+		err = h.DB.QueryRow(`
+            INSERT INTO course_invites (course_id, code, group_id, expires_at, max_uses, requires_approval, created_by)
+            VALUES ($1, $2, $3, $4, $5, $6, $7)
+            RETURNING id
+        `, course.ID, code, groupID, expiresAt, req.MaxUses, req.RequiresApproval, user.UserID).Scan(&id)
+		if err == nil {
+			break
+		}
+		if !strings.Contains(err.Error(), "UNIQUE") || attempt >= 4 {
+			http.Error(w, "Ошибка базы данных: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	invites, err := h.listInvites(` WHERE i.id = $1`, id)
+	if err != nil || len(invites) == 0 {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(invites[0])
+}
+
+// ListInvites возвращает приглашения курса, включая отозванные и истекшие
+func (h *EnrollmentHandler) ListInvites(w http.ResponseWriter, r *http.Request) {
+	course, ok := h.managedCourse(w, r)
+	if !ok {
+		return
+	}
+
+	invites, err := h.listInvites(` WHERE i.course_id = $1 ORDER BY i.created_at DESC, i.id DESC`, course.ID)
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(invites)
+}
+
+// RevokeInvite отзывает приглашение. Уже записавшиеся студенты остаются на курсе.
+func (h *EnrollmentHandler) RevokeInvite(w http.ResponseWriter, r *http.Request) {
+	course, ok := h.managedCourse(w, r)
+	if !ok {
+		return
+	}
+	inviteID, err := strconv.Atoi(chi.URLParam(r, "inviteID"))
+	if err != nil {
+		http.Error(w, "Неверный ID приглашения", http.StatusBadRequest)
+		return
+	}
+
+	res, err := h.DB.Exec(`
+        UPDATE course_invites SET revoked = TRUE WHERE id = $1 AND course_id = $2
+    `, inviteID, course.ID)
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Приглашение не найдено", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"message": "Приглашение отозвано",
+	})
+}
+
+// Enroll записывает студента на курс по коду приглашения
+func (h *EnrollmentHandler) Enroll(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.GetUserFromContext(r.Context())
+	if auth.IsStaff(user.UserType) {
+		http.Error(w, "Записаться на курс может только студент", http.StatusForbidden)
+		return
+	}
+
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Неверный формат JSON", http.StatusBadRequest)
+		return
+	}
+	code := strings.ToUpper(strings.TrimSpace(req.Code))
+	if code == "" {
+		http.Error(w, "Не указан код приглашения", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var inviteID, courseID, maxUses, uses, groupID int
+	var expiresAt sql.NullTime
+	var requiresApproval, revoked, archived bool
+	var courseName string
+	err = tx.QueryRow(`
+        SELECT i.id, i.course_id, c.name, COALESCE(i.group_id, 0), i.expires_at, i.max_uses, i.uses,
+               i.requires_approval, i.revoked, c.archived
+        FROM course_invites i
+        JOIN courses c ON c.id = i.course_id
+        WHERE i.code = $1
+    `, code).Scan(&inviteID, &courseID, &courseName, &groupID, &expiresAt, &maxUses, &uses,
+		&requiresApproval, &revoked, &archived)
+	if err == sql.ErrNoRows || (err == nil && revoked) {
+		http.Error(w, "Приглашение не найдено", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+	if archived {
+		http.Error(w, "Курс перенесен в архив", http.StatusGone)
+		return
+	}
+	if expiresAt.Valid && !time.Now().Before(expiresAt.Time) {
+		http.Error(w, "Срок действия приглашения истек", http.StatusGone)
+		return
+	}
+	if maxUses > 0 && uses >= maxUses {
+		http.Error(w, "Приглашение уже использовано максимальное число раз", http.StatusGone)
+		return
+	}
+	if groupID != 0 {
+		var studentGroup int
+		tx.QueryRow(`SELECT COALESCE(group_id, 0) FROM users WHERE id = $1`, user.UserID).Scan(&studentGroup)
+		if studentGroup != groupID {
+			http.Error(w, "Приглашение предназначено для другой группы", http.StatusForbidden)
+			return
+		}
+	}
+
+	status := "active"
+	if requiresApproval {
+		status = "pending"
+	}
+	var enrolledAt time.Time
+	err = tx.QueryRow(`
+        INSERT INTO enrollments (course_id, student_id, status, invite_id)
+        VALUES ($1, $2, $3, $4)
+        ON CONFLICT (course_id, student_id) DO NOTHING
+        RETURNING enrolled_at
+    `, courseID, user.UserID, status, inviteID).Scan(&enrolledAt)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Вы уже записаны на этот курс", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	// Лимит проверяем еще раз при обновлении: код могли использовать параллельно
+	res, err := tx.Exec(`
+        UPDATE course_invites SET uses = uses + 1
+        WHERE id = $1 AND (max_uses = 0 OR uses < max_uses)
+    `, inviteID)
+	if err == nil {
+		if n, _ := res.RowsAffected(); n == 0 {
+			http.Error(w, "Приглашение уже использовано максимальное число раз", http.StatusGone)
+			return
+		}
+		err = tx.Commit()
+	}
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	message := "Вы записаны на курс"
+	if requiresApproval {
+		message = "Заявка отправлена преподавателю"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":     true,
+		"message":     message,
+		"course_id":   courseID,
+		"course_name": courseName,
+		"status":      status,
+	})
+}
+
+// MyEnrollments возвращает курсы, на которые записан текущий студент
+func (h *EnrollmentHandler) MyEnrollments(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.GetUserFromContext(r.Context())
+
+	enrollments, err := h.listEnrollments(` WHERE e.student_id = $1 ORDER BY c.name`, user.UserID)
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(enrollments)
+}
+
+// ListEnrollments возвращает студентов курса. ?status=pending - только заявки.
+func (h *EnrollmentHandler) ListEnrollments(w http.ResponseWriter, r *http.Request) {
+	course, ok := h.managedCourse(w, r)
+	if !ok {
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	if status != "" && status != "pending" && status != "active" {
+		http.Error(w, "Неизвестный статус", http.StatusBadRequest)
+		return
+	}
+
+	enrollments, err := h.listEnrollments(`
+        WHERE e.course_id = $1 AND ($2 = '' OR e.status = $2)
+        ORDER BY e.status DESC, pii_decrypt(u.full_name)
+    `, course.ID, status)
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(enrollments)
+}
+
+// ApproveEnrollment подтверждает заявку студента
+func (h *EnrollmentHandler) ApproveEnrollment(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.GetUserFromContext(r.Context())
+	course, ok := h.managedCourse(w, r)
+	if !ok || !notArchived(w, course) {
+		return
+	}
+	studentID, err := strconv.Atoi(chi.URLParam(r, "studentID"))
+	if err != nil {
+		http.Error(w, "Неверный ID студента", http.StatusBadRequest)
+		return
+	}
+
+	res, err := h.DB.Exec(`
+        UPDATE enrollments SET status = 'active', approved_by = $1
+        WHERE course_id = $2 AND student_id = $3 AND status = 'pending'
+    `, user.UserID, course.ID, studentID)
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Заявка не найдена", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"message": "Заявка подтверждена",
+	})
+}
+
+// RemoveEnrollment отчисляет студента с курса или отклоняет заявку.
+// Результаты студента сохраняются.
+func (h *EnrollmentHandler) RemoveEnrollment(w http.ResponseWriter, r *http.Request) {
+	course, ok := h.managedCourse(w, r)
+	if !ok || !notArchived(w, course) {
+		return
+	}
+	studentID, err := strconv.Atoi(chi.URLParam(r, "studentID"))
+	if err != nil {
+		http.Error(w, "Неверный ID студента", http.StatusBadRequest)
+		return
+	}
+
+	res, err := h.DB.Exec(`
+        DELETE FROM enrollments WHERE course_id = $1 AND student_id = $2
+    `, course.ID, studentID)
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Студент не записан на курс", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"message": "Студент отчислен с курса",
+	})
+}
+
+// BulkEnroll записывает на курс всех студентов группы. Заявки группы при этом подтверждаются.
+func (h *EnrollmentHandler) BulkEnroll(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.GetUserFromContext(r.Context())
+	course, ok := h.managedCourse(w, r)
+	if !ok || !notArchived(w, course) {
+		return
+	}
+
+	var req struct {
+		GroupID int `json:"group_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Неверный формат JSON", http.StatusBadRequest)
+		return
+	}
+	if _, err := loadGroup(h.DB, req.GroupID); err != nil {
+		http.Error(w, "Группа не найдена", http.StatusBadRequest)
+		return
+	}
+
+	res, err := h.DB.Exec(`
+        INSERT INTO enrollments (course_id, student_id, status, approved_by)
+        SELECT $1, id, 'active', $2 FROM users
+        WHERE group_id = $3 AND user_type = 'student'
+        ON CONFLICT (course_id, student_id) DO UPDATE
+        SET status = 'active', approved_by = excluded.approved_by
+        WHERE enrollments.status = 'pending'
+    `, course.ID, user.UserID, req.GroupID)
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+	n, _ := res.RowsAffected()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"enrolled": n, // новые записи и подтвержденные заявки
+	})
+}
+
+// EnrollPage - страница записи по ссылке-приглашению. Код можно ввести и вручную.
+func (h *EnrollmentHandler) EnrollPage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+	html := `<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>Запись на курс - VisualMath</title>
+    <script src="/static/js/csrf.js"></script>
+    <link rel="stylesheet" href="/static/css/style.css">
+</head>
+<body style="text-align: center; padding: 50px;">
+    <h1 id="title">📚 Запись на курс</h1>
+    <form id="enrollForm">
+        <input type="text" id="code" placeholder="Код приглашения" required style="text-transform: uppercase;">
+        <button type="submit" class="btn btn-primary">Записаться</button>
+    </form>
+    <p id="message"></p>
+    <script>
+        document.getElementById('code').value = new URLSearchParams(window.location.search).get('code') || '';
+
+        document.getElementById('enrollForm').addEventListener('submit', async function(e) {
+            e.preventDefault();
+            const response = await fetch('/api/enroll', {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({ code: document.getElementById('code').value })
+            });
+            if (response.ok) {
+                const result = await response.json();
+                document.getElementById('title').textContent = '✅ ' + result.message;
+                document.getElementById('message').textContent = result.course_name;
+                document.getElementById('enrollForm').style.display = 'none';
+            } else {
+                document.getElementById('message').textContent = '❌ ' + await response.text();
+            }
+        });
+    </script>
+</body>
+</html>`
+
+	fmt.Fprint(w, html)
+}
+
+// managedCourse загружает курс из URL и проверяет, что текущий пользователь его ведет
+func (h *EnrollmentHandler) managedCourse(w http.ResponseWriter, r *http.Request) (*models.Course, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Неверный ID", http.StatusBadRequest)
+		return nil, false
+	}
+	course, err := loadCourse(h.DB, id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Курс не найден", http.StatusNotFound)
+		return nil, false
+	} else if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return nil, false
+	}
+	if !canManageCourse(h.DB, w, r, course) {
+		return nil, false
+	}
+	return course, true
+}
+
+func (h *EnrollmentHandler) listInvites(where string, args ...interface{}) ([]models.CourseInvite, error) {
+	rows, err := h.DB.Query(`
+        SELECT i.id, i.course_id, i.code, COALESCE(i.group_id, 0), COALESCE(g.name, ''), i.expires_at,
+               i.max_uses, i.uses, i.requires_approval, i.revoked, i.created_at
+        FROM course_invites i
+        LEFT JOIN groups g ON g.id = i.group_id
+    `+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invites := []models.CourseInvite{}
+	for rows.Next() {
+		var inv models.CourseInvite
+		var groupID int
+		var groupName string
+		var expiresAt sql.NullTime
+		if err := rows.Scan(&inv.ID, &inv.CourseID, &inv.Code, &groupID, &groupName, &expiresAt,
+			&inv.MaxUses, &inv.Uses, &inv.RequiresApproval, &inv.Revoked, &inv.CreatedAt); err != nil {
+			return nil, err
+		}
+		if groupID != 0 {
+			inv.Group = &models.GroupRef{ID: groupID, Name: groupName}
+		}
+		if expiresAt.Valid {
+			inv.ExpiresAt = &expiresAt.Time
+		}
+		inv.Link = strings.TrimRight(h.BaseURL, "/") + "/enroll?code=" + inv.Code
+		invites = append(invites, inv)
+	}
+	return invites, rows.Err()
+}
+
+func (h *EnrollmentHandler) listEnrollments(where string, args ...interface{}) ([]models.Enrollment, error) {
+	rows, err := h.DB.Query(`
+        SELECT e.course_id, c.name, e.student_id, pii_decrypt(u.login), pii_decrypt(u.full_name), COALESCE(g.name, ''),
+               e.status, e.enrolled_at
+        FROM enrollments e
+        JOIN courses c ON c.id = e.course_id
+        JOIN users u ON u.id = e.student_id
+        LEFT JOIN groups g ON g.id = u.group_id
+    `+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	enrollments := []models.Enrollment{}
+	for rows.Next() {
+		var e models.Enrollment
+		if err := rows.Scan(&e.CourseID, &e.CourseName, &e.StudentID, &e.Login, &e.FullName, &e.GroupName,
+			&e.Status, &e.EnrolledAt); err != nil {
+			return nil, err
+		}
+		enrollments = append(enrollments, e)
+	}
+	return enrollments, rows.Err()
+}
+
+// canViewLecture - преподаватели и ассистенты видят все лекции, студенты - только опубликованные
+// лекции курсов, на которые они записаны
+func canViewLecture(db *sql.DB, w http.ResponseWriter, user *auth.UserClaims, lecture *models.Lecture) bool {
+	if auth.IsStaff(user.UserType) {
+		return true
+	}
+	if !lecture.Published {
+		http.Error(w, "Лекция не найдена", http.StatusNotFound)
+		return false
+	}
+	if !isEnrolled(db, user.UserID, lecture.CourseID) {
+		http.Error(w, "Вы не записаны на этот курс", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// canViewModule - то же для модуля вне лекции: студенту доступны опубликованные
+// модули курсов, на которые он записан
+func canViewModule(db *sql.DB, w http.ResponseWriter, user *auth.UserClaims, module *models.Module) bool {
+	if auth.IsStaff(user.UserType) {
+		return true
+	}
+	if !module.Published {
+		http.Error(w, "Модуль не найден", http.StatusNotFound)
+		return false
+	}
+	if !isEnrolled(db, user.UserID, module.CourseID) {
+		http.Error(w, "Вы не записаны на этот курс", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// visibleModules оставляет модули, которые пользователь может открыть по canViewModule
+func visibleModules(db *sql.DB, user *auth.UserClaims, modules []models.Module) ([]models.Module, error) {
+	if auth.IsStaff(user.UserType) {
+		return modules, nil
+	}
+	rows, err := db.Query(`
+        SELECT course_id FROM enrollments WHERE student_id = $1 AND status = 'active'
+    `, user.UserID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	enrolled := map[int]bool{}
+	for rows.Next() {
+		var courseID int
+		if err := rows.Scan(&courseID); err != nil {
+			return nil, err
+		}
+		enrolled[courseID] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	visible := modules[:0]
+	for _, m := range modules {
+		if m.Published && enrolled[m.CourseID] {
+			visible = append(visible, m)
+		}
+	}
+	return visible, nil
+}
+
+func isEnrolled(db *sql.DB, studentID, courseID int) bool {
+	var n int
+	db.QueryRow(`
+        SELECT COUNT(*) FROM enrollments WHERE course_id = $1 AND student_id = $2 AND status = 'active'
+    `, courseID, studentID).Scan(&n)
+	return n > 0
+}
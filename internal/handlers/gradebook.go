@@ -0,0 +1,453 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"visualmath/internal/auth"
+	"visualmath/internal/models"
+	"visualmath/internal/xlsx"
+)
+
+// GradebookHandler - журнал оценок: студенты по строкам, лекции и тесты по столбцам
+type GradebookHandler struct {
+	DB *sql.DB
+}
+
+// attempt - выбранная по политике попытка прохождения модуля
+type attempt struct {
+	score       float64
+	ability     *float64
+	completedAt time.Time
+	late        bool
+	count       int
+}
+
+// GetGradebook отдает журнал. Параметры:
+// ?course_id= - лекции курса (по умолчанию все), ?group_id= - группа (можно несколько),
+// ?policy=best|last - лучший или последний результат, ?format=csv|xlsx - файл для Excel.
+func (h *GradebookHandler) GetGradebook(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.GetUserFromContext(r.Context())
+	if !auth.HasPermission(h.DB, user, auth.PermProgressRead, auth.Scope{}) {
+		http.Error(w, "Доступ запрещен", http.StatusForbidden)
+		return
+	}
+
+	query := r.URL.Query()
+	courseID, _ := strconv.Atoi(query.Get("course_id"))
+	policy := query.Get("policy")
+	if policy == "" {
+		policy = "best"
+	}
+	if policy != "best" && policy != "last" {
+		http.Error(w, "policy должен быть best или last", http.StatusBadRequest)
+		return
+	}
+
+	groups, err := groupIDs(query["group_id"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	groups, ok := permittedGroups(w, r, h.DB, auth.PermProgressRead, courseID, groups)
+	if !ok {
+		return
+	}
+
+	gradebook, err := h.buildGradebook(courseID, groups, policy)
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	switch query.Get("format") {
+	case "csv":
+		writeCSV(w, "gradebook.csv", stringRows(gradebookTable(gradebook, false)))
+	case "xlsx":
+		writeXLSX(w, "gradebook.xlsx", xlsx.Sheet{Name: "Журнал", Rows: gradebookTable(gradebook, true)})
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(gradebook)
+	}
+}
+
+func (h *GradebookHandler) buildGradebook(courseID int, groups []int, policy string) (*models.Gradebook, error) {
+	gradebook := &models.Gradebook{
+		Policy:  policy,
+		Columns: []models.GradebookColumn{},
+		Rows:    []models.GradebookRow{},
+	}
+
+	lectures, err := h.courseLectures(courseID)
+	if err != nil {
+		return nil, err
+	}
+	for _, l := range lectures {
+		gradebook.Columns = append(gradebook.Columns, models.GradebookColumn{
+			Kind:      "lecture",
+			LectureID: l.ID,
+			Title:     l.Title,
+		})
+		// Тесты лекции идут отдельными столбцами сразу после нее
+		for _, m := range l.Modules {
+			if m.Type != "test" {
+				continue
+			}
+			var config models.TestConfig
+			json.Unmarshal(m.Module, &config)
+			gradebook.Columns = append(gradebook.Columns, models.GradebookColumn{
+				Kind:         "test",
+				LectureID:    l.ID,
+				ModuleID:     m.ModuleID,
+				Title:        m.Title,
+				PassingScore: config.PassingScore,
+				Adaptive:     config.Adaptive,
+			})
+		}
+	}
+
+	students, err := groupStudents(h.DB, groups)
+	if err != nil {
+		return nil, err
+	}
+	attempts, err := h.attempts(lectures, policy)
+	if err != nil {
+		return nil, err
+	}
+	pending, err := h.pendingReviews()
+	if err != nil {
+		return nil, err
+	}
+
+	modulesByLecture := make(map[int][]models.LectureModule, len(lectures))
+	for _, l := range lectures {
+		modulesByLecture[l.ID] = l.Modules
+	}
+
+	for _, st := range students {
+		row := models.GradebookRow{
+			StudentID:   st.UserID,
+			FullName:    st.FullName,
+			Login:       st.Login,
+			GroupID:     st.GroupID,
+			GroupNumber: st.GroupNumber,
+			Cells:       make([]models.GradebookCell, len(gradebook.Columns)),
+		}
+		for i, col := range gradebook.Columns {
+			if col.Kind == "test" {
+				key := progressKey{st.UserID, col.LectureID, col.ModuleID}
+				row.Cells[i] = testCell(attempts[key], col.PassingScore)
+				row.Cells[i].Pending = pending[key]
+			} else {
+				row.Cells[i] = lectureCell(attempts, st.UserID, col.LectureID, modulesByLecture[col.LectureID])
+				for _, m := range modulesByLecture[col.LectureID] {
+					if pending[progressKey{st.UserID, col.LectureID, m.ModuleID}] {
+						row.Cells[i].Pending = true
+					}
+				}
+			}
+		}
+		gradebook.Rows = append(gradebook.Rows, row)
+	}
+	return gradebook, nil
+}
+
+// lectureCell - средний балл по пройденным модулям лекции; лекция завершена,
+// когда пройдены все модули, дата завершения - последнее из прохождений
+func lectureCell(attempts map[progressKey]*attempt, studentID, lectureID int, modules []models.LectureModule) models.GradebookCell {
+	var cell models.GradebookCell
+	var sum float64
+	var last time.Time
+	done := 0
+	for _, m := range modules {
+		a := attempts[progressKey{studentID, lectureID, m.ModuleID}]
+		if a == nil {
+			continue
+		}
+		done++
+		sum += a.score
+		cell.Attempts += a.count
+		cell.Late = cell.Late || a.late
+		if a.completedAt.After(last) {
+			last = a.completedAt
+		}
+	}
+	if done == 0 {
+		return cell
+	}
+	score := sum / float64(done)
+	cell.Score = &score
+	cell.Completed = done == len(modules)
+	if cell.Completed {
+		cell.CompletedAt = &last
+	}
+	return cell
+}
+
+func testCell(a *attempt, passingScore int) models.GradebookCell {
+	var cell models.GradebookCell
+	if a == nil {
+		return cell
+	}
+	score := a.score
+	passed := score >= float64(passingScore)
+	completedAt := a.completedAt
+	cell.Score = &score
+	cell.Passed = &passed
+	cell.Completed = true
+	cell.CompletedAt = &completedAt
+	cell.Attempts = a.count
+	cell.Ability = a.ability
+	cell.Late = a.late
+	return cell
+}
+
+func (h *GradebookHandler) courseLectures(courseID int) ([]*models.Lecture, error) {
+	rows, err := h.DB.Query(`
+        SELECT id FROM lectures
+        WHERE $1 = 0 OR course_id = $1
+        ORDER BY course_id, course_position, created_at, id
+    `, courseID)
+	if err != nil {
+		return nil, err
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	lectures := make([]*models.Lecture, 0, len(ids))
+	for _, id := range ids {
+		l, err := loadLecture(h.DB, id)
+		if err != nil {
+			return nil, err
+		}
+		lectures = append(lectures, l)
+	}
+	return lectures, nil
+}
+
+// groupStudents - студенты указанных групп (всех, если группы не заданы)
+func groupStudents(db *sql.DB, groups []int) ([]models.AttendanceRecord, error) {
+	where := "u.user_type = 'student'"
+	var args []interface{}
+	if len(groups) > 0 {
+		var list string
+		list, args = inList(args, groups)
+		where += " AND u.group_id IN (" + list + ")"
+	}
+
+	rows, err := db.Query(studentQuery+`
+        WHERE `+where+`
+        ORDER BY g.name, pii_decrypt(u.full_name)
+    `, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	students := []models.AttendanceRecord{}
+	for rows.Next() {
+		var st models.AttendanceRecord
+		if err := scanStudent(rows, &st); err != nil {
+			return nil, err
+		}
+		students = append(students, st)
+	}
+	return students, rows.Err()
+}
+
+type progressKey struct {
+	studentID, lectureID, moduleID int
+}
+
+// attempts выбирает по каждому модулю лучшую или последнюю попытку студента
+func (h *GradebookHandler) attempts(lectures []*models.Lecture, policy string) (map[progressKey]*attempt, error) {
+	result := make(map[progressKey]*attempt)
+	if len(lectures) == 0 {
+		return result, nil
+	}
+
+	placeholders := make([]string, len(lectures))
+	args := make([]interface{}, len(lectures))
+	for i, l := range lectures {
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+		args[i] = l.ID
+	}
+
+	rows, err := h.DB.Query(`
+        SELECT p.student_id, p.lecture_id, p.module_id, COALESCE(p.score, 0), p.completed_at, p.late_days > 0,
+               (SELECT t.ability FROM test_attempts t
+                WHERE t.student_id = p.student_id AND t.lecture_id = p.lecture_id
+                  AND t.module_id = p.module_id AND t.finished_at = p.completed_at
+                LIMIT 1)
+        FROM student_progress p
+        WHERE p.completed AND p.completed_at IS NOT NULL
+          AND p.lecture_id IN (`+strings.Join(placeholders, ", ")+`)
+        ORDER BY p.completed_at, p.id
+    `, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var key progressKey
+		var score float64
+		var completedAt time.Time
+		var late bool
+		var ability sql.NullFloat64
+		if err := rows.Scan(&key.studentID, &key.lectureID, &key.moduleID, &score, &completedAt, &late, &ability); err != nil {
+			return nil, err
+		}
+		a := result[key]
+		if a == nil {
+			a = &attempt{}
+			result[key] = a
+		}
+		a.count++
+		// Строки идут по времени, так что для last берем каждую следующую
+		if a.count == 1 || policy == "last" || score > a.score {
+			a.score = score
+			a.completedAt = completedAt
+			a.late = late
+			a.ability = nil
+			if ability.Valid {
+				a.ability = &ability.Float64
+			}
+		}
+	}
+	return result, rows.Err()
+}
+
+// pendingReviews - модули лекций, попытки по которым ждут проверки преподавателем
+func (h *GradebookHandler) pendingReviews() (map[progressKey]bool, error) {
+	rows, err := h.DB.Query(`
+        SELECT student_id, lecture_id, module_id FROM student_progress WHERE status = 'pending_review'
+    `)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pending := make(map[progressKey]bool)
+	for rows.Next() {
+		var key progressKey
+		if err := rows.Scan(&key.studentID, &key.lectureID, &key.moduleID); err != nil {
+			return nil, err
+		}
+		pending[key] = true
+	}
+	return pending, rows.Err()
+}
+
+// gradebookTable раскладывает журнал в таблицу: на каждый столбец журнала
+// приходятся балл, статус и дата. Для XLSX числа и даты остаются значениями.
+func gradebookTable(g *models.Gradebook, typed bool) [][]interface{} {
+	header := []interface{}{"ФИО", "Логин", "Группа"}
+	for _, col := range g.Columns {
+		title := col.Title
+		if col.Kind == "test" {
+			title = "Тест: " + title
+		}
+		header = append(header, title+" - балл", title+" - статус", title+" - дата")
+		if col.Adaptive {
+			header = append(header, title+" - способность")
+		}
+	}
+	table := [][]interface{}{header}
+
+	for _, row := range g.Rows {
+		line := []interface{}{row.FullName, row.Login, row.GroupNumber}
+		for i, cell := range row.Cells {
+			var score, date interface{}
+			if cell.Score != nil {
+				if typed {
+					score = *cell.Score
+				} else {
+					score = strconv.FormatFloat(*cell.Score, 'f', 1, 64)
+				}
+			}
+			if cell.CompletedAt != nil {
+				if typed {
+					date = cell.CompletedAt.Local()
+				} else {
+					date = cell.CompletedAt.Local().Format("02.01.2006 15:04")
+				}
+			}
+			line = append(line, score, cellStatus(cell), date)
+			if g.Columns[i].Adaptive {
+				var ability interface{}
+				if cell.Ability != nil {
+					if typed {
+						ability = *cell.Ability
+					} else {
+						ability = strconv.FormatFloat(*cell.Ability, 'f', 2, 64)
+					}
+				}
+				line = append(line, ability)
+			}
+		}
+		table = append(table, line)
+	}
+	return table
+}
+
+func cellStatus(cell models.GradebookCell) string {
+	status := baseStatus(cell)
+	if cell.Late && !cell.Pending {
+		status += ", с опозданием"
+	}
+	return status
+}
+
+func baseStatus(cell models.GradebookCell) string {
+	switch {
+	case cell.Pending:
+		return "на проверке"
+	case cell.Attempts == 0:
+		return "не начато"
+	case cell.Passed != nil && *cell.Passed:
+		return "зачтено"
+	case cell.Passed != nil:
+		return "не зачтено"
+	case cell.Completed:
+		return "пройдено"
+	default:
+		return "в процессе"
+	}
+}
+
+func stringRows(table [][]interface{}) [][]string {
+	rows := make([][]string, len(table))
+	for i, line := range table {
+		rows[i] = make([]string, len(line))
+		for j, v := range line {
+			if v != nil {
+				rows[i][j] = fmt.Sprint(v)
+			}
+		}
+	}
+	return rows
+}
+
+// writeXLSX отдает книгу Excel как файл
+func writeXLSX(w http.ResponseWriter, filename string, sheets ...xlsx.Sheet) {
+	w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	xlsx.Write(w, sheets...)
+}
@@ -0,0 +1,678 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+
+	"visualmath/internal/auth"
+	"visualmath/internal/models"
+)
+
+// GradingHandler - ручная проверка развернутых ответов
+type GradingHandler struct {
+	DB *sql.DB
+}
+
+// GradingQueue - очередь ответов на проверку. Параметры: ?status=pending|graded|released|all
+// (по умолчанию pending), ?lecture_id=, ?module_id=, ?group_id= (можно несколько).
+func (h *GradingHandler) GradingQueue(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.GetUserFromContext(r.Context())
+	if !auth.HasPermission(h.DB, user, auth.PermGradeWrite, auth.Scope{}) {
+		http.Error(w, "Доступ запрещен", http.StatusForbidden)
+		return
+	}
+
+	query := r.URL.Query()
+	status := query.Get("status")
+	if status == "" {
+		status = "pending"
+	}
+	lectureID, _ := strconv.Atoi(query.Get("lecture_id"))
+	moduleID, _ := strconv.Atoi(query.Get("module_id"))
+
+	groups, err := groupIDs(query["group_id"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Ассистент видит только ответы студентов своих групп
+	groups, ok := permittedGroups(w, r, h.DB, auth.PermGradeWrite, lectureCourse(h.DB, lectureID), groups)
+	if !ok {
+		return
+	}
+
+	where, args := gradingScope(lectureID, moduleID, groups)
+	if status != "all" {
+		args = append(args, status)
+		where += fmt.Sprintf(" AND rr.status = $%d", len(args))
+	}
+
+	responses, err := h.freeResponses(where+` ORDER BY r.answered_at, r.id`, args...)
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(responses)
+}
+
+// GetFreeResponse возвращает ответ вместе с вопросом и рубрикой
+func (h *GradingHandler) GetFreeResponse(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.GetUserFromContext(r.Context())
+	if !auth.HasPermission(h.DB, user, auth.PermGradeWrite, auth.Scope{}) {
+		http.Error(w, "Доступ запрещен", http.StatusForbidden)
+		return
+	}
+	response, ok := h.responseFromURL(w, r)
+	if !ok {
+		return
+	}
+	if !auth.HasPermission(h.DB, user, auth.PermGradeWrite, studentScope(h.DB, response.LectureID, response.StudentID)) {
+		http.Error(w, "Доступ запрещен", http.StatusForbidden)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// GradeFreeResponse сохраняет оценку ответа. Если у вопроса есть рубрика, балл - сумма
+// баллов по критериям, иначе передается score. Студент увидит оценку после публикации,
+// исправление уже опубликованной оценки сразу пересчитывает попытку.
+func (h *GradingHandler) GradeFreeResponse(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.GetUserFromContext(r.Context())
+	if !auth.HasPermission(h.DB, user, auth.PermGradeWrite, auth.Scope{}) {
+		http.Error(w, "Доступ запрещен", http.StatusForbidden)
+		return
+	}
+	response, ok := h.responseFromURL(w, r)
+	if !ok {
+		return
+	}
+	if !auth.HasPermission(h.DB, user, auth.PermGradeWrite, studentScope(h.DB, response.LectureID, response.StudentID)) {
+		http.Error(w, "Доступ запрещен", http.StatusForbidden)
+		return
+	}
+	if attemptArchived(h.DB, response.AttemptID) {
+		http.Error(w, "Курс перенесен в архив, изменения запрещены", http.StatusConflict)
+		return
+	}
+
+	var req struct {
+		Criteria []models.CriterionScore `json:"criteria"`
+		Comments []models.InlineComment  `json:"comments"`
+		Feedback string                  `json:"feedback"`
+		Score    *float64                `json:"score"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Неверный формат JSON", http.StatusBadRequest)
+		return
+	}
+
+	var score float64
+	if len(response.Rubric) > 0 {
+		seen := map[int]bool{}
+		for _, c := range req.Criteria {
+			if c.Criterion < 0 || c.Criterion >= len(response.Rubric) || seen[c.Criterion] {
+				http.Error(w, "Неверный номер критерия", http.StatusBadRequest)
+				return
+			}
+			if c.Points < 0 || c.Points > response.Rubric[c.Criterion].Points {
+				http.Error(w, "Баллы за критерий вне допустимого диапазона", http.StatusBadRequest)
+				return
+			}
+			seen[c.Criterion] = true
+			score += c.Points
+		}
+	} else {
+		if req.Score == nil || *req.Score < 0 || *req.Score > response.MaxScore {
+			http.Error(w, fmt.Sprintf("Укажите балл от 0 до %g", response.MaxScore), http.StatusBadRequest)
+			return
+		}
+		score = *req.Score
+		req.Criteria = nil
+	}
+
+	length := utf8.RuneCountInString(response.Text)
+	for _, c := range req.Comments {
+		if c.Start < 0 || c.End < c.Start || c.End > length || strings.TrimSpace(c.Text) == "" {
+			http.Error(w, "Неверный комментарий к фрагменту", http.StatusBadRequest)
+			return
+		}
+	}
+	if req.Criteria == nil {
+		req.Criteria = []models.CriterionScore{}
+	}
+	if req.Comments == nil {
+		req.Comments = []models.InlineComment{}
+	}
+	criteria, _ := json.Marshal(req.Criteria)
+	comments, _ := json.Marshal(req.Comments)
+
+	status := "graded"
+	if response.Status == "released" {
+		status = "released"
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	_, err = tx.Exec(`
+        UPDATE response_reviews
+        SET status = $1, score = $2, criteria = $3, comments = $4, feedback = $5, grader_id = $6, graded_at = $7
+        WHERE response_id = $8
+    `, status, score, string(criteria), string(comments), req.Feedback, user.UserID, now, response.ID)
+	if err == nil && status == "released" {
+		_, err = finalizeAttempt(tx, response.AttemptID)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	response.Status = status
+	response.Score = &score
+	response.Criteria = req.Criteria
+	response.Comments = req.Comments
+	response.Feedback = req.Feedback
+	response.GradedAt = &now
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"response": response,
+	})
+}
+
+// ReleaseGrades публикует проверенные ответы в рамках лекции, модуля или групп.
+// Попытки, в которых проверены и опубликованы все развернутые ответы, получают
+// итоговый балл, а модуль отмечается пройденным.
+func (h *GradingHandler) ReleaseGrades(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.GetUserFromContext(r.Context())
+	if !auth.HasPermission(h.DB, user, auth.PermGradeWrite, auth.Scope{}) {
+		http.Error(w, "Доступ запрещен", http.StatusForbidden)
+		return
+	}
+
+	var req struct {
+		LectureID int   `json:"lecture_id"`
+		ModuleID  int   `json:"module_id"`
+		GroupIDs  []int `json:"group_ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Неверный формат JSON", http.StatusBadRequest)
+		return
+	}
+
+	groups, ok := permittedGroups(w, r, h.DB, auth.PermGradeWrite, lectureCourse(h.DB, req.LectureID), req.GroupIDs)
+	if !ok {
+		return
+	}
+
+	where, args := gradingScope(req.LectureID, req.ModuleID, groups)
+	// Оценки архивных курсов не меняются, поэтому их ответы не выставляем
+	responses, err := h.freeResponses(where+` AND rr.status = 'graded' AND NOT `+archivedAttempt, args...)
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	questions := make([]*models.Question, len(responses))
+	for i, resp := range responses {
+		if q, ok := reviewQuestion(h.DB, resp.SourceModuleID, resp.QuestionIndex); ok {
+			questions[i] = &q
+		}
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	attempts := map[int]bool{}
+	for i, resp := range responses {
+		_, err := tx.Exec(`
+            UPDATE response_reviews SET status = 'released', released_at = $1 WHERE response_id = $2
+        `, now, resp.ID)
+		if err != nil {
+			http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+			return
+		}
+		// Для владения навыком ответ верен, если набрано не меньше половины баллов
+		if questions[i] != nil && resp.Score != nil {
+			correct := *resp.Score*2 >= resp.MaxScore
+			if err := updateMastery(tx, resp.StudentID, resp.SourceModuleID, *questions[i], correct, now); err != nil {
+				http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+				return
+			}
+		}
+		attempts[resp.AttemptID] = true
+	}
+
+	finished := 0
+	for attemptID := range attempts {
+		done, err := finalizeAttempt(tx, attemptID)
+		if err != nil {
+			http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+			return
+		}
+		if done {
+			finished++
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":         true,
+		"released":        len(responses),
+		"attempts_graded": finished,
+	})
+}
+
+// MyFreeResponses - развернутые ответы студента. Оценка и комментарии видны
+// только после публикации, до этого ответ показывается как ожидающий проверки.
+func (h *GradingHandler) MyFreeResponses(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.GetUserFromContext(r.Context())
+
+	responses, err := h.freeResponses(` WHERE r.student_id = $1 ORDER BY r.answered_at DESC, r.id DESC`, user.UserID)
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+	for i := range responses {
+		if responses[i].Status != "released" {
+			responses[i].Status = "pending"
+			responses[i].Score = nil
+			responses[i].Criteria = []models.CriterionScore{}
+			responses[i].Comments = []models.InlineComment{}
+			responses[i].Feedback = ""
+			responses[i].GradedAt = nil
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(responses)
+}
+
+// finalizeAttempt подводит итог попытки, если все ее развернутые ответы опубликованы:
+// пересчитывает балл и отмечает модуль пройденным в лекции
+func finalizeAttempt(tx *sql.Tx, attemptID int) (bool, error) {
+	var unreleased int
+	var manual float64
+	err := tx.QueryRow(`
+        SELECT COALESCE(SUM(rr.status != 'released'), 0),
+               COALESCE(SUM(CASE WHEN rr.max_score > 0 THEN rr.points * COALESCE(rr.score, 0) / rr.max_score END), 0)
+        FROM response_reviews rr
+        JOIN question_responses r ON r.id = rr.response_id
+        WHERE r.attempt_id = $1
+    `, attemptID).Scan(&unreleased, &manual)
+	if err != nil || unreleased > 0 {
+		return false, err
+	}
+
+	var score, maxScore float64
+	err = tx.QueryRow(`
+        UPDATE test_attempts
+        SET score = score - manual_score + $1, manual_score = $1, status = 'graded'
+        WHERE id = $2
+        RETURNING score, max_score
+    `, manual, attemptID).Scan(&score, &maxScore)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = tx.Exec(`
+        UPDATE student_progress
+        SET completed = TRUE, status = 'completed', score = $1 * (1 - penalty),
+            completed_at = (SELECT finished_at FROM test_attempts WHERE id = $2)
+        WHERE attempt_id = $2
+    `, percentOf(score, maxScore), attemptID)
+	return err == nil, err
+}
+
+// gradingScope - условие отбора ответов по лекции, модулю и группам
+func gradingScope(lectureID, moduleID int, groups []int) (string, []interface{}) {
+	where := ` WHERE 1 = 1`
+	var args []interface{}
+	if lectureID != 0 {
+		args = append(args, lectureID)
+		where += fmt.Sprintf(" AND t.lecture_id = $%d", len(args))
+	}
+	if moduleID != 0 {
+		args = append(args, moduleID)
+		where += fmt.Sprintf(" AND t.module_id = $%d", len(args))
+	}
+	if len(groups) > 0 {
+		var list string
+		list, args = inList(args, groups)
+		where += " AND u.group_id IN (" + list + ")"
+	}
+	return where, args
+}
+
+func (h *GradingHandler) responseFromURL(w http.ResponseWriter, r *http.Request) (*models.FreeResponse, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Неверный ID", http.StatusBadRequest)
+		return nil, false
+	}
+	responses, err := h.freeResponses(` WHERE r.id = $1`, id)
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return nil, false
+	}
+	if len(responses) == 0 {
+		http.Error(w, "Ответ не найден", http.StatusNotFound)
+		return nil, false
+	}
+	return &responses[0], true
+}
+
+const freeResponseQuery = `
+    SELECT r.id, r.attempt_id, r.student_id, COALESCE(pii_decrypt(u.full_name), ''), COALESCE(g.name, ''),
+           t.lecture_id, t.module_id, COALESCE(m.title, ''), r.source_module_id, r.question_index,
+           r.text, r.answered_at, rr.status, rr.points, rr.score, rr.max_score, rr.criteria,
+           rr.comments, rr.feedback, rr.graded_at, rr.released_at
+    FROM response_reviews rr
+    JOIN question_responses r ON r.id = rr.response_id
+    JOIN test_attempts t ON t.id = r.attempt_id
+    LEFT JOIN users u ON u.id = r.student_id
+    LEFT JOIN groups g ON g.id = u.group_id
+    LEFT JOIN modules m ON m.id = t.module_id`
+
+// freeResponses выбирает развернутые ответы и дополняет их текстом вопроса и рубрикой
+func (h *GradingHandler) freeResponses(where string, args ...interface{}) ([]models.FreeResponse, error) {
+	rows, err := h.DB.Query(freeResponseQuery+where, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	responses := []models.FreeResponse{}
+	for rows.Next() {
+		var fr models.FreeResponse
+		var score sql.NullFloat64
+		var criteria, comments string
+		var gradedAt, releasedAt sql.NullTime
+		err := rows.Scan(&fr.ID, &fr.AttemptID, &fr.StudentID, &fr.StudentName, &fr.GroupNumber,
+			&fr.LectureID, &fr.ModuleID, &fr.ModuleTitle, &fr.SourceModuleID, &fr.QuestionIndex,
+			&fr.Text, &fr.SubmittedAt, &fr.Status, &fr.Points, &score, &fr.MaxScore, &criteria,
+			&comments, &fr.Feedback, &gradedAt, &releasedAt)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if score.Valid {
+			fr.Score = &score.Float64
+		}
+		json.Unmarshal([]byte(criteria), &fr.Criteria)
+		json.Unmarshal([]byte(comments), &fr.Comments)
+		if gradedAt.Valid {
+			fr.GradedAt = &gradedAt.Time
+		}
+		if releasedAt.Valid {
+			fr.ReleasedAt = &releasedAt.Time
+		}
+		responses = append(responses, fr)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range responses {
+		if q, ok := reviewQuestion(h.DB, responses[i].SourceModuleID, responses[i].QuestionIndex); ok {
+			responses[i].Question = q.Question
+			responses[i].Rubric = q.Rubric
+		}
+	}
+	return responses, nil
+}
+
+// GradingPage - страница преподавателя для проверки развернутых ответов
+func (h *GradingHandler) GradingPage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+	html := `<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="UTF-8">
+    <title>Проверка работ - VisualMath</title>
+    <script src="/static/js/csrf.js"></script>
+    <link rel="stylesheet" href="/static/css/style.css">
+    <!-- MathJax для LaTeX -->
+    <script id="MathJax-script" async src="https://cdn.jsdelivr.net/npm/mathjax@3/es5/tex-mml-chtml.js"></script>
+    <script>
+        MathJax = {
+            tex: {
+                inlineMath: [['$', '$'], ['\\(', '\\)']],
+                displayMath: [['$$', '$$'], ['\\[', '\\]']]
+            }
+        };
+    </script>
+    <style>
+        .grading-container { display: flex; gap: 20px; margin: 30px; }
+        .queue { width: 320px; }
+        .queue-item { background: white; padding: 12px; margin-bottom: 8px; border-radius: 8px; cursor: pointer; box-shadow: 0 2px 4px rgba(0,0,0,0.08); }
+        .queue-item.active { border-left: 4px solid #3498db; }
+        .queue-item small { color: #7f8c8d; }
+        .panel { flex: 1; background: white; padding: 25px; border-radius: 10px; box-shadow: 0 4px 6px rgba(0,0,0,0.1); }
+        .filters { margin: 0 30px; display: flex; gap: 10px; flex-wrap: wrap; }
+        .filters input, .filters select { padding: 8px; border: 1px solid #ddd; border-radius: 6px; }
+        .btn { padding: 8px 18px; background: #3498db; color: white; border: none; border-radius: 6px; cursor: pointer; }
+        .btn.release { background: #27ae60; }
+        .answer-source { white-space: pre-wrap; background: #f8f9fa; padding: 15px; border-radius: 6px; font-family: monospace; }
+        .answer-source mark { background: #fff3b0; }
+        .answer-rendered { padding: 15px; border: 1px dashed #ddd; border-radius: 6px; margin-top: 10px; }
+        .criterion { display: flex; justify-content: space-between; align-items: center; margin: 8px 0; }
+        .criterion input { width: 70px; }
+        .comment { font-size: 14px; margin: 4px 0; }
+        textarea { width: 100%; min-height: 70px; }
+    </style>
+</head>
+<body>
+    <div style="margin: 30px 30px 10px;">
+        <a href="/dashboard">← В личный кабинет</a>
+        <h1>📝 Проверка развернутых ответов</h1>
+    </div>
+    <div class="filters">
+        <select id="status">
+            <option value="pending">На проверке</option>
+            <option value="graded">Проверены, не опубликованы</option>
+            <option value="released">Опубликованы</option>
+            <option value="all">Все</option>
+        </select>
+        <input type="number" id="lectureId" placeholder="ID лекции">
+        <select id="group"><option value="">Все группы</option></select>
+        <button class="btn" onclick="loadQueue()">Показать</button>
+        <button class="btn release" onclick="releaseGrades()">Опубликовать оценки</button>
+    </div>
+    <div class="grading-container">
+        <div class="queue" id="queue">Загрузка...</div>
+        <div class="panel" id="panel">Выберите ответ в очереди</div>
+    </div>
+    <script>
+        let current = null;
+        let comments = [];
+
+        function escapeHTML(s) {
+            const div = document.createElement('div');
+            div.textContent = s;
+            return div.innerHTML;
+        }
+
+        function filters() {
+            const params = new URLSearchParams();
+            const lecture = document.getElementById('lectureId').value;
+            const group = document.getElementById('group').value;
+            if (lecture) params.set('lecture_id', lecture);
+            if (group) params.set('group_id', group);
+            return params;
+        }
+
+        async function loadQueue() {
+            const params = filters();
+            params.set('status', document.getElementById('status').value);
+            const response = await fetch('/api/grading/queue?' + params);
+            const items = await response.json();
+            const queue = document.getElementById('queue');
+            if (items.length === 0) {
+                queue.textContent = 'Ответов нет';
+                return;
+            }
+            queue.innerHTML = items.map(item =>
+                '<div class="queue-item" id="item' + item.id + '" onclick="openResponse(' + item.id + ')">' +
+                '<b>' + escapeHTML(item.student_name) + '</b> <small>' + escapeHTML(item.group_number) + '</small><br>' +
+                escapeHTML(item.module_title) + '<br><small>' + new Date(item.submitted_at).toLocaleString('ru-RU') +
+                (item.score !== null ? ' · ' + item.score + ' из ' + item.max_score : '') + '</small></div>').join('');
+        }
+
+        async function openResponse(id) {
+            const response = await fetch('/api/grading/responses/' + id);
+            current = await response.json();
+            comments = current.comments || [];
+            document.querySelectorAll('.queue-item').forEach(el => el.classList.remove('active'));
+            document.getElementById('item' + id).classList.add('active');
+
+            let html = '<h2>' + escapeHTML(current.question) + '</h2>' +
+                '<p><small>' + escapeHTML(current.student_name) + ' · ' + escapeHTML(current.module_title) + '</small></p>' +
+                '<div class="answer-source" id="source"></div>' +
+                '<button class="btn" style="margin-top:8px" onclick="addComment()">💬 Комментарий к выделенному</button>' +
+                '<div class="answer-rendered" id="rendered"></div>' +
+                '<div id="comments"></div><h3>Оценка</h3>';
+            if (current.rubric && current.rubric.length) {
+                current.rubric.forEach((c, i) => {
+                    const given = (current.criteria || []).find(s => s.criterion === i);
+                    html += '<div class="criterion"><span>' + escapeHTML(c.title) + ' (до ' + c.points + ')</span>' +
+                        '<input type="number" step="0.5" min="0" max="' + c.points + '" id="crit' + i + '" value="' + (given ? given.points : '') + '"></div>';
+                });
+            } else {
+                html += '<div class="criterion"><span>Балл (до ' + current.max_score + ')</span>' +
+                    '<input type="number" step="0.5" min="0" max="' + current.max_score + '" id="score" value="' + (current.score ?? '') + '"></div>';
+            }
+            html += '<p>Общий отзыв</p><textarea id="feedback">' + escapeHTML(current.feedback || '') + '</textarea>' +
+                '<button class="btn" style="margin-top:10px" onclick="saveGrade()">Сохранить</button> <span id="saved"></span>';
+            document.getElementById('panel').innerHTML = html;
+            document.getElementById('rendered').textContent = current.text;
+            renderComments();
+            if (window.MathJax && MathJax.typesetPromise) MathJax.typesetPromise([document.getElementById('rendered')]);
+        }
+
+        // Исходный текст с подсветкой прокомментированных фрагментов
+        function renderComments() {
+            const chars = Array.from(current.text);
+            const marked = new Array(chars.length).fill(false);
+            comments.forEach(c => { for (let i = c.start; i < c.end; i++) marked[i] = true; });
+            let html = '';
+            chars.forEach((ch, i) => {
+                if (marked[i] && (i === 0 || !marked[i - 1])) html += '<mark>';
+                html += escapeHTML(ch);
+                if (marked[i] && (i === chars.length - 1 || !marked[i + 1])) html += '</mark>';
+            });
+            document.getElementById('source').innerHTML = html;
+            document.getElementById('comments').innerHTML = comments.map((c, i) =>
+                '<div class="comment">💬 «' + escapeHTML(chars.slice(c.start, c.end).join('')) + '»: ' + escapeHTML(c.text) +
+                ' <a href="#" onclick="removeComment(' + i + '); return false;">✕</a></div>').join('');
+        }
+
+        // Смещение выделения в символах исходного текста
+        function offsetIn(container, node, offset) {
+            const range = document.createRange();
+            range.selectNodeContents(container);
+            range.setEnd(node, offset);
+            return Array.from(range.toString()).length;
+        }
+
+        function addComment() {
+            const selection = window.getSelection();
+            const source = document.getElementById('source');
+            if (selection.rangeCount === 0 || selection.isCollapsed || !source.contains(selection.anchorNode)) {
+                alert('Выделите фрагмент ответа');
+                return;
+            }
+            const range = selection.getRangeAt(0);
+            const start = offsetIn(source, range.startContainer, range.startOffset);
+            const end = offsetIn(source, range.endContainer, range.endOffset);
+            const text = prompt('Комментарий к фрагменту');
+            if (!text) return;
+            comments.push({ start: start, end: end, text: text });
+            renderComments();
+        }
+
+        function removeComment(i) {
+            comments.splice(i, 1);
+            renderComments();
+        }
+
+        async function saveGrade() {
+            const body = { comments: comments, feedback: document.getElementById('feedback').value };
+            if (current.rubric && current.rubric.length) {
+                body.criteria = [];
+                current.rubric.forEach((c, i) => {
+                    const value = document.getElementById('crit' + i).value;
+                    if (value !== '') body.criteria.push({ criterion: i, points: parseFloat(value) });
+                });
+            } else {
+                body.score = parseFloat(document.getElementById('score').value);
+            }
+            const response = await fetch('/api/grading/responses/' + current.id, {
+                method: 'PUT',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify(body)
+            });
+            document.getElementById('saved').textContent = response.ok ? '✅ Сохранено' : '❌ ' + await response.text();
+            if (response.ok) loadQueue();
+        }
+
+        async function releaseGrades() {
+            const params = filters();
+            const body = {};
+            if (params.get('lecture_id')) body.lecture_id = parseInt(params.get('lecture_id'));
+            if (params.get('group_id')) body.group_ids = [parseInt(params.get('group_id'))];
+            if (!confirm('Опубликовать все проверенные оценки по выбранным фильтрам?')) return;
+            const response = await fetch('/api/grading/release', {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify(body)
+            });
+            const data = await response.json();
+            alert('Опубликовано ответов: ' + data.released + ', завершено попыток: ' + data.attempts_graded);
+            loadQueue();
+        }
+
+        async function loadGroups() {
+            const groups = await (await fetch('/api/groups')).json();
+            const select = document.getElementById('group');
+            groups.forEach(g => select.add(new Option(g.name, g.id)));
+        }
+
+        window.addEventListener('DOMContentLoaded', () => { loadGroups(); loadQueue(); });
+    </script>
+</body>
+</html>`
+
+	fmt.Fprint(w, html)
+}
@@ -0,0 +1,461 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"visualmath/internal/auth"
+	"visualmath/internal/models"
+)
+
+// GroupHandler - учебные группы и их состав
+type GroupHandler struct {
+	DB *sql.DB
+}
+
+type groupRequest struct {
+	Name      string `json:"name"`
+	Faculty   string `json:"faculty"`
+	Year      int    `json:"year"`
+	CuratorID *int   `json:"curator_id"`
+}
+
+// ListGroups возвращает все группы. Доступен без входа - нужен странице регистрации.
+func (h *GroupHandler) ListGroups(w http.ResponseWriter, r *http.Request) {
+	groups, err := listGroups(h.DB, ` ORDER BY g.name`)
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(groups)
+}
+
+// GetGroup возвращает группу со списком студентов
+func (h *GroupHandler) GetGroup(w http.ResponseWriter, r *http.Request) {
+	group, ok := h.groupFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	rows, err := h.DB.Query(`
+        SELECT id, pii_decrypt(login), pii_decrypt(full_name), pii_decrypt(email) FROM users
+        WHERE group_id = $1 AND user_type = 'student'
+        ORDER BY 3
+    `, group.ID)
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	members := []models.GroupMember{}
+	for rows.Next() {
+		var m models.GroupMember
+		if err := rows.Scan(&m.UserID, &m.Login, &m.FullName, &m.Email); err != nil {
+			http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+			return
+		}
+		members = append(members, m)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"group":   group,
+		"members": members,
+	})
+}
+
+// CreateGroup создает группу
+func (h *GroupHandler) CreateGroup(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.GetUserFromContext(r.Context())
+	if !auth.HasPermission(h.DB, user, auth.PermGroupManage, auth.Scope{}) {
+		http.Error(w, "Доступ запрещен", http.StatusForbidden)
+		return
+	}
+
+	var req groupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Неверный формат JSON", http.StatusBadRequest)
+		return
+	}
+	if !h.validGroup(w, &req) {
+		return
+	}
+
+	var id int
+	err := h.DB.QueryRow(`
+        INSERT INTO groups (name, faculty, year, curator_id)
+        VALUES ($1, $2, $3, $4)
+        ON CONFLICT (name) DO NOTHING
+        RETURNING id
+    `, req.Name, req.Faculty, req.Year, req.CuratorID).Scan(&id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Группа с таким названием уже есть", http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	h.respondGroup(w, id)
+}
+
+// UpdateGroup меняет название, факультет, год набора или куратора группы
+func (h *GroupHandler) UpdateGroup(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.GetUserFromContext(r.Context())
+	if !auth.HasPermission(h.DB, user, auth.PermGroupManage, auth.Scope{}) {
+		http.Error(w, "Доступ запрещен", http.StatusForbidden)
+		return
+	}
+	group, ok := h.groupFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	req := groupRequest{Name: group.Name, Faculty: group.Faculty, Year: group.Year, CuratorID: group.CuratorID}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Неверный формат JSON", http.StatusBadRequest)
+		return
+	}
+	if !h.validGroup(w, &req) {
+		return
+	}
+
+	_, err := h.DB.Exec(`
+        UPDATE groups SET name = $1, faculty = $2, year = $3, curator_id = $4 WHERE id = $5
+    `, req.Name, req.Faculty, req.Year, req.CuratorID, group.ID)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE") {
+			http.Error(w, "Группа с таким названием уже есть", http.StatusConflict)
+			return
+		}
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	h.respondGroup(w, group.ID)
+}
+
+// DeleteGroup удаляет пустую группу. Группу со студентами сначала нужно
+// расформировать или слить с другой.
+func (h *GroupHandler) DeleteGroup(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.GetUserFromContext(r.Context())
+	if !auth.HasPermission(h.DB, user, auth.PermGroupManage, auth.Scope{}) {
+		http.Error(w, "Доступ запрещен", http.StatusForbidden)
+		return
+	}
+	group, ok := h.groupFromURL(w, r)
+	if !ok {
+		return
+	}
+	if group.Students > 0 {
+		http.Error(w, "В группе есть студенты", http.StatusConflict)
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`DELETE FROM assignment_groups WHERE group_id = $1`, group.ID)
+	if err == nil {
+		_, err = tx.Exec(`DELETE FROM groups WHERE id = $1`, group.ID)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"group_id": group.ID,
+	})
+}
+
+// AddGroupMembers переводит студентов в группу (из прежних групп они выходят)
+func (h *GroupHandler) AddGroupMembers(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.GetUserFromContext(r.Context())
+	if !auth.HasPermission(h.DB, user, auth.PermGroupManage, auth.Scope{}) {
+		http.Error(w, "Доступ запрещен", http.StatusForbidden)
+		return
+	}
+	group, ok := h.groupFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		UserIDs []int `json:"user_ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Неверный формат JSON", http.StatusBadRequest)
+		return
+	}
+	if len(req.UserIDs) == 0 {
+		http.Error(w, "Укажите студентов", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	for _, userID := range req.UserIDs {
+		res, err := tx.Exec(`
+            UPDATE users SET group_id = $1 WHERE id = $2 AND user_type = 'student'
+        `, group.ID, userID)
+		if err != nil {
+			http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			http.Error(w, fmt.Sprintf("Студент %d не найден", userID), http.StatusBadRequest)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	h.respondGroup(w, group.ID)
+}
+
+// RemoveGroupMember исключает студента из группы
+func (h *GroupHandler) RemoveGroupMember(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.GetUserFromContext(r.Context())
+	if !auth.HasPermission(h.DB, user, auth.PermGroupManage, auth.Scope{}) {
+		http.Error(w, "Доступ запрещен", http.StatusForbidden)
+		return
+	}
+	group, ok := h.groupFromURL(w, r)
+	if !ok {
+		return
+	}
+	userID, err := strconv.Atoi(chi.URLParam(r, "userID"))
+	if err != nil {
+		http.Error(w, "Неверный ID студента", http.StatusBadRequest)
+		return
+	}
+
+	res, err := h.DB.Exec(`UPDATE users SET group_id = NULL WHERE id = $1 AND group_id = $2`, userID, group.ID)
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Студент не состоит в группе", http.StatusNotFound)
+		return
+	}
+
+	h.respondGroup(w, group.ID)
+}
+
+// MergeGroup переносит студентов и задания группы source_id в эту группу и удаляет
+// source_id. Нужен, чтобы свести дубликаты вроде "ПМ-21" и "ПМ21".
+func (h *GroupHandler) MergeGroup(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.GetUserFromContext(r.Context())
+	if !auth.HasPermission(h.DB, user, auth.PermGroupManage, auth.Scope{}) {
+		http.Error(w, "Доступ запрещен", http.StatusForbidden)
+		return
+	}
+	group, ok := h.groupFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		SourceID int `json:"source_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Неверный формат JSON", http.StatusBadRequest)
+		return
+	}
+	if req.SourceID == group.ID {
+		http.Error(w, "Нельзя слить группу саму с собой", http.StatusBadRequest)
+		return
+	}
+	if _, err := loadGroup(h.DB, req.SourceID); err == sql.ErrNoRows {
+		http.Error(w, "Группа source_id не найдена", http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`UPDATE users SET group_id = $1 WHERE group_id = $2`, group.ID, req.SourceID)
+	if err == nil {
+		_, err = tx.Exec(`
+            INSERT OR IGNORE INTO assignment_groups (assignment_id, group_id)
+            SELECT assignment_id, $1 FROM assignment_groups WHERE group_id = $2
+        `, group.ID, req.SourceID)
+	}
+	if err == nil {
+		_, err = tx.Exec(`DELETE FROM assignment_groups WHERE group_id = $1`, req.SourceID)
+	}
+	if err == nil {
+		_, err = tx.Exec(`DELETE FROM groups WHERE id = $1`, req.SourceID)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	h.respondGroup(w, group.ID)
+}
+
+func (h *GroupHandler) validGroup(w http.ResponseWriter, req *groupRequest) bool {
+	req.Name = strings.TrimSpace(req.Name)
+	req.Faculty = strings.TrimSpace(req.Faculty)
+	if req.Name == "" {
+		http.Error(w, "Укажите название группы", http.StatusBadRequest)
+		return false
+	}
+	if req.Year < 0 {
+		http.Error(w, "Неверный год набора", http.StatusBadRequest)
+		return false
+	}
+	if req.CuratorID != nil {
+		var userType string
+		h.DB.QueryRow(`SELECT user_type FROM users WHERE id = $1`, *req.CuratorID).Scan(&userType)
+		if userType != "teacher" && userType != "admin" {
+			http.Error(w, "Куратором может быть только преподаватель", http.StatusBadRequest)
+			return false
+		}
+	}
+	return true
+}
+
+func (h *GroupHandler) respondGroup(w http.ResponseWriter, id int) {
+	group, err := loadGroup(h.DB, id)
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"group":   group,
+	})
+}
+
+func (h *GroupHandler) groupFromURL(w http.ResponseWriter, r *http.Request) (*models.Group, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Неверный ID", http.StatusBadRequest)
+		return nil, false
+	}
+	group, err := loadGroup(h.DB, id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Группа не найдена", http.StatusNotFound)
+		return nil, false
+	} else if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return nil, false
+	}
+	return group, true
+}
+
+const groupQuery = `
+    SELECT g.id, g.name, g.faculty, g.year, g.curator_id, COALESCE(pii_decrypt(c.full_name), ''), g.created_at,
+           (SELECT COUNT(*) FROM users u WHERE u.group_id = g.id AND u.user_type = 'student')
+    FROM groups g
+    LEFT JOIN users c ON c.id = g.curator_id`
+
+func scanGroup(row interface{ Scan(...interface{}) error }) (*models.Group, error) {
+	var g models.Group
+	var curatorID sql.NullInt64
+	if err := row.Scan(&g.ID, &g.Name, &g.Faculty, &g.Year, &curatorID, &g.CuratorName,
+		&g.CreatedAt, &g.Students); err != nil {
+		return nil, err
+	}
+	if curatorID.Valid {
+		id := int(curatorID.Int64)
+		g.CuratorID = &id
+	}
+	return &g, nil
+}
+
+func loadGroup(db *sql.DB, id int) (*models.Group, error) {
+	return scanGroup(db.QueryRow(groupQuery+` WHERE g.id = $1`, id))
+}
+
+func listGroups(db *sql.DB, where string, args ...interface{}) ([]models.Group, error) {
+	rows, err := db.Query(groupQuery+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	groups := []models.Group{}
+	for rows.Next() {
+		g, err := scanGroup(rows)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, *g)
+	}
+	return groups, rows.Err()
+}
+
+// groupIDs разбирает повторяющийся параметр ?group_id=
+func groupIDs(values []string) ([]int, error) {
+	ids := make([]int, 0, len(values))
+	for _, v := range values {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("неверный ID группы: %q", v)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// inList дописывает идентификаторы в аргументы запроса и возвращает "$n, $n+1, ..."
+func inList(args []interface{}, ids []int) (string, []interface{}) {
+	placeholders := make([]string, len(ids))
+	for i, id := range ids {
+		args = append(args, id)
+		placeholders[i] = fmt.Sprintf("$%d", len(args))
+	}
+	return strings.Join(placeholders, ", "), args
+}
+
+// studentQuery - студент с названием его группы
+const studentQuery = `
+    SELECT u.id, pii_decrypt(u.full_name), pii_decrypt(u.login), COALESCE(u.group_id, 0), COALESCE(g.name, '')
+    FROM users u
+    LEFT JOIN groups g ON g.id = u.group_id`
+
+func scanStudent(row interface{ Scan(...interface{}) error }, st *models.AttendanceRecord) error {
+	return row.Scan(&st.UserID, &st.FullName, &st.Login, &st.GroupID, &st.GroupNumber)
+}
@@ -3,124 +3,182 @@ package handlers
 import (
 	"database/sql"
 	"encoding/json"
-	//"fmt"
+	"math"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"visualmath/internal/auth"
+	"visualmath/internal/models"
 )
 
 type LectureHandler struct {
 	DB *sql.DB
 }
 
-// ListLectures показывает список всех лекций
+// ListLectures показывает список лекций
 func (h *LectureHandler) ListLectures(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
+	user, _ := auth.GetUserFromContext(r.Context())
+	search := strings.TrimSpace(r.URL.Query().Get("search"))
+
+	// Студент видит только опубликованные лекции своих курсов
+	studentID := 0
+	if !auth.IsStaff(user.UserType) {
+		studentID = user.UserID
+	}
 
-	// Заглушка - в реальности запрос к БД
-	lectures := []map[string]interface{}{
-		{
-			"id":          1,
-			"title":       "Введение в математический анализ",
-			"course_name": "Математический анализ",
-			"author_name": "Иванов И.И.",
-			"description": "Базовые понятия анализа",
-			"modules_count": 5,
-			"created_at":  "2024-01-01",
-			"published":   true,
-		},
-		{
-			"id":          2,
-			"title":       "Линейная алгебра для начинающих",
-			"course_name": "Линейная алгебра",
-			"author_name": "Петров П.П.",
-			"description": "Основы матриц и векторов",
-			"modules_count": 4,
-			"created_at":  "2024-01-02",
-			"published":   true,
-		},
+	order := "l.created_at DESC"
+	switch r.URL.Query().Get("sort") {
+	case "title":
+		order = "l.title"
+	case "oldest":
+		order = "l.created_at"
 	}
 
+	rows, err := h.DB.Query(`
+        SELECT l.id, l.title, COALESCE(l.course_id, 0), COALESCE(c.name, ''), COALESCE(pii_decrypt(u.full_name), ''),
+               l.description, (SELECT COUNT(*) FROM lecture_modules lm WHERE lm.lecture_id = l.id),
+               l.created_at, l.published
+        FROM lectures l
+        LEFT JOIN users u ON u.id = l.author_id
+        LEFT JOIN courses c ON c.id = l.course_id
+        WHERE ($1 = '' OR l.title LIKE '%' || $1 || '%' OR c.name LIKE '%' || $1 || '%')
+          AND ($2 = 0 OR (l.published AND EXISTS (
+                SELECT 1 FROM enrollments e
+                WHERE e.course_id = l.course_id AND e.student_id = $2 AND e.status = 'active')))
+        ORDER BY `+order, search, studentID)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	lectures := []map[string]interface{}{}
+	for rows.Next() {
+		var id, courseID, modulesCount int
+		var title, courseName, authorName, description string
+		var createdAt time.Time
+		var published bool
+		if err := rows.Scan(&id, &title, &courseID, &courseName, &authorName, &description,
+			&modulesCount, &createdAt, &published); err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		lectures = append(lectures, map[string]interface{}{
+			"id":            id,
+			"title":         title,
+			"course_id":     courseID,
+			"course_name":   courseName,
+			"author_name":   authorName,
+			"description":   description,
+			"modules_count": modulesCount,
+			"created_at":    createdAt.Format("2006-01-02"),
+			"published":     published,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(lectures)
 }
 
 // CreateLecture создает новую лекцию
 func (h *LectureHandler) CreateLecture(w http.ResponseWriter, r *http.Request) {
-	var req struct {
-		Title       string   `json:"title"`
-		CourseID    int      `json:"course_id"`
-		CourseName  string   `json:"course_name"`
-		Description string   `json:"description"`
-		ModuleIDs   []int    `json:"module_ids"`
-		Published   bool     `json:"published"`
-		AllowBack   bool     `json:"allow_back"`
-	}
+	user, _ := auth.GetUserFromContext(r.Context())
 
+	var req models.LectureRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid JSON", http.StatusBadRequest)
 		return
 	}
 
 	// Валидация
-	if req.Title == "" || req.CourseName == "" || len(req.ModuleIDs) == 0 {
+	if req.Title == "" || req.CourseID == 0 || len(req.ModuleIDs) == 0 {
 		http.Error(w, "Missing required fields", http.StatusBadRequest)
 		return
 	}
+	if !courseExists(h.DB, req.CourseID) {
+		http.Error(w, "Course not found", http.StatusBadRequest)
+		return
+	}
+	if courseArchived(h.DB, req.CourseID) {
+		http.Error(w, "Course is archived", http.StatusConflict)
+		return
+	}
+	if !canEditCourses(h.DB, w, r, auth.PermModuleEdit, req.CourseID) {
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var lectureID int
+	err = tx.QueryRow(`
+        INSERT INTO lectures (title, course_id, author_id, description, published, allow_back, course_position)
+        VALUES ($1, $2, $3, $4, $5, $6,
+                (SELECT COALESCE(MAX(course_position), 0) + 1 FROM lectures WHERE course_id = $2))
+        RETURNING id
+    `, req.Title, req.CourseID, user.UserID, req.Description, req.Published, req.AllowBack).Scan(&lectureID)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := saveLectureModules(tx, lectureID, req.ModuleIDs); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	lecture, err := loadLecture(h.DB, lectureID)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
 
-	// Заглушка - в реальности сохранение в БД
 	response := map[string]interface{}{
-		"success":  true,
-		"message":  "Лекция успешно создана",
-		"lecture": map[string]interface{}{
-			"id":          3,
-			"title":       req.Title,
-			"course_name": req.CourseName,
-			"description": req.Description,
-			"module_ids":  req.ModuleIDs,
-			"published":   req.Published,
-			"allow_back":  req.AllowBack,
-			"created_at":  time.Now().Format("2006-01-02 15:04:05"),
-		},
+		"success": true,
+		"message": "Лекция успешно создана",
+		"lecture": lecture,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
 
-// GetLecture возвращает лекцию с модулями
+// GetLecture возвращает лекцию с модулями; студентам - без правильных ответов в вопросах
 func (h *LectureHandler) GetLecture(w http.ResponseWriter, r *http.Request) {
-	// В реальности извлекаем ID из URL
-	lectureID := 1
-
-	// Заглушка - в реальности запрос к БД
-	lecture := map[string]interface{}{
-		"id":          lectureID,
-		"title":       "Введение в математический анализ",
-		"course_name": "Математический анализ",
-		"author_name": "Иванов И.И.",
-		"description": "Базовые понятия анализа",
-		"published":   true,
-		"allow_back":  true,
-		"modules": []map[string]interface{}{
-			{
-				"id":    1,
-				"order": 1,
-				"title": "Понятие предела",
-				"type":  "text",
-			},
-			{
-				"id":    2,
-				"order": 2,
-				"title": "Производные функций",
-				"type":  "text",
-			},
-			{
-				"id":    3,
-				"order": 3,
-				"title": "Тест по производным",
-				"type":  "test",
-			},
-		},
+	user, _ := auth.GetUserFromContext(r.Context())
+	lectureID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid lecture ID", http.StatusBadRequest)
+		return
+	}
+
+	lecture, err := loadLecture(h.DB, lectureID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Lecture not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if !canViewLecture(h.DB, w, user, lecture) {
+		return
+	}
+	if !auth.IsStaff(user.UserType) {
+		for i := range lecture.Modules {
+			lecture.Modules[i].Module = hideAnswers(lecture.Modules[i].Type, lecture.Modules[i].Module)
+		}
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -129,24 +187,77 @@ func (h *LectureHandler) GetLecture(w http.ResponseWriter, r *http.Request) {
 
 // UpdateLecture обновляет лекцию
 func (h *LectureHandler) UpdateLecture(w http.ResponseWriter, r *http.Request) {
-	var req struct {
-		Title       string   `json:"title"`
-		CourseName  string   `json:"course_name"`
-		Description string   `json:"description"`
-		ModuleIDs   []int    `json:"module_ids"`
-		Published   bool     `json:"published"`
-		AllowBack   bool     `json:"allow_back"`
+	lectureID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid lecture ID", http.StatusBadRequest)
+		return
+	}
+	if lectureArchived(h.DB, lectureID) {
+		http.Error(w, "Lecture belongs to an archived course", http.StatusConflict)
+		return
 	}
 
+	var req models.LectureRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid JSON", http.StatusBadRequest)
 		return
 	}
+	if req.Title == "" || req.CourseID == 0 || len(req.ModuleIDs) == 0 {
+		http.Error(w, "Missing required fields", http.StatusBadRequest)
+		return
+	}
+	if !courseExists(h.DB, req.CourseID) {
+		http.Error(w, "Course not found", http.StatusBadRequest)
+		return
+	}
+	if courseArchived(h.DB, req.CourseID) {
+		http.Error(w, "Course is archived", http.StatusConflict)
+		return
+	}
+	if !canEditCourses(h.DB, w, r, auth.PermModuleEdit, lectureCourse(h.DB, lectureID), req.CourseID) {
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+        UPDATE lectures
+        SET title = $1, course_id = $2, description = $3, published = $4, allow_back = $5,
+            course_position = CASE WHEN course_id = $2 THEN course_position ELSE
+                (SELECT COALESCE(MAX(p.course_position), 0) + 1 FROM lectures p WHERE p.course_id = $2) END
+        WHERE id = $6
+    `, req.Title, req.CourseID, req.Description, req.Published, req.AllowBack, lectureID)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "Lecture not found", http.StatusNotFound)
+		return
+	}
+
+	if _, err := tx.Exec(`DELETE FROM lecture_modules WHERE lecture_id = $1`, lectureID); err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if err := saveLectureModules(tx, lectureID, req.ModuleIDs); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
 
 	response := map[string]interface{}{
-		"success":   true,
-		"message":   "Лекция обновлена",
-		"lecture_id": 1,
+		"success":    true,
+		"message":    "Лекция обновлена",
+		"lecture_id": lectureID,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -155,10 +266,34 @@ func (h *LectureHandler) UpdateLecture(w http.ResponseWriter, r *http.Request) {
 
 // DeleteLecture удаляет лекцию
 func (h *LectureHandler) DeleteLecture(w http.ResponseWriter, r *http.Request) {
+	lectureID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid lecture ID", http.StatusBadRequest)
+		return
+	}
+	if lectureArchived(h.DB, lectureID) {
+		http.Error(w, "Lecture belongs to an archived course", http.StatusConflict)
+		return
+	}
+	if !canEditCourses(h.DB, w, r, auth.PermModuleEdit, lectureCourse(h.DB, lectureID)) {
+		return
+	}
+
+	result, err := h.DB.Exec(`DELETE FROM lectures WHERE id = $1`, lectureID)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "Lecture not found", http.StatusNotFound)
+		return
+	}
+	h.DB.Exec(`DELETE FROM lecture_modules WHERE lecture_id = $1`, lectureID)
+
 	response := map[string]interface{}{
-		"success":   true,
-		"message":   "Лекция удалена",
-		"lecture_id": 1,
+		"success":    true,
+		"message":    "Лекция удалена",
+		"lecture_id": lectureID,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -167,61 +302,53 @@ func (h *LectureHandler) DeleteLecture(w http.ResponseWriter, r *http.Request) {
 
 // GetAvailableModules возвращает модули для добавления в лекцию
 func (h *LectureHandler) GetAvailableModules(w http.ResponseWriter, r *http.Request) {
-	// Заглушка - в реальности запрос к БД
-	modules := []map[string]interface{}{
-		{
-			"id":          1,
-			"title":       "Понятие предела",
-			"course":      "Математический анализ",
-			"type":        "text",
-			"description": "Определение предела функции",
-			"author":      "Иванов И.И.",
-		},
-		{
-			"id":          2,
-			"title":       "Производные функций",
-			"course":      "Математический анализ",
-			"type":        "text",
-			"description": "Основы дифференцирования",
-			"author":      "Иванов И.И.",
-		},
-		{
-			"id":          3,
-			"title":       "Тест по производным",
-			"course":      "Математический анализ",
-			"type":        "test",
-			"description": "Контрольный тест",
-			"author":      "Иванов И.И.",
-		},
-		{
-			"id":          4,
-			"title":       "Интегралы",
-			"course":      "Математический анализ",
-			"type":        "text",
-			"description": "Основы интегрирования",
-			"author":      "Иванов И.И.",
-		},
+	user, _ := auth.GetUserFromContext(r.Context())
+	modules, err := listModules(h.DB)
+	if err == nil {
+		modules, err = visibleModules(h.DB, user, modules)
+	}
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	result := []map[string]interface{}{}
+	for i := range modules {
+		if !auth.IsStaff(user.UserType) {
+			modules[i].Content = hideAnswers(modules[i].ModuleType, modules[i].Content)
+		}
+		result = append(result, moduleResponse(&modules[i]))
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(modules)
+	json.NewEncoder(w).Encode(result)
 }
 
 // StartLecture для студента - начинает прохождение
 func (h *LectureHandler) StartLecture(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.GetUserFromContext(r.Context())
 	lectureID, _ := strconv.Atoi(r.URL.Query().Get("lecture_id"))
-	studentID := 1 // В реальности из JWT токена
 
-	// Заглушка - в реальности создаем запись прогресса
-	progress := map[string]interface{}{
-		"lecture_id":   lectureID,
-		"student_id":   studentID,
-		"current_module": 1,
-		"completed_modules": []int{},
-		"started_at": time.Now().Format(time.RFC3339),
-		"allow_back": true,
+	lecture, err := loadLecture(h.DB, lectureID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Lecture not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if !canViewLecture(h.DB, w, user, lecture) {
+		return
 	}
 
+	progress, err := lectureProgress(h.DB, lecture, user.UserID)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	progress["allow_back"] = lecture.AllowBack
+	progress["started_at"] = time.Now().Format(time.RFC3339)
+
 	response := map[string]interface{}{
 		"success":  true,
 		"message":  "Лекция начата",
@@ -232,12 +359,21 @@ func (h *LectureHandler) StartLecture(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
-// CompleteModule отмечает модуль как пройденный
+// contentModuleScore - балл за прохождение модуля без вопросов, в процентах, как у попыток
+const contentModuleScore = 100
+
+// CompleteModule отмечает пройденным модуль без вопросов (text, visual). Каждое
+// прохождение сохраняется отдельной записью, чтобы в журнале можно было взять
+// лучший или последний результат. Модули question и test засчитываются только
+// через попытки: их балл считает сервер по ответам.
 func (h *LectureHandler) CompleteModule(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.GetUserFromContext(r.Context())
+
 	var req struct {
-		LectureID int     `json:"lecture_id"`
-		ModuleID  int     `json:"module_id"`
-		Score     float64 `json:"score"`
+		LectureID int       `json:"lecture_id"`
+		ModuleID  int       `json:"module_id"`
+		Score     *float64  `json:"score"` // самопроверка в интерактивном модуле, по умолчанию полный балл
+		StartedAt time.Time `json:"started_at"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -245,11 +381,77 @@ func (h *LectureHandler) CompleteModule(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	lecture, err := loadLecture(h.DB, req.LectureID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Lecture not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if !canViewLecture(h.DB, w, user, lecture) {
+		return
+	}
+
+	position := -1
+	for i, m := range lecture.Modules {
+		if m.ModuleID == req.ModuleID {
+			position = i
+			break
+		}
+	}
+	if position < 0 {
+		http.Error(w, "Module is not part of the lecture", http.StatusBadRequest)
+		return
+	}
+	if t := lecture.Modules[position].Type; t == "question" || t == "test" {
+		http.Error(w, "Question and test modules are completed through /api/modules/{id}/attempts", http.StatusBadRequest)
+		return
+	}
+	score := float64(contentModuleScore)
+	if req.Score != nil {
+		score = math.Max(0, math.Min(*req.Score, contentModuleScore))
+	}
+
+	// SQLite сравнивает даты как строки, поэтому храним все в UTC, как CURRENT_TIMESTAMP
+	now := time.Now().UTC()
+	if req.StartedAt.IsZero() || req.StartedAt.After(now) {
+		req.StartedAt = now
+	}
+	req.StartedAt = req.StartedAt.UTC()
+
+	assignment, ok := assignmentGate(h.DB, w, user.UserID, req.LectureID, req.ModuleID, now)
+	if !ok {
+		return
+	}
+	assignmentID := 0
+	lateDays, penalty := lateness(assignment, now)
+	if assignment != nil {
+		assignmentID = assignment.ID
+	}
+
+	_, err = h.DB.Exec(`
+        INSERT INTO student_progress (student_id, lecture_id, module_id, completed, score, started_at, completed_at,
+                                      assignment_id, late_days, penalty)
+        VALUES ($1, $2, $3, TRUE, $4, $5, $6, $7, $8, $9)
+    `, user.UserID, req.LectureID, req.ModuleID, score*(1-penalty), req.StartedAt, now,
+		assignmentID, lateDays, penalty)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	nextModule := 0
+	if position+1 < len(lecture.Modules) {
+		nextModule = lecture.Modules[position+1].ModuleID
+	}
+
 	response := map[string]interface{}{
-		"success":  true,
-		"message":  "Модуль пройден",
-		"next_module": req.ModuleID + 1, // следующий по порядку
-		"completed": true,
+		"success":     true,
+		"message":     "Модуль пройден",
+		"next_module": nextModule, // следующий по порядку, 0 - лекция закончилась
+		"completed":   true,
+		"late_days":   lateDays,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -258,19 +460,152 @@ func (h *LectureHandler) CompleteModule(w http.ResponseWriter, r *http.Request)
 
 // GetStudentProgress возвращает прогресс студента
 func (h *LectureHandler) GetStudentProgress(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.GetUserFromContext(r.Context())
 	lectureID, _ := strconv.Atoi(r.URL.Query().Get("lecture_id"))
-	studentID := 1
 
-	progress := map[string]interface{}{
-		"lecture_id":   lectureID,
-		"student_id":   studentID,
-		"current_module": 2,
-		"completed_modules": []int{1},
-		"total_modules": 3,
-		"progress_percent": 33,
-		"started_at": "2024-01-10T10:00:00Z",
+	lecture, err := loadLecture(h.DB, lectureID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Lecture not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if !canViewLecture(h.DB, w, user, lecture) {
+		return
+	}
+
+	progress, err := lectureProgress(h.DB, lecture, user.UserID)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(progress)
 }
+
+// saveLectureModules сохраняет порядок модулей лекции
+func saveLectureModules(tx *sql.Tx, lectureID int, moduleIDs []int) error {
+	for i, moduleID := range moduleIDs {
+		var exists int
+		tx.QueryRow(`SELECT COUNT(*) FROM modules WHERE id = $1`, moduleID).Scan(&exists)
+		if exists == 0 {
+			return &missingModuleError{moduleID}
+		}
+		if _, err := tx.Exec(`
+            INSERT INTO lecture_modules (lecture_id, module_id, position) VALUES ($1, $2, $3)
+        `, lectureID, moduleID, i+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type missingModuleError struct {
+	moduleID int
+}
+
+func (e *missingModuleError) Error() string {
+	return "Module " + strconv.Itoa(e.moduleID) + " not found"
+}
+
+// loadLecture загружает лекцию вместе с модулями в порядке следования
+func loadLecture(db *sql.DB, id int) (*models.Lecture, error) {
+	var l models.Lecture
+	err := db.QueryRow(`
+        SELECT l.id, l.title, COALESCE(l.course_id, 0), COALESCE(c.name, ''), COALESCE(l.author_id, 0),
+               COALESCE(pii_decrypt(u.full_name), ''), l.description, l.created_at, l.published, l.allow_back
+        FROM lectures l
+        LEFT JOIN users u ON u.id = l.author_id
+        LEFT JOIN courses c ON c.id = l.course_id
+        WHERE l.id = $1
+    `, id).Scan(&l.ID, &l.Title, &l.CourseID, &l.CourseName, &l.AuthorID, &l.AuthorName,
+		&l.Description, &l.CreatedAt, &l.Published, &l.AllowBack)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`
+        SELECT lm.id, lm.module_id, lm.position, m.title, m.module_type, m.content
+        FROM lecture_modules lm
+        JOIN modules m ON m.id = lm.module_id
+        WHERE lm.lecture_id = $1
+        ORDER BY lm.position
+    `, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	l.Modules = []models.LectureModule{}
+	for rows.Next() {
+		m := models.LectureModule{LectureID: l.ID}
+		var content string
+		if err := rows.Scan(&m.ID, &m.ModuleID, &m.Order, &m.Title, &m.Type, &content); err != nil {
+			return nil, err
+		}
+		m.Module = json.RawMessage(content)
+		l.Modules = append(l.Modules, m)
+	}
+	return &l, rows.Err()
+}
+
+// lectureProgress считает пройденные модули и текущий модуль студента
+func lectureProgress(db *sql.DB, lecture *models.Lecture, studentID int) (map[string]interface{}, error) {
+	rows, err := db.Query(`
+        SELECT module_id, completed FROM student_progress
+        WHERE student_id = $1 AND lecture_id = $2 AND (completed OR status = 'pending_review')
+    `, studentID, lecture.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	done := make(map[int]bool)
+	waiting := make(map[int]bool)
+	for rows.Next() {
+		var moduleID int
+		var completed bool
+		if err := rows.Scan(&moduleID, &completed); err != nil {
+			return nil, err
+		}
+		if completed {
+			done[moduleID] = true
+		} else {
+			waiting[moduleID] = true
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Модуль на проверке не задерживает студента, но и не считается пройденным
+	completed := []int{}
+	pending := []int{}
+	current := 0
+	for _, m := range lecture.Modules {
+		if done[m.ModuleID] {
+			completed = append(completed, m.ModuleID)
+		} else if waiting[m.ModuleID] {
+			pending = append(pending, m.ModuleID)
+		} else if current == 0 {
+			current = m.ModuleID
+		}
+	}
+
+	percent := 0
+	if len(lecture.Modules) > 0 {
+		percent = len(completed) * 100 / len(lecture.Modules)
+	}
+
+	return map[string]interface{}{
+		"lecture_id":        lecture.ID,
+		"student_id":        studentID,
+		"current_module":    current,
+		"completed_modules": completed,
+		"pending_modules":   pending,
+		"total_modules":     len(lecture.Modules),
+		"progress_percent":  percent,
+	}, nil
+}
@@ -0,0 +1,538 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"visualmath/internal/auth"
+	"visualmath/internal/live"
+	"visualmath/internal/models"
+)
+
+// joinCodeAlphabet не содержит похожих символов (0/O, 1/I), чтобы код было легко продиктовать
+const joinCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+const (
+	joinCodeLength = 6
+	sseRetry       = 1000 // мс, через сколько браузер переподключается после обрыва
+	sseHeartbeat   = 15 * time.Second
+)
+
+type LiveHandler struct {
+	DB  *sql.DB
+	Hub *live.Hub
+}
+
+// StartSession создает живую сессию лекции и возвращает код подключения
+func (h *LiveHandler) StartSession(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.GetUserFromContext(r.Context())
+	var req struct {
+		LectureID int `json:"lecture_id"`
+		ModuleID  int `json:"module_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Неверный формат JSON", http.StatusBadRequest)
+		return
+	}
+	if req.LectureID == 0 {
+		http.Error(w, "Не указана лекция", http.StatusBadRequest)
+		return
+	}
+	lecture, err := loadLecture(h.DB, req.LectureID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Лекция не найдена", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+	if !auth.HasPermission(h.DB, user, auth.PermLiveRun, auth.Scope{CourseID: lecture.CourseID}) {
+		http.Error(w, "Только преподаватель курса может начать живую лекцию", http.StatusForbidden)
+		return
+	}
+	if req.ModuleID != 0 && !h.moduleInLecture(w, req.LectureID, req.ModuleID) {
+		return
+	}
+
+	// Код может совпасть с уже выданным, поэтому пробуем несколько раз
+	var sessionID int
+	for attempt := 0; ; attempt++ {
+		code, err := generateJoinCode()
+		if err != nil {
+			http.Error(w, "Ошибка генерации кода", http.StatusInternalServerError)
+			return
+		}
+
+		err = h.DB.QueryRow(`
+            INSERT INTO live_sessions (lecture_id, teacher_id, join_code, current_module_id)
+            VALUES ($1, $2, $3, $4)
+            RETURNING id
+        `, req.LectureID, user.UserID, code, req.ModuleID).Scan(&sessionID)
+		if err == nil {
+			break
+		}
+		if !strings.Contains(err.Error(), "UNIQUE") || attempt >= 4 {
+			http.Error(w, "Ошибка базы данных: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	session, err := loadLiveSession(h.DB, sessionID)
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+	h.Hub.Open(session.ID, moduleEvent(session.CurrentModuleID))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"message": "Живая лекция начата",
+		"session": session,
+	})
+}
+
+// JoinSession находит активную сессию по коду подключения и записывает пользователя
+// в участники: без этого студент не получит ни состояние сессии, ни поток событий
+func (h *LiveHandler) JoinSession(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.GetUserFromContext(r.Context())
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Неверный формат JSON", http.StatusBadRequest)
+		return
+	}
+
+	var sessionID int
+	err := h.DB.QueryRow(`
+        SELECT id FROM live_sessions
+        WHERE join_code = $1 AND ended_at IS NULL
+    `, strings.ToUpper(strings.TrimSpace(req.Code))).Scan(&sessionID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Сессия не найдена или уже завершена", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	session, err := loadLiveSession(h.DB, sessionID)
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+	if session.TeacherID != user.UserID {
+		if _, err := h.DB.Exec(`
+            INSERT INTO live_participants (session_id, user_id) VALUES ($1, $2)
+            ON CONFLICT (session_id, user_id) DO NOTHING
+        `, session.ID, user.UserID); err != nil {
+			http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"session": session,
+	})
+}
+
+// GetSession возвращает состояние сессии, преподавателю - со списком участников
+func (h *LiveHandler) GetSession(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.GetUserFromContext(r.Context())
+	session, ok := h.memberSession(w, r)
+	if !ok {
+		return
+	}
+
+	response := map[string]interface{}{
+		"session": session,
+	}
+
+	if session.TeacherID == user.UserID {
+		participants, err := h.participants(session.ID)
+		if err != nil {
+			http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+			return
+		}
+		response["participants"] = participants
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Events - поток Server-Sent Events для студентов и преподавателя.
+// Браузер сам переподключается после обрыва и получает текущий модуль заново.
+func (h *LiveHandler) Events(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.GetUserFromContext(r.Context())
+	session, ok := h.memberSession(w, r)
+	if !ok {
+		return
+	}
+	if session.EndedAt != nil {
+		http.Error(w, "Сессия завершена", http.StatusGone)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Потоковая передача не поддерживается", http.StatusInternalServerError)
+		return
+	}
+
+	// После перезапуска сервера хаб пуст, восстанавливаем сессию из БД. Если
+	// преподаватель тем временем завершил сессию, открытый здесь хаб никто бы не закрыл.
+	initial, err := h.lastPollEvents(session.ID)
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+	h.Hub.Open(session.ID, append([]live.Event{moduleEvent(session.CurrentModuleID)}, initial...)...)
+	if ended, err := sessionEnded(h.DB, session.ID); err != nil || ended {
+		if ended {
+			h.Hub.Close(session.ID)
+		}
+		http.Error(w, "Сессия завершена", http.StatusGone)
+		return
+	}
+
+	role := "student"
+	if session.TeacherID == user.UserID {
+		role = "teacher"
+	}
+
+	client, err := h.Hub.Subscribe(session.ID, user.UserID, role)
+	if err != nil {
+		http.Error(w, "Сессия завершена", http.StatusGone)
+		return
+	}
+	if role == "student" {
+		h.markJoined(session.ID, user.UserID)
+	}
+	defer func() {
+		if h.Hub.Unsubscribe(session.ID, client) && role == "student" {
+			h.markLeft(session.ID, user.UserID)
+		}
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	fmt.Fprintf(w, "retry: %d\n\n", sseRetry)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(sseHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case ev, ok := <-client.Events:
+			if !ok {
+				// Хаб отключил клиента: сессия завершена или клиент не успевал читать
+				return
+			}
+			if err := writeSSE(w, ev); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// SetModule переключает текущий модуль у всех подключенных студентов
+func (h *LiveHandler) SetModule(w http.ResponseWriter, r *http.Request) {
+	session, ok := h.teacherSession(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		ModuleID int `json:"module_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Неверный формат JSON", http.StatusBadRequest)
+		return
+	}
+
+	if !h.moduleInLecture(w, session.LectureID, req.ModuleID) {
+		return
+	}
+
+	result, err := h.DB.Exec(`
+        UPDATE live_sessions SET current_module_id = $1 WHERE id = $2 AND ended_at IS NULL
+    `, req.ModuleID, session.ID)
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "Сессия завершена", http.StatusGone)
+		return
+	}
+
+	// Хаб не открывается заново: если сессию только что завершили, событие
+	// отбрасывается, а подключившиеся позже получат модуль из базы в Events
+	h.Hub.Broadcast(session.ID, moduleEvent(req.ModuleID))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":   true,
+		"module_id": req.ModuleID,
+	})
+}
+
+// EndSession завершает сессию и отключает всех участников
+func (h *LiveHandler) EndSession(w http.ResponseWriter, r *http.Request) {
+	session, ok := h.teacherSession(w, r)
+	if !ok {
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`
+        UPDATE live_sessions SET ended_at = CURRENT_TIMESTAMP
+        WHERE id = $1 AND ended_at IS NULL
+    `, session.ID); err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+	if _, err := tx.Exec(`
+        UPDATE live_participants SET left_at = CURRENT_TIMESTAMP
+        WHERE session_id = $1 AND left_at IS NULL
+    `, session.ID); err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+	if _, err := tx.Exec(`
+        UPDATE polls SET closed_at = CURRENT_TIMESTAMP
+        WHERE session_id = $1 AND closed_at IS NULL
+    `, session.ID); err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	h.Hub.Close(session.ID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"message": "Живая лекция завершена",
+	})
+}
+
+// liveSessionFromURL загружает сессию по {id} из URL и пишет ошибку в ответ
+func liveSessionFromURL(db *sql.DB, w http.ResponseWriter, r *http.Request) (*models.LiveSession, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Неверный ID сессии", http.StatusBadRequest)
+		return nil, false
+	}
+
+	session, err := loadLiveSession(db, id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Сессия не найдена", http.StatusNotFound)
+		return nil, false
+	} else if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return nil, false
+	}
+	return session, true
+}
+
+// teacherSession загружает активную сессию и проверяет, что ее ведет текущий пользователь
+func (h *LiveHandler) teacherSession(w http.ResponseWriter, r *http.Request) (*models.LiveSession, bool) {
+	user, _ := auth.GetUserFromContext(r.Context())
+	session, ok := liveSessionFromURL(h.DB, w, r)
+	if !ok {
+		return nil, false
+	}
+	if session.TeacherID != user.UserID {
+		http.Error(w, "Сессией управляет другой преподаватель", http.StatusForbidden)
+		return nil, false
+	}
+	if session.EndedAt != nil {
+		http.Error(w, "Сессия завершена", http.StatusGone)
+		return nil, false
+	}
+	return session, true
+}
+
+// moduleInLecture - модуль входит в лекцию сессии; иначе отвечает 400
+func (h *LiveHandler) moduleInLecture(w http.ResponseWriter, lectureID, moduleID int) bool {
+	var inLecture int
+	if err := h.DB.QueryRow(`
+        SELECT COUNT(*) FROM lecture_modules WHERE lecture_id = $1 AND module_id = $2
+    `, lectureID, moduleID).Scan(&inLecture); err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return false
+	}
+	if inLecture == 0 {
+		http.Error(w, "Модуль не входит в эту лекцию", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// memberSession загружает сессию для ее преподавателя или студента, подключившегося
+// по коду через JoinSession. Остальным сессия не видна, даже если угадать ее ID.
+func (h *LiveHandler) memberSession(w http.ResponseWriter, r *http.Request) (*models.LiveSession, bool) {
+	user, _ := auth.GetUserFromContext(r.Context())
+	session, ok := liveSessionFromURL(h.DB, w, r)
+	if !ok || !h.isMember(w, user, session) {
+		return nil, false
+	}
+	return session, true
+}
+
+// isMember - то же для уже загруженной сессии, например сессии опроса
+func (h *LiveHandler) isMember(w http.ResponseWriter, user *auth.UserClaims, session *models.LiveSession) bool {
+	if session.TeacherID == user.UserID {
+		return true
+	}
+	var joined int
+	if err := h.DB.QueryRow(`
+        SELECT COUNT(*) FROM live_participants WHERE session_id = $1 AND user_id = $2
+    `, session.ID, user.UserID).Scan(&joined); err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return false
+	}
+	if joined == 0 {
+		http.Error(w, "Подключитесь к сессии по коду", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// sessionEnded читает ended_at заново, в обход уже загруженной сессии
+func sessionEnded(db *sql.DB, sessionID int) (bool, error) {
+	var ended bool
+	err := db.QueryRow(`SELECT ended_at IS NOT NULL FROM live_sessions WHERE id = $1`, sessionID).Scan(&ended)
+	return ended, err
+}
+
+func loadLiveSession(db *sql.DB, id int) (*models.LiveSession, error) {
+	var s models.LiveSession
+	var endedAt sql.NullTime
+	err := db.QueryRow(`
+        SELECT id, lecture_id, teacher_id, join_code, current_module_id, started_at, ended_at
+        FROM live_sessions WHERE id = $1
+    `, id).Scan(&s.ID, &s.LectureID, &s.TeacherID, &s.JoinCode, &s.CurrentModuleID, &s.StartedAt, &endedAt)
+	if err != nil {
+		return nil, err
+	}
+	if endedAt.Valid {
+		s.EndedAt = &endedAt.Time
+	}
+	return &s, nil
+}
+
+func (h *LiveHandler) participants(sessionID int) ([]models.LiveParticipant, error) {
+	rows, err := h.DB.Query(`
+        SELECT p.user_id, COALESCE(pii_decrypt(u.full_name), ''), p.joined_at, p.left_at, p.reconnects
+        FROM live_participants p
+        LEFT JOIN users u ON u.id = p.user_id
+        WHERE p.session_id = $1
+        ORDER BY p.joined_at
+    `, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	online := make(map[int]bool)
+	for _, id := range h.Hub.Online(sessionID) {
+		online[id] = true
+	}
+
+	participants := []models.LiveParticipant{}
+	for rows.Next() {
+		var p models.LiveParticipant
+		var leftAt sql.NullTime
+		if err := rows.Scan(&p.UserID, &p.FullName, &p.JoinedAt, &leftAt, &p.Reconnects); err != nil {
+			return nil, err
+		}
+		if leftAt.Valid {
+			p.LeftAt = &leftAt.Time
+		}
+		p.Online = online[p.UserID]
+		participants = append(participants, p)
+	}
+	return participants, rows.Err()
+}
+
+// markJoined отмечает подключение студента. Запись создает JoinSession, поэтому
+// переподключением считается только вход после ухода.
+func (h *LiveHandler) markJoined(sessionID, userID int) {
+	_, err := h.DB.Exec(`
+        INSERT INTO live_participants (session_id, user_id) VALUES ($1, $2)
+        ON CONFLICT (session_id, user_id)
+        DO UPDATE SET left_at = NULL, reconnects = reconnects + (left_at IS NOT NULL)
+    `, sessionID, userID)
+	if err == nil {
+		h.Hub.Broadcast(sessionID, live.Event{Type: "join", Data: map[string]int{"user_id": userID}})
+	}
+}
+
+func (h *LiveHandler) markLeft(sessionID, userID int) {
+	_, err := h.DB.Exec(`
+        UPDATE live_participants SET left_at = CURRENT_TIMESTAMP
+        WHERE session_id = $1 AND user_id = $2
+    `, sessionID, userID)
+	if err == nil {
+		h.Hub.Broadcast(sessionID, live.Event{Type: "leave", Data: map[string]int{"user_id": userID}})
+	}
+}
+
+// moduleEvent - текущий модуль сессии, сохраняется для переподключающихся клиентов
+func moduleEvent(moduleID int) live.Event {
+	return live.Event{Type: "module", Data: map[string]int{"module_id": moduleID}, Retain: true}
+}
+
+func writeSSE(w http.ResponseWriter, ev live.Event) error {
+	data, err := json.Marshal(ev.Data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", ev.ID, ev.Type, data)
+	return err
+}
+
+func generateJoinCode() (string, error) {
+	buf := make([]byte, joinCodeLength)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	for i, b := range buf {
+		buf[i] = joinCodeAlphabet[int(b)%len(joinCodeAlphabet)]
+	}
+	return string(buf), nil
+}
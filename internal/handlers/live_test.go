@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"visualmath/internal/live"
+)
+
+// addLecture создает лекцию курса courseID с модулями moduleIDs
+func addLecture(t *testing.T, db *sql.DB, courseID int, moduleIDs ...int) int {
+	t.Helper()
+	var id int
+	if err := db.QueryRow(`
+        INSERT INTO lectures (title, course_id, published) VALUES ('Лекция', $1, TRUE) RETURNING id
+    `, courseID).Scan(&id); err != nil {
+		t.Fatal(err)
+	}
+	for i, m := range moduleIDs {
+		if _, err := db.Exec(`
+            INSERT INTO lecture_modules (lecture_id, module_id, position) VALUES ($1, $2, $3)
+        `, id, m, i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return id
+}
+
+func TestStartSessionChecksLecture(t *testing.T) {
+	db := testDB(t)
+	h := &LiveHandler{DB: db, Hub: live.NewHub()}
+	teacher := addUser(t, db, "teacher", "teacher")
+	scoped := addUser(t, db, "scoped", "teacher")
+	db.Exec(`INSERT INTO permission_scopes (user_id, course_id) VALUES ($1, 2)`, scoped.UserID)
+
+	inLecture := addModule(t, db, "text", "{}")
+	outside := addModule(t, db, "text", "{}")
+	lecture := strconv.Itoa(addLecture(t, db, 1, inLecture))
+
+	cases := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"no lecture", `{}`, http.StatusBadRequest},
+		{"unknown lecture", `{"lecture_id": 999}`, http.StatusNotFound},
+		{"module outside lecture", `{"lecture_id": ` + lecture + `, "module_id": ` + strconv.Itoa(outside) + `}`, http.StatusBadRequest},
+		{"module in lecture", `{"lecture_id": ` + lecture + `, "module_id": ` + strconv.Itoa(inLecture) + `}`, http.StatusOK},
+		{"no module yet", `{"lecture_id": ` + lecture + `}`, http.StatusOK},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if w := serve(h.StartSession, teacher, "POST", tc.body); w.Code != tc.want {
+				t.Errorf("status %d, want %d: %s", w.Code, tc.want, w.Body)
+			}
+		})
+	}
+
+	// право на живые сессии только в другом курсе
+	if w := serve(h.StartSession, scoped, "POST", `{"lecture_id": `+lecture+`}`); w.Code != http.StatusForbidden {
+		t.Errorf("teacher scoped to another course: status %d, want 403", w.Code)
+	}
+}
+
+func TestSetModule(t *testing.T) {
+	db := testDB(t)
+	hub := live.NewHub()
+	h := &LiveHandler{DB: db, Hub: hub}
+	teacher := addUser(t, db, "teacher", "teacher")
+	inLecture := addModule(t, db, "text", "{}")
+	outside := addModule(t, db, "text", "{}")
+	lecture := addLecture(t, db, 1, inLecture)
+
+	w := serve(h.StartSession, teacher, "POST", `{"lecture_id": `+strconv.Itoa(lecture)+`}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("start: %d %s", w.Code, w.Body)
+	}
+	var started struct {
+		Session struct {
+			ID int `json:"id"`
+		} `json:"session"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &started)
+	id := strconv.Itoa(started.Session.ID)
+
+	if w := serve(h.SetModule, teacher, "POST", `{"module_id": `+strconv.Itoa(outside)+`}`, "id", id); w.Code != http.StatusBadRequest {
+		t.Errorf("module outside lecture: status %d, want 400", w.Code)
+	}
+	if w := serve(h.SetModule, teacher, "POST", `{"module_id": `+strconv.Itoa(inLecture)+`}`, "id", id); w.Code != http.StatusOK {
+		t.Errorf("module in lecture: status %d %s", w.Code, w.Body)
+	}
+
+	if w := serve(h.EndSession, teacher, "POST", "", "id", id); w.Code != http.StatusOK {
+		t.Fatalf("end: %d %s", w.Code, w.Body)
+	}
+	if w := serve(h.SetModule, teacher, "POST", `{"module_id": `+strconv.Itoa(inLecture)+`}`, "id", id); w.Code != http.StatusGone {
+		t.Errorf("ended session: status %d, want 410", w.Code)
+	}
+	if hub.IsOpen(started.Session.ID) {
+		t.Error("SetModule reopened an ended session in the hub")
+	}
+}
+
+func TestPollResultsRequireMembership(t *testing.T) {
+	db := testDB(t)
+	h := &LiveHandler{DB: db, Hub: live.NewHub()}
+	teacher := addUser(t, db, "teacher", "teacher")
+	member := addUser(t, db, "member", "student")
+	stranger := addUser(t, db, "stranger", "student")
+	module := addModule(t, db, "question", questions)
+	lecture := addLecture(t, db, 1, module)
+
+	w := serve(h.StartSession, teacher, "POST", `{"lecture_id": `+strconv.Itoa(lecture)+`}`)
+	var started struct {
+		Session struct {
+			ID       int    `json:"id"`
+			JoinCode string `json:"join_code"`
+		} `json:"session"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &started)
+	if w := serve(h.JoinSession, member, "POST", `{"code": "`+started.Session.JoinCode+`"}`); w.Code != http.StatusOK {
+		t.Fatalf("join: %d %s", w.Code, w.Body)
+	}
+
+	var pollID int
+	if err := db.QueryRow(`
+        INSERT INTO polls (session_id, lecture_id, module_id, question, closed_at, revealed)
+        VALUES ($1, $2, $3, '{"question": "2+2", "answers": ["3", "4"], "correct": 1}', CURRENT_TIMESTAMP, TRUE)
+        RETURNING id
+    `, started.Session.ID, lecture, module).Scan(&pollID); err != nil {
+		t.Fatal(err)
+	}
+	id := strconv.Itoa(pollID)
+
+	if w := serve(h.GetPollResults, member, "GET", "", "pollID", id); w.Code != http.StatusOK {
+		t.Errorf("participant: status %d %s", w.Code, w.Body)
+	}
+	if w := serve(h.GetPollResults, stranger, "GET", "", "pollID", id); w.Code != http.StatusForbidden {
+		t.Errorf("user who never joined: status %d, want 403: %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,236 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"visualmath/internal/loginlimit"
+	"visualmath/internal/models"
+)
+
+// accountKey - ключ учетной записи для ограничения попыток. Для несуществующего
+// логина считается его слепой индекс (loginIndex), чтобы перебор не отличался
+// от обычного, а сам логин не попадал в хранилище попыток.
+func accountKey(userID int, index string) string {
+	if userID != 0 {
+		return "user:" + strconv.Itoa(userID)
+	}
+	return "index:" + index
+}
+
+// loginIndex - слепой индекс логина, как в users.login_index
+func loginIndex(db *sql.DB, login string) (string, error) {
+	var index string
+	err := db.QueryRow(`SELECT pii_index($1)`, login).Scan(&index)
+	return index, err
+}
+
+// allowAttempt проверяет ограничения перед проверкой пароля или кода и отвечает 429
+func (h *AuthHandler) allowAttempt(w http.ResponseWriter, r *http.Request, account string) bool {
+	if h.Limiter == nil {
+		return true
+	}
+	d, err := h.Limiter.Check(h.Limiter.ClientIP(r), account)
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return false
+	}
+	if d.Allowed {
+		return true
+	}
+
+	seconds := int(math.Ceil(d.RetryAfter.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	switch d.Reason {
+	case loginlimit.ReasonLocked:
+		http.Error(w, fmt.Sprintf("Вход временно заблокирован после нескольких неудачных попыток. Попробуйте через %s",
+			waitText(d.RetryAfter)), http.StatusTooManyRequests)
+	case loginlimit.ReasonIP:
+		http.Error(w, fmt.Sprintf("Слишком много попыток входа с вашего адреса. Попробуйте через %s",
+			waitText(d.RetryAfter)), http.StatusTooManyRequests)
+	default:
+		http.Error(w, fmt.Sprintf("Подождите %s перед следующей попыткой", waitText(d.RetryAfter)),
+			http.StatusTooManyRequests)
+	}
+	return false
+}
+
+// attemptFailed учитывает неудачную попытку. При блокировке пишет событие
+// в login_events и сообщает владельцу учетной записи письмом. Для
+// несуществующего логина (user == nil) в журнал пишется его слепой индекс index.
+func (h *AuthHandler) attemptFailed(r *http.Request, account string, user *loginUser, index string) {
+	if h.Limiter == nil {
+		return
+	}
+	until, err := h.Limiter.Failure(account)
+	if err != nil {
+		log.Printf("Ограничение попыток входа: %v", err)
+		return
+	}
+	if until.IsZero() {
+		return
+	}
+
+	ip := h.Limiter.ClientIP(r)
+	var userID, unknown interface{}
+	if user != nil {
+		userID = user.ID
+	} else {
+		unknown = index
+	}
+	if _, err := h.DB.Exec(`
+        INSERT INTO login_events (user_id, login, login_index, ip, event, locked_until)
+        VALUES ($1, '', $2, $3, 'lockout', $4)
+    `, userID, unknown, ip, until.UTC()); err != nil {
+		log.Printf("Не удалось записать блокировку входа %s: %v", account, err)
+	}
+	log.Printf("Вход для %s заблокирован до %s (адрес %s)", account, until.Format("15:04"), ip)
+
+	if user == nil || user.Email == "" || h.Mailer == nil {
+		return
+	}
+	body := fmt.Sprintf(`Здравствуйте, %s!
+
+Было несколько неудачных попыток войти в вашу учетную запись VisualMath (%s),
+последняя - с адреса %s. Вход временно заблокирован до %s.
+
+Если это были вы, просто подождите. Если нет - после разблокировки смените пароль
+и включите двухфакторную аутентификацию.
+`, user.FullName, user.Login, ip, until.Format("02.01.2006 15:04"))
+	// письмо не задерживает ответ на попытку входа
+	go func(to string) {
+		if err := h.Mailer.Send(to, "Вход в VisualMath временно заблокирован", body); err != nil {
+			log.Printf("Не удалось отправить уведомление о блокировке %s: %v", to, err)
+		}
+	}(user.Email)
+}
+
+// attemptSucceeded сбрасывает неудачные попытки после входа
+func (h *AuthHandler) attemptSucceeded(userID int) {
+	if h.Limiter == nil {
+		return
+	}
+	if err := h.Limiter.Success(accountKey(userID, "")); err != nil {
+		log.Printf("Ограничение попыток входа: %v", err)
+	}
+}
+
+// waitText - "45 с" или "12 мин" для сообщений об ограничении
+func waitText(d time.Duration) string {
+	if d < time.Minute {
+		return fmt.Sprintf("%d с", int(math.Ceil(d.Seconds())))
+	}
+	return fmt.Sprintf("%d мин", int(math.Ceil(d.Minutes())))
+}
+
+// ListLoginEvents - журнал блокировок входа (?user_id=, ?page=, ?per_page=)
+func (h *AdminHandler) ListLoginEvents(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireAdmin(w, r); !ok {
+		return
+	}
+
+	where := ""
+	var args []interface{}
+	if userID := r.URL.Query().Get("user_id"); userID != "" {
+		id, err := strconv.Atoi(userID)
+		if err != nil {
+			http.Error(w, "Неверный ID пользователя", http.StatusBadRequest)
+			return
+		}
+		args = append(args, id)
+		where = " WHERE e.user_id = $1"
+	}
+
+	page, perPage, ok := pageParams(w, r)
+	if !ok {
+		return
+	}
+
+	var total int
+	if err := h.DB.QueryRow(`SELECT COUNT(*) FROM login_events e`+where, args...).Scan(&total); err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	args = append(args, perPage, (page-1)*perPage)
+	rows, err := h.DB.Query(`
+        SELECT e.id, COALESCE(e.user_id, 0), COALESCE(pii_decrypt(u.login), ''), COALESCE(e.login_index, ''),
+               e.ip, e.event, e.locked_until, e.created_at
+        FROM login_events e LEFT JOIN users u ON u.id = e.user_id`+where+
+		fmt.Sprintf(" ORDER BY e.id DESC LIMIT $%d OFFSET $%d", len(args)-1, len(args)), args...)
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	events := []models.LoginEvent{}
+	for rows.Next() {
+		var e models.LoginEvent
+		var lockedUntil sql.NullTime
+		if err := rows.Scan(&e.ID, &e.UserID, &e.Login, &e.LoginIndex, &e.IP, &e.Event, &lockedUntil, &e.CreatedAt); err != nil {
+			http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+			return
+		}
+		if lockedUntil.Valid {
+			e.LockedUntil = &lockedUntil.Time
+		}
+		events = append(events, e)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"events":   events,
+		"total":    total,
+		"page":     page,
+		"per_page": perPage,
+	})
+}
+
+// UnlockLogin снимает блокировку входа пользователя
+func (h *AdminHandler) UnlockLogin(w http.ResponseWriter, r *http.Request) {
+	admin, ok := requireAdmin(w, r)
+	if !ok {
+		return
+	}
+	target, ok := h.userFromURL(w, r)
+	if !ok {
+		return
+	}
+	if h.Limiter == nil {
+		http.Error(w, "Ограничение попыток входа отключено", http.StatusBadRequest)
+		return
+	}
+
+	account := accountKey(target.ID, "")
+	until, err := h.Limiter.LockedUntil(account)
+	if err == nil {
+		err = h.Limiter.Unlock(account)
+	}
+	if err == nil && !until.IsZero() {
+		err = h.inTx(func(tx *sql.Tx) error {
+			if _, err := tx.Exec(`
+                INSERT INTO login_events (user_id, login, ip, event) VALUES ($1, '', '', 'unlock')
+            `, target.ID); err != nil {
+				return err
+			}
+			return audit(tx, admin.UserID, "user.unlock", target.ID, nil)
+		})
+	}
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":    true,
+		"was_locked": !until.IsZero(),
+	})
+}
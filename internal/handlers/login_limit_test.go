@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"visualmath/internal/loginlimit"
+	"visualmath/internal/pii"
+	"visualmath/internal/storage"
+)
+
+// encryptedDB - база с включенным шифрованием персональных данных
+func encryptedDB(t *testing.T) *sql.DB {
+	t.Helper()
+	keys, err := pii.New([]pii.Key{{ID: "k1", Secret: bytes.Repeat([]byte{1}, pii.KeySize)}},
+		pii.Key{ID: "i1", Secret: bytes.Repeat([]byte{2}, pii.KeySize)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := storage.Open(filepath.Join(t.TempDir(), "visualmath.db"), keys)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// plainText возвращает строки таблиц вне users, где встречается value
+func plainText(t *testing.T, db *sql.DB, value string) []string {
+	t.Helper()
+	var found []string
+	for _, query := range []string{
+		`SELECT login || ' ' || COALESCE(login_index, '') FROM login_events`,
+		`SELECT key FROM login_attempts`,
+		`SELECT key FROM login_lockouts`,
+		`SELECT details FROM audit_log`,
+	} {
+		rows, err := db.Query(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for rows.Next() {
+			var s string
+			if err := rows.Scan(&s); err != nil {
+				t.Fatal(err)
+			}
+			if strings.Contains(strings.ToLower(s), value) {
+				found = append(found, s)
+			}
+		}
+		rows.Close()
+	}
+	return found
+}
+
+func TestLoginLimitStoresNoPlainLogins(t *testing.T) {
+	db := encryptedDB(t)
+	cfg := loginlimit.Config{AccountLimit: 2, AccountWindow: time.Hour, LockoutDuration: time.Hour}
+	h := &AuthHandler{DB: db, Limiter: loginlimit.New(cfg, loginlimit.NewSQLStore(db, time.Hour))}
+
+	// перебор несуществующего логина доходит до блокировки
+	for i, want := range []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusTooManyRequests} {
+		w := httptest.NewRecorder()
+		h.Login(w, httptest.NewRequest("POST", "/api/login",
+			strings.NewReader(`{"login": "Ghost", "password": "secret"}`)))
+		if w.Code != want {
+			t.Fatalf("attempt %d: status %d, want %d: %s", i+1, w.Code, want, w.Body)
+		}
+	}
+	var index string
+	if err := db.QueryRow(`SELECT login_index FROM login_events WHERE event = 'lockout'`).Scan(&index); err != nil {
+		t.Fatal(err)
+	}
+	if want, _ := loginIndex(db, "ghost"); index != want {
+		t.Errorf("lockout logged with index %q, want %q", index, want)
+	}
+
+	// объединение учетных записей пишет в журнал индексы вместо логина и почты
+	admin := addUser(t, db, "admin", "admin")
+	target := addUser(t, db, "ivanov", "student")
+	source := addUser(t, db, "ghost", "student")
+	a := &AdminHandler{DB: db}
+	if w := serve(a.MergeUsers, admin, "POST", `{"source_id": `+strconv.Itoa(source.UserID)+`}`,
+		"id", strconv.Itoa(target.UserID)); w.Code != http.StatusOK {
+		t.Fatalf("merge: %d %s", w.Code, w.Body)
+	}
+
+	if found := plainText(t, db, "ghost"); len(found) > 0 {
+		t.Errorf("plain login stored outside users: %q", found)
+	}
+}
+
+func TestMigrateLoginLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "visualmath.db")
+	db := storage.Open(path, nil)
+	for _, stmt := range []string{
+		`INSERT INTO login_events (user_id, login, ip, event) VALUES (NULL, 'ghost', '', 'lockout')`,
+		`INSERT INTO login_attempts (key, at) VALUES ('login:ghost', CURRENT_TIMESTAMP)`,
+		`INSERT INTO login_lockouts (key, locked_until) VALUES ('login:ghost', CURRENT_TIMESTAMP)`,
+		`INSERT INTO audit_log (actor_id, action, details)
+         VALUES (1, 'user.merge', '{"source_id": 7, "source_login": "ghost", "source_email": "ghost@uni.ru"}')`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Close()
+
+	db = storage.Open(path, nil)
+	defer db.Close()
+	// без ключей индекс - нормализованное значение с префиксом plain:
+	var login, index, details string
+	if err := db.QueryRow(`SELECT login, login_index FROM login_events`).Scan(&login, &index); err != nil {
+		t.Fatal(err)
+	}
+	if login != "" || index != "plain:ghost" {
+		t.Errorf("login_events: login %q, index %q", login, index)
+	}
+	var limits int
+	db.QueryRow(`SELECT (SELECT COUNT(*) FROM login_attempts) + (SELECT COUNT(*) FROM login_lockouts)`).Scan(&limits)
+	if limits != 0 {
+		t.Errorf("%d limiter rows keyed by login left", limits)
+	}
+	db.QueryRow(`SELECT details FROM audit_log`).Scan(&details)
+	if strings.Contains(details, `"source_login"`) || !strings.Contains(details, `"source_email_index":"plain:ghost@uni.ru"`) {
+		t.Errorf("audit details not migrated: %s", details)
+	}
+}
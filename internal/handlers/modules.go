@@ -1,13 +1,20 @@
 package handlers
 
 import (
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+
+	"visualmath/internal/auth"
+	"visualmath/internal/models"
 )
 
 type ModuleHandler struct {
-	// Позже добавим БД
+	DB *sql.DB
 }
 
 // ListModules показывает список всех модулей
@@ -19,6 +26,7 @@ func (h *ModuleHandler) ListModules(w http.ResponseWriter, r *http.Request) {
 <html>
 <head>
     <title>Библиотека модулей - VisualMath</title>
+    <script src="/static/js/csrf.js"></script>
     <link rel="stylesheet" href="/static/css/style.css">
     <!-- MathJax для LaTeX -->
     <script src="https://polyfill.io/v3/polyfill.min.js?features=es6"></script>
@@ -190,10 +198,6 @@ func (h *ModuleHandler) ListModules(w http.ResponseWriter, r *http.Request) {
             <input type="text" class="search-box" placeholder="Поиск модулей..." id="searchInput">
             <select class="filter-select" id="courseFilter">
                 <option value="">Все предметы</option>
-                <option value="Математический анализ">Математический анализ</option>
-                <option value="Линейная алгебра">Линейная алгебра</option>
-                <option value="Дискретная математика">Дискретная математика</option>
-                <option value="Экономика">Экономика</option>
             </select>
             <select class="filter-select" id="typeFilter">
                 <option value="">Все типы</option>
@@ -261,6 +265,8 @@ func (h *ModuleHandler) ListModules(w http.ResponseWriter, r *http.Request) {
                         '<div class="module-actions">' +
                         '<a href="/modules/view/' + module.id + '" class="action-btn primary">Открыть</a>' +
                         '<a href="/modules/edit/' + module.id + '" class="action-btn">Редактировать</a>' +
+                        (module.type === 'question' || module.type === 'test'
+                            ? '<a href="/modules/analysis/' + module.id + '" class="action-btn">📊 Анализ</a>' : '') +
                         '</div>' +
                         '</div>';
             });
@@ -278,13 +284,21 @@ func (h *ModuleHandler) ListModules(w http.ResponseWriter, r *http.Request) {
             // В реальном приложении здесь будет запрос к API
         }
         
+        // Предметы из справочника курсов
+        fetch('/api/courses')
+            .then(response => response.json())
+            .then(courses => {
+                const select = document.getElementById('courseFilter');
+                courses.forEach(c => select.add(new Option(c.name, c.id)));
+            });
+
         // Загружаем модули при загрузке страницы
         window.addEventListener('DOMContentLoaded', loadModules);
     </script>
 </body>
 </html>`
 
-	fmt.Fprintf(w, html)
+	fmt.Fprint(w, html)
 }
 
 // CreateModulePage показывает страницу создания модуля
@@ -296,6 +310,7 @@ func (h *ModuleHandler) CreateModulePage(w http.ResponseWriter, r *http.Request)
 <html>
 <head>
     <title>Создать модуль - VisualMath</title>
+    <script src="/static/js/csrf.js"></script>
     <link rel="stylesheet" href="/static/css/style.css">
     <!-- MathJax для LaTeX -->
     <script src="https://polyfill.io/v3/polyfill.min.js?features=es6"></script>
@@ -367,6 +382,17 @@ func (h *ModuleHandler) CreateModulePage(w http.ResponseWriter, r *http.Request)
             resize: vertical;
             font-family: monospace;
         }
+        .form-group .checkbox-group {
+            display: flex;
+            align-items: center;
+            gap: 10px;
+        }
+        .form-group .checkbox-group input {
+            width: auto;
+        }
+        .form-group .checkbox-group label {
+            margin: 0;
+        }
         .module-type-selector {
             display: grid;
             grid-template-columns: repeat(auto-fit, minmax(180px, 1fr));
@@ -550,13 +576,6 @@ func (h *ModuleHandler) CreateModulePage(w http.ResponseWriter, r *http.Request)
                 <label for="moduleCourse">Предмет *</label>
                 <select id="moduleCourse" name="course" required>
                     <option value="">Выберите предмет</option>
-                    <option value="Математический анализ">Математический анализ</option>
-                    <option value="Линейная алгебра и аналитическая геометрия">Линейная алгебра и аналитическая геометрия</option>
-                    <option value="Дискретная математика">Дискретная математика</option>
-                    <option value="Экономика">Экономика</option>
-                    <option value="Физика">Физика</option>
-                    <option value="Дифференциальные уравнения">Дифференциальные уравнения</option>
-                    <option value="Литература">Литература</option>
                 </select>
             </div>
             
@@ -566,6 +585,19 @@ func (h *ModuleHandler) CreateModulePage(w http.ResponseWriter, r *http.Request)
                           placeholder="Опишите содержание модуля (необязательно)"></textarea>
             </div>
             
+            <div class="form-group">
+                <label for="moduleSkills">Навыки</label>
+                <input type="text" id="moduleSkills" name="skills"
+                       placeholder="Через запятую, например: цепное правило, определитель 3×3">
+            </div>
+
+            <div class="form-group">
+                <div class="checkbox-group">
+                    <input type="checkbox" id="modulePublished" name="published" checked>
+                    <label for="modulePublished">Опубликовать: студенты курса увидят модуль вне лекций</label>
+                </div>
+            </div>
+            
             <!-- Выбор типа модуля -->
             <div class="form-group">
                 <label>Тип модуля *</label>
@@ -680,7 +712,7 @@ func (h *ModuleHandler) CreateModulePage(w http.ResponseWriter, r *http.Request)
                     html = '<div class="form-group">' +
                            '<label for="questions">Вопросы и ответы *</label>' +
                            '<textarea id="questions" name="questions" rows="12" required placeholder=\'[\n  {\n    "question": "Что такое производная функции?",\n    "answers": [\n      "Скорость изменения функции",\n      "Площадь под графиком",\n      "Корень уравнения",\n      "Предел функции"\n    ],\n    "correct": 0,\n    "explanation": "Производная показывает скорость изменения функции в точке"\n  },\n  {\n    "question": "Чему равна производная константы?",\n    "answers": ["0", "1", "Сама константа", "Не существует"],\n    "correct": 0\n  }\n]\'></textarea>' +
-                           '<p style="color: #7f8c8d; font-size: 14px; margin-top: 5px;">Формат: JSON массив объектов. Каждый вопрос должен содержать:<br>• <code>"question"</code> - текст вопроса<br>• <code>"answers"</code> - массив вариантов ответов<br>• <code>"correct"</code> - индекс правильного ответа (0, 1, 2...)<br>• <code>"explanation"</code> - объяснение (опционально)</p>' +
+                           '<p style="color: #7f8c8d; font-size: 14px; margin-top: 5px;">Формат: JSON массив объектов. Каждый вопрос должен содержать:<br>• <code>"question"</code> - текст вопроса<br>• <code>"answers"</code> - массив вариантов ответов<br>• <code>"correct"</code> - индекс правильного ответа (0, 1, 2...)<br>• <code>"explanation"</code> - объяснение (опционально)<br>• <code>"skills"</code> - навыки вопроса (опционально)<br>• <code>"type": "free"</code> - развернутый ответ в LaTeX/Markdown, проверяется вручную; вместо <code>"answers"</code> можно задать <code>"rubric": [{"title": "...", "points": 2}]</code></p>' +
                            '</div>';
                     break;
                     
@@ -796,6 +828,14 @@ func (h *ModuleHandler) CreateModulePage(w http.ResponseWriter, r *http.Request)
             updateImagePreview();
         }
         
+        // Предметы из справочника курсов
+        fetch('/api/courses')
+            .then(response => response.json())
+            .then(courses => {
+                const select = document.getElementById('moduleCourse');
+                courses.forEach(c => select.add(new Option(c.name, c.id)));
+            });
+
         // Обработка формы
         document.getElementById('createModuleForm').addEventListener('submit', async function(e) {
             e.preventDefault();
@@ -806,14 +846,16 @@ func (h *ModuleHandler) CreateModulePage(w http.ResponseWriter, r *http.Request)
             // Собираем данные
             const formData = {
                 title: document.getElementById('moduleTitle').value,
-                course: document.getElementById('moduleCourse').value,
+                course_id: parseInt(document.getElementById('moduleCourse').value) || 0,
                 description: document.getElementById('moduleDescription').value,
                 type: document.getElementById('moduleType').value,
-                content: getModuleContent()
+                content: getModuleContent(),
+                published: document.getElementById('modulePublished').checked,
+                skills: document.getElementById('moduleSkills').value.split(',').map(s => s.trim()).filter(s => s)
             };
             
             // Валидация
-            if (!formData.title || !formData.course || !formData.type) {
+            if (!formData.title || !formData.course_id || !formData.type) {
                 showMessage('Заполните все обязательные поля', 'error');
                 return;
             }
@@ -896,17 +938,21 @@ func (h *ModuleHandler) CreateModulePage(w http.ResponseWriter, r *http.Request)
 </body>
 </html>`
 
-	fmt.Fprintf(w, html)
+	fmt.Fprint(w, html)
 }
 
 // CreateModule обрабатывает создание модуля
 func (h *ModuleHandler) CreateModule(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.GetUserFromContext(r.Context())
+
 	var request struct {
-		Title       string      `json:"title"`
-		Course      string      `json:"course"`
-		Description string      `json:"description"`
-		Type        string      `json:"type"`
-		Content     interface{} `json:"content"`
+		Title       string          `json:"title"`
+		CourseID    int             `json:"course_id"`
+		Description string          `json:"description"`
+		Type        string          `json:"type"`
+		Content     json.RawMessage `json:"content"`
+		Published   *bool           `json:"published"` // не указан - модуль не опубликован
+		Skills      []string        `json:"skills"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
@@ -915,137 +961,313 @@ func (h *ModuleHandler) CreateModule(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Валидация
-	if request.Title == "" || request.Course == "" || request.Type == "" {
+	if request.Title == "" || request.CourseID == 0 || request.Type == "" {
 		http.Error(w, "Missing required fields", http.StatusBadRequest)
 		return
 	}
+	if !courseExists(h.DB, request.CourseID) {
+		http.Error(w, "Course not found", http.StatusBadRequest)
+		return
+	}
+	if courseArchived(h.DB, request.CourseID) {
+		http.Error(w, "Course is archived", http.StatusConflict)
+		return
+	}
+	if !validModuleType(request.Type) {
+		http.Error(w, "Invalid module type", http.StatusBadRequest)
+		return
+	}
+	if !canEditCourses(h.DB, w, r, auth.PermModuleEdit, request.CourseID) {
+		return
+	}
+	if len(request.Content) == 0 {
+		request.Content = json.RawMessage("{}")
+	}
+
+	var moduleID int
+	err := h.DB.QueryRow(`
+        INSERT INTO modules (title, course_id, author_id, description, module_type, content, published)
+        VALUES ($1, $2, $3, $4, $5, $6, $7)
+        RETURNING id
+    `, request.Title, request.CourseID, user.UserID, request.Description, request.Type,
+		string(request.Content), request.Published != nil && *request.Published).Scan(&moduleID)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if err := saveModuleSkills(h.DB, moduleID, request.Type, request.Content, request.Skills); err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
 
-	// Здесь будет сохранение в БД
-	// Пока просто возвращаем успех
+	module, err := loadModule(h.DB, moduleID)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
 
 	response := map[string]interface{}{
-		"success":  true,
-		"message":  "Module created successfully",
-		"module": map[string]interface{}{
-			"id":          1,
-			"title":       request.Title,
-			"course":      request.Course,
-			"description": request.Description,
-			"type":        request.Type,
-			"content":     request.Content,
-			"created_at":  "2024-01-01 12:00:00",
-		},
+		"success": true,
+		"message": "Module created successfully",
+		"module":  moduleResponse(module),
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
 
-// GetModule возвращает информацию о модуле
+// GetModule возвращает информацию о модуле. Студенты получают вопросы без правильных ответов.
 func (h *ModuleHandler) GetModule(w http.ResponseWriter, r *http.Request) {
-	//_ := "1" // временно фиксированный ID
+	user, _ := auth.GetUserFromContext(r.Context())
+	moduleID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid module ID", http.StatusBadRequest)
+		return
+	}
 
-	// Здесь будет получение из БД
-	// Пока возвращаем тестовые данные
-	module := map[string]interface{}{
-		"id":          1,
-		"title":       "Пример модуля",
-		"course":      "Математический анализ",
-		"description": "Описание модуля",
-		"type":        "text",
-		"content": map[string]interface{}{
-			"text": "Текст модуля с формулами $E=mc^2$",
-		},
-		"author":      "Иванов И.И.",
-		"created_at":  "2024-01-01 12:00:00",
+	module, err := loadModule(h.DB, moduleID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Module not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if !canViewModule(h.DB, w, user, module) {
+		return
+	}
+	if !auth.IsStaff(user.UserType) {
+		module.Content = hideAnswers(module.ModuleType, module.Content)
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(module)
+	json.NewEncoder(w).Encode(moduleResponse(module))
 }
 
 // UpdateModule обновляет модуль
 func (h *ModuleHandler) UpdateModule(w http.ResponseWriter, r *http.Request) {
-	// moduleID не используется в текущей реализации
-	// В реальной версии нужно будет извлекать ID из URL
+	moduleID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid module ID", http.StatusBadRequest)
+		return
+	}
 
 	var request struct {
-		Title       string      `json:"title"`
-		Course      string      `json:"course"`
-		Description string      `json:"description"`
-		Type        string      `json:"type"`
-		Content     interface{} `json:"content"`
+		Title       string          `json:"title"`
+		CourseID    int             `json:"course_id"`
+		Description string          `json:"description"`
+		Type        string          `json:"type"`
+		Content     json.RawMessage `json:"content"`
+		Published   *bool           `json:"published"` // не указан - остается прежним
+		Skills      []string        `json:"skills"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
 		http.Error(w, "Invalid JSON", http.StatusBadRequest)
 		return
 	}
+	if request.Title == "" || request.CourseID == 0 || !validModuleType(request.Type) {
+		http.Error(w, "Missing required fields", http.StatusBadRequest)
+		return
+	}
+	if !courseExists(h.DB, request.CourseID) {
+		http.Error(w, "Course not found", http.StatusBadRequest)
+		return
+	}
+	// модули прошлых семестров доступны только для чтения, как и их курсы
+	if courseArchived(h.DB, request.CourseID) || courseArchived(h.DB, moduleCourse(h.DB, moduleID)) {
+		http.Error(w, "Course is archived", http.StatusConflict)
+		return
+	}
+	if !canEditCourses(h.DB, w, r, auth.PermModuleEdit, moduleCourse(h.DB, moduleID), request.CourseID) {
+		return
+	}
+	if len(request.Content) == 0 {
+		request.Content = json.RawMessage("{}")
+	}
 
-	// Здесь будет обновление в БД
+	result, err := h.DB.Exec(`
+        UPDATE modules
+        SET title = $1, course_id = $2, description = $3, module_type = $4, content = $5,
+            published = COALESCE($6, published)
+        WHERE id = $7
+    `, request.Title, request.CourseID, request.Description, request.Type,
+		string(request.Content), request.Published, moduleID)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "Module not found", http.StatusNotFound)
+		return
+	}
+	if err := saveModuleSkills(h.DB, moduleID, request.Type, request.Content, request.Skills); err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
 
 	response := map[string]interface{}{
 		"success":   true,
 		"message":   "Module updated successfully",
-		"module_id": "1",
+		"module_id": moduleID,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
+
 // DeleteModule удаляет модуль
 func (h *ModuleHandler) DeleteModule(w http.ResponseWriter, r *http.Request) {
-	// moduleID не используется в текущей реализации
-	// В реальной версии нужно будет извлекать ID из URL
+	moduleID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid module ID", http.StatusBadRequest)
+		return
+	}
+	if !canEditCourses(h.DB, w, r, auth.PermModuleEdit, moduleCourse(h.DB, moduleID)) {
+		return
+	}
+	if courseArchived(h.DB, moduleCourse(h.DB, moduleID)) {
+		http.Error(w, "Course is archived", http.StatusConflict)
+		return
+	}
 
-	// Здесь будет удаление из БД
+	result, err := h.DB.Exec(`DELETE FROM modules WHERE id = $1`, moduleID)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "Module not found", http.StatusNotFound)
+		return
+	}
 
 	response := map[string]interface{}{
 		"success":   true,
 		"message":   "Module deleted successfully",
-		"module_id": "1",
+		"module_id": moduleID,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
 
-// ListModulesAPI возвращает список модулей для API
+// ListModulesAPI возвращает список модулей для API; студентам - только модули их курсов
 func (h *ModuleHandler) ListModulesAPI(w http.ResponseWriter, r *http.Request) {
-	// Здесь будет получение из БД
-	// Пока возвращаем тестовые данные
-	modules := []map[string]interface{}{
-		{
-			"id":          1,
-			"title":       "Производные функций",
-			"course":      "Математический анализ",
-			"description": "Основы дифференцирования",
-			"type":        "text",
-			"author":      "Иванов И.И.",
-			"created_at":  "2024-01-01 12:00:00",
-		},
-		{
-			"id":          2,
-			"title":       "Матрицы и определители",
-			"course":      "Линейная алгебра",
-			"description": "Работа с матрицами",
-			"type":        "visual",
-			"author":      "Петров П.П.",
-			"created_at":  "2024-01-02 14:30:00",
-		},
-		{
-			"id":          3,
-			"title":       "Тест по теории вероятностей",
-			"course":      "Теория вероятностей",
-			"description": "Контрольный тест",
-			"type":        "test",
-			"author":      "Сидоров С.С.",
-			"created_at":  "2024-01-03 10:15:00",
-		},
+	user, _ := auth.GetUserFromContext(r.Context())
+	modules, err := listModules(h.DB)
+	if err == nil {
+		modules, err = visibleModules(h.DB, user, modules)
+	}
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	result := []map[string]interface{}{}
+	for i := range modules {
+		if !auth.IsStaff(user.UserType) {
+			modules[i].Content = hideAnswers(modules[i].ModuleType, modules[i].Content)
+		}
+		result = append(result, moduleResponse(&modules[i]))
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(modules)
+	json.NewEncoder(w).Encode(result)
+}
+
+// moduleQuery выбирает модуль вместе с именем автора и названием курса
+const moduleQuery = `
+    SELECT m.id, m.title, COALESCE(m.course_id, 0), COALESCE(c.name, ''), COALESCE(m.author_id, 0),
+           COALESCE(pii_decrypt(u.full_name), ''), m.description, m.module_type, m.content, m.created_at, m.published,
+           (SELECT json_group_array(name) FROM (
+                SELECT s.name FROM module_skills ms JOIN skills s ON s.id = ms.skill_id
+                WHERE ms.module_id = m.id AND NOT ms.from_questions ORDER BY s.name))
+    FROM modules m
+    LEFT JOIN users u ON u.id = m.author_id
+    LEFT JOIN courses c ON c.id = m.course_id
+`
+
+func scanModule(row interface{ Scan(...interface{}) error }) (*models.Module, error) {
+	var m models.Module
+	var content, skills string
+	err := row.Scan(&m.ID, &m.Title, &m.CourseID, &m.CourseName, &m.AuthorID, &m.AuthorName,
+		&m.Description, &m.ModuleType, &content, &m.CreatedAt, &m.Published, &skills)
+	if err != nil {
+		return nil, err
+	}
+	m.Content = json.RawMessage(content)
+	json.Unmarshal([]byte(skills), &m.Skills)
+	return &m, nil
+}
+
+func loadModule(db *sql.DB, id int) (*models.Module, error) {
+	return scanModule(db.QueryRow(moduleQuery+` WHERE m.id = $1`, id))
+}
+
+func listModules(db *sql.DB) ([]models.Module, error) {
+	rows, err := db.Query(moduleQuery + ` ORDER BY m.created_at DESC, m.id DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	modules := []models.Module{}
+	for rows.Next() {
+		m, err := scanModule(rows)
+		if err != nil {
+			return nil, err
+		}
+		modules = append(modules, *m)
+	}
+	return modules, rows.Err()
+}
+
+// hideAnswers убирает из вопросов модуля правильные ответы и пояснения. Так модуль
+// отдается студентам: балл за ответы считает сервер при сдаче попытки, и ключ
+// на клиенте позволил бы подобрать ответы. Тесты ссылаются на модули с вопросами
+// и ответов не содержат.
+func hideAnswers(moduleType string, content json.RawMessage) json.RawMessage {
+	if moduleType != "question" {
+		return content
+	}
+	var questions []map[string]json.RawMessage
+	if err := json.Unmarshal(content, &questions); err != nil {
+		return json.RawMessage("[]")
+	}
+	for _, q := range questions {
+		delete(q, "correct")
+		delete(q, "explanation")
+	}
+	hidden, err := json.Marshal(questions)
+	if err != nil {
+		return json.RawMessage("[]")
+	}
+	return hidden
+}
+
+// moduleResponse сохраняет поля, которые ожидают страницы модулей и редактор лекций
+func moduleResponse(m *models.Module) map[string]interface{} {
+	return map[string]interface{}{
+		"id":          m.ID,
+		"title":       m.Title,
+		"course":      m.CourseName,
+		"course_id":   m.CourseID,
+		"description": m.Description,
+		"type":        m.ModuleType,
+		"content":     m.Content,
+		"author":      m.AuthorName,
+		"published":   m.Published,
+		"skills":      m.Skills,
+		"created_at":  m.CreatedAt.Format("2006-01-02 15:04:05"),
+	}
+}
+
+func validModuleType(t string) bool {
+	switch t {
+	case "text", "visual", "question", "test":
+		return true
+	}
+	return false
 }
 
 // ViewModulePage показывает страницу просмотра модуля
@@ -1059,6 +1281,7 @@ func (h *ModuleHandler) ViewModulePage(w http.ResponseWriter, r *http.Request) {
 <html>
 <head>
     <title>Просмотр модуля - VisualMath</title>
+    <script src="/static/js/csrf.js"></script>
     <link rel="stylesheet" href="/static/css/style.css">
     <!-- MathJax для LaTeX -->
     <script src="https://polyfill.io/v3/polyfill.min.js?features=es6"></script>
@@ -1201,7 +1424,7 @@ func (h *ModuleHandler) ViewModulePage(w http.ResponseWriter, r *http.Request) {
 </body>
 </html>`
 
-	fmt.Fprintf(w, html)
+	fmt.Fprint(w, html)
 }
 
 // EditModulePage показывает страницу редактирования модуля
@@ -1215,6 +1438,7 @@ func (h *ModuleHandler) EditModulePage(w http.ResponseWriter, r *http.Request) {
 <html>
 <head>
     <title>Редактирование модуля - VisualMath</title>
+    <script src="/static/js/csrf.js"></script>
     <link rel="stylesheet" href="/static/css/style.css">
     <!-- MathJax для LaTeX -->
     <script src="https://polyfill.io/v3/polyfill.min.js?features=es6"></script>
@@ -1273,6 +1497,17 @@ func (h *ModuleHandler) EditModulePage(w http.ResponseWriter, r *http.Request) {
             border-radius: 8px;
             font-size: 16px;
         }
+        .form-group .checkbox-group {
+            display: flex;
+            align-items: center;
+            gap: 10px;
+        }
+        .form-group .checkbox-group input {
+            width: auto;
+        }
+        .form-group .checkbox-group label {
+            margin: 0;
+        }
         .form-actions {
             display: flex;
             gap: 15px;
@@ -1320,9 +1555,7 @@ func (h *ModuleHandler) EditModulePage(w http.ResponseWriter, r *http.Request) {
             <div class="form-group">
                 <label for="editCourse">Предмет *</label>
                 <select id="editCourse" name="course" required>
-                    <option value="Математический анализ" selected>Математический анализ</option>
-                    <option value="Линейная алгебра">Линейная алгебра</option>
-                    <option value="Дискретная математика">Дискретная математика</option>
+                    <option value="">Выберите предмет</option>
                 </select>
             </div>
             
@@ -1330,6 +1563,13 @@ func (h *ModuleHandler) EditModulePage(w http.ResponseWriter, r *http.Request) {
                 <label for="editDescription">Краткое описание</label>
                 <textarea id="editDescription" name="description">Основы дифференцирования</textarea>
             </div>
+
+            <div class="form-group">
+                <div class="checkbox-group">
+                    <input type="checkbox" id="editPublished" name="published">
+                    <label for="editPublished">Опубликовать: студенты курса увидят модуль вне лекций</label>
+                </div>
+            </div>
             
             <div class="form-group">
                 <label for="editContent">Содержание модуля *</label>
@@ -1360,14 +1600,28 @@ $$f'(x_0) = \lim_{\Delta x \to 0} \frac{f(x_0 + \Delta x) - f(x_0)}{\Delta x}$$
     </div>
     
     <script>
+        // Предметы из справочника курсов
+        fetch('/api/courses')
+            .then(response => response.json())
+            .then(courses => {
+                const select = document.getElementById('editCourse');
+                courses.forEach(c => select.add(new Option(c.name, c.id)));
+            });
+
+        // Текущее состояние публикации, чтобы сохранение не сбрасывало его
+        fetch('/api/modules/` + moduleID + `')
+            .then(response => response.json())
+            .then(m => { document.getElementById('editPublished').checked = m.published; });
+
         document.getElementById('editModuleForm').addEventListener('submit', async function(e) {
             e.preventDefault();
             
             const formData = {
                 title: document.getElementById('editTitle').value,
-                course: document.getElementById('editCourse').value,
+                course_id: parseInt(document.getElementById('editCourse').value) || 0,
                 description: document.getElementById('editDescription').value,
-                content: document.getElementById('editContent').value
+                content: document.getElementById('editContent').value,
+                published: document.getElementById('editPublished').checked
             };
             
             try {
@@ -1403,5 +1657,5 @@ $$f'(x_0) = \lim_{\Delta x \to 0} \frac{f(x_0 + \Delta x) - f(x_0)}{\Delta x}$$
 </body>
 </html>`
 
-	fmt.Fprintf(w, html)
+	fmt.Fprint(w, html)
 }
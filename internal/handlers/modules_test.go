@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	"visualmath/internal/auth"
+	"visualmath/internal/storage"
+)
+
+// testDB - пустая база со всеми миграциями во временном каталоге теста
+func testDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db := storage.Open(filepath.Join(t.TempDir(), "visualmath.db"), nil)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// addUser создает пользователя и возвращает его данные для контекста запроса
+func addUser(t *testing.T, db *sql.DB, login, userType string) *auth.UserClaims {
+	t.Helper()
+	var id int
+	err := db.QueryRow(`
+        INSERT INTO users (login, password_hash, full_name, user_type, email, login_index, email_index)
+        VALUES (pii_encrypt($1), '', pii_encrypt($1), $2, pii_encrypt($3), pii_index($1), pii_index($3))
+        RETURNING id
+    `, login, userType, login+"@uni.ru").Scan(&id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &auth.UserClaims{UserID: id, Login: login, UserType: userType}
+}
+
+// serve вызывает обработчик от имени user с параметрами маршрута params ("id", "5", ...)
+func serve(handler http.HandlerFunc, user *auth.UserClaims, method, body string, params ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, "/", strings.NewReader(body))
+	route := chi.NewRouteContext()
+	for i := 0; i+1 < len(params); i += 2 {
+		route.URLParams.Add(params[i], params[i+1])
+	}
+	ctx := context.WithValue(r.Context(), chi.RouteCtxKey, route)
+	ctx = context.WithValue(ctx, auth.UserContextKey, *user)
+	w := httptest.NewRecorder()
+	handler(w, r.WithContext(ctx))
+	return w
+}
+
+func TestUpdateModuleKeepsPublished(t *testing.T) {
+	db := testDB(t)
+	h := &ModuleHandler{DB: db}
+	teacher := addUser(t, db, "teacher", "teacher")
+	student := addUser(t, db, "student", "student")
+	if _, err := db.Exec(`INSERT INTO enrollments (course_id, student_id) VALUES (1, $1)`, student.UserID); err != nil {
+		t.Fatal(err)
+	}
+
+	w := serve(h.CreateModule, teacher, "POST",
+		`{"title": "Пределы", "course_id": 1, "type": "text", "content": {"text": "..."}, "published": true}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("create: %d %s", w.Code, w.Body)
+	}
+	var id int
+	db.QueryRow(`SELECT id FROM modules WHERE title = 'Пределы'`).Scan(&id)
+	moduleID := strconv.Itoa(id)
+
+	// редактор, который не знает о публикации, не должен ее снимать
+	w = serve(h.UpdateModule, teacher, "PUT",
+		`{"title": "Пределы функций", "course_id": 1, "type": "text", "content": {"text": "..."}}`, "id", moduleID)
+	if w.Code != http.StatusOK {
+		t.Fatalf("update: %d %s", w.Code, w.Body)
+	}
+	if w := serve(h.GetModule, student, "GET", "", "id", moduleID); w.Code != http.StatusOK {
+		t.Fatalf("student after update without published: %d %s", w.Code, w.Body)
+	}
+
+	w = serve(h.UpdateModule, teacher, "PUT",
+		`{"title": "Пределы функций", "course_id": 1, "type": "text", "content": {"text": "..."}, "published": false}`,
+		"id", moduleID)
+	if w.Code != http.StatusOK {
+		t.Fatalf("unpublish: %d %s", w.Code, w.Body)
+	}
+	if w := serve(h.GetModule, student, "GET", "", "id", moduleID); w.Code != http.StatusNotFound {
+		t.Fatalf("student after unpublishing: %d, want 404", w.Code)
+	}
+}
+
+func TestModuleEditIsScopedToCourse(t *testing.T) {
+	db := testDB(t)
+	h := &ModuleHandler{DB: db}
+	admin := addUser(t, db, "admin", "admin")
+	teacher := addUser(t, db, "teacher", "teacher")
+	// преподавателю право выдано только в курсе 1
+	if _, err := db.Exec(`INSERT INTO permission_scopes (user_id, course_id) VALUES ($1, 1)`, teacher.UserID); err != nil {
+		t.Fatal(err)
+	}
+
+	ids := map[int]string{}
+	for _, course := range []int{1, 2} {
+		body := `{"title": "Модуль", "course_id": ` + strconv.Itoa(course) + `, "type": "text", "content": {}}`
+		if w := serve(h.CreateModule, admin, "POST", body); w.Code != http.StatusOK {
+			t.Fatalf("create in course %d: %d %s", course, w.Code, w.Body)
+		}
+		var id int
+		db.QueryRow(`SELECT MAX(id) FROM modules`).Scan(&id)
+		ids[course] = strconv.Itoa(id)
+	}
+
+	cases := []struct {
+		name    string
+		handler http.HandlerFunc
+		method  string
+		module  string
+		body    string
+		want    int
+	}{
+		{"update own course", h.UpdateModule, "PUT", ids[1], `{"title": "A", "course_id": 1, "type": "text"}`, http.StatusOK},
+		{"update other course", h.UpdateModule, "PUT", ids[2], `{"title": "A", "course_id": 2, "type": "text"}`, http.StatusForbidden},
+		{"move into own course", h.UpdateModule, "PUT", ids[2], `{"title": "A", "course_id": 1, "type": "text"}`, http.StatusForbidden},
+		{"move out of own course", h.UpdateModule, "PUT", ids[1], `{"title": "A", "course_id": 2, "type": "text"}`, http.StatusForbidden},
+		{"create in other course", h.CreateModule, "POST", "", `{"title": "A", "course_id": 2, "type": "text"}`, http.StatusForbidden},
+		{"delete other course", h.DeleteModule, "DELETE", ids[2], "", http.StatusForbidden},
+		{"delete own course", h.DeleteModule, "DELETE", ids[1], "", http.StatusOK},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if w := serve(tc.handler, teacher, tc.method, tc.body, "id", tc.module); w.Code != tc.want {
+				t.Errorf("status %d, want %d: %s", w.Code, tc.want, w.Body)
+			}
+		})
+	}
+}
@@ -4,6 +4,7 @@ import (
     "context"
     "database/sql"
     "encoding/json"
+    "errors"
     "fmt"
     "net/http"
     "strings"
@@ -14,6 +15,9 @@ import (
 	"visualmath/internal/models"
 )
 
+// errUserInactive - учетная запись отключена администратором
+var errUserInactive = errors.New("учетная запись отключена")
+
 type OAuthHandler struct {
     DB      *sql.DB
     OAuth   *auth.OAuthConfig
@@ -109,6 +113,10 @@ func (h *OAuthHandler) handleOAuthCallback(w http.ResponseWriter, r *http.Reques
     
     // Ищем или создаем пользователя в базе данных
     user, err := h.findOrCreateUser(userInfo)
+    if err == errUserInactive {
+        http.Error(w, "Учетная запись отключена", http.StatusForbidden)
+        return
+    }
     if err != nil {
         http.Error(w, fmt.Sprintf("Ошибка базы данных: %v", err), http.StatusInternalServerError)
         return
@@ -132,9 +140,10 @@ func (h *OAuthHandler) findOrCreateUser(oauthUser *auth.OAuthUserInfo) (*models.
     
     // Сначала ищем по OAuth ID (provider_id)
     query := `
-        SELECT u.id, u.login, u.full_name, u.user_type, u.group_number, u.email
+        SELECT u.id, u.login, u.full_name, u.user_type, COALESCE(u.group_id, 0), COALESCE(g.name, ''), u.email, u.active
         FROM users u
         INNER JOIN oauth_connections oc ON u.id = oc.user_id
+        LEFT JOIN groups g ON g.id = u.group_id
         WHERE oc.provider = $1 AND oc.provider_user_id = $2
     `
     
@@ -143,10 +152,15 @@ func (h *OAuthHandler) findOrCreateUser(oauthUser *auth.OAuthUserInfo) (*models.
         &user.Login,
         &user.FullName,
         &user.UserType,
+        &user.GroupID,
         &user.GroupNumber,
         &user.Email,
+        &user.Active,
     )
     
+    if err == nil && !user.Active {
+        return nil, errUserInactive
+    }
     if err == nil {
         // Пользователь найден
         return &user, nil
@@ -212,13 +226,14 @@ func (h *OAuthHandler) findOrCreateUser(oauthUser *auth.OAuthUserInfo) (*models.
     
     // Получаем созданного пользователя
     err = h.DB.QueryRow(`
-        SELECT id, login, full_name, user_type, group_number, email
-        FROM users WHERE id = $1
+        SELECT u.id, u.login, u.full_name, u.user_type, COALESCE(u.group_id, 0), COALESCE(g.name, ''), u.email
+        FROM users u LEFT JOIN groups g ON g.id = u.group_id WHERE u.id = $1
     `, userID).Scan(
         &user.ID,
         &user.Login,
         &user.FullName,
         &user.UserType,
+        &user.GroupID,
         &user.GroupNumber,
         &user.Email,
     )
@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// passwordAlphabet - символы сгенерированных паролей без похожих (0/O, 1/l/I)
+const passwordAlphabet = "abcdefghjkmnpqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+const (
+	generatedPasswordLength = 10
+	minPasswordLength       = 8
+	inviteTokenTTL          = 7 * 24 * time.Hour
+)
+
+// PasswordHandler - установка пароля по одноразовой ссылке из письма
+type PasswordHandler struct {
+	DB *sql.DB
+}
+
+// SetPassword устанавливает пароль по токену {token, password}
+func (h *PasswordHandler) SetPassword(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Неверный формат JSON", http.StatusBadRequest)
+		return
+	}
+	if len([]rune(req.Password)) < minPasswordLength {
+		http.Error(w, fmt.Sprintf("Пароль должен быть не короче %d символов", minPasswordLength), http.StatusBadRequest)
+		return
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		http.Error(w, "Ошибка при установке пароля", http.StatusInternalServerError)
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var userID int
+	err = tx.QueryRow(`
+        UPDATE password_tokens SET used_at = $1
+        WHERE token_hash = $2 AND purpose IN ('invite', 'reset') AND used_at IS NULL AND expires_at > $1
+        RETURNING user_id
+    `, time.Now().UTC(), hashToken(req.Token)).Scan(&userID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Ссылка недействительна или устарела", http.StatusBadRequest)
+		return
+	}
+	if err == nil {
+		_, err = tx.Exec(`UPDATE users SET password_hash = $1 WHERE id = $2`, string(hash), userID)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"message": "Пароль установлен",
+	})
+}
+
+// SetPasswordPage - страница из письма-приглашения
+func (h *PasswordHandler) SetPasswordPage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+	html := `<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>Установка пароля - VisualMath</title>
+    <link rel="stylesheet" href="/static/css/style.css">
+</head>
+<body style="text-align: center; padding: 50px;">
+    <h1 id="title">🔑 Установка пароля</h1>
+    <form id="passwordForm">
+        <input type="password" id="password" placeholder="Новый пароль" required minlength="8">
+        <input type="password" id="confirm" placeholder="Повторите пароль" required minlength="8">
+        <button type="submit" class="btn btn-primary">Сохранить</button>
+    </form>
+    <p id="message"></p>
+    <script>
+        document.getElementById('passwordForm').addEventListener('submit', async function(e) {
+            e.preventDefault();
+            const password = document.getElementById('password').value;
+            if (password !== document.getElementById('confirm').value) {
+                document.getElementById('message').textContent = '❌ Пароли не совпадают';
+                return;
+            }
+            const response = await fetch('/api/password/set', {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({
+                    token: new URLSearchParams(window.location.search).get('token'),
+                    password: password
+                })
+            });
+            if (response.ok) {
+                document.getElementById('title').textContent = '✅ Пароль установлен';
+                document.getElementById('passwordForm').style.display = 'none';
+                document.getElementById('message').innerHTML = '<a href="/login">Войти</a>';
+            } else {
+                document.getElementById('message').textContent = '❌ ' + await response.text();
+            }
+        });
+    </script>
+</body>
+</html>`
+
+	fmt.Fprint(w, html)
+}
+
+// issuePasswordToken создает одноразовый токен установки пароля и возвращает его.
+// В базе остается только хэш.
+func issuePasswordToken(tx *sql.Tx, userID int, purpose string, ttl time.Duration) (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(buf)
+
+	_, err := tx.Exec(`
+        INSERT INTO password_tokens (user_id, token_hash, purpose, expires_at) VALUES ($1, $2, $3, $4)
+    `, userID, hashToken(token), purpose, time.Now().Add(ttl).UTC())
+	return token, err
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(strings.TrimSpace(token)))
+	return hex.EncodeToString(sum[:])
+}
+
+// setPasswordURL - ссылка на страницу установки пароля
+func setPasswordURL(baseURL, token string) string {
+	return strings.TrimRight(baseURL, "/") + "/set-password?token=" + token
+}
+
+// generatePassword - случайный пароль для выдачи на бумаге
+func generatePassword() (string, error) {
+	buf := make([]byte, generatedPasswordLength)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	for i, b := range buf {
+		buf[i] = passwordAlphabet[int(b)%len(passwordAlphabet)]
+	}
+	return string(buf), nil
+}
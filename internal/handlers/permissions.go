@@ -0,0 +1,372 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+
+	"visualmath/internal/auth"
+	"visualmath/internal/models"
+)
+
+// ListPermissions - все права, их описания и права каждой роли
+func (h *AdminHandler) ListPermissions(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireAdmin(w, r); !ok {
+		return
+	}
+
+	roles, err := rolePermissions(h.DB)
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"permissions": auth.Permissions,
+		"roles":       roles,
+	})
+}
+
+// SetRolePermissions заменяет права роли {permissions: [...]}.
+// Права администратора не настраиваются - ему разрешено все.
+func (h *AdminHandler) SetRolePermissions(w http.ResponseWriter, r *http.Request) {
+	admin, ok := requireAdmin(w, r)
+	if !ok {
+		return
+	}
+	role := chi.URLParam(r, "role")
+	if !auth.ValidRole(role) {
+		http.Error(w, "Неизвестная роль", http.StatusNotFound)
+		return
+	}
+	if role == "admin" {
+		http.Error(w, "Права администратора не настраиваются", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Permissions []string `json:"permissions"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Неверный формат JSON", http.StatusBadRequest)
+		return
+	}
+	for _, p := range req.Permissions {
+		if _, ok := auth.Permissions[p]; !ok {
+			http.Error(w, "Неизвестное право: "+p, http.StatusBadRequest)
+			return
+		}
+	}
+
+	err := h.inTx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`DELETE FROM role_permissions WHERE role = $1`, role); err != nil {
+			return err
+		}
+		for _, p := range req.Permissions {
+			if _, err := tx.Exec(`
+                INSERT OR IGNORE INTO role_permissions (role, permission) VALUES ($1, $2)
+            `, role, p); err != nil {
+				return err
+			}
+		}
+		return audit(tx, admin.UserID, "role.permissions", 0, map[string]interface{}{
+			"role":        role,
+			"permissions": req.Permissions,
+		})
+	})
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	roles, err := rolePermissions(h.DB)
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":     true,
+		"role":        role,
+		"permissions": roles[role],
+	})
+}
+
+// ListScopes - курсы и группы, которыми ограничены права пользователя
+func (h *AdminHandler) ListScopes(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireAdmin(w, r); !ok {
+		return
+	}
+	target, ok := h.userFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	scopes, err := listScopes(h.DB, target.ID)
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(scopes)
+}
+
+// AddScope ограничивает права пользователя курсом и/или группой {course_id, group_id}.
+// Например, ассистент с группой ПМ-21 проверяет работы и видит журнал только этой группы.
+func (h *AdminHandler) AddScope(w http.ResponseWriter, r *http.Request) {
+	admin, ok := requireAdmin(w, r)
+	if !ok {
+		return
+	}
+	target, ok := h.userFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		CourseID int `json:"course_id"`
+		GroupID  int `json:"group_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Неверный формат JSON", http.StatusBadRequest)
+		return
+	}
+	if req.CourseID == 0 && req.GroupID == 0 {
+		http.Error(w, "Укажите курс или группу", http.StatusBadRequest)
+		return
+	}
+
+	var courseID, groupID interface{}
+	if req.CourseID != 0 {
+		if _, err := loadCourse(h.DB, req.CourseID); err == sql.ErrNoRows {
+			http.Error(w, "Курс не найден", http.StatusBadRequest)
+			return
+		} else if err != nil {
+			http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+			return
+		}
+		courseID = req.CourseID
+	}
+	if req.GroupID != 0 {
+		if _, err := loadGroup(h.DB, req.GroupID); err == sql.ErrNoRows {
+			http.Error(w, "Группа не найдена", http.StatusBadRequest)
+			return
+		} else if err != nil {
+			http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+			return
+		}
+		groupID = req.GroupID
+	}
+
+	var exists int
+	h.DB.QueryRow(`
+        SELECT COUNT(*) FROM permission_scopes
+        WHERE user_id = $1 AND COALESCE(course_id, 0) = $2 AND COALESCE(group_id, 0) = $3
+    `, target.ID, req.CourseID, req.GroupID).Scan(&exists)
+	if exists > 0 {
+		http.Error(w, "Такая область уже назначена", http.StatusConflict)
+		return
+	}
+
+	err := h.inTx(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+            INSERT INTO permission_scopes (user_id, course_id, group_id, created_by) VALUES ($1, $2, $3, $4)
+        `, target.ID, courseID, groupID, admin.UserID)
+		if err != nil {
+			return err
+		}
+		return audit(tx, admin.UserID, "scope.add", target.ID, map[string]interface{}{
+			"course_id": req.CourseID,
+			"group_id":  req.GroupID,
+		})
+	})
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	h.respondScopes(w, target.ID)
+}
+
+// RemoveScope снимает ограничение. Если областей не осталось, права преподавателя
+// снова действуют везде, а ассистент лишается прав.
+func (h *AdminHandler) RemoveScope(w http.ResponseWriter, r *http.Request) {
+	admin, ok := requireAdmin(w, r)
+	if !ok {
+		return
+	}
+	target, ok := h.userFromURL(w, r)
+	if !ok {
+		return
+	}
+	scopeID, err := strconv.Atoi(chi.URLParam(r, "scopeID"))
+	if err != nil {
+		http.Error(w, "Неверный ID области", http.StatusBadRequest)
+		return
+	}
+
+	err = h.inTx(func(tx *sql.Tx) error {
+		var courseID, groupID int
+		err := tx.QueryRow(`
+            DELETE FROM permission_scopes WHERE id = $1 AND user_id = $2
+            RETURNING COALESCE(course_id, 0), COALESCE(group_id, 0)
+        `, scopeID, target.ID).Scan(&courseID, &groupID)
+		if err != nil {
+			return err
+		}
+		return audit(tx, admin.UserID, "scope.remove", target.ID, map[string]interface{}{
+			"course_id": courseID,
+			"group_id":  groupID,
+		})
+	})
+	if err == sql.ErrNoRows {
+		http.Error(w, "Область не найдена", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	h.respondScopes(w, target.ID)
+}
+
+func (h *AdminHandler) respondScopes(w http.ResponseWriter, userID int) {
+	scopes, err := listScopes(h.DB, userID)
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"scopes":  scopes,
+	})
+}
+
+// rolePermissions - права каждой роли, кроме администратора
+func rolePermissions(db *sql.DB) (map[string][]string, error) {
+	roles := map[string][]string{}
+	for _, role := range auth.Roles {
+		if role != "admin" {
+			roles[role] = []string{}
+		}
+	}
+
+	rows, err := db.Query(`SELECT role, permission FROM role_permissions ORDER BY role, permission`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var role, permission string
+		if err := rows.Scan(&role, &permission); err != nil {
+			return nil, err
+		}
+		if _, ok := roles[role]; ok {
+			roles[role] = append(roles[role], permission)
+		}
+	}
+	return roles, rows.Err()
+}
+
+func listScopes(db *sql.DB, userID int) ([]models.PermissionScope, error) {
+	rows, err := db.Query(`
+        SELECT ps.id, COALESCE(ps.course_id, 0), COALESCE(c.name, ''), COALESCE(ps.group_id, 0),
+               COALESCE(g.name, ''), ps.created_at
+        FROM permission_scopes ps
+        LEFT JOIN courses c ON c.id = ps.course_id
+        LEFT JOIN groups g ON g.id = ps.group_id
+        WHERE ps.user_id = $1
+        ORDER BY ps.id
+    `, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	scopes := []models.PermissionScope{}
+	for rows.Next() {
+		var s models.PermissionScope
+		if err := rows.Scan(&s.ID, &s.CourseID, &s.CourseName, &s.GroupID, &s.GroupName, &s.CreatedAt); err != nil {
+			return nil, err
+		}
+		scopes = append(scopes, s)
+	}
+	return scopes, rows.Err()
+}
+
+// allowed проверяет право пользователя в области и отвечает 403, если его нет
+func allowed(w http.ResponseWriter, r *http.Request, db *sql.DB, permission string, scope auth.Scope) bool {
+	user, _ := auth.GetUserFromContext(r.Context())
+	if !auth.HasPermission(db, user, permission, scope) {
+		http.Error(w, "Доступ запрещен", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// permittedGroups сужает выбранные в запросе группы до областей права пользователя.
+// Если пользователь ограничен группами и ничего не выбрал, берутся все его группы,
+// запрос чужой группы получает 403.
+func permittedGroups(w http.ResponseWriter, r *http.Request, db *sql.DB, permission string,
+	courseID int, requested []int) ([]int, bool) {
+	user, _ := auth.GetUserFromContext(r.Context())
+	groups, all, err := auth.ScopedGroups(db, user, permission, courseID)
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return nil, false
+	}
+	if all {
+		return requested, true
+	}
+	if len(groups) == 0 {
+		http.Error(w, "Доступ запрещен", http.StatusForbidden)
+		return nil, false
+	}
+	if len(requested) == 0 {
+		sort.Ints(groups)
+		return groups, true
+	}
+	for _, id := range requested {
+		found := false
+		for _, g := range groups {
+			found = found || g == id
+		}
+		if !found {
+			http.Error(w, "Доступ запрещен", http.StatusForbidden)
+			return nil, false
+		}
+	}
+	return requested, true
+}
+
+// studentScope - курс лекции и группа студента для проверки прав на его работу
+func studentScope(db *sql.DB, lectureID, studentID int) auth.Scope {
+	scope := auth.Scope{CourseID: lectureCourse(db, lectureID)}
+	db.QueryRow(`SELECT COALESCE(group_id, 0) FROM users WHERE id = $1`, studentID).Scan(&scope.GroupID)
+	return scope
+}
+
+// lectureCourse - курс лекции, 0 если лекция не выбрана или вне курса
+func lectureCourse(db *sql.DB, lectureID int) int {
+	var courseID int
+	if lectureID != 0 {
+		db.QueryRow(`SELECT COALESCE(course_id, 0) FROM lectures WHERE id = $1`, lectureID).Scan(&courseID)
+	}
+	return courseID
+}
+
+// moduleCourse - то же для модуля
+func moduleCourse(db *sql.DB, moduleID int) int {
+	var courseID int
+	db.QueryRow(`SELECT COALESCE(course_id, 0) FROM modules WHERE id = $1`, moduleID).Scan(&courseID)
+	return courseID
+}
@@ -0,0 +1,457 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"visualmath/internal/auth"
+	"visualmath/internal/live"
+	"visualmath/internal/models"
+)
+
+// OpenPoll запускает опрос по вопросу из модуля типа question, входящего в лекцию
+// сессии. Текст и варианты берутся из модуля. Ранее открытый опрос сессии закрывается.
+func (h *LiveHandler) OpenPoll(w http.ResponseWriter, r *http.Request) {
+	session, ok := h.teacherSession(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		ModuleID      int `json:"module_id"`
+		QuestionIndex int `json:"question_index"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Неверный формат JSON", http.StatusBadRequest)
+		return
+	}
+
+	if !h.moduleInLecture(w, session.LectureID, req.ModuleID) {
+		return
+	}
+	module, err := loadModule(h.DB, req.ModuleID)
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+	if module.ModuleType != "question" {
+		http.Error(w, "Опрос можно запустить только по модулю с вопросами", http.StatusBadRequest)
+		return
+	}
+	items, err := questionItems(module, 1)
+	if err != nil || req.QuestionIndex < 0 || req.QuestionIndex >= len(items) {
+		http.Error(w, "Вопрос не найден в модуле", http.StatusBadRequest)
+		return
+	}
+	q := items[req.QuestionIndex].Question
+	if freeResponse(q) || len(q.Answers) < 2 || q.Correct < 0 || q.Correct >= len(q.Answers) {
+		http.Error(w, "Для опроса нужен вопрос с выбором из нескольких вариантов", http.StatusBadRequest)
+		return
+	}
+
+	question, err := json.Marshal(q)
+	if err != nil {
+		http.Error(w, "Неверный формат вопроса", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`
+        UPDATE polls SET closed_at = CURRENT_TIMESTAMP
+        WHERE session_id = $1 AND closed_at IS NULL
+    `, session.ID); err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	var pollID int
+	err = tx.QueryRow(`
+        INSERT INTO polls (session_id, lecture_id, module_id, question_index, question)
+        VALUES ($1, $2, $3, $4, $5)
+        RETURNING id
+    `, session.ID, session.LectureID, req.ModuleID, req.QuestionIndex, string(question)).Scan(&pollID)
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	poll, results, err := h.pollWithResults(pollID)
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+	h.publishPoll(poll, results)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"poll":    poll,
+	})
+}
+
+// ListPolls возвращает все опросы сессии с результатами
+func (h *LiveHandler) ListPolls(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.GetUserFromContext(r.Context())
+	session, ok := liveSessionFromURL(h.DB, w, r)
+	if !ok {
+		return
+	}
+	if session.TeacherID != user.UserID {
+		http.Error(w, "Доступ запрещен", http.StatusForbidden)
+		return
+	}
+
+	rows, err := h.DB.Query(`SELECT id FROM polls WHERE session_id = $1 ORDER BY id`, session.ID)
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+			return
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	polls := []map[string]interface{}{}
+	for _, id := range ids {
+		poll, results, err := h.pollWithResults(id)
+		if err != nil {
+			http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+			return
+		}
+		polls = append(polls, map[string]interface{}{
+			"poll":    poll,
+			"results": results,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(polls)
+}
+
+// AnswerPoll принимает ответ студента, один ответ на студента
+func (h *LiveHandler) AnswerPoll(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.GetUserFromContext(r.Context())
+	poll, ok := h.pollFromURL(w, r)
+	if !ok {
+		return
+	}
+	if poll.ClosedAt != nil {
+		http.Error(w, "Опрос уже закрыт", http.StatusConflict)
+		return
+	}
+	ended, err := sessionEnded(h.DB, poll.SessionID)
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+	if ended {
+		http.Error(w, "Живая лекция уже завершена", http.StatusConflict)
+		return
+	}
+
+	var req struct {
+		Answer int `json:"answer"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Неверный формат JSON", http.StatusBadRequest)
+		return
+	}
+	if req.Answer < 0 || req.Answer >= len(poll.Question.Answers) {
+		http.Error(w, "Неверный вариант ответа", http.StatusBadRequest)
+		return
+	}
+
+	// Отвечать могут только студенты, подключившиеся к сессии
+	var joined int
+	h.DB.QueryRow(`
+        SELECT COUNT(*) FROM live_participants WHERE session_id = $1 AND user_id = $2
+    `, poll.SessionID, user.UserID).Scan(&joined)
+	if joined == 0 {
+		http.Error(w, "Вы не подключены к этой лекции", http.StatusForbidden)
+		return
+	}
+
+	_, err = h.DB.Exec(`
+        INSERT INTO poll_answers (poll_id, student_id, answer, is_correct)
+        VALUES ($1, $2, $3, $4)
+    `, poll.ID, user.UserID, req.Answer, req.Answer == poll.Question.Correct)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE") {
+			http.Error(w, "Вы уже ответили на этот вопрос", http.StatusConflict)
+			return
+		}
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	if results, err := h.pollResults(poll); err == nil {
+		h.Hub.Broadcast(poll.SessionID, resultsEvent(results))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"message": "Ответ принят",
+	})
+}
+
+// ClosePoll прекращает прием ответов
+func (h *LiveHandler) ClosePoll(w http.ResponseWriter, r *http.Request) {
+	poll, ok := h.teacherPoll(w, r)
+	if !ok {
+		return
+	}
+
+	if _, err := h.DB.Exec(`
+        UPDATE polls SET closed_at = CURRENT_TIMESTAMP
+        WHERE id = $1 AND closed_at IS NULL
+    `, poll.ID); err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	h.respondPoll(w, poll.ID)
+}
+
+// RevealPoll показывает студентам распределение ответов и правильный ответ
+func (h *LiveHandler) RevealPoll(w http.ResponseWriter, r *http.Request) {
+	poll, ok := h.teacherPoll(w, r)
+	if !ok {
+		return
+	}
+
+	if _, err := h.DB.Exec(`
+        UPDATE polls SET revealed = TRUE, closed_at = COALESCE(closed_at, CURRENT_TIMESTAMP)
+        WHERE id = $1
+    `, poll.ID); err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	h.respondPoll(w, poll.ID)
+}
+
+// GetPollResults возвращает результаты опроса. Студенты видят их только после показа.
+func (h *LiveHandler) GetPollResults(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.GetUserFromContext(r.Context())
+	poll, ok := h.pollFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	session, err := loadLiveSession(h.DB, poll.SessionID)
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+	if !h.isMember(w, user, session) {
+		return
+	}
+	if session.TeacherID != user.UserID && !poll.Revealed {
+		http.Error(w, "Результаты еще не открыты", http.StatusForbidden)
+		return
+	}
+
+	results, err := h.pollResults(poll)
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
+
+// respondPoll рассылает новое состояние опроса и возвращает его в ответе
+func (h *LiveHandler) respondPoll(w http.ResponseWriter, pollID int) {
+	poll, results, err := h.pollWithResults(pollID)
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+	h.publishPoll(poll, results)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"poll":    poll,
+		"results": results,
+	})
+}
+
+// publishPoll рассылает состояние опроса подключенным клиентам. Хаб здесь не
+// открывается: после перезапуска сервера его откроет Events вместе с последним
+// опросом, а завершенную сессию открывать нельзя - ее горутину никто не закроет.
+func (h *LiveHandler) publishPoll(poll *models.Poll, results *models.PollResults) {
+	for _, ev := range pollEvents(poll, results) {
+		h.Hub.Broadcast(poll.SessionID, ev)
+	}
+}
+
+// pollEvents - вопрос для студентов без правильного ответа и текущее
+// распределение ответов для преподавателя
+func pollEvents(poll *models.Poll, results *models.PollResults) []live.Event {
+	state := map[string]interface{}{
+		"id":       poll.ID,
+		"question": poll.Question.Question,
+		"answers":  poll.Question.Answers,
+		"open":     poll.ClosedAt == nil,
+		"revealed": poll.Revealed,
+	}
+	if poll.Revealed {
+		state["results"] = results
+	}
+
+	return []live.Event{
+		{Type: "poll", Data: state, Retain: true},
+		resultsEvent(results),
+	}
+}
+
+// lastPollEvents - события последнего опроса сессии, если опросы были
+func (h *LiveHandler) lastPollEvents(sessionID int) ([]live.Event, error) {
+	var pollID int
+	err := h.DB.QueryRow(`
+        SELECT id FROM polls WHERE session_id = $1 ORDER BY id DESC LIMIT 1
+    `, sessionID).Scan(&pollID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	poll, results, err := h.pollWithResults(pollID)
+	if err != nil {
+		return nil, err
+	}
+	return pollEvents(poll, results), nil
+}
+
+func resultsEvent(results *models.PollResults) live.Event {
+	return live.Event{Type: "poll_results", Data: results, Retain: true, Role: "teacher"}
+}
+
+func (h *LiveHandler) pollFromURL(w http.ResponseWriter, r *http.Request) (*models.Poll, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "pollID"))
+	if err != nil {
+		http.Error(w, "Неверный ID опроса", http.StatusBadRequest)
+		return nil, false
+	}
+
+	poll, err := h.loadPoll(id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Опрос не найден", http.StatusNotFound)
+		return nil, false
+	} else if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return nil, false
+	}
+	return poll, true
+}
+
+// teacherPoll загружает опрос активной сессии и проверяет, что сессию ведет текущий пользователь
+func (h *LiveHandler) teacherPoll(w http.ResponseWriter, r *http.Request) (*models.Poll, bool) {
+	user, _ := auth.GetUserFromContext(r.Context())
+	poll, ok := h.pollFromURL(w, r)
+	if !ok {
+		return nil, false
+	}
+
+	session, err := loadLiveSession(h.DB, poll.SessionID)
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return nil, false
+	}
+	if session.TeacherID != user.UserID {
+		http.Error(w, "Опросом управляет другой преподаватель", http.StatusForbidden)
+		return nil, false
+	}
+	if session.EndedAt != nil {
+		http.Error(w, "Сессия завершена", http.StatusGone)
+		return nil, false
+	}
+	return poll, true
+}
+
+func (h *LiveHandler) loadPoll(id int) (*models.Poll, error) {
+	var p models.Poll
+	var question string
+	var closedAt sql.NullTime
+	err := h.DB.QueryRow(`
+        SELECT id, session_id, lecture_id, module_id, question_index, question, opened_at, closed_at, revealed
+        FROM polls WHERE id = $1
+    `, id).Scan(&p.ID, &p.SessionID, &p.LectureID, &p.ModuleID, &p.QuestionIndex,
+		&question, &p.OpenedAt, &closedAt, &p.Revealed)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(question), &p.Question); err != nil {
+		return nil, err
+	}
+	if closedAt.Valid {
+		p.ClosedAt = &closedAt.Time
+	}
+	return &p, nil
+}
+
+func (h *LiveHandler) pollResults(poll *models.Poll) (*models.PollResults, error) {
+	results := &models.PollResults{
+		PollID:  poll.ID,
+		Counts:  make([]int, len(poll.Question.Answers)),
+		Correct: poll.Question.Correct,
+	}
+
+	rows, err := h.DB.Query(`
+        SELECT answer, COUNT(*) FROM poll_answers
+        WHERE poll_id = $1 GROUP BY answer
+    `, poll.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var answer, count int
+		if err := rows.Scan(&answer, &count); err != nil {
+			return nil, err
+		}
+		if answer >= 0 && answer < len(results.Counts) {
+			results.Counts[answer] = count
+			results.Total += count
+		}
+	}
+	return results, rows.Err()
+}
+
+func (h *LiveHandler) pollWithResults(id int) (*models.Poll, *models.PollResults, error) {
+	poll, err := h.loadPoll(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	results, err := h.pollResults(poll)
+	if err != nil {
+		return nil, nil, err
+	}
+	return poll, results, nil
+}
@@ -0,0 +1,489 @@
+package handlers
+
+import (
+	"archive/zip"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"visualmath/internal/auth"
+	"visualmath/internal/models"
+	"visualmath/internal/privacy"
+)
+
+// exportFiles - файлы выгрузки персональных данных; $1 - ID пользователя
+var exportFiles = []struct{ name, query string }{
+	{"progress.json", `SELECT * FROM student_progress WHERE student_id = $1 ORDER BY id`},
+	{"attempts.json", `SELECT * FROM test_attempts WHERE student_id = $1 ORDER BY id`},
+	// оценка развернутого ответа попадает в выгрузку только после публикации
+	{"answers.json", `
+        SELECT r.*, rv.score AS review_score, rv.max_score AS review_max_score, rv.feedback AS review_feedback
+        FROM question_responses r
+        LEFT JOIN response_reviews rv ON rv.response_id = r.id AND rv.status = 'released'
+        WHERE r.student_id = $1 ORDER BY r.id`},
+	{"review_schedule.json", `SELECT * FROM review_items WHERE student_id = $1 ORDER BY id`},
+	{"skills.json", `
+        SELECT s.name AS skill, m.p_known, m.attempts, m.correct, m.updated_at
+        FROM skill_mastery m JOIN skills s ON s.id = m.skill_id
+        WHERE m.student_id = $1 ORDER BY s.name`},
+	{"enrollments.json", `
+        SELECT e.course_id, c.name AS course, e.status, e.enrolled_at
+        FROM enrollments e LEFT JOIN courses c ON c.id = e.course_id
+        WHERE e.student_id = $1 ORDER BY e.enrolled_at`},
+	{"attendance.json", `SELECT * FROM attendance WHERE user_id = $1 ORDER BY checked_in_at`},
+	{"poll_answers.json", `
+        SELECT p.lecture_id, p.question, a.answer, a.is_correct, a.answered_at
+        FROM poll_answers a JOIN polls p ON p.id = a.poll_id
+        WHERE a.student_id = $1 ORDER BY a.answered_at`},
+	{"sessions.json", `
+        SELECT id, user_agent, ip, last_seen_at, expires_at, revoked_at, created_at
+        FROM sessions WHERE user_id = $1 ORDER BY id`},
+	{"api_tokens.json", `
+        SELECT id, name, prefix, scopes, expires_at, last_used_at, revoked_at, created_at
+        FROM api_tokens WHERE user_id = $1 ORDER BY id`},
+	{"login_events.json", `SELECT id, ip, event, locked_until, created_at FROM login_events WHERE user_id = $1 ORDER BY id`},
+	{"deletion_requests.json", `SELECT * FROM deletion_requests WHERE user_id = $1 ORDER BY id`},
+}
+
+// avatarExtensions - расширение файла аватара в выгрузке
+var avatarExtensions = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/gif":  ".gif",
+}
+
+// ExportData - выгрузка своих персональных данных: ZIP с JSON-файлами
+// профиля, прогресса, попыток, ответов и остальных записей о пользователе
+func (h *ProfileHandler) ExportData(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.GetUserFromContext(r.Context())
+
+	profile, err := h.loadProfile(user.UserID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Пользователь не найден", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	// данные собираются до начала ответа, чтобы ошибка базы не оборвала архив
+	files := map[string]interface{}{"profile.json": profile}
+	for _, f := range exportFiles {
+		rows, err := exportRows(h.DB, f.query, user.UserID)
+		if err != nil {
+			log.Printf("Выгрузка данных пользователя %d, %s: %v", user.UserID, f.name, err)
+			http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+			return
+		}
+		files[f.name] = rows
+	}
+	var avatarType string
+	var avatar []byte
+	err = h.DB.QueryRow(`SELECT content_type, data FROM user_avatars WHERE user_id = $1`,
+		user.UserID).Scan(&avatarType, &avatar)
+	if err != nil && err != sql.ErrNoRows {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="visualmath-%s-%s.zip"`,
+		profile.Login, time.Now().Format("2006-01-02")))
+
+	zw := zip.NewWriter(w)
+	names := append([]string{"profile.json"}, exportNames()...)
+	for _, name := range names {
+		fw, err := zw.Create(name)
+		if err != nil {
+			log.Printf("Выгрузка данных пользователя %d: %v", user.UserID, err)
+			return
+		}
+		enc := json.NewEncoder(fw)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(files[name]); err != nil {
+			log.Printf("Выгрузка данных пользователя %d: %v", user.UserID, err)
+			return
+		}
+	}
+	if len(avatar) > 0 {
+		if fw, err := zw.Create("avatar" + avatarExtensions[avatarType]); err == nil {
+			fw.Write(avatar)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		log.Printf("Выгрузка данных пользователя %d: %v", user.UserID, err)
+	}
+}
+
+func exportNames() []string {
+	names := make([]string, len(exportFiles))
+	for i, f := range exportFiles {
+		names[i] = f.name
+	}
+	return names
+}
+
+// exportRows - строки запроса как список объектов "столбец: значение"
+func exportRows(db *sql.DB, query string, args ...interface{}) ([]map[string]interface{}, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	result := []map[string]interface{}{}
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		ptrs := make([]interface{}, len(columns))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, err
+		}
+		row := map[string]interface{}{}
+		for i, col := range columns {
+			// текст SQLite может прийти байтами, в JSON он нужен строкой
+			if b, ok := values[i].([]byte); ok {
+				values[i] = string(b)
+			}
+			row[col] = values[i]
+		}
+		result = append(result, row)
+	}
+	return result, rows.Err()
+}
+
+// RequestDeletion - запрос на удаление своих персональных данных {reason}.
+// Данные обезличиваются после одобрения администратором.
+func (h *ProfileHandler) RequestDeletion(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.GetUserFromContext(r.Context())
+
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Неверный формат JSON", http.StatusBadRequest)
+		return
+	}
+
+	var pending int
+	if err := h.DB.QueryRow(`SELECT COUNT(*) FROM deletion_requests WHERE user_id = $1 AND status = 'pending'`,
+		user.UserID).Scan(&pending); err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+	if pending > 0 {
+		http.Error(w, "Запрос на удаление уже ожидает рассмотрения", http.StatusConflict)
+		return
+	}
+
+	var id int
+	if err := h.DB.QueryRow(`
+        INSERT INTO deletion_requests (user_id, reason) VALUES ($1, $2) RETURNING id
+    `, user.UserID, strings.TrimSpace(req.Reason)).Scan(&id); err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	request, err := loadDeletionRequest(h.DB, id)
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(request)
+}
+
+// GetDeletionRequest - последний свой запрос на удаление
+func (h *ProfileHandler) GetDeletionRequest(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.GetUserFromContext(r.Context())
+
+	request, err := scanDeletionRequest(h.DB.QueryRow(deletionRequestQuery+
+		` WHERE d.user_id = $1 ORDER BY d.id DESC LIMIT 1`, user.UserID))
+	if err == sql.ErrNoRows {
+		http.Error(w, "Запросов на удаление нет", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(request)
+}
+
+// CancelDeletion отменяет свой запрос, пока он не рассмотрен
+func (h *ProfileHandler) CancelDeletion(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.GetUserFromContext(r.Context())
+
+	res, err := h.DB.Exec(`
+        UPDATE deletion_requests SET status = 'cancelled', decided_at = $1
+        WHERE user_id = $2 AND status = 'pending'
+    `, time.Now().UTC(), user.UserID)
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Нет запроса, ожидающего рассмотрения", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+	})
+}
+
+// ListDeletionRequests - запросы на удаление (?status=pending по умолчанию, all - все)
+func (h *AdminHandler) ListDeletionRequests(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireAdmin(w, r); !ok {
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = "pending"
+	}
+	where := ""
+	var args []interface{}
+	switch status {
+	case "all":
+	case "pending", "approved", "rejected", "cancelled":
+		where = " WHERE d.status = $1"
+		args = append(args, status)
+	default:
+		http.Error(w, "status: pending, approved, rejected, cancelled или all", http.StatusBadRequest)
+		return
+	}
+
+	rows, err := h.DB.Query(deletionRequestQuery+where+` ORDER BY d.id`, args...)
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	requests := []models.DeletionRequest{}
+	for rows.Next() {
+		d, err := scanDeletionRequest(rows)
+		if err != nil {
+			http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+			return
+		}
+		requests = append(requests, *d)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"requests": requests,
+	})
+}
+
+// ApproveDeletion одобряет запрос {id}: ФИО, логин и почта заменяются заглушками,
+// учетные данные удаляются, баллы остаются в статистике без имени
+func (h *AdminHandler) ApproveDeletion(w http.ResponseWriter, r *http.Request) {
+	admin, ok := requireAdmin(w, r)
+	if !ok {
+		return
+	}
+	request, ok := h.pendingDeletion(w, r)
+	if !ok {
+		return
+	}
+	if request.UserID == admin.UserID {
+		http.Error(w, "Запрос на удаление своей учетной записи одобряет другой администратор", http.StatusBadRequest)
+		return
+	}
+	target, err := loadAdminUser(h.DB, request.UserID)
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now().UTC()
+	err = h.inTx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`
+            UPDATE deletion_requests SET status = 'approved', decided_by = $1, decided_at = $2 WHERE id = $3
+        `, admin.UserID, now, request.ID); err != nil {
+			return err
+		}
+		if err := privacy.Anonymize(tx, target.ID, now); err != nil {
+			return err
+		}
+		return audit(tx, admin.UserID, "user.anonymize", target.ID, map[string]interface{}{
+			"request_id": request.ID,
+		})
+	})
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	// письмо уходит на адрес, которого в базе уже нет
+	h.notifyDeletion(target, fmt.Sprintf(`Здравствуйте, %s!
+
+Ваш запрос на удаление персональных данных выполнен. Учетная запись VisualMath (%s)
+закрыта, ФИО и адрес почты удалены. Результаты тестов сохранены только
+в обезличенной статистике курсов.
+`, target.FullName, target.Login))
+
+	d, err := loadDeletionRequest(h.DB, request.ID)
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(d)
+}
+
+// RejectDeletion отклоняет запрос {id} с объяснением {comment}
+func (h *AdminHandler) RejectDeletion(w http.ResponseWriter, r *http.Request) {
+	admin, ok := requireAdmin(w, r)
+	if !ok {
+		return
+	}
+	request, ok := h.pendingDeletion(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Comment string `json:"comment"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Неверный формат JSON", http.StatusBadRequest)
+		return
+	}
+	req.Comment = strings.TrimSpace(req.Comment)
+	if req.Comment == "" {
+		http.Error(w, "Укажите причину отказа", http.StatusBadRequest)
+		return
+	}
+	target, err := loadAdminUser(h.DB, request.UserID)
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	err = h.inTx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`
+            UPDATE deletion_requests SET status = 'rejected', decided_by = $1, decided_at = $2, comment = $3
+            WHERE id = $4
+        `, admin.UserID, time.Now().UTC(), req.Comment, request.ID); err != nil {
+			return err
+		}
+		return audit(tx, admin.UserID, "user.deletion_reject", target.ID, map[string]interface{}{
+			"request_id": request.ID,
+		})
+	})
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	h.notifyDeletion(target, fmt.Sprintf(`Здравствуйте, %s!
+
+Ваш запрос на удаление персональных данных из VisualMath отклонен.
+Причина: %s
+`, target.FullName, req.Comment))
+
+	d, err := loadDeletionRequest(h.DB, request.ID)
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(d)
+}
+
+// RetentionStatus - срок хранения неактивных учетных записей и кого задание
+// обезличит при следующей проверке
+func (h *AdminHandler) RetentionStatus(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireAdmin(w, r); !ok {
+		return
+	}
+
+	due := []privacy.InactiveUser{}
+	if h.Retention.InactiveYears > 0 {
+		var err error
+		if due, err = privacy.InactiveUsers(h.DB, h.Retention.Cutoff(time.Now())); err != nil {
+			http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"enabled":        h.Retention.InactiveYears > 0,
+		"inactive_years": h.Retention.InactiveYears,
+		"check_interval": h.Retention.Interval.String(),
+		"due":            due,
+	})
+}
+
+// pendingDeletion - запрос {id} из URL, ожидающий рассмотрения
+func (h *AdminHandler) pendingDeletion(w http.ResponseWriter, r *http.Request) (*models.DeletionRequest, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Неверный ID", http.StatusBadRequest)
+		return nil, false
+	}
+	d, err := loadDeletionRequest(h.DB, id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Запрос не найден", http.StatusNotFound)
+		return nil, false
+	} else if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return nil, false
+	}
+	if d.Status != "pending" {
+		http.Error(w, "Запрос уже рассмотрен или отменен", http.StatusConflict)
+		return nil, false
+	}
+	return d, true
+}
+
+func (h *AdminHandler) notifyDeletion(target *models.User, body string) {
+	go func() {
+		if err := h.Mailer.Send(target.Email, "Удаление персональных данных VisualMath", body); err != nil {
+			log.Printf("Не удалось отправить письмо об удалении данных %s: %v", target.Email, err)
+		}
+	}()
+}
+
+const deletionRequestQuery = `
+    SELECT d.id, d.user_id, pii_decrypt(u.login), pii_decrypt(u.full_name), d.reason, d.status, COALESCE(d.decided_by, 0),
+           d.decided_at, d.comment, d.created_at
+    FROM deletion_requests d
+    JOIN users u ON u.id = d.user_id`
+
+func scanDeletionRequest(row interface{ Scan(...interface{}) error }) (*models.DeletionRequest, error) {
+	var d models.DeletionRequest
+	var decidedAt sql.NullTime
+	if err := row.Scan(&d.ID, &d.UserID, &d.Login, &d.FullName, &d.Reason, &d.Status, &d.DecidedBy,
+		&decidedAt, &d.Comment, &d.CreatedAt); err != nil {
+		return nil, err
+	}
+	if decidedAt.Valid {
+		d.DecidedAt = &decidedAt.Time
+	}
+	return &d, nil
+}
+
+func loadDeletionRequest(db *sql.DB, id int) (*models.DeletionRequest, error) {
+	return scanDeletionRequest(db.QueryRow(deletionRequestQuery+` WHERE d.id = $1`, id))
+}
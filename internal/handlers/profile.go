@@ -0,0 +1,539 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"golang.org/x/crypto/bcrypt"
+
+	"visualmath/internal/auth"
+	"visualmath/internal/mail"
+	"visualmath/internal/models"
+)
+
+const (
+	emailTokenTTL   = 24 * time.Hour
+	maxAvatarSize   = 1 << 20
+	maxAvatarPixels = 2048 // по каждой стороне
+)
+
+var errEmailTaken = errors.New("email taken")
+
+// avatarTypes - форматы аватаров; браузеры показывают их без преобразования
+var avatarTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+}
+
+// ProfileHandler - личный кабинет: свои данные, пароль, аватар и сессии
+type ProfileHandler struct {
+	DB      *sql.DB
+	Mailer  *mail.Mailer
+	BaseURL string // для ссылки подтверждения почты
+}
+
+// GetProfile - данные текущего пользователя для личного кабинета
+func (h *ProfileHandler) GetProfile(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.GetUserFromContext(r.Context())
+
+	profile, err := h.loadProfile(user.UserID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Пользователь не найден", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(profile)
+}
+
+// UpdateProfile меняет ФИО и почту {full_name, email}. Новый адрес начинает
+// действовать после перехода по ссылке из письма, до этого остается старый.
+func (h *ProfileHandler) UpdateProfile(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.GetUserFromContext(r.Context())
+
+	var req struct {
+		FullName *string `json:"full_name"`
+		Email    *string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Неверный формат JSON", http.StatusBadRequest)
+		return
+	}
+
+	current, err := loadAdminUser(h.DB, user.UserID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Пользователь не найден", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+	// ФИО и почта пользователей каталога обновляются из него при каждом входе
+	if current.AuthSource == "ldap" && (req.FullName != nil || req.Email != nil) {
+		http.Error(w, "ФИО и почта учетной записи университета меняются в каталоге", http.StatusBadRequest)
+		return
+	}
+
+	var fullName string
+	if req.FullName != nil {
+		fullName = strings.TrimSpace(*req.FullName)
+		if fullName == "" {
+			http.Error(w, "ФИО не может быть пустым", http.StatusBadRequest)
+			return
+		}
+	}
+	var email string
+	if req.Email != nil {
+		email = strings.ToLower(strings.TrimSpace(*req.Email))
+		if !validEmail(email) {
+			http.Error(w, "Неверный email", http.StatusBadRequest)
+			return
+		}
+		if email == current.Email {
+			email = ""
+		} else {
+			var taken int
+			if err := h.DB.QueryRow(`SELECT COUNT(*) FROM users WHERE email_index = pii_index($1)`, email).Scan(&taken); err != nil {
+				http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+				return
+			}
+			if taken > 0 {
+				http.Error(w, "Этот email уже зарегистрирован", http.StatusConflict)
+				return
+			}
+		}
+	}
+
+	var token string
+	err = inTx(h.DB, func(tx *sql.Tx) error {
+		if fullName != "" {
+			if _, err := tx.Exec(`UPDATE users SET full_name = pii_encrypt($1) WHERE id = $2`, fullName, user.UserID); err != nil {
+				return err
+			}
+		}
+		if email == "" {
+			return nil
+		}
+		// действует только последняя ссылка
+		if _, err := tx.Exec(`
+            UPDATE password_tokens SET used_at = $1 WHERE user_id = $2 AND purpose = 'email' AND used_at IS NULL
+        `, time.Now().UTC(), user.UserID); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`UPDATE users SET pending_email = pii_encrypt($1) WHERE id = $2`, email, user.UserID); err != nil {
+			return err
+		}
+		var err error
+		token, err = issuePasswordToken(tx, user.UserID, "email", emailTokenTTL)
+		return err
+	})
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	if token != "" {
+		body := fmt.Sprintf(`Здравствуйте, %s!
+
+Чтобы подтвердить новый адрес почты для учетной записи VisualMath (%s),
+перейдите по ссылке:
+
+%s
+
+Ссылка действует сутки. Если вы не меняли адрес, просто проигнорируйте письмо.
+`, nonEmpty(fullName, current.FullName), current.Login,
+			strings.TrimRight(h.BaseURL, "/")+"/verify-email?token="+token)
+		if err := h.Mailer.Send(email, "Подтверждение адреса VisualMath", body); err != nil {
+			log.Printf("Не удалось отправить письмо подтверждения %s: %v", email, err)
+			http.Error(w, "Не удалось отправить письмо на новый адрес", http.StatusBadGateway)
+			return
+		}
+	}
+
+	profile, err := h.loadProfile(user.UserID)
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":           true,
+		"verification_sent": token != "",
+		"profile":           profile,
+	})
+}
+
+// VerifyEmail - страница по ссылке из письма: новый адрес заменяет старый
+func (h *ProfileHandler) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	var email string
+	err := inTx(h.DB, func(tx *sql.Tx) error {
+		var userID int
+		var pending sql.NullString
+		err := tx.QueryRow(`
+            UPDATE password_tokens SET used_at = $1
+            WHERE token_hash = $2 AND purpose = 'email' AND used_at IS NULL AND expires_at > $1
+            RETURNING user_id
+        `, time.Now().UTC(), hashToken(r.URL.Query().Get("token"))).Scan(&userID)
+		if err != nil {
+			return err
+		}
+		if err := tx.QueryRow(`SELECT pii_decrypt(pending_email) FROM users WHERE id = $1`, userID).Scan(&pending); err != nil {
+			return err
+		}
+		if !pending.Valid {
+			return sql.ErrNoRows
+		}
+		// адрес могли занять, пока письмо шло
+		var taken int
+		if err := tx.QueryRow(`SELECT COUNT(*) FROM users WHERE email_index = pii_index($1) AND id <> $2`,
+			pending.String, userID).Scan(&taken); err != nil {
+			return err
+		}
+		if taken > 0 {
+			return errEmailTaken
+		}
+		email = pending.String
+		_, err = tx.Exec(`
+            UPDATE users SET email = pending_email, email_index = pii_index(pii_decrypt(pending_email)),
+                email_verified = TRUE, pending_email = NULL
+            WHERE id = $1
+        `, userID)
+		return err
+	})
+
+	title, message := "✅ Адрес подтвержден", "Теперь письма будут приходить на "+email
+	status := http.StatusOK
+	switch {
+	case err == sql.ErrNoRows:
+		title, message, status = "❌ Ссылка недействительна", "Ссылка устарела или уже использована", http.StatusBadRequest
+	case err == errEmailTaken:
+		title, message, status = "❌ Адрес занят", "Этот email уже зарегистрирован другим пользователем", http.StatusConflict
+	case err != nil:
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="UTF-8">
+    <title>Подтверждение почты - VisualMath</title>
+    <link rel="stylesheet" href="/static/css/style.css">
+</head>
+<body style="text-align: center; padding: 50px;">
+    <h1>%s</h1>
+    <p>%s</p>
+    <a href="/dashboard">В личный кабинет</a>
+</body>
+</html>`, title, html.EscapeString(message))
+}
+
+// ChangePassword меняет пароль {current_password, new_password}. Остальные
+// сессии пользователя завершаются.
+func (h *ProfileHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.GetUserFromContext(r.Context())
+
+	var req struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Неверный формат JSON", http.StatusBadRequest)
+		return
+	}
+
+	var passwordHash, authSource, email, fullName string
+	err := h.DB.QueryRow(`SELECT password_hash, auth_source, pii_decrypt(email), pii_decrypt(full_name) FROM users WHERE id = $1`,
+		user.UserID).Scan(&passwordHash, &authSource, &email, &fullName)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Пользователь не найден", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+	if authSource == "ldap" {
+		http.Error(w, "Пароль учетной записи университета меняется в каталоге", http.StatusBadRequest)
+		return
+	}
+	if bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(req.CurrentPassword)) != nil {
+		http.Error(w, "Неверный текущий пароль", http.StatusForbidden)
+		return
+	}
+	if len([]rune(req.NewPassword)) < minPasswordLength {
+		http.Error(w, fmt.Sprintf("Пароль должен быть не короче %d символов", minPasswordLength), http.StatusBadRequest)
+		return
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		http.Error(w, "Ошибка при установке пароля", http.StatusInternalServerError)
+		return
+	}
+	var revoked int64
+	err = inTx(h.DB, func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`UPDATE users SET password_hash = $1 WHERE id = $2`, string(hash), user.UserID); err != nil {
+			return err
+		}
+		res, err := tx.Exec(`
+            UPDATE sessions SET revoked_at = $1 WHERE user_id = $2 AND id <> $3 AND revoked_at IS NULL
+        `, time.Now().UTC(), user.UserID, user.SessionID)
+		if err != nil {
+			return err
+		}
+		revoked, err = res.RowsAffected()
+		return err
+	})
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	body := fmt.Sprintf(`Здравствуйте, %s!
+
+Пароль вашей учетной записи VisualMath только что изменен.
+Если это были не вы, сразу обратитесь к администратору.
+`, fullName)
+	go func() {
+		if err := h.Mailer.Send(email, "Пароль VisualMath изменен", body); err != nil {
+			log.Printf("Не удалось отправить уведомление о смене пароля %s: %v", email, err)
+		}
+	}()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":          true,
+		"message":          "Пароль изменен",
+		"sessions_revoked": revoked,
+	})
+}
+
+// UploadAvatar сохраняет аватар из формы multipart (поле avatar): PNG, JPEG
+// или GIF не больше 1 МБ и 2048 точек по каждой стороне
+func (h *ProfileHandler) UploadAvatar(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.GetUserFromContext(r.Context())
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxAvatarSize+4096)
+	if err := r.ParseMultipartForm(maxAvatarSize); err != nil {
+		http.Error(w, "Ожидается форма с изображением не больше 1 МБ", http.StatusBadRequest)
+		return
+	}
+	file, _, err := r.FormFile("avatar")
+	if err != nil {
+		http.Error(w, "Не передан файл", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, maxAvatarSize+1))
+	if err != nil {
+		http.Error(w, "Не удалось прочитать файл", http.StatusBadRequest)
+		return
+	}
+	if len(data) > maxAvatarSize {
+		http.Error(w, "Изображение больше 1 МБ", http.StatusBadRequest)
+		return
+	}
+
+	// тип определяется по содержимому, а не по имени файла
+	contentType := http.DetectContentType(data)
+	if !avatarTypes[contentType] {
+		http.Error(w, "Поддерживаются PNG, JPEG и GIF", http.StatusBadRequest)
+		return
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		http.Error(w, "Файл поврежден или не является изображением", http.StatusBadRequest)
+		return
+	}
+	if cfg.Width > maxAvatarPixels || cfg.Height > maxAvatarPixels {
+		http.Error(w, fmt.Sprintf("Изображение больше %dx%d", maxAvatarPixels, maxAvatarPixels), http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now().UTC()
+	if _, err := h.DB.Exec(`
+        INSERT INTO user_avatars (user_id, content_type, data, updated_at) VALUES ($1, $2, $3, $4)
+        ON CONFLICT (user_id) DO UPDATE SET content_type = excluded.content_type,
+            data = excluded.data, updated_at = excluded.updated_at
+    `, user.UserID, contentType, data, now); err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":    true,
+		"avatar_url": avatarURL(user.UserID, now),
+	})
+}
+
+// DeleteAvatar удаляет аватар
+func (h *ProfileHandler) DeleteAvatar(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.GetUserFromContext(r.Context())
+
+	if _, err := h.DB.Exec(`DELETE FROM user_avatars WHERE user_id = $1`, user.UserID); err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+	})
+}
+
+// GetAvatar отдает аватар пользователя {id}
+func (h *ProfileHandler) GetAvatar(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Неверный ID", http.StatusBadRequest)
+		return
+	}
+
+	var contentType string
+	var data []byte
+	err = h.DB.QueryRow(`SELECT content_type, data FROM user_avatars WHERE user_id = $1`, id).Scan(&contentType, &data)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Аватар не загружен", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	// в ссылке есть время загрузки, поэтому новый аватар придет по новой ссылке
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	w.Write(data)
+}
+
+// ListSessions - действующие сессии браузера текущего пользователя
+func (h *ProfileHandler) ListSessions(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.GetUserFromContext(r.Context())
+
+	rows, err := h.DB.Query(`
+        SELECT id, user_agent, ip, last_seen_at, expires_at, created_at
+        FROM sessions
+        WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > $2
+        ORDER BY last_seen_at DESC
+    `, user.UserID, time.Now().UTC())
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	sessions := []models.Session{}
+	for rows.Next() {
+		var s models.Session
+		if err := rows.Scan(&s.ID, &s.UserAgent, &s.IP, &s.LastSeenAt, &s.ExpiresAt, &s.CreatedAt); err != nil {
+			http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+			return
+		}
+		s.Current = s.ID == user.SessionID
+		sessions = append(sessions, s)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"sessions": sessions,
+	})
+}
+
+// RevokeSession завершает свою сессию {id}, например на чужом компьютере
+func (h *ProfileHandler) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.GetUserFromContext(r.Context())
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Неверный ID", http.StatusBadRequest)
+		return
+	}
+	res, err := h.DB.Exec(`
+        UPDATE sessions SET revoked_at = $1 WHERE id = $2 AND user_id = $3 AND revoked_at IS NULL
+    `, time.Now().UTC(), id, user.UserID)
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Сессия не найдена", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+	})
+}
+
+// loadProfile собирает данные личного кабинета
+func (h *ProfileHandler) loadProfile(userID int) (*models.Profile, error) {
+	u, err := loadAdminUser(h.DB, userID)
+	if err != nil {
+		return nil, err
+	}
+	profile := &models.Profile{User: *u, OAuthProviders: []models.OAuthProvider{}}
+
+	var pending sql.NullString
+	if err := h.DB.QueryRow(`SELECT pii_decrypt(pending_email) FROM users WHERE id = $1`, userID).Scan(&pending); err != nil {
+		return nil, err
+	}
+	profile.PendingEmail = pending.String
+
+	var avatarUpdated time.Time
+	err = h.DB.QueryRow(`SELECT updated_at FROM user_avatars WHERE user_id = $1`, userID).Scan(&avatarUpdated)
+	if err == nil {
+		profile.AvatarURL = avatarURL(userID, avatarUpdated)
+	} else if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	rows, err := h.DB.Query(`
+        SELECT provider, COALESCE(email, ''), created_at FROM oauth_connections WHERE user_id = $1 ORDER BY provider
+    `, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var p models.OAuthProvider
+		if err := rows.Scan(&p.Provider, &p.Email, &p.ConnectedAt); err != nil {
+			return nil, err
+		}
+		profile.OAuthProviders = append(profile.OAuthProviders, p)
+	}
+	return profile, rows.Err()
+}
+
+// avatarURL - ссылка на аватар; время загрузки сбрасывает кэш браузера
+func avatarURL(userID int, updated time.Time) string {
+	return fmt.Sprintf("/api/users/%d/avatar?v=%d", userID, updated.Unix())
+}
+
+// validEmail - простая проверка формата адреса
+func validEmail(email string) bool {
+	at := strings.LastIndex(email, "@")
+	return at >= 1 && strings.Contains(email[at:], ".") && !strings.ContainsAny(email, " ,;")
+}
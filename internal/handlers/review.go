@@ -0,0 +1,458 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"visualmath/internal/auth"
+	"visualmath/internal/models"
+)
+
+const (
+	// reviewLowConfidence - верный ответ с такой или меньшей уверенностью тоже повторяем
+	reviewLowConfidence = 2
+	reviewDefaultLimit  = 20
+	minEasiness         = 1.3
+)
+
+// ReviewHandler - очередь интервального повторения вопросов (SM-2)
+type ReviewHandler struct {
+	DB *sql.DB
+}
+
+// DueReviews возвращает вопросы, которые пора повторить, и число вопросов на сегодня.
+// Перед этим в очередь добавляются новые ошибки студента из вопросников и тестов.
+func (h *ReviewHandler) DueReviews(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.GetUserFromContext(r.Context())
+
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit < 0 {
+		limit = reviewDefaultLimit
+	}
+
+	if err := syncReviewQueue(h.DB, user.UserID); err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	endOfDay := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, now.Location()).UTC()
+
+	var dueToday int
+	err = h.DB.QueryRow(`
+        SELECT COUNT(*) FROM review_items WHERE student_id = $1 AND due_at < $2
+    `, user.UserID, endOfDay).Scan(&dueToday)
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	items, dueNow, err := h.dueItems(user.UserID, now.UTC(), limit)
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"due_today": dueToday,
+		"due_now":   dueNow,
+		"items":     items,
+	})
+}
+
+// GradeReview принимает ответ на вопрос из очереди и переносит следующее повторение.
+// Оценку качества 0-5 можно передать явно (quality) или по ответу и уверенности.
+func (h *ReviewHandler) GradeReview(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.GetUserFromContext(r.Context())
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Неверный ID", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Answer     *int `json:"answer"`
+		Confidence int  `json:"confidence"`
+		Quality    *int `json:"quality"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Неверный формат JSON", http.StatusBadRequest)
+		return
+	}
+
+	item, studentID, err := h.loadReviewItem(id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Вопрос не найден", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+	if studentID != user.UserID {
+		http.Error(w, "Доступ запрещен", http.StatusForbidden)
+		return
+	}
+
+	question, ok := reviewQuestion(h.DB, item.SourceModuleID, item.QuestionIndex)
+	if !ok {
+		http.Error(w, "Вопрос удален из модуля", http.StatusGone)
+		return
+	}
+
+	response := map[string]interface{}{"success": true}
+	var quality int
+	switch {
+	case req.Quality != nil:
+		if *req.Quality < 0 || *req.Quality > 5 {
+			http.Error(w, "quality должно быть от 0 до 5", http.StatusBadRequest)
+			return
+		}
+		quality = *req.Quality
+	case req.Answer != nil:
+		correct := *req.Answer == question.Correct
+		quality = answerQuality(correct, *req.Answer < 0, validConfidence(req.Confidence))
+		hidden, err := answersHiddenByTest(h.DB, item.SourceModuleID)
+		if err != nil {
+			http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+			return
+		}
+		if !hidden {
+			response["is_correct"] = correct
+			response["correct"] = question.Correct
+			response["explanation"] = question.Explanation
+		}
+	default:
+		http.Error(w, "Нужен ответ или оценка", http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now().UTC()
+	scheduleReview(item, quality, now)
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+        UPDATE review_items
+        SET easiness = $1, interval_days = $2, repetitions = $3, lapses = $4, due_at = $5, last_reviewed_at = $6
+        WHERE id = $7
+    `, item.Easiness, item.IntervalDays, item.Repetitions, item.Lapses, item.DueAt, now, item.ID)
+	// Ответ при повторении учитывается во владении навыком, самооценка - нет
+	if err == nil && req.Answer != nil && *req.Answer >= 0 {
+		err = updateMastery(tx, studentID, item.SourceModuleID, question, *req.Answer == question.Correct, now)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+	item.LastReviewedAt = &now
+
+	response["quality"] = quality
+	response["item"] = item
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// answerQuality переводит ответ в оценку SM-2: ошибка - 1, пропуск - 0,
+// верный ответ - 3, 4 или 5 в зависимости от уверенности
+func answerQuality(correct, skipped bool, confidence int) int {
+	switch {
+	case skipped:
+		return 0
+	case !correct:
+		return 1
+	case confidence >= 5:
+		return 5
+	case confidence == 4 || confidence == 0:
+		return 4
+	default:
+		return 3
+	}
+}
+
+// scheduleReview - шаг алгоритма SM-2
+func scheduleReview(item *models.ReviewItem, quality int, now time.Time) {
+	if quality < 3 {
+		item.Repetitions = 0
+		item.IntervalDays = 1
+		item.Lapses++
+	} else {
+		item.Repetitions++
+		switch item.Repetitions {
+		case 1:
+			item.IntervalDays = 1
+		case 2:
+			item.IntervalDays = 6
+		default:
+			item.IntervalDays = int(math.Round(float64(item.IntervalDays) * item.Easiness))
+		}
+	}
+
+	q := float64(5 - quality)
+	item.Easiness += 0.1 - q*(0.08+q*0.02)
+	if item.Easiness < minEasiness {
+		item.Easiness = minEasiness
+	}
+	item.DueAt = now.AddDate(0, 0, item.IntervalDays)
+}
+
+// syncReviewQueue добавляет в очередь вопросы, на которые студент ответил неверно
+// или верно, но неуверенно. Первое повторение - на следующий день после ответа.
+// Развернутые ответы в очередь не попадают.
+func syncReviewQueue(db *sql.DB, studentID int) error {
+	rows, err := db.Query(`
+        SELECT r.source_module_id, r.question_index, r.answered_at
+        FROM question_responses r
+        WHERE r.student_id = $1
+          AND (NOT r.is_correct OR (r.confidence BETWEEN 1 AND $2))
+          AND NOT EXISTS (SELECT 1 FROM response_reviews rr WHERE rr.response_id = r.id)
+          AND NOT EXISTS (
+              SELECT 1 FROM review_items ri
+              WHERE ri.student_id = r.student_id AND ri.source_module_id = r.source_module_id
+                AND ri.question_index = r.question_index
+          )
+        ORDER BY r.answered_at
+    `, studentID, reviewLowConfidence)
+	if err != nil {
+		return err
+	}
+
+	type pending struct {
+		key itemKey
+		due time.Time
+	}
+	var queue []pending
+	seen := map[itemKey]bool{}
+	for rows.Next() {
+		var key itemKey
+		var answeredAt time.Time
+		if err := rows.Scan(&key.sourceModuleID, &key.index, &answeredAt); err != nil {
+			rows.Close()
+			return err
+		}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		queue = append(queue, pending{key, answeredAt.UTC().AddDate(0, 0, 1)})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, p := range queue {
+		_, err := db.Exec(`
+            INSERT OR IGNORE INTO review_items (student_id, source_module_id, question_index, due_at)
+            VALUES ($1, $2, $3, $4)
+        `, studentID, p.key.sourceModuleID, p.key.index, p.due)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dueItems - первые limit вопросов, срок повторения которых наступил, вместе с текстом
+// вопроса, и общее число таких вопросов
+func (h *ReviewHandler) dueItems(studentID int, now time.Time, limit int) ([]models.ReviewItem, int, error) {
+	rows, err := h.DB.Query(reviewItemQuery+`
+        WHERE student_id = $1 AND due_at <= $2
+        ORDER BY due_at
+    `, studentID, now)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	items := []models.ReviewItem{}
+	for rows.Next() {
+		item, _, err := scanReviewItem(rows)
+		if err != nil {
+			rows.Close()
+			return nil, 0, err
+		}
+		items = append(items, *item)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	// Вопросы, удаленные из модулей, не показываем
+	result := []models.ReviewItem{}
+	titles := map[int]string{}
+	for _, item := range items {
+		if len(result) >= limit {
+			break
+		}
+		question, ok := reviewQuestion(h.DB, item.SourceModuleID, item.QuestionIndex)
+		if !ok {
+			continue
+		}
+		if _, ok := titles[item.SourceModuleID]; !ok {
+			h.DB.QueryRow(`SELECT title FROM modules WHERE id = $1`, item.SourceModuleID).Scan(&item.ModuleTitle)
+			titles[item.SourceModuleID] = item.ModuleTitle
+		}
+		item.ModuleTitle = titles[item.SourceModuleID]
+		item.Question = question.Question
+		item.Answers = question.Answers
+		result = append(result, item)
+	}
+	return result, len(items), nil
+}
+
+const reviewItemQuery = `
+        SELECT student_id, id, source_module_id, question_index, easiness, interval_days, repetitions,
+               lapses, due_at, last_reviewed_at
+        FROM review_items`
+
+// loadReviewItem возвращает вопрос из очереди и ID студента, которому он принадлежит
+func (h *ReviewHandler) loadReviewItem(id int) (*models.ReviewItem, int, error) {
+	return scanReviewItem(h.DB.QueryRow(reviewItemQuery+` WHERE id = $1`, id))
+}
+
+func scanReviewItem(row interface{ Scan(...interface{}) error }) (*models.ReviewItem, int, error) {
+	var item models.ReviewItem
+	var studentID int
+	var lastReviewed sql.NullTime
+	err := row.Scan(&studentID, &item.ID, &item.SourceModuleID, &item.QuestionIndex, &item.Easiness,
+		&item.IntervalDays, &item.Repetitions, &item.Lapses, &item.DueAt, &lastReviewed)
+	if err != nil {
+		return nil, 0, err
+	}
+	if lastReviewed.Valid {
+		item.LastReviewedAt = &lastReviewed.Time
+	}
+	return &item, studentID, nil
+}
+
+// reviewQuestion находит вопрос в модуле-вопроснике
+func reviewQuestion(db *sql.DB, sourceModuleID, index int) (models.Question, bool) {
+	module, err := loadModule(db, sourceModuleID)
+	if err != nil || module.ModuleType != "question" {
+		return models.Question{}, false
+	}
+	items, err := questionItems(module, 1)
+	if err != nil || index < 0 || index >= len(items) {
+		return models.Question{}, false
+	}
+	return items[index].Question, true
+}
+
+// ReviewPage - страница повторения для студента
+func (h *ReviewHandler) ReviewPage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+	html := `<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="UTF-8">
+    <title>Повторение - VisualMath</title>
+    <script src="/static/js/csrf.js"></script>
+    <link rel="stylesheet" href="/static/css/style.css">
+    <style>
+        .review-container { max-width: 700px; margin: 40px auto; padding: 0 20px; }
+        .review-card { background: white; border-radius: 10px; padding: 30px; box-shadow: 0 4px 6px rgba(0,0,0,0.1); }
+        .review-meta { color: #7f8c8d; font-size: 14px; margin-bottom: 10px; }
+        .answer-option { display: block; padding: 12px 15px; margin: 8px 0; border: 2px solid #eee; border-radius: 8px; cursor: pointer; }
+        .answer-option.correct { border-color: #27ae60; background: #eafaf1; }
+        .answer-option.wrong { border-color: #e74c3c; background: #fdedec; }
+        .confidence { margin: 20px 0; }
+        .review-btn { padding: 12px 25px; background: #3498db; color: white; border: none; border-radius: 6px; cursor: pointer; font-size: 16px; }
+        .explanation { margin-top: 15px; color: #2c3e50; }
+    </style>
+</head>
+<body>
+    <div class="review-container">
+        <a href="/dashboard">← В личный кабинет</a>
+        <h1>🔁 Повторение</h1>
+        <p id="progress"></p>
+        <div class="review-card" id="card">Загрузка...</div>
+    </div>
+    <script>
+        let items = [];
+        let current = 0;
+
+        function escapeHTML(s) {
+            const div = document.createElement('div');
+            div.textContent = s;
+            return div.innerHTML;
+        }
+
+        async function loadDue() {
+            const response = await fetch('/api/review/due');
+            const data = await response.json();
+            items = data.items;
+            current = 0;
+            document.getElementById('progress').textContent = 'Сегодня к повторению: ' + data.due_today;
+            showItem();
+        }
+
+        function showItem() {
+            const card = document.getElementById('card');
+            if (current >= items.length) {
+                card.innerHTML = '<h2>🎉 На сегодня все!</h2><p>Следующие вопросы появятся, когда подойдет срок повторения.</p>';
+                return;
+            }
+            const item = items[current];
+            let html = '<div class="review-meta">' + escapeHTML(item.module_title) + ' · ' + (current + 1) + ' из ' + items.length + '</div>' +
+                '<h3>' + escapeHTML(item.question) + '</h3>';
+            item.answers.forEach((answer, i) => {
+                html += '<label class="answer-option" id="option' + i + '"><input type="radio" name="answer" value="' + i + '"> ' + escapeHTML(answer) + '</label>';
+            });
+            html += '<div class="confidence">Уверенность: <select id="confidence">' +
+                '<option value="1">1 - угадываю</option><option value="2">2</option><option value="3" selected>3</option>' +
+                '<option value="4">4</option><option value="5">5 - точно знаю</option></select></div>' +
+                '<button class="review-btn" id="submitBtn" onclick="submitAnswer()">Ответить</button>' +
+                '<div class="explanation" id="explanation"></div>';
+            card.innerHTML = html;
+        }
+
+        async function submitAnswer() {
+            const item = items[current];
+            const checked = document.querySelector('input[name="answer"]:checked');
+            const answer = checked ? parseInt(checked.value) : -1;
+            const response = await fetch('/api/review/' + item.id + '/grade', {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({ answer: answer, confidence: parseInt(document.getElementById('confidence').value) })
+            });
+            const data = await response.json();
+
+            document.getElementById('option' + data.correct).classList.add('correct');
+            if (!data.is_correct && answer >= 0) {
+                document.getElementById('option' + answer).classList.add('wrong');
+            }
+            const next = new Date(data.item.due_at).toLocaleDateString('ru-RU');
+            document.getElementById('explanation').innerHTML =
+                (data.is_correct ? '✅ Верно' : '❌ Неверно') + '. Следующее повторение: ' + next +
+                (data.explanation ? '<p>' + escapeHTML(data.explanation) + '</p>' : '');
+            const btn = document.getElementById('submitBtn');
+            btn.textContent = 'Дальше';
+            btn.onclick = () => { current++; showItem(); };
+        }
+
+        window.addEventListener('DOMContentLoaded', loadDue);
+    </script>
+</body>
+</html>`
+
+	fmt.Fprint(w, html)
+}
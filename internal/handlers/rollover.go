@@ -0,0 +1,375 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"visualmath/internal/models"
+)
+
+var (
+	errCourseArchived  = errors.New("курс уже перенесен в архив")
+	errCourseNameTaken = errors.New("курс с таким названием уже есть")
+	errRolloverName    = errors.New("укажите название или семестр нового курса")
+)
+
+// RolloverOptions - параметры переноса курса на новый семестр
+type RolloverOptions struct {
+	Name       string // по умолчанию - старое название с новым семестром в скобках
+	Term       string
+	OffsetDays int // на сколько дней сдвигаются сроки заданий
+}
+
+// RolloverCourse переносит курс на новый семестр: копирует описание, преподавателей,
+// модули, лекции и задания со сдвинутыми сроками. Новые лекции и задания ссылаются
+// на копии модулей, так что их правка не меняет прошлый семестр. Старый курс вместе
+// с модулями, прогрессом и оценками становится архивным и доступен только для
+// чтения. Возвращает ID нового курса.
+func RolloverCourse(db *sql.DB, courseID int, opts RolloverOptions) (int, error) {
+	course, err := loadCourse(db, courseID)
+	if err != nil {
+		return 0, err
+	}
+	if course.Archived {
+		return 0, errCourseArchived
+	}
+
+	opts.Name = strings.TrimSpace(opts.Name)
+	opts.Term = strings.TrimSpace(opts.Term)
+	if opts.Name == "" {
+		if opts.Term == "" {
+			return 0, errRolloverName
+		}
+		base := course.Name
+		if course.Term != "" {
+			base = strings.TrimSuffix(base, " ("+course.Term+")")
+		}
+		opts.Name = fmt.Sprintf("%s (%s)", base, opts.Term)
+	}
+
+	assignments, err := listAssignments(db, `
+        WHERE NOT a.archived
+          AND (a.lecture_id IN (SELECT id FROM lectures WHERE course_id = $1)
+               OR a.module_id IN (SELECT id FROM modules WHERE course_id = $1))
+        ORDER BY a.id
+    `, course.ID)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var newID int
+	err = tx.QueryRow(`
+        INSERT INTO courses (name, description, term, previous_course_id) VALUES ($1, $2, $3, $4)
+        ON CONFLICT (name) DO NOTHING
+        RETURNING id
+    `, opts.Name, course.Description, opts.Term, course.ID).Scan(&newID)
+	if err == sql.ErrNoRows {
+		return 0, errCourseNameTaken
+	} else if err != nil {
+		return 0, err
+	}
+
+	if _, err := tx.Exec(`
+        INSERT INTO course_teachers (course_id, teacher_id)
+        SELECT $1, teacher_id FROM course_teachers WHERE course_id = $2
+    `, newID, course.ID); err != nil {
+		return 0, err
+	}
+
+	modules, err := cloneModules(tx, course.ID, newID)
+	if err != nil {
+		return 0, err
+	}
+
+	lectures := make(map[int]int, len(course.Lectures)) // старый ID -> новый
+	for _, l := range course.Lectures {
+		var id int
+		err := tx.QueryRow(`
+            INSERT INTO lectures (title, course_id, author_id, description, published, allow_back, course_position)
+            SELECT title, $1, author_id, description, published, allow_back, course_position
+            FROM lectures WHERE id = $2
+            RETURNING id
+        `, newID, l.ID).Scan(&id)
+		if err != nil {
+			return 0, err
+		}
+		if err := copyLectureModules(tx, l.ID, id, modules); err != nil {
+			return 0, err
+		}
+		lectures[l.ID] = id
+	}
+
+	for _, a := range assignments {
+		if clone, ok := modules[a.ModuleID]; ok {
+			a.ModuleID = clone
+		}
+		if err := copyAssignment(tx, a, lectures[a.LectureID], opts.OffsetDays); err != nil {
+			return 0, err
+		}
+	}
+
+	if _, err := tx.Exec(`
+        UPDATE courses SET archived = TRUE, archived_at = CURRENT_TIMESTAMP WHERE id = $1
+    `, course.ID); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return newID, nil
+}
+
+// cloneModules копирует модули курса courseID в курс newID вместе с навыками и
+// калибровкой вопросов и возвращает соответствие старых ID новым. Тесты копий
+// ссылаются на копии вопросников курса.
+func cloneModules(tx *sql.Tx, courseID, newID int) (map[int]int, error) {
+	rows, err := tx.Query(`SELECT id FROM modules WHERE course_id = $1 ORDER BY id`, courseID)
+	if err != nil {
+		return nil, err
+	}
+	var old []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		old = append(old, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	ids := make(map[int]int, len(old))
+	for _, id := range old {
+		var clone int
+		err := tx.QueryRow(`
+            INSERT INTO modules (title, course_id, course_name, author_id, description, module_type, content, published)
+            SELECT title, $1, course_name, author_id, description, module_type, content, published
+            FROM modules WHERE id = $2
+            RETURNING id
+        `, newID, id).Scan(&clone)
+		if err != nil {
+			return nil, err
+		}
+		ids[id] = clone
+	}
+
+	for _, id := range old {
+		clone := ids[id]
+		statements := []string{
+			`INSERT INTO module_skills (module_id, skill_id, from_questions)
+             SELECT $1, skill_id, from_questions FROM module_skills WHERE module_id = $2`,
+			`INSERT INTO item_parameters (source_module_id, question_index, discrimination, difficulty, responses, calibrated_at)
+             SELECT $1, question_index, discrimination, difficulty, responses, calibrated_at
+             FROM item_parameters WHERE source_module_id = $2`,
+		}
+		for _, stmt := range statements {
+			if _, err := tx.Exec(stmt, clone, id); err != nil {
+				return nil, err
+			}
+		}
+
+		var moduleType, content string
+		if err := tx.QueryRow(`SELECT module_type, content FROM modules WHERE id = $1`,
+			clone).Scan(&moduleType, &content); err != nil {
+			return nil, err
+		}
+		if moduleType != "test" {
+			continue
+		}
+		content, changed := remapTestQuestions(content, ids)
+		if !changed {
+			continue
+		}
+		if _, err := tx.Exec(`UPDATE modules SET content = $1 WHERE id = $2`, content, clone); err != nil {
+			return nil, err
+		}
+	}
+	return ids, nil
+}
+
+// copyLectureModules заполняет лекцию newID модулями лекции lectureID, заменяя их
+// копиями по ids. Модули других курсов лекция продолжает использовать как есть.
+func copyLectureModules(tx *sql.Tx, lectureID, newID int, ids map[int]int) error {
+	rows, err := tx.Query(`SELECT module_id, position FROM lecture_modules WHERE lecture_id = $1`, lectureID)
+	if err != nil {
+		return err
+	}
+	type entry struct{ module, position int }
+	var entries []entry
+	for rows.Next() {
+		var e entry
+		if err := rows.Scan(&e.module, &e.position); err != nil {
+			rows.Close()
+			return err
+		}
+		entries = append(entries, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		if clone, ok := ids[e.module]; ok {
+			e.module = clone
+		}
+		if _, err := tx.Exec(`
+            INSERT INTO lecture_modules (lecture_id, module_id, position) VALUES ($1, $2, $3)
+        `, newID, e.module, e.position); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// remapTestQuestions заменяет в содержимом теста ID вопросников по ids. Остальные
+// поля содержимого сохраняются; неразборчивое содержимое не меняется.
+func remapTestQuestions(content string, ids map[int]int) (string, bool) {
+	var config map[string]json.RawMessage
+	if json.Unmarshal([]byte(content), &config) != nil {
+		return content, false
+	}
+	var questions []map[string]json.RawMessage
+	if json.Unmarshal(config["questions"], &questions) != nil {
+		return content, false
+	}
+
+	changed := false
+	for _, q := range questions {
+		var id int
+		if json.Unmarshal(q["id"], &id) != nil {
+			continue
+		}
+		if clone, ok := ids[id]; ok {
+			q["id"] = json.RawMessage(strconv.Itoa(clone))
+			changed = true
+		}
+	}
+	if !changed {
+		return content, false
+	}
+	data, err := json.Marshal(questions)
+	if err != nil {
+		return content, false
+	}
+	config["questions"] = data
+	if data, err = json.Marshal(config); err != nil {
+		return content, false
+	}
+	return string(data), true
+}
+
+// copyAssignment создает задание нового семестра со сдвинутыми сроками и теми же группами,
+// а старое помечает архивным
+func copyAssignment(tx *sql.Tx, a models.Assignment, lectureID, offsetDays int) error {
+	closesAt := a.ClosesAt
+	if closesAt != nil {
+		shifted := closesAt.AddDate(0, 0, offsetDays).UTC()
+		closesAt = &shifted
+	}
+	var authorID interface{}
+	if a.AuthorID != 0 {
+		authorID = a.AuthorID
+	}
+
+	var id int
+	err := tx.QueryRow(`
+        INSERT INTO assignments (title, description, lecture_id, module_id, opens_at, due_at, closes_at,
+                                 late_penalty, max_attempts, author_id)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+        RETURNING id
+    `, a.Title, a.Description, lectureID, a.ModuleID, a.OpensAt.AddDate(0, 0, offsetDays).UTC(),
+		a.DueAt.AddDate(0, 0, offsetDays).UTC(), closesAt, a.LatePenalty, a.MaxAttempts, authorID).Scan(&id)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`
+        INSERT INTO assignment_groups (assignment_id, group_id)
+        SELECT $1, group_id FROM assignment_groups WHERE assignment_id = $2
+    `, id, a.ID); err != nil {
+		return err
+	}
+	_, err = tx.Exec(`UPDATE assignments SET archived = TRUE WHERE id = $1`, a.ID)
+	return err
+}
+
+// Rollover переносит курс на новый семестр по запросу {name, term, offset_days}
+func (h *CourseHandler) Rollover(w http.ResponseWriter, r *http.Request) {
+	course, ok := h.courseFromURL(w, r)
+	if !ok || !canManageCourse(h.DB, w, r, course) || !notArchived(w, course) {
+		return
+	}
+
+	var req struct {
+		Name       string `json:"name"`
+		Term       string `json:"term"`
+		OffsetDays int    `json:"offset_days"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Неверный формат JSON", http.StatusBadRequest)
+		return
+	}
+
+	id, err := RolloverCourse(h.DB, course.ID, RolloverOptions{
+		Name:       req.Name,
+		Term:       req.Term,
+		OffsetDays: req.OffsetDays,
+	})
+	switch {
+	case err == errRolloverName:
+		http.Error(w, "Укажите название или семестр нового курса", http.StatusBadRequest)
+		return
+	case err == errCourseNameTaken:
+		http.Error(w, "Курс с таким названием уже есть", http.StatusConflict)
+		return
+	case err == errCourseArchived:
+		http.Error(w, "Курс перенесен в архив, изменения запрещены", http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	h.respondCourse(w, id)
+}
+
+// courseArchived - курс перенесен в архив
+func courseArchived(db *sql.DB, courseID int) bool {
+	var archived bool
+	db.QueryRow(`SELECT archived FROM courses WHERE id = $1`, courseID).Scan(&archived)
+	return archived
+}
+
+// lectureArchived - лекция относится к курсу в архиве
+func lectureArchived(db *sql.DB, lectureID int) bool {
+	var archived bool
+	db.QueryRow(`
+        SELECT COALESCE(c.archived, FALSE) FROM lectures l LEFT JOIN courses c ON c.id = l.course_id
+        WHERE l.id = $1
+    `, lectureID).Scan(&archived)
+	return archived
+}
+
+// archivedAttempt - условие на попытку t по заданию или лекции курса в архиве
+const archivedAttempt = `(t.assignment_id IN (SELECT id FROM assignments WHERE archived)
+    OR t.lecture_id IN (SELECT l.id FROM lectures l JOIN courses c ON c.id = l.course_id WHERE c.archived))`
+
+func attemptArchived(db *sql.DB, attemptID int) bool {
+	var n int
+	db.QueryRow(`SELECT COUNT(*) FROM test_attempts t WHERE t.id = $1 AND `+archivedAttempt, attemptID).Scan(&n)
+	return n > 0
+}
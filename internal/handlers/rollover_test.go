@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+
+	"visualmath/internal/models"
+)
+
+func TestRolloverClonesModules(t *testing.T) {
+	db := testDB(t)
+	h := &ModuleHandler{DB: db}
+	teacher := addUser(t, db, "teacher", "teacher")
+
+	question := addModule(t, db, "question", `{"questions": `+questions+`}`)
+	// вопросник другого курса остается общим
+	shared := addModule(t, db, "question", `{"questions": `+questions+`}`)
+	if _, err := db.Exec(`UPDATE modules SET course_id = 2 WHERE id = $1`, shared); err != nil {
+		t.Fatal(err)
+	}
+	test := addModule(t, db, "test", `{"show_results": true, "questions": [{"id": `+strconv.Itoa(question)+
+		`, "points": 2}, {"id": `+strconv.Itoa(shared)+`, "points": 1}]}`)
+	lecture := addLecture(t, db, 1, test, question, shared)
+
+	for _, stmt := range []string{
+		`INSERT INTO skills (id, name) VALUES (1, 'Пределы')`,
+		`INSERT INTO module_skills (module_id, skill_id) VALUES ($1, 1)`,
+		`INSERT INTO item_parameters (source_module_id, question_index, discrimination, difficulty) VALUES ($1, 0, 1.5, -0.5)`,
+	} {
+		if _, err := db.Exec(stmt, question); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.Exec(`
+        INSERT INTO assignments (title, module_id, opens_at, due_at) VALUES ('ДЗ', $1, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+    `, test); err != nil {
+		t.Fatal(err)
+	}
+
+	newID, err := RolloverCourse(db, 1, RolloverOptions{Term: "Весна 2027"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// прошлый семестр по-прежнему ссылается на свои модули
+	old, err := loadLecture(db, lecture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := moduleIDs(old); got != [3]int{test, question, shared} {
+		t.Errorf("archived lecture modules %v", got)
+	}
+
+	var newLecture int
+	db.QueryRow(`SELECT id FROM lectures WHERE course_id = $1`, newID).Scan(&newLecture)
+	current, err := loadLecture(db, newLecture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := moduleIDs(current)
+	testCopy, questionCopy := ids[0], ids[1]
+	if testCopy == test || questionCopy == question || ids[2] != shared {
+		t.Fatalf("new lecture modules %v, want copies of %d and %d and shared %d", ids, test, question, shared)
+	}
+	for _, id := range []int{testCopy, questionCopy} {
+		if course := moduleCourse(db, id); course != newID {
+			t.Errorf("copy %d belongs to course %d, want %d", id, course, newID)
+		}
+	}
+	for _, id := range []int{test, question} {
+		if course := moduleCourse(db, id); course != 1 {
+			t.Errorf("original %d moved to course %d", id, course)
+		}
+	}
+
+	var content string
+	db.QueryRow(`SELECT content FROM modules WHERE id = $1`, testCopy).Scan(&content)
+	want := `{"questions":[{"id":` + strconv.Itoa(questionCopy) + `,"points":2},{"id":` + strconv.Itoa(shared) +
+		`,"points":1}],"show_results":true}`
+	if content != want {
+		t.Errorf("test copy content %s, want %s", content, want)
+	}
+
+	var skills, params, assignment int
+	db.QueryRow(`SELECT COUNT(*) FROM module_skills WHERE module_id = $1`, questionCopy).Scan(&skills)
+	db.QueryRow(`SELECT COUNT(*) FROM item_parameters WHERE source_module_id = $1`, questionCopy).Scan(&params)
+	db.QueryRow(`SELECT module_id FROM assignments WHERE NOT archived`).Scan(&assignment)
+	if skills != 1 || params != 1 || assignment != testCopy {
+		t.Errorf("copy has %d skills, %d calibrated items, assignment on module %d", skills, params, assignment)
+	}
+
+	// правка копии разрешена, модули архивного курса доступны только для чтения
+	body := `{"title": "Новый", "course_id": ` + strconv.Itoa(newID) + `, "type": "question", "content": {}}`
+	if w := serve(h.UpdateModule, teacher, "PUT", body, "id", strconv.Itoa(questionCopy)); w.Code != http.StatusOK {
+		t.Errorf("update copy: %d %s", w.Code, w.Body)
+	}
+	if w := serve(h.UpdateModule, teacher, "PUT", body, "id", strconv.Itoa(question)); w.Code != http.StatusConflict {
+		t.Errorf("move archived module: %d, want 409", w.Code)
+	}
+	if w := serve(h.DeleteModule, teacher, "DELETE", "", "id", strconv.Itoa(question)); w.Code != http.StatusConflict {
+		t.Errorf("delete archived module: %d, want 409", w.Code)
+	}
+}
+
+func moduleIDs(l *models.Lecture) [3]int {
+	var ids [3]int
+	for i, m := range l.Modules {
+		if i < len(ids) {
+			ids[i] = m.ModuleID
+		}
+	}
+	return ids
+}
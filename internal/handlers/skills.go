@@ -0,0 +1,543 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"visualmath/internal/auth"
+	"visualmath/internal/bkt"
+	"visualmath/internal/models"
+)
+
+const weakestSkillsLimit = 5
+
+// SkillHandler - навыки, их параметры BKT и владение навыками студентами
+type SkillHandler struct {
+	DB *sql.DB
+}
+
+// ListSkills возвращает все навыки с параметрами и числом модулей
+func (h *SkillHandler) ListSkills(w http.ResponseWriter, r *http.Request) {
+	skills, err := listSkills(h.DB)
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(skills)
+}
+
+// CreateSkill создает навык. Не указанные параметры BKT берутся по умолчанию.
+func (h *SkillHandler) CreateSkill(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.GetUserFromContext(r.Context())
+	if !auth.HasPermission(h.DB, user, auth.PermModuleEdit, auth.Scope{}) {
+		http.Error(w, "Доступ запрещен", http.StatusForbidden)
+		return
+	}
+
+	skill := models.Skill{Params: bkt.DefaultParams}
+	if err := json.NewDecoder(r.Body).Decode(&skill); err != nil {
+		http.Error(w, "Неверный формат JSON", http.StatusBadRequest)
+		return
+	}
+	skill.Name = strings.TrimSpace(skill.Name)
+	if skill.Name == "" {
+		http.Error(w, "Укажите название навыка", http.StatusBadRequest)
+		return
+	}
+	if err := skill.Params.Validate(); err != nil {
+		http.Error(w, "Неверные параметры: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err := h.DB.QueryRow(`
+        INSERT INTO skills (name, p_init, p_learn, p_slip, p_guess)
+        VALUES ($1, $2, $3, $4, $5)
+        ON CONFLICT (name) DO NOTHING
+        RETURNING id
+    `, skill.Name, skill.Init, skill.Learn, skill.Slip, skill.Guess).Scan(&skill.ID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Навык с таким названием уже есть", http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"skill":   skill,
+	})
+}
+
+// UpdateSkill меняет название или параметры BKT навыка. Новые параметры
+// применяются к следующим ответам, накопленные оценки не пересчитываются.
+func (h *SkillHandler) UpdateSkill(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.GetUserFromContext(r.Context())
+	if !auth.HasPermission(h.DB, user, auth.PermModuleEdit, auth.Scope{}) {
+		http.Error(w, "Доступ запрещен", http.StatusForbidden)
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Неверный ID", http.StatusBadRequest)
+		return
+	}
+	skill, err := loadSkill(h.DB, id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Навык не найден", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(skill); err != nil {
+		http.Error(w, "Неверный формат JSON", http.StatusBadRequest)
+		return
+	}
+	skill.ID = id
+	skill.Name = strings.TrimSpace(skill.Name)
+	if skill.Name == "" {
+		http.Error(w, "Укажите название навыка", http.StatusBadRequest)
+		return
+	}
+	if err := skill.Params.Validate(); err != nil {
+		http.Error(w, "Неверные параметры: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	_, err = h.DB.Exec(`
+        UPDATE skills SET name = $1, p_init = $2, p_learn = $3, p_slip = $4, p_guess = $5
+        WHERE id = $6
+    `, skill.Name, skill.Init, skill.Learn, skill.Slip, skill.Guess, id)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE") {
+			http.Error(w, "Навык с таким названием уже есть", http.StatusConflict)
+			return
+		}
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"skill":   skill,
+	})
+}
+
+// SkillHeatmap - таблица навык × студент для преподавателя.
+// ?group_id= - группа (можно несколько), по умолчанию все студенты.
+func (h *SkillHandler) SkillHeatmap(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.GetUserFromContext(r.Context())
+	if !auth.HasPermission(h.DB, user, auth.PermProgressRead, auth.Scope{}) {
+		http.Error(w, "Доступ запрещен", http.StatusForbidden)
+		return
+	}
+
+	groups, err := groupIDs(r.URL.Query()["group_id"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	groups, ok := permittedGroups(w, r, h.DB, auth.PermProgressRead, 0, groups)
+	if !ok {
+		return
+	}
+
+	heatmap, err := h.buildHeatmap(groups)
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(heatmap)
+}
+
+func (h *SkillHandler) buildHeatmap(groups []int) (*models.SkillHeatmap, error) {
+	skills, err := listSkills(h.DB)
+	if err != nil {
+		return nil, err
+	}
+	students, err := groupStudents(h.DB, groups)
+	if err != nil {
+		return nil, err
+	}
+
+	column := map[int]int{}
+	for j, s := range skills {
+		column[s.ID] = j
+	}
+	heatmap := &models.SkillHeatmap{Skills: skills, Rows: make([]models.SkillHeatmapRow, len(students))}
+	row := map[int]int{}
+	for i, st := range students {
+		row[st.UserID] = i
+		heatmap.Rows[i] = models.SkillHeatmapRow{
+			StudentID:   st.UserID,
+			FullName:    st.FullName,
+			Login:       st.Login,
+			GroupID:     st.GroupID,
+			GroupNumber: st.GroupNumber,
+			Mastery:     make([]*float64, len(skills)),
+		}
+	}
+
+	rows, err := h.DB.Query(`SELECT student_id, skill_id, p_known FROM skill_mastery`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var studentID, skillID int
+		var known float64
+		if err := rows.Scan(&studentID, &skillID, &known); err != nil {
+			return nil, err
+		}
+		i, ok := row[studentID]
+		if !ok {
+			continue
+		}
+		heatmap.Rows[i].Mastery[column[skillID]] = &known
+	}
+	return heatmap, rows.Err()
+}
+
+// MySkills возвращает навыки студента, начиная с самых слабых, и модули,
+// в которых их можно подтянуть. Освоенные навыки не показываются, если не передан ?all=1.
+func (h *SkillHandler) MySkills(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.GetUserFromContext(r.Context())
+
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = weakestSkillsLimit
+	}
+	all := r.URL.Query().Get("all") == "1"
+
+	rows, err := h.DB.Query(`
+        SELECT s.id, s.name, sm.p_known, sm.attempts, sm.correct, sm.updated_at
+        FROM skill_mastery sm
+        JOIN skills s ON s.id = sm.skill_id
+        WHERE sm.student_id = $1 AND ($2 OR sm.p_known < $3)
+        ORDER BY sm.p_known, s.name
+        LIMIT $4
+    `, user.UserID, all, bkt.MasteredThreshold, limit)
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	skills := []models.SkillMastery{}
+	for rows.Next() {
+		var m models.SkillMastery
+		if err := rows.Scan(&m.SkillID, &m.Name, &m.PKnown, &m.Attempts, &m.Correct, &m.UpdatedAt); err != nil {
+			rows.Close()
+			http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+			return
+		}
+		m.Mastered = m.PKnown >= bkt.MasteredThreshold
+		skills = append(skills, m)
+	}
+	rows.Close()
+
+	for i := range skills {
+		skills[i].Modules, err = skillModules(h.DB, skills[i].SkillID)
+		if err != nil {
+			http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(skills)
+}
+
+// skillModules - опубликованные модули, в которых встречается навык
+func skillModules(db *sql.DB, skillID int) ([]models.ModuleLink, error) {
+	rows, err := db.Query(`
+        SELECT m.id, m.title FROM module_skills ms
+        JOIN modules m ON m.id = ms.module_id
+        WHERE ms.skill_id = $1 AND m.published
+        ORDER BY ms.from_questions, m.title
+    `, skillID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	links := []models.ModuleLink{}
+	for rows.Next() {
+		var l models.ModuleLink
+		if err := rows.Scan(&l.ID, &l.Title); err != nil {
+			return nil, err
+		}
+		links = append(links, l)
+	}
+	return links, rows.Err()
+}
+
+const skillQuery = `
+    SELECT s.id, s.name, s.p_init, s.p_learn, s.p_slip, s.p_guess,
+           (SELECT COUNT(*) FROM module_skills ms WHERE ms.skill_id = s.id)
+    FROM skills s`
+
+func scanSkill(row interface{ Scan(...interface{}) error }) (*models.Skill, error) {
+	var s models.Skill
+	err := row.Scan(&s.ID, &s.Name, &s.Init, &s.Learn, &s.Slip, &s.Guess, &s.Modules)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func loadSkill(db *sql.DB, id int) (*models.Skill, error) {
+	return scanSkill(db.QueryRow(skillQuery+` WHERE s.id = $1`, id))
+}
+
+func listSkills(db *sql.DB) ([]models.Skill, error) {
+	rows, err := db.Query(skillQuery + ` ORDER BY s.name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	skills := []models.Skill{}
+	for rows.Next() {
+		s, err := scanSkill(rows)
+		if err != nil {
+			return nil, err
+		}
+		skills = append(skills, *s)
+	}
+	return skills, rows.Err()
+}
+
+// saveModuleSkills сохраняет навыки модуля и навыки, указанные у его вопросов.
+// Если skills равен nil, навыки самого модуля остаются прежними. Новые навыки создаются
+// с параметрами по умолчанию.
+func saveModuleSkills(db *sql.DB, moduleID int, moduleType string, content json.RawMessage, skills []string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM module_skills WHERE module_id = $1 AND from_questions`, moduleID); err != nil {
+		return err
+	}
+
+	if skills != nil {
+		if _, err := tx.Exec(`DELETE FROM module_skills WHERE module_id = $1`, moduleID); err != nil {
+			return err
+		}
+		for _, name := range skills {
+			if err := tagModule(tx, moduleID, name, false); err != nil {
+				return err
+			}
+		}
+	}
+
+	if moduleType == "question" {
+		var questions []models.Question
+		json.Unmarshal(content, &questions)
+		for _, q := range questions {
+			for _, name := range q.Skills {
+				if err := tagModule(tx, moduleID, name, true); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// tagModule добавляет навык модулю, создавая навык при необходимости.
+// Навык самого модуля не перезаписывается навыком вопроса.
+func tagModule(tx *sql.Tx, moduleID int, name string, fromQuestions bool) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil
+	}
+
+	p := bkt.DefaultParams
+	_, err := tx.Exec(`
+        INSERT OR IGNORE INTO skills (name, p_init, p_learn, p_slip, p_guess) VALUES ($1, $2, $3, $4, $5)
+    `, name, p.Init, p.Learn, p.Slip, p.Guess)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+        INSERT OR IGNORE INTO module_skills (module_id, skill_id, from_questions)
+        SELECT $1, id, $2 FROM skills WHERE name = $3
+    `, moduleID, fromQuestions, name)
+	return err
+}
+
+// updateMastery пересчитывает владение навыками вопроса после ответа студента.
+// Навыки берутся из самого вопроса, а если их нет - из модуля-вопросника.
+func updateMastery(tx *sql.Tx, studentID, sourceModuleID int, question models.Question, correct bool, at time.Time) error {
+	query := `
+        SELECT s.id, s.p_init, s.p_learn, s.p_slip, s.p_guess FROM skills s
+        JOIN module_skills ms ON ms.skill_id = s.id
+        WHERE ms.module_id = $1 AND NOT ms.from_questions`
+	args := []interface{}{sourceModuleID}
+	if len(question.Skills) > 0 {
+		placeholders := make([]string, len(question.Skills))
+		args = make([]interface{}, len(question.Skills))
+		for i, name := range question.Skills {
+			placeholders[i] = fmt.Sprintf("$%d", i+1)
+			args[i] = strings.TrimSpace(name)
+		}
+		query = `
+        SELECT id, p_init, p_learn, p_slip, p_guess FROM skills
+        WHERE name IN (` + strings.Join(placeholders, ", ") + `)`
+	}
+
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	var skills []models.Skill
+	for rows.Next() {
+		var s models.Skill
+		if err := rows.Scan(&s.ID, &s.Init, &s.Learn, &s.Slip, &s.Guess); err != nil {
+			rows.Close()
+			return err
+		}
+		skills = append(skills, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, s := range skills {
+		known := s.Init
+		err := tx.QueryRow(`
+            SELECT p_known FROM skill_mastery WHERE student_id = $1 AND skill_id = $2
+        `, studentID, s.ID).Scan(&known)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		known = bkt.Update(known, s.Params, correct)
+
+		right := 0
+		if correct {
+			right = 1
+		}
+		_, err = tx.Exec(`
+            INSERT INTO skill_mastery (student_id, skill_id, p_known, attempts, correct, updated_at)
+            VALUES ($1, $2, $3, 1, $4, $5)
+            ON CONFLICT (student_id, skill_id) DO UPDATE
+            SET p_known = excluded.p_known, attempts = attempts + 1,
+                correct = correct + excluded.correct, updated_at = excluded.updated_at
+        `, studentID, s.ID, known, right, at)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SkillHeatmapPage - страница с тепловой картой навыков группы
+func (h *SkillHandler) SkillHeatmapPage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+	html := `<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="UTF-8">
+    <title>Навыки группы - VisualMath</title>
+    <script src="/static/js/csrf.js"></script>
+    <link rel="stylesheet" href="/static/css/style.css">
+    <style>
+        .heatmap-container { margin: 40px; }
+        .filters { margin: 20px 0; }
+        .filters input { padding: 8px; border: 1px solid #ddd; border-radius: 6px; }
+        .filters button { padding: 8px 20px; background: #3498db; color: white; border: none; border-radius: 6px; cursor: pointer; }
+        .heatmap { border-collapse: collapse; background: white; box-shadow: 0 4px 6px rgba(0,0,0,0.1); }
+        .heatmap th, .heatmap td { border: 1px solid #eee; padding: 8px 10px; text-align: center; font-size: 14px; }
+        .heatmap th.skill { writing-mode: vertical-rl; transform: rotate(180deg); white-space: nowrap; }
+        .heatmap td.student { text-align: left; white-space: nowrap; }
+        .heatmap td.empty { color: #bbb; }
+        .legend { margin-top: 15px; color: #7f8c8d; font-size: 14px; }
+    </style>
+</head>
+<body>
+    <div class="heatmap-container">
+        <a href="/dashboard">← В личный кабинет</a>
+        <h1>🎯 Владение навыками</h1>
+        <div class="filters">
+            <select id="group"><option value="">Все группы</option></select>
+            <button onclick="loadHeatmap()">Показать</button>
+        </div>
+        <div id="heatmap">Загрузка...</div>
+        <p class="legend">Вероятность владения навыком по модели BKT. Навык считается освоенным от 95%. «—» - ответов еще не было.</p>
+    </div>
+    <script>
+
+        function escapeHTML(s) {
+            const div = document.createElement('div');
+            div.textContent = s;
+            return div.innerHTML;
+        }
+
+        // От красного (0) через желтый к зеленому (1)
+        function color(p) {
+            return 'hsl(' + Math.round(p * 120) + ', 70%, 75%)';
+        }
+
+        async function loadHeatmap() {
+            const group = document.getElementById('group').value;
+            const url = '/api/skills/heatmap' + (group ? '?group_id=' + group : '');
+            const response = await fetch(url);
+            if (!response.ok) {
+                document.getElementById('heatmap').textContent = 'Ошибка: ' + await response.text();
+                return;
+            }
+            const data = await response.json();
+            if (data.skills.length === 0) {
+                document.getElementById('heatmap').textContent = 'Навыков пока нет. Укажите навыки у модулей или вопросов.';
+                return;
+            }
+
+            let html = '<table class="heatmap"><tr><th>Студент</th><th>Группа</th>';
+            data.skills.forEach(s => { html += '<th class="skill">' + escapeHTML(s.name) + '</th>'; });
+            html += '</tr>';
+            data.rows.forEach(row => {
+                html += '<tr><td class="student">' + escapeHTML(row.full_name) + '</td><td>' + escapeHTML(row.group_number) + '</td>';
+                row.mastery.forEach(p => {
+                    html += p === null
+                        ? '<td class="empty">—</td>'
+                        : '<td style="background:' + color(p) + '">' + Math.round(p * 100) + '%</td>';
+                });
+                html += '</tr>';
+            });
+            document.getElementById('heatmap').innerHTML = html + '</table>';
+        }
+
+        async function loadGroups() {
+            const groups = await (await fetch('/api/groups')).json();
+            const select = document.getElementById('group');
+            groups.forEach(g => select.add(new Option(g.name, g.id)));
+        }
+
+        window.addEventListener('DOMContentLoaded', () => { loadGroups(); loadHeatmap(); });
+    </script>
+</body>
+</html>`
+
+	fmt.Fprint(w, html)
+}
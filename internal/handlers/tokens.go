@@ -0,0 +1,269 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"visualmath/internal/auth"
+	"visualmath/internal/models"
+)
+
+const maxTokenNameLength = 100
+
+// TokenHandler - персональные токены API для скриптов
+type TokenHandler struct {
+	DB *sql.DB
+}
+
+// ListTokens - токены текущего пользователя и разделы, на которые их можно выдать
+func (h *TokenHandler) ListTokens(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.GetUserFromContext(r.Context())
+
+	tokens, err := listTokens(h.DB, user.UserID)
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"tokens":    tokens,
+		"resources": auth.TokenResources,
+	})
+}
+
+// CreateToken создает токен {name, scopes: ["modules:read", ...], expires_in_days}.
+// expires_in_days = 0 - бессрочный. Токен возвращается только в этом ответе.
+// Области не расширяют права: токен преподавателя с modules:write может менять
+// модули, токен студента с той же областью - нет. Выпустить токен можно только
+// после входа по паролю: токен не выпускает другие токены.
+func (h *TokenHandler) CreateToken(w http.ResponseWriter, r *http.Request) {
+	user, ok := auth.GetUserFromContext(r.Context())
+	if !ok || user.UserID == 0 {
+		http.Error(w, "Требуется авторизация", http.StatusUnauthorized)
+		return
+	}
+	if user.TokenID != 0 {
+		http.Error(w, "Токен API нельзя выпустить по другому токену", http.StatusForbidden)
+		return
+	}
+
+	var req struct {
+		Name          string   `json:"name"`
+		Scopes        []string `json:"scopes"`
+		ExpiresInDays int      `json:"expires_in_days"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Неверный формат JSON", http.StatusBadRequest)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len([]rune(req.Name)) > maxTokenNameLength {
+		http.Error(w, "Укажите название токена до 100 символов", http.StatusBadRequest)
+		return
+	}
+	if len(req.Scopes) == 0 {
+		http.Error(w, "Укажите хотя бы одну область токена", http.StatusBadRequest)
+		return
+	}
+	seen := map[string]bool{}
+	var scopes []string
+	for _, s := range req.Scopes {
+		if !auth.ValidTokenScope(s) {
+			http.Error(w, "Неизвестная область токена: "+s, http.StatusBadRequest)
+			return
+		}
+		if !seen[s] {
+			seen[s] = true
+			scopes = append(scopes, s)
+		}
+	}
+	if req.ExpiresInDays < 0 {
+		http.Error(w, "Срок действия не может быть отрицательным", http.StatusBadRequest)
+		return
+	}
+
+	token, hash, err := auth.NewToken()
+	if err != nil {
+		http.Error(w, "Ошибка при создании токена", http.StatusInternalServerError)
+		return
+	}
+	var expiresAt interface{}
+	if req.ExpiresInDays > 0 {
+		expiresAt = time.Now().UTC().AddDate(0, 0, req.ExpiresInDays)
+	}
+
+	var id int
+	err = h.DB.QueryRow(`
+        INSERT INTO api_tokens (user_id, name, token_hash, prefix, scopes, expires_at)
+        VALUES ($1, $2, $3, $4, $5, $6)
+        RETURNING id
+    `, user.UserID, req.Name, hash, token[:len(auth.TokenPrefix)+4], strings.Join(scopes, " "), expiresAt).Scan(&id)
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	created, err := loadToken(h.DB, user.UserID, id)
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"token":   token,
+		"info":    created,
+		"message": "Сохраните токен: он показывается только один раз",
+	})
+}
+
+// RevokeToken отзывает токен текущего пользователя
+func (h *TokenHandler) RevokeToken(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.GetUserFromContext(r.Context())
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Неверный ID токена", http.StatusBadRequest)
+		return
+	}
+
+	if err := revokeToken(h.DB, user.UserID, id); err == sql.ErrNoRows {
+		http.Error(w, "Токен не найден", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+	})
+}
+
+// ListUserTokens - токены пользователя для администратора
+func (h *AdminHandler) ListUserTokens(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireAdmin(w, r); !ok {
+		return
+	}
+	target, ok := h.userFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	tokens, err := listTokens(h.DB, target.ID)
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// RevokeUserToken - отзыв токена пользователя администратором, например при утечке
+func (h *AdminHandler) RevokeUserToken(w http.ResponseWriter, r *http.Request) {
+	admin, ok := requireAdmin(w, r)
+	if !ok {
+		return
+	}
+	target, ok := h.userFromURL(w, r)
+	if !ok {
+		return
+	}
+	tokenID, err := strconv.Atoi(chi.URLParam(r, "tokenID"))
+	if err != nil {
+		http.Error(w, "Неверный ID токена", http.StatusBadRequest)
+		return
+	}
+
+	err = revokeToken(h.DB, target.ID, tokenID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Токен не найден", http.StatusNotFound)
+		return
+	}
+	if err == nil {
+		err = h.inTx(func(tx *sql.Tx) error {
+			return audit(tx, admin.UserID, "token.revoke", target.ID, map[string]interface{}{
+				"token_id": tokenID,
+			})
+		})
+	}
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+	})
+}
+
+// revokeToken отзывает действующий токен; sql.ErrNoRows - нет такого или уже отозван
+func revokeToken(db *sql.DB, userID, tokenID int) error {
+	res, err := db.Exec(`
+        UPDATE api_tokens SET revoked_at = $1 WHERE id = $2 AND user_id = $3 AND revoked_at IS NULL
+    `, time.Now().UTC(), tokenID, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+const tokenQuery = `
+    SELECT id, name, prefix, scopes, expires_at, last_used_at, revoked_at, created_at
+    FROM api_tokens`
+
+func scanToken(row interface{ Scan(...interface{}) error }) (*models.APIToken, error) {
+	var t models.APIToken
+	var scopes string
+	var expiresAt, lastUsedAt, revokedAt sql.NullTime
+	if err := row.Scan(&t.ID, &t.Name, &t.Prefix, &scopes, &expiresAt, &lastUsedAt, &revokedAt, &t.CreatedAt); err != nil {
+		return nil, err
+	}
+	t.Scopes = strings.Fields(scopes)
+	if expiresAt.Valid {
+		t.ExpiresAt = &expiresAt.Time
+	}
+	if lastUsedAt.Valid {
+		t.LastUsedAt = &lastUsedAt.Time
+	}
+	if revokedAt.Valid {
+		t.RevokedAt = &revokedAt.Time
+	}
+	return &t, nil
+}
+
+func loadToken(db *sql.DB, userID, id int) (*models.APIToken, error) {
+	return scanToken(db.QueryRow(tokenQuery+` WHERE id = $1 AND user_id = $2`, id, userID))
+}
+
+func listTokens(db *sql.DB, userID int) ([]models.APIToken, error) {
+	rows, err := db.Query(tokenQuery+` WHERE user_id = $1 ORDER BY revoked_at IS NOT NULL, id DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []models.APIToken{}
+	for rows.Next() {
+		t, err := scanToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, *t)
+	}
+	return tokens, rows.Err()
+}
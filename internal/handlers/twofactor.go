@@ -0,0 +1,687 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+
+	"visualmath/internal/auth"
+	"visualmath/internal/qrcode"
+	"visualmath/internal/totp"
+)
+
+const (
+	totpIssuer        = "VisualMath"
+	mfaTokenTTL       = 5 * time.Minute
+	recoveryCodeCount = 10
+
+	// назначение временного токена второго шага входа
+	mfaVerify = "2fa"       // ввести код
+	mfaSetup  = "2fa_setup" // роль требует 2FA, а она не подключена
+)
+
+// recoveryAlphabet - символы кодов восстановления без похожих (0/o, 1/l/i)
+const recoveryAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+
+var (
+	errTOTPEnabled    = errors.New("two-factor authentication is already enabled")
+	errTOTPNotStarted = errors.New("two-factor setup is not started")
+	errBadCode        = errors.New("invalid code")
+)
+
+// LoginSecondFactor - второй шаг входа {mfa_token, code}. Подходит код из
+// приложения или один из кодов восстановления.
+func (h *AuthHandler) LoginSecondFactor(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		MFAToken string `json:"mfa_token"`
+		Code     string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Неверный формат JSON", http.StatusBadRequest)
+		return
+	}
+	user, ok := h.mfaUser(w, req.MFAToken, mfaVerify)
+	if !ok {
+		return
+	}
+	// код из 6 цифр перебирается быстрее пароля - те же ограничения попыток
+	account := accountKey(user.ID, "")
+	if !h.allowAttempt(w, r, account) {
+		return
+	}
+
+	if err := verifySecondFactor(h.DB, user.ID, req.Code); err == errBadCode {
+		h.attemptFailed(r, account, user, "")
+		http.Error(w, "Неверный код", http.StatusUnauthorized)
+		return
+	} else if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	h.respondSession(w, r, user, nil)
+}
+
+// LoginSetupTwoFactor - подключение обязательной для роли 2FA при входе {mfa_token}
+func (h *AuthHandler) LoginSetupTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		MFAToken string `json:"mfa_token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Неверный формат JSON", http.StatusBadRequest)
+		return
+	}
+	user, ok := h.mfaUser(w, req.MFAToken, mfaSetup)
+	if !ok {
+		return
+	}
+
+	secret, uri, err := startTOTP(h.DB, user.ID, user.Login)
+	if err != nil {
+		respondTOTPError(w, err)
+		return
+	}
+	code, err := qrcode.Encode(uri)
+	if err != nil {
+		http.Error(w, "Ошибка генерации QR-кода", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"secret": secret,
+		"uri":    uri,
+		"qr_svg": code.SVG(4),
+	})
+}
+
+// LoginEnableTwoFactor подтверждает подключение кодом {mfa_token, code} и завершает
+// вход. Коды восстановления возвращаются один раз вместе с токеном сессии.
+func (h *AuthHandler) LoginEnableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		MFAToken string `json:"mfa_token"`
+		Code     string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Неверный формат JSON", http.StatusBadRequest)
+		return
+	}
+	user, ok := h.mfaUser(w, req.MFAToken, mfaSetup)
+	if !ok {
+		return
+	}
+	account := accountKey(user.ID, "")
+	if !h.allowAttempt(w, r, account) {
+		return
+	}
+
+	codes, err := confirmTOTP(h.DB, user.ID, req.Code)
+	if err != nil {
+		if err == errBadCode {
+			h.attemptFailed(r, account, user, "")
+		}
+		respondTOTPError(w, err)
+		return
+	}
+
+	h.respondSession(w, r, user, map[string]interface{}{
+		"recovery_codes": codes,
+	})
+}
+
+// TwoFactorStatus - состояние 2FA текущего пользователя
+func (h *AuthHandler) TwoFactorStatus(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.GetUserFromContext(r.Context())
+
+	var enabled, pending bool
+	var left int
+	err := h.DB.QueryRow(`
+        SELECT totp_enabled, totp_secret IS NOT NULL AND NOT totp_enabled,
+               (SELECT COUNT(*) FROM recovery_codes WHERE user_id = users.id AND used_at IS NULL)
+        FROM users WHERE id = $1
+    `, user.UserID).Scan(&enabled, &pending, &left)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Пользователь не найден", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+	required, err := twoFactorRequired(h.DB, user.UserType)
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"enabled":             enabled,
+		"pending":             pending, // ключ создан, но не подтвержден кодом
+		"required":            required,
+		"recovery_codes_left": left,
+	})
+}
+
+// SetupTwoFactor создает новый ключ. 2FA включится после подтверждения кодом.
+func (h *AuthHandler) SetupTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.GetUserFromContext(r.Context())
+
+	secret, uri, err := startTOTP(h.DB, user.UserID, user.Login)
+	if err != nil {
+		respondTOTPError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"secret": secret,
+		"uri":    uri,
+		"qr_url": "/api/user/2fa/qr",
+	})
+}
+
+// TwoFactorQR - QR-код с ключом для приложения (PNG, ?format=svg - SVG)
+func (h *AuthHandler) TwoFactorQR(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.GetUserFromContext(r.Context())
+
+	var secret sql.NullString
+	err := h.DB.QueryRow(`SELECT totp_secret FROM users WHERE id = $1 AND NOT totp_enabled`,
+		user.UserID).Scan(&secret)
+	if err == sql.ErrNoRows || (err == nil && !secret.Valid) {
+		http.Error(w, "Сначала начните подключение двухфакторной аутентификации", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	code, err := qrcode.Encode(totp.URI(totpIssuer, user.Login, secret.String))
+	if err != nil {
+		http.Error(w, "Ошибка генерации QR-кода", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	if r.URL.Query().Get("format") == "svg" {
+		w.Header().Set("Content-Type", "image/svg+xml")
+		fmt.Fprint(w, code.SVG(8))
+		return
+	}
+	image, err := code.PNG(8)
+	if err != nil {
+		http.Error(w, "Ошибка генерации QR-кода", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Write(image)
+}
+
+// EnableTwoFactor подтверждает ключ кодом из приложения {code} и возвращает коды восстановления
+func (h *AuthHandler) EnableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.GetUserFromContext(r.Context())
+
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Неверный формат JSON", http.StatusBadRequest)
+		return
+	}
+
+	codes, err := confirmTOTP(h.DB, user.UserID, req.Code)
+	if err != nil {
+		respondTOTPError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":        true,
+		"recovery_codes": codes,
+		"message":        "Сохраните коды восстановления: они показываются только один раз",
+	})
+}
+
+// DisableTwoFactor отключает 2FA по коду {code}, если она не обязательна для роли
+func (h *AuthHandler) DisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.GetUserFromContext(r.Context())
+
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Неверный формат JSON", http.StatusBadRequest)
+		return
+	}
+
+	required, err := twoFactorRequired(h.DB, user.UserType)
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+	if required {
+		http.Error(w, "Для вашей роли двухфакторная аутентификация обязательна", http.StatusForbidden)
+		return
+	}
+	if !h.checkCode(w, user.UserID, req.Code) {
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+	if err = clearTOTP(tx, user.UserID); err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+	})
+}
+
+// RegenerateRecoveryCodes заменяет коды восстановления новыми по коду {code}
+func (h *AuthHandler) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.GetUserFromContext(r.Context())
+
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Неверный формат JSON", http.StatusBadRequest)
+		return
+	}
+	if !h.checkCode(w, user.UserID, req.Code) {
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+	codes, err := newRecoveryCodes(tx, user.UserID)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":        true,
+		"recovery_codes": codes,
+	})
+}
+
+// checkCode проверяет код включенной 2FA и отвечает ошибкой, если он не подошел
+func (h *AuthHandler) checkCode(w http.ResponseWriter, userID int, code string) bool {
+	var enabled bool
+	if err := h.DB.QueryRow(`SELECT totp_enabled FROM users WHERE id = $1`, userID).Scan(&enabled); err != nil && err != sql.ErrNoRows {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return false
+	}
+	if !enabled {
+		http.Error(w, "Двухфакторная аутентификация не включена", http.StatusBadRequest)
+		return false
+	}
+	if err := verifySecondFactor(h.DB, userID, code); err == errBadCode {
+		http.Error(w, "Неверный код", http.StatusBadRequest)
+		return false
+	} else if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+// GetTwoFactorRoles - роли с обязательной 2FA и сколько их пользователей ее подключили
+func (h *AdminHandler) GetTwoFactorRoles(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireAdmin(w, r); !ok {
+		return
+	}
+	h.respondTwoFactorRoles(w)
+}
+
+// SetTwoFactorRoles задает роли с обязательной 2FA {roles: ["teacher", "admin"]}.
+// Пользователи этих ролей без 2FA подключат ее при следующем входе.
+func (h *AdminHandler) SetTwoFactorRoles(w http.ResponseWriter, r *http.Request) {
+	admin, ok := requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Roles []string `json:"roles"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Неверный формат JSON", http.StatusBadRequest)
+		return
+	}
+	for _, role := range req.Roles {
+		if !auth.ValidRole(role) {
+			http.Error(w, "Неизвестная роль: "+role, http.StatusBadRequest)
+			return
+		}
+	}
+
+	err := h.inTx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`DELETE FROM two_factor_roles`); err != nil {
+			return err
+		}
+		for _, role := range req.Roles {
+			if _, err := tx.Exec(`
+                INSERT OR IGNORE INTO two_factor_roles (role, created_by) VALUES ($1, $2)
+            `, role, admin.UserID); err != nil {
+				return err
+			}
+		}
+		return audit(tx, admin.UserID, "2fa.roles", 0, map[string]interface{}{
+			"roles": req.Roles,
+		})
+	})
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	h.respondTwoFactorRoles(w)
+}
+
+// ResetTwoFactor отключает 2FA пользователя, потерявшего телефон и коды восстановления
+func (h *AdminHandler) ResetTwoFactor(w http.ResponseWriter, r *http.Request) {
+	admin, ok := requireAdmin(w, r)
+	if !ok {
+		return
+	}
+	target, ok := h.userFromURL(w, r)
+	if !ok {
+		return
+	}
+	if target.ID == admin.UserID {
+		http.Error(w, "Нельзя сбросить собственную двухфакторную аутентификацию", http.StatusBadRequest)
+		return
+	}
+
+	err := h.inTx(func(tx *sql.Tx) error {
+		if err := clearTOTP(tx, target.ID); err != nil {
+			return err
+		}
+		return audit(tx, admin.UserID, "user.2fa_reset", target.ID, nil)
+	})
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	h.respondUser(w, target.ID)
+}
+
+func (h *AdminHandler) respondTwoFactorRoles(w http.ResponseWriter) {
+	rows, err := h.DB.Query(`
+        SELECT t.role, COUNT(u.id), COALESCE(SUM(u.totp_enabled), 0)
+        FROM two_factor_roles t
+        LEFT JOIN users u ON u.user_type = t.role AND u.active
+        GROUP BY t.role
+        ORDER BY t.role
+    `)
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	type roleStats struct {
+		Role    string `json:"role"`
+		Users   int    `json:"users"`
+		Enabled int    `json:"enabled"` // пользователи, уже подключившие 2FA
+	}
+	roles := []roleStats{}
+	for rows.Next() {
+		var s roleStats
+		if err := rows.Scan(&s.Role, &s.Users, &s.Enabled); err != nil {
+			http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+			return
+		}
+		roles = append(roles, s)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"roles": roles,
+	})
+}
+
+// respondSecondFactor выдает временный токен второго шага вместо сессии
+func (h *AuthHandler) respondSecondFactor(w http.ResponseWriter, user *loginUser, purpose string) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"user_id": user.ID,
+		"purpose": purpose,
+		"cookie":  user.CookieSession,
+		"exp":     time.Now().Add(mfaTokenTTL).Unix(),
+	})
+	tokenString, err := token.SignedString([]byte(h.JWTSecret))
+	if err != nil {
+		http.Error(w, "Ошибка генерации токена", http.StatusInternalServerError)
+		return
+	}
+
+	message := "Введите код из приложения-аутентификатора"
+	if purpose == mfaSetup {
+		message = "Для вашей роли нужна двухфакторная аутентификация: подключите приложение-аутентификатор"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":                   true,
+		"two_factor_required":       purpose == mfaVerify,
+		"two_factor_setup_required": purpose == mfaSetup,
+		"mfa_token":                 tokenString,
+		"message":                   message,
+	})
+}
+
+// mfaUser проверяет временный токен второго шага и загружает пользователя
+func (h *AuthHandler) mfaUser(w http.ResponseWriter, tokenString, purpose string) (*loginUser, bool) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method %v", t.Header["alg"])
+		}
+		return []byte(h.JWTSecret), nil
+	})
+	userID, _ := claims["user_id"].(float64)
+	if err != nil || claims["purpose"] != purpose || userID == 0 {
+		http.Error(w, "Время на ввод кода истекло, войдите заново", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	user, err := h.loadLoginUserByID(int(userID))
+	if err == sql.ErrNoRows {
+		http.Error(w, "Время на ввод кода истекло, войдите заново", http.StatusUnauthorized)
+		return nil, false
+	} else if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return nil, false
+	}
+	if !user.Active {
+		http.Error(w, "Учетная запись отключена", http.StatusForbidden)
+		return nil, false
+	}
+	// вид сессии выбран на первом шаге входа
+	user.CookieSession, _ = claims["cookie"].(bool)
+	return user, true
+}
+
+func respondTOTPError(w http.ResponseWriter, err error) {
+	switch err {
+	case errTOTPEnabled:
+		http.Error(w, "Двухфакторная аутентификация уже включена", http.StatusConflict)
+	case errTOTPNotStarted:
+		http.Error(w, "Сначала начните подключение двухфакторной аутентификации", http.StatusBadRequest)
+	case errBadCode:
+		http.Error(w, "Неверный код", http.StatusBadRequest)
+	default:
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+	}
+}
+
+// twoFactorRequired - администратор сделал 2FA обязательной для роли
+func twoFactorRequired(db *sql.DB, role string) (bool, error) {
+	var n int
+	err := db.QueryRow(`SELECT COUNT(*) FROM two_factor_roles WHERE role = $1`, role).Scan(&n)
+	return n > 0, err
+}
+
+// startTOTP создает новый неподтвержденный ключ
+func startTOTP(db *sql.DB, userID int, login string) (secret, uri string, err error) {
+	secret, err = totp.NewSecret()
+	if err != nil {
+		return "", "", err
+	}
+	res, err := db.Exec(`
+        UPDATE users SET totp_secret = $1, totp_last_step = 0 WHERE id = $2 AND NOT totp_enabled
+    `, secret, userID)
+	if err != nil {
+		return "", "", err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return "", "", errTOTPEnabled
+	}
+	return secret, totp.URI(totpIssuer, login, secret), nil
+}
+
+// confirmTOTP включает 2FA, если код подходит к неподтвержденному ключу
+func confirmTOTP(db *sql.DB, userID int, code string) ([]string, error) {
+	var secret sql.NullString
+	var enabled bool
+	err := db.QueryRow(`SELECT totp_secret, totp_enabled FROM users WHERE id = $1`, userID).Scan(&secret, &enabled)
+	if err != nil {
+		return nil, err
+	}
+	if enabled {
+		return nil, errTOTPEnabled
+	}
+	if !secret.Valid {
+		return nil, errTOTPNotStarted
+	}
+	step, ok := totp.Validate(secret.String, code, time.Now(), 0)
+	if !ok {
+		return nil, errBadCode
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`
+        UPDATE users SET totp_enabled = TRUE, totp_last_step = $1 WHERE id = $2
+    `, step, userID); err != nil {
+		return nil, err
+	}
+	codes, err := newRecoveryCodes(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return codes, tx.Commit()
+}
+
+// verifySecondFactor принимает код из приложения (каждый не больше одного раза)
+// или неиспользованный код восстановления
+func verifySecondFactor(db *sql.DB, userID int, code string) error {
+	var secret sql.NullString
+	var lastStep int64
+	err := db.QueryRow(`
+        SELECT totp_secret, totp_last_step FROM users WHERE id = $1 AND totp_enabled
+    `, userID).Scan(&secret, &lastStep)
+	if err == sql.ErrNoRows {
+		return errBadCode
+	} else if err != nil {
+		return err
+	}
+
+	if step, ok := totp.Validate(secret.String, code, time.Now(), lastStep); ok {
+		// условие на шаг не даст принять код дважды при параллельных запросах
+		res, err := db.Exec(`
+            UPDATE users SET totp_last_step = $1 WHERE id = $2 AND totp_last_step < $1
+        `, step, userID)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return errBadCode
+		}
+		return nil
+	}
+
+	res, err := db.Exec(`
+        UPDATE recovery_codes SET used_at = $1 WHERE user_id = $2 AND code_hash = $3 AND used_at IS NULL
+    `, time.Now().UTC(), userID, recoveryCodeHash(code))
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errBadCode
+	}
+	return nil
+}
+
+// newRecoveryCodes заменяет коды восстановления пользователя новыми
+func newRecoveryCodes(tx *sql.Tx, userID int) ([]string, error) {
+	if _, err := tx.Exec(`DELETE FROM recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return nil, err
+	}
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 10)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		for j, c := range b {
+			b[j] = recoveryAlphabet[int(c)%len(recoveryAlphabet)]
+		}
+		codes[i] = string(b[:5]) + "-" + string(b[5:])
+		if _, err := tx.Exec(`
+            INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, $2)
+        `, userID, recoveryCodeHash(codes[i])); err != nil {
+			return nil, err
+		}
+	}
+	return codes, nil
+}
+
+// recoveryCodeHash - хэш кода без учета регистра, пробелов и дефиса
+func recoveryCodeHash(code string) string {
+	code = strings.ToLower(code)
+	code = strings.NewReplacer("-", "", " ", "").Replace(code)
+	return hashToken(code)
+}
+
+// clearTOTP отключает 2FA и удаляет ключ и коды восстановления
+func clearTOTP(tx *sql.Tx, userID int) error {
+	if _, err := tx.Exec(`
+        UPDATE users SET totp_enabled = FALSE, totp_secret = NULL, totp_last_step = 0 WHERE id = $1
+    `, userID); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+	return err
+}
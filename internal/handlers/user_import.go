@@ -0,0 +1,466 @@
+package handlers
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"visualmath/internal/auth"
+	"visualmath/internal/loginlimit"
+	"visualmath/internal/mail"
+	"visualmath/internal/models"
+	"visualmath/internal/privacy"
+)
+
+// AdminHandler - администрирование пользователей
+type AdminHandler struct {
+	DB        *sql.DB
+	Mailer    *mail.Mailer
+	BaseURL   string                  // для ссылок в письмах
+	Limiter   *loginlimit.Limiter     // снятие блокировки входа
+	Retention privacy.RetentionConfig // срок хранения неактивных учетных записей
+}
+
+const maxImportSize = 5 << 20
+
+// importColumns - заголовки, по которым столбцы находятся без явного сопоставления
+var importColumns = map[string][]string{
+	"full_name": {"фио", "ф.и.о.", "ф. и. о.", "full_name", "name"},
+	"group":     {"группа", "group"},
+	"email":     {"email", "e-mail", "почта", "электронная почта"},
+	"login":     {"логин", "login"},
+}
+
+var loginPattern = regexp.MustCompile(`^[a-z0-9._-]{3,}$`)
+
+// ImportUsers создает пользователей из CSV-выгрузки деканата. Форма multipart:
+//   - file - CSV с заголовком, разделитель "," или ";";
+//   - mapping - JSON {"full_name": "ФИО", "group": "Группа", "email": "Почта", "login": "3"}:
+//     заголовок или номер столбца с 1, по умолчанию столбцы ищутся по заголовкам;
+//   - dry_run=true - только проверить и показать, что будет создано;
+//   - user_type - student (по умолчанию) или teacher;
+//   - password_mode - generate (пароли в отчете) или invite (письма со ссылкой).
+//
+// Если хотя бы в одной строке есть ошибка, ничего не создается. Результат - CSV с учетными данными.
+func (h *AdminHandler) ImportUsers(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.GetUserFromContext(r.Context())
+	if !auth.IsAdmin(user) {
+		http.Error(w, "Доступ запрещен", http.StatusForbidden)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+	if err := r.ParseMultipartForm(maxImportSize); err != nil {
+		http.Error(w, "Ожидается форма с CSV-файлом не больше 5 МБ", http.StatusBadRequest)
+		return
+	}
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "Не передан файл", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	userType := r.FormValue("user_type")
+	if userType == "" {
+		userType = "student"
+	}
+	if userType != "student" && userType != "teacher" {
+		http.Error(w, "Импортировать можно студентов или преподавателей", http.StatusBadRequest)
+		return
+	}
+	mode := r.FormValue("password_mode")
+	if mode == "" {
+		mode = "generate"
+	}
+	if mode != "generate" && mode != "invite" {
+		http.Error(w, "password_mode: generate или invite", http.StatusBadRequest)
+		return
+	}
+	mapping := map[string]string{}
+	if m := r.FormValue("mapping"); m != "" {
+		if err := json.Unmarshal([]byte(m), &mapping); err != nil {
+			http.Error(w, "Неверный формат mapping", http.StatusBadRequest)
+			return
+		}
+	}
+
+	records, err := readCSV(file)
+	if err != nil {
+		http.Error(w, "Не удалось прочитать CSV: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(records) < 2 {
+		http.Error(w, "В файле нет строк с данными", http.StatusBadRequest)
+		return
+	}
+	columns, err := importColumnIndexes(records[0], mapping, userType)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rows, err := h.validateImport(records, columns, userType)
+	if err != nil {
+		http.Error(w, "Ошибка базы данных", http.StatusInternalServerError)
+		return
+	}
+
+	invalid := 0
+	newGroups := []string{}
+	seenGroups := map[string]bool{}
+	for _, row := range rows {
+		if len(row.Errors) > 0 {
+			invalid++
+		}
+		if row.NewGroup && !seenGroups[row.Group] {
+			seenGroups[row.Group] = true
+			newGroups = append(newGroups, row.Group)
+		}
+	}
+	dryRun := r.FormValue("dry_run") == "true"
+	if dryRun || invalid > 0 {
+		status := http.StatusOK
+		if !dryRun {
+			status = http.StatusUnprocessableEntity
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"dry_run":    dryRun,
+			"rows":       rows,
+			"total":      len(rows),
+			"valid":      len(rows) - invalid,
+			"invalid":    invalid,
+			"new_groups": newGroups,
+		})
+		return
+	}
+
+	report, err := h.createImportedUsers(user.UserID, rows, userType, mode)
+	if err != nil {
+		http.Error(w, "Ошибка базы данных: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeCSV(w, "credentials.csv", report)
+}
+
+// readCSV читает файл с разделителем "," или ";" (Excel в русской локали сохраняет с ";")
+func readCSV(file io.Reader) ([][]string, error) {
+	br := bufio.NewReader(file)
+	first, _ := br.Peek(4096)
+	text := strings.TrimPrefix(string(first), utf8BOM)
+	if i := strings.IndexAny(text, "\r\n"); i >= 0 {
+		text = text[:i]
+	}
+	if strings.HasPrefix(string(first), utf8BOM) {
+		br.Discard(len(utf8BOM))
+	}
+
+	cr := csv.NewReader(br)
+	if strings.Count(text, ";") > strings.Count(text, ",") {
+		cr.Comma = ';'
+	}
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = true
+	cr.TrimLeadingSpace = true
+	return cr.ReadAll()
+}
+
+// importColumnIndexes сопоставляет поля столбцам файла. -1 - поля в файле нет.
+func importColumnIndexes(header []string, mapping map[string]string, userType string) (map[string]int, error) {
+	columns := map[string]int{}
+	for field, aliases := range importColumns {
+		columns[field] = -1
+		if spec, ok := mapping[field]; ok && strings.TrimSpace(spec) != "" {
+			aliases = []string{spec}
+			if n, err := strconv.Atoi(strings.TrimSpace(spec)); err == nil {
+				if n < 1 || n > len(header) {
+					return nil, fmt.Errorf("Столбца %d нет в файле", n)
+				}
+				columns[field] = n - 1
+				continue
+			}
+		}
+		for i, name := range header {
+			for _, alias := range aliases {
+				if strings.EqualFold(strings.TrimSpace(name), strings.TrimSpace(alias)) {
+					columns[field] = i
+				}
+			}
+		}
+		if strings.TrimSpace(mapping[field]) != "" && columns[field] < 0 {
+			return nil, fmt.Errorf("Столбец %q не найден", mapping[field])
+		}
+	}
+
+	if columns["full_name"] < 0 {
+		return nil, fmt.Errorf("Не найден столбец с ФИО")
+	}
+	if columns["email"] < 0 {
+		return nil, fmt.Errorf("Не найден столбец с email")
+	}
+	if userType == "student" && columns["group"] < 0 {
+		return nil, fmt.Errorf("Не найден столбец с группой")
+	}
+	return columns, nil
+}
+
+// validateImport разбирает строки и проверяет их по базе и друг с другом.
+// Логин, если его нет в файле, строится из ФИО.
+func (h *AdminHandler) validateImport(records [][]string, columns map[string]int, userType string) ([]models.ImportRow, error) {
+	cell := func(record []string, field string) string {
+		i := columns[field]
+		if i < 0 || i >= len(record) {
+			return ""
+		}
+		return strings.Join(strings.Fields(record[i]), " ")
+	}
+
+	groups := map[string]bool{}
+	rows, err := h.DB.Query(`SELECT name FROM groups`)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var name string
+		rows.Scan(&name)
+		groups[name] = true
+	}
+	rows.Close()
+
+	exists := func(column, value string) (bool, error) {
+		var n int
+		err := h.DB.QueryRow(`SELECT COUNT(*) FROM users WHERE `+column+`_index = pii_index($1)`, value).Scan(&n)
+		return n > 0, err
+	}
+
+	result := []models.ImportRow{}
+	emails := map[string]int{}
+	logins := map[string]int{}
+	for i, record := range records[1:] {
+		row := models.ImportRow{
+			Row:      i + 2,
+			FullName: cell(record, "full_name"),
+			Group:    cell(record, "group"),
+			Email:    strings.ToLower(cell(record, "email")),
+			Login:    strings.ToLower(cell(record, "login")),
+		}
+		if row.FullName == "" && row.Group == "" && row.Email == "" && row.Login == "" {
+			continue // пустые строки в конце выгрузки
+		}
+
+		if row.FullName == "" {
+			row.Errors = append(row.Errors, "не указано ФИО")
+		}
+
+		at := strings.LastIndex(row.Email, "@")
+		switch {
+		case row.Email == "":
+			row.Errors = append(row.Errors, "не указан email")
+		case !validEmail(row.Email):
+			row.Errors = append(row.Errors, "неверный email")
+		case emails[row.Email] != 0:
+			row.Errors = append(row.Errors, fmt.Sprintf("email повторяется в строке %d", emails[row.Email]))
+		default:
+			taken, err := exists("email", row.Email)
+			if err != nil {
+				return nil, err
+			}
+			if taken {
+				row.Errors = append(row.Errors, "email уже зарегистрирован")
+			}
+			emails[row.Email] = row.Row
+		}
+
+		if row.Login == "" {
+			base := loginFromName(row.FullName)
+			if base == "" && at > 0 {
+				base = strings.Trim(loginFromName(row.Email[:at]), "._-")
+			}
+			for n := 1; base != ""; n++ {
+				candidate := base
+				if n > 1 {
+					candidate = fmt.Sprintf("%s%d", base, n)
+				}
+				taken, err := exists("login", candidate)
+				if err != nil {
+					return nil, err
+				}
+				if !taken && logins[candidate] == 0 {
+					row.Login = candidate
+					break
+				}
+			}
+		}
+		switch {
+		case row.Login == "" && row.FullName == "":
+			// логин не из чего построить, ошибка ФИО уже есть
+		case !loginPattern.MatchString(row.Login):
+			row.Errors = append(row.Errors, "недопустимый логин: латиница, цифры, . _ -, не короче 3 символов")
+		case logins[row.Login] != 0:
+			row.Errors = append(row.Errors, fmt.Sprintf("логин повторяется в строке %d", logins[row.Login]))
+		default:
+			taken, err := exists("login", row.Login)
+			if err != nil {
+				return nil, err
+			}
+			if taken {
+				row.Errors = append(row.Errors, "логин занят")
+			}
+			logins[row.Login] = row.Row
+		}
+
+		if userType == "student" && row.Group == "" {
+			row.Errors = append(row.Errors, "не указана группа")
+		}
+		if userType != "student" {
+			row.Group = ""
+		}
+		row.NewGroup = row.Group != "" && !groups[row.Group]
+
+		result = append(result, row)
+	}
+	return result, nil
+}
+
+// createImportedUsers создает группы и пользователей в одной транзакции и возвращает
+// таблицу учетных данных. Письма-приглашения отправляются после фиксации.
+func (h *AdminHandler) createImportedUsers(actorID int, rows []models.ImportRow, userType, mode string) ([][]string, error) {
+	// bcrypt медленный, поэтому считаем хэши до транзакции, чтобы не держать базу
+	passwords := make([]string, len(rows))
+	hashes := make([]string, len(rows))
+	for i := range rows {
+		password, err := generatePassword()
+		if err != nil {
+			return nil, err
+		}
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, err
+		}
+		if mode == "generate" {
+			passwords[i] = password
+		}
+		hashes[i] = string(hash)
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	groupIDs := map[string]int{}
+	tokens := make([]string, len(rows))
+	for i, row := range rows {
+		var groupID interface{}
+		if row.Group != "" {
+			id, ok := groupIDs[row.Group]
+			if !ok {
+				if _, err := tx.Exec(`
+                    INSERT INTO groups (name) VALUES ($1) ON CONFLICT (name) DO NOTHING
+                `, row.Group); err != nil {
+					return nil, err
+				}
+				if err := tx.QueryRow(`SELECT id FROM groups WHERE name = $1`, row.Group).Scan(&id); err != nil {
+					return nil, err
+				}
+				groupIDs[row.Group] = id
+			}
+			groupID = id
+		}
+
+		var userID int
+		err := tx.QueryRow(`
+            INSERT INTO users (login, password_hash, full_name, user_type, group_id, email, login_index, email_index)
+            VALUES (pii_encrypt($1), $2, pii_encrypt($3), $4, $5, pii_encrypt($6), pii_index($1), pii_index($6))
+            RETURNING id
+        `, row.Login, hashes[i], row.FullName, userType, groupID, row.Email).Scan(&userID)
+		if err != nil {
+			return nil, fmt.Errorf("строка %d: %v", row.Row, err)
+		}
+		if mode == "invite" {
+			if tokens[i], err = issuePasswordToken(tx, userID, "invite", inviteTokenTTL); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := audit(tx, actorID, "user.import", 0, map[string]interface{}{
+		"count":         len(rows),
+		"user_type":     userType,
+		"password_mode": mode,
+	}); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	report := [][]string{{"Строка", "ФИО", "Группа", "Логин", "Email", "Пароль", "Приглашение"}}
+	for i, row := range rows {
+		invite := ""
+		if mode == "invite" {
+			invite = "отправлено"
+			body := fmt.Sprintf("Здравствуйте, %s!\n\nДля вас создана учетная запись VisualMath, логин: %s.\n"+
+				"Чтобы задать пароль, перейдите по ссылке (действует 7 дней):\n%s\n",
+				row.FullName, row.Login, setPasswordURL(h.BaseURL, tokens[i]))
+			if err := h.Mailer.Send(row.Email, "Учетная запись VisualMath", body); err != nil {
+				log.Printf("Не удалось отправить приглашение %s: %v", row.Email, err)
+				invite = "ошибка отправки"
+			}
+		}
+		report = append(report, []string{strconv.Itoa(row.Row), row.FullName, row.Group, row.Login, row.Email,
+			passwords[i], invite})
+	}
+	return report, nil
+}
+
+// translit - транслитерация кириллицы для логинов
+var translit = map[rune]string{
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "e", 'ж': "zh", 'з': "z",
+	'и': "i", 'й': "y", 'к': "k", 'л': "l", 'м': "m", 'н': "n", 'о': "o", 'п': "p", 'р': "r",
+	'с': "s", 'т': "t", 'у': "u", 'ф': "f", 'х': "kh", 'ц': "ts", 'ч': "ch", 'ш': "sh", 'щ': "shch",
+	'ъ': "", 'ы': "y", 'ь': "", 'э': "e", 'ю': "yu", 'я': "ya",
+}
+
+// loginFromName строит логин "фамилия.инициалы": "Иванов Иван Петрович" -> "ivanov.ip"
+func loginFromName(name string) string {
+	latin := func(s string) string {
+		var b strings.Builder
+		for _, r := range strings.ToLower(s) {
+			if t, ok := translit[r]; ok {
+				b.WriteString(t)
+			} else if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '.' || r == '_' || r == '-' {
+				b.WriteRune(r)
+			}
+		}
+		return b.String()
+	}
+
+	parts := strings.Fields(name)
+	if len(parts) == 0 {
+		return ""
+	}
+	login := latin(parts[0])
+	initials := ""
+	for _, p := range parts[1:] {
+		if l := latin(p); l != "" {
+			initials += l[:1]
+		}
+	}
+	if initials != "" {
+		login += "." + initials
+	}
+	return login
+}
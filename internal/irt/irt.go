@@ -0,0 +1,241 @@
+// Package irt реализует двухпараметрическую логистическую модель (2PL) теории
+// ответов на задания: калибровку параметров вопросов по накопленным ответам
+// и оценку уровня подготовки студента.
+package irt
+
+import "math"
+
+// Границы параметров, за которые оценки не выходят
+const (
+	MinAbility        = -4.0
+	MaxAbility        = 4.0
+	minDiscrimination = 0.2
+	maxDiscrimination = 3.0
+	maxDifficulty     = 4.0
+
+	gridPoints = 81 // узлы сетки для оценки способности
+)
+
+// Item - параметры вопроса: A - дискриминативность, B - трудность
+type Item struct {
+	A float64 `json:"discrimination"`
+	B float64 `json:"difficulty"`
+}
+
+// DefaultItem используется для вопросов, по которым еще нет ответов
+var DefaultItem = Item{A: 1, B: 0}
+
+// Response - ответ на вопрос с известными параметрами
+type Response struct {
+	Item    Item
+	Correct bool
+}
+
+// Probability - вероятность верного ответа при способности theta
+func Probability(item Item, theta float64) float64 {
+	return 1 / (1 + math.Exp(-item.A*(theta-item.B)))
+}
+
+// Information - информация Фишера вопроса в точке theta
+func Information(item Item, theta float64) float64 {
+	p := Probability(item, theta)
+	return item.A * item.A * p * (1 - p)
+}
+
+// EstimateAbility возвращает апостериорное среднее способности (EAP) с нормальным
+// априорным распределением N(0, 1) и ее стандартную ошибку. Оценка конечна даже
+// когда все ответы верные или все неверные.
+func EstimateAbility(responses []Response) (theta, se float64) {
+	var sum, sumSq, total float64
+	step := (MaxAbility - MinAbility) / (gridPoints - 1)
+	// Логарифм правдоподобия, чтобы не терять точность на длинных тестах
+	logL := make([]float64, gridPoints)
+	for i := range logL {
+		t := MinAbility + float64(i)*step
+		logL[i] = -t * t / 2
+		for _, r := range responses {
+			logL[i] += logProbability(r.Item, t, r.Correct)
+		}
+	}
+	w := relativeWeights(logL)
+	for i := range w {
+		t := MinAbility + float64(i)*step
+		total += w[i]
+		sum += w[i] * t
+		sumSq += w[i] * t * t
+	}
+	if total == 0 {
+		return 0, 1
+	}
+	theta = sum / total
+	variance := sumSq/total - theta*theta
+	if variance < 0 {
+		variance = 0
+	}
+	return theta, math.Sqrt(variance)
+}
+
+// Calibrate оценивает параметры вопросов методом маргинального максимального
+// правдоподобия (EM-алгоритм Бока-Эйткина) при нормальном распределении способностей.
+// data[человек][вопрос]: 1 - верно, 0 - неверно, -1 - вопрос не задавался.
+// Для вопросов, на которые меньше minResponses ответов, возвращается DefaultItem.
+func Calibrate(data [][]int, items, minResponses int) []Item {
+	result := make([]Item, items)
+	for i := range result {
+		result[i] = DefaultItem
+	}
+	if len(data) == 0 {
+		return result
+	}
+
+	counts := make([]int, items)
+	for _, row := range data {
+		for i, u := range row {
+			if u >= 0 {
+				counts[i]++
+			}
+		}
+	}
+
+	nodes, weights := quadrature()
+	posterior := make([]float64, len(nodes))
+	// Ожидаемое число ответивших и ответивших верно в каждом узле
+	expected := make([][]float64, items)
+	expectedRight := make([][]float64, items)
+	for i := range expected {
+		expected[i] = make([]float64, len(nodes))
+		expectedRight[i] = make([]float64, len(nodes))
+	}
+
+	for iteration := 0; iteration < 100; iteration++ {
+		for i := range expected {
+			for k := range nodes {
+				expected[i][k], expectedRight[i][k] = 0, 0
+			}
+		}
+
+		// E-шаг: апостериорное распределение способности каждого человека
+		for _, row := range data {
+			var total float64
+			for k, t := range nodes {
+				posterior[k] = math.Log(weights[k])
+				for i, u := range row {
+					if u >= 0 {
+						posterior[k] += logProbability(result[i], t, u == 1)
+					}
+				}
+			}
+			for _, w := range relativeWeights(posterior) {
+				total += w
+			}
+			if total == 0 {
+				continue
+			}
+			for i, u := range row {
+				if u < 0 {
+					continue
+				}
+				for k := range nodes {
+					share := posterior[k] / total
+					expected[i][k] += share
+					expectedRight[i][k] += share * float64(u)
+				}
+			}
+		}
+
+		// M-шаг: каждый вопрос подгоняется независимо
+		maxChange := 0.0
+		for i := 0; i < items; i++ {
+			if counts[i] < minResponses {
+				continue
+			}
+			updated := fitItem(result[i], nodes, expected[i], expectedRight[i])
+			change := math.Max(math.Abs(updated.A-result[i].A), math.Abs(updated.B-result[i].B))
+			maxChange = math.Max(maxChange, change)
+			result[i] = updated
+		}
+		if maxChange < 0.0005 {
+			break
+		}
+	}
+	return result
+}
+
+// logProbability - логарифм вероятности ответа. Вероятность отодвинута от 0 и 1,
+// чтобы очень легкий или очень трудный вопрос не давал -Inf.
+func logProbability(item Item, theta float64, correct bool) float64 {
+	p := clamp(Probability(item, theta), 1e-12, 1-1e-12)
+	if correct {
+		return math.Log(p)
+	}
+	return math.Log(1 - p)
+}
+
+// relativeWeights заменяет логарифмы весов на веса относительно наибольшего
+// (log-sum-exp): exp(logL) длинного теста уходит в 0 во всех узлах сразу,
+// а exp(logL - max) - нет. Для нормировки это равносильно, множитель сокращается.
+func relativeWeights(logs []float64) []float64 {
+	max := math.Inf(-1)
+	for _, l := range logs {
+		max = math.Max(max, l)
+	}
+	for k, l := range logs {
+		logs[k] = math.Exp(l - max)
+	}
+	return logs
+}
+
+// quadrature - узлы сетки способностей и веса стандартного нормального распределения
+func quadrature() (nodes, weights []float64) {
+	const points = 41
+	step := (MaxAbility - MinAbility) / (points - 1)
+	var total float64
+	for k := 0; k < points; k++ {
+		t := MinAbility + float64(k)*step
+		nodes = append(nodes, t)
+		weights = append(weights, math.Exp(-t*t/2))
+		total += weights[k]
+	}
+	for k := range weights {
+		weights[k] /= total
+	}
+	return nodes, weights
+}
+
+// fitItem - несколько шагов Ньютона для логистической регрессии по ожидаемым
+// частотам в параметризации a*theta + c. Слабый штраф удерживает оценки, когда
+// почти все отвечают одинаково.
+func fitItem(item Item, nodes, n, r []float64) Item {
+	const ridge = 0.05
+	a, c := item.A, -item.A*item.B
+	for step := 0; step < 10; step++ {
+		ga, gc := -ridge*(a-1), -ridge*c
+		haa, hac, hcc := ridge, 0.0, ridge
+		for k, t := range nodes {
+			p := 1 / (1 + math.Exp(-(a*t + c)))
+			residual := r[k] - n[k]*p
+			ga += residual * t
+			gc += residual
+			wt := n[k] * p * (1 - p)
+			haa += wt * t * t
+			hac += wt * t
+			hcc += wt
+		}
+		det := haa*hcc - hac*hac
+		if det <= 0 {
+			break
+		}
+		da := (hcc*ga - hac*gc) / det
+		dc := (haa*gc - hac*ga) / det
+		a = clamp(a+da, minDiscrimination, maxDiscrimination)
+		c += dc
+		if math.Abs(da) < 1e-6 && math.Abs(dc) < 1e-6 {
+			break
+		}
+	}
+	return Item{A: a, B: clamp(-c/a, -maxDifficulty, maxDifficulty)}
+}
+
+func clamp(x, lo, hi float64) float64 {
+	return math.Max(lo, math.Min(hi, x))
+}
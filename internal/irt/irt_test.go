@@ -0,0 +1,102 @@
+package irt
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// repeat - n ответов одного вида на вопросы с параметрами по умолчанию
+func repeat(n int, correct bool) []Response {
+	responses := make([]Response, n)
+	for i := range responses {
+		responses[i] = Response{Item: DefaultItem, Correct: correct}
+	}
+	return responses
+}
+
+func TestEstimateAbilityExtremes(t *testing.T) {
+	cases := []struct {
+		name    string
+		correct bool
+	}{
+		{"all correct", true},
+		{"all wrong", false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			prevTheta, prevSE := 0.0, math.Inf(1)
+			for _, n := range []int{1, 5, 10, 50, 200, 1000} {
+				theta, se := EstimateAbility(repeat(n, tc.correct))
+				if math.IsNaN(theta) || math.IsInf(theta, 0) || math.IsNaN(se) || math.IsInf(se, 0) {
+					t.Fatalf("n=%d: theta=%v se=%v, want finite", n, theta, se)
+				}
+				if theta < MinAbility || theta > MaxAbility {
+					t.Fatalf("n=%d: theta=%v outside [%v, %v]", n, theta, MinAbility, MaxAbility)
+				}
+				// чем длиннее тест, тем дальше оценка от нуля в сторону ответов
+				if tc.correct && theta < prevTheta || !tc.correct && theta > prevTheta {
+					t.Errorf("n=%d: theta=%v is not monotone after %v", n, theta, prevTheta)
+				}
+				if se > prevSE {
+					t.Errorf("n=%d: se=%v grew from %v", n, se, prevSE)
+				}
+				prevTheta, prevSE = theta, se
+			}
+			if math.Abs(prevTheta) < 2 {
+				t.Errorf("theta=%v after 1000 identical answers, want |theta| >= 2", prevTheta)
+			}
+		})
+	}
+}
+
+func TestEstimateAbilityNoResponses(t *testing.T) {
+	theta, se := EstimateAbility(nil)
+	if math.Abs(theta) > 1e-9 || math.Abs(se-1) > 0.01 {
+		t.Errorf("EstimateAbility(nil) = %v, %v; want the prior 0, 1", theta, se)
+	}
+}
+
+func TestCalibrateRecoversParameters(t *testing.T) {
+	want := []Item{
+		{A: 0.8, B: -1.5},
+		{A: 1.3, B: -1.0},
+		{A: 1.2, B: -0.5},
+		{A: 1.1, B: -0.2},
+		{A: 1.0, B: 0},
+		{A: 1.6, B: 0},
+		{A: 1.0, B: 0.3},
+		{A: 0.9, B: 0.5},
+		{A: 1.5, B: 0.7},
+		{A: 2.0, B: 1.2},
+	}
+	rng := rand.New(rand.NewSource(1))
+	data := make([][]int, 3000)
+	for p := range data {
+		theta := rng.NormFloat64()
+		data[p] = make([]int, len(want))
+		for i, item := range want {
+			switch {
+			case rng.Float64() < 0.1:
+				data[p][i] = -1 // вопрос не задавался, как в адаптивном тесте
+			case rng.Float64() < Probability(item, theta):
+				data[p][i] = 1
+			}
+		}
+	}
+
+	got := Calibrate(data, len(want), 5)
+	for i := range want {
+		if math.Abs(got[i].A-want[i].A) > 0.3 || math.Abs(got[i].B-want[i].B) > 0.25 {
+			t.Errorf("item %d: got a=%.2f b=%.2f, want a=%.2f b=%.2f", i, got[i].A, got[i].B, want[i].A, want[i].B)
+		}
+	}
+}
+
+func TestCalibrateTooFewResponses(t *testing.T) {
+	data := [][]int{{1, -1}, {0, -1}, {1, 1}}
+	got := Calibrate(data, 2, 3)
+	if got[1] != DefaultItem {
+		t.Errorf("item with 1 response = %+v, want DefaultItem", got[1])
+	}
+}
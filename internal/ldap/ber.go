@@ -0,0 +1,180 @@
+package ldap
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Классы и теги BER, нужные протоколу LDAP
+const (
+	tagBoolean     = 0x01
+	tagInteger     = 0x02
+	tagOctetString = 0x04
+	tagEnumerated  = 0x0a
+	tagSequence    = 0x30
+	tagSet         = 0x31
+
+	classApplication = 0x40
+	classContext     = 0x80
+	constructed      = 0x20
+)
+
+// maxPacket - ограничение размера ответа сервера, чтобы битый пакет не съел память
+const maxPacket = 1 << 20
+
+var errMalformed = errors.New("ldap: malformed packet")
+
+// packet - элемент BER: тег и либо значение, либо вложенные элементы
+type packet struct {
+	tag      byte
+	value    []byte
+	children []*packet
+}
+
+func (p *packet) isConstructed() bool { return p.tag&constructed != 0 }
+
+func newSequence(tag byte, children ...*packet) *packet {
+	return &packet{tag: tag, children: children}
+}
+
+func newString(tag byte, s string) *packet {
+	return &packet{tag: tag, value: []byte(s)}
+}
+
+func newInt(tag byte, n int) *packet {
+	// минимальная запись в дополнительном коде, старший бит - знак
+	var b []byte
+	for {
+		b = append([]byte{byte(n)}, b...)
+		n >>= 8
+		if (n == 0 && b[0]&0x80 == 0) || (n == -1 && b[0]&0x80 != 0) {
+			break
+		}
+	}
+	return &packet{tag: tag, value: b}
+}
+
+func newBool(b bool) *packet {
+	if b {
+		return &packet{tag: tagBoolean, value: []byte{0xff}}
+	}
+	return &packet{tag: tagBoolean, value: []byte{0}}
+}
+
+// bytes кодирует элемент с длиной в короткой или длинной форме
+func (p *packet) bytes() []byte {
+	content := p.value
+	if p.children != nil || p.isConstructed() {
+		content = nil
+		for _, c := range p.children {
+			content = append(content, c.bytes()...)
+		}
+	}
+
+	out := []byte{p.tag}
+	n := len(content)
+	if n < 0x80 {
+		out = append(out, byte(n))
+	} else {
+		var length []byte
+		for ; n > 0; n >>= 8 {
+			length = append([]byte{byte(n)}, length...)
+		}
+		out = append(out, 0x80|byte(len(length)))
+		out = append(out, length...)
+	}
+	return append(out, content...)
+}
+
+// readPacket читает один элемент верхнего уровня из потока
+func readPacket(r *bufio.Reader) (*packet, error) {
+	tag, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	first, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	length := int(first)
+	if first&0x80 != 0 {
+		count := int(first & 0x7f)
+		if count == 0 || count > 4 {
+			return nil, errMalformed
+		}
+		length = 0
+		for i := 0; i < count; i++ {
+			b, err := r.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			length = length<<8 | int(b)
+		}
+	}
+	if length > maxPacket {
+		return nil, fmt.Errorf("ldap: packet of %d bytes is too large", length)
+	}
+
+	content := make([]byte, length)
+	if _, err := io.ReadFull(r, content); err != nil {
+		return nil, err
+	}
+	return parsePacket(tag, content)
+}
+
+func parsePacket(tag byte, content []byte) (*packet, error) {
+	p := &packet{tag: tag, value: content}
+	if !p.isConstructed() {
+		return p, nil
+	}
+	for len(content) > 0 {
+		if len(content) < 2 {
+			return nil, errMalformed
+		}
+		childTag := content[0]
+		length := int(content[1])
+		offset := 2
+		if content[1]&0x80 != 0 {
+			count := int(content[1] & 0x7f)
+			if count == 0 || count > 4 || len(content) < 2+count {
+				return nil, errMalformed
+			}
+			length = 0
+			for _, b := range content[2 : 2+count] {
+				length = length<<8 | int(b)
+			}
+			offset += count
+		}
+		if length < 0 || len(content) < offset+length {
+			return nil, errMalformed
+		}
+		child, err := parsePacket(childTag, content[offset:offset+length])
+		if err != nil {
+			return nil, err
+		}
+		p.children = append(p.children, child)
+		content = content[offset+length:]
+	}
+	return p, nil
+}
+
+// int разбирает INTEGER или ENUMERATED
+func (p *packet) int() int {
+	if len(p.value) == 0 {
+		return 0
+	}
+	n := int(int8(p.value[0]))
+	for _, b := range p.value[1:] {
+		n = n<<8 | int(b)
+	}
+	return n
+}
+
+func (p *packet) child(i int) *packet {
+	if i < len(p.children) {
+		return p.children[i]
+	}
+	return &packet{}
+}
@@ -0,0 +1,135 @@
+package ldap
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Теги фильтров поиска (RFC 4511, 4.5.1)
+const (
+	filterAnd      = classContext | constructed | 0
+	filterOr       = classContext | constructed | 1
+	filterNot      = classContext | constructed | 2
+	filterEquality = classContext | constructed | 3
+	filterPresent  = classContext | 7
+)
+
+// EscapeFilter экранирует значение для подстановки в фильтр, чтобы логин
+// вида "*)(uid=*" не менял смысл запроса
+func EscapeFilter(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '*', '(', ')', '\\', 0:
+			fmt.Fprintf(&b, "\\%02x", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// parseFilter разбирает фильтр в строковой записи RFC 4515. Поддерживаются
+// &, |, !, равенство и проверка наличия атрибута (attr=*) - этого хватает для
+// поиска учетной записи.
+func parseFilter(s string) (*packet, error) {
+	p, rest, err := parseFilterAt(strings.TrimSpace(s))
+	if err != nil {
+		return nil, err
+	}
+	if rest != "" {
+		return nil, fmt.Errorf("ldap: unexpected %q after filter", rest)
+	}
+	return p, nil
+}
+
+func parseFilterAt(s string) (*packet, string, error) {
+	if !strings.HasPrefix(s, "(") {
+		return nil, "", fmt.Errorf("ldap: filter must start with '(': %q", s)
+	}
+	s = s[1:]
+	if s == "" {
+		return nil, "", fmt.Errorf("ldap: unterminated filter")
+	}
+
+	switch s[0] {
+	case '&', '|':
+		tag := byte(filterAnd)
+		if s[0] == '|' {
+			tag = filterOr
+		}
+		set := newSequence(tag)
+		s = s[1:]
+		for strings.HasPrefix(s, "(") {
+			child, rest, err := parseFilterAt(s)
+			if err != nil {
+				return nil, "", err
+			}
+			set.children = append(set.children, child)
+			s = rest
+		}
+		if !strings.HasPrefix(s, ")") {
+			return nil, "", fmt.Errorf("ldap: unterminated filter")
+		}
+		return set, s[1:], nil
+	case '!':
+		child, rest, err := parseFilterAt(s[1:])
+		if err != nil {
+			return nil, "", err
+		}
+		if !strings.HasPrefix(rest, ")") {
+			return nil, "", fmt.Errorf("ldap: unterminated filter")
+		}
+		return newSequence(filterNot, child), rest[1:], nil
+	}
+
+	end := strings.IndexByte(s, ')')
+	if end < 0 {
+		return nil, "", fmt.Errorf("ldap: unterminated filter")
+	}
+	item, rest := s[:end], s[end+1:]
+	eq := strings.IndexByte(item, '=')
+	if eq <= 0 {
+		return nil, "", fmt.Errorf("ldap: bad filter item %q", item)
+	}
+	attr, value := item[:eq], item[eq+1:]
+	if strings.ContainsAny(attr, "<>~:") {
+		return nil, "", fmt.Errorf("ldap: unsupported filter item %q", item)
+	}
+	if value == "*" {
+		return newString(filterPresent, attr), rest, nil
+	}
+	if strings.Contains(value, "*") {
+		return nil, "", fmt.Errorf("ldap: substring filters are not supported: %q", item)
+	}
+	decoded, err := unescapeFilter(value)
+	if err != nil {
+		return nil, "", err
+	}
+	return newSequence(filterEquality,
+		newString(tagOctetString, attr),
+		newString(tagOctetString, decoded),
+	), rest, nil
+}
+
+// unescapeFilter раскрывает последовательности \XX
+func unescapeFilter(s string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+3 > len(s) {
+			return "", fmt.Errorf("ldap: bad escape in %q", s)
+		}
+		n, err := strconv.ParseUint(s[i+1:i+3], 16, 8)
+		if err != nil {
+			return "", fmt.Errorf("ldap: bad escape in %q", s)
+		}
+		b.WriteByte(byte(n))
+		i += 2
+	}
+	return b.String(), nil
+}
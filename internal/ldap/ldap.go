@@ -0,0 +1,391 @@
+// Package ldap проверяет пароли по каталогу университета (LDAPv3, простая
+// аутентификация) без внешних зависимостей: сервисная учетная запись ищет
+// пользователя, затем выполняется bind от его имени с введенным паролем.
+package ldap
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Коды результата LDAP, которые различает вызывающий код
+const (
+	resultSuccess            = 0
+	resultInvalidCredentials = 49
+)
+
+// Теги операций протокола
+const (
+	opBindRequest     = classApplication | constructed | 0
+	opBindResponse    = classApplication | constructed | 1
+	opUnbindRequest   = classApplication | 2
+	opSearchRequest   = classApplication | constructed | 3
+	opSearchEntry     = classApplication | constructed | 4
+	opSearchDone      = classApplication | constructed | 5
+	opSearchReference = classApplication | constructed | 19
+)
+
+var (
+	// ErrInvalidCredentials - нет такого пользователя или пароль неверный
+	ErrInvalidCredentials = errors.New("ldap: invalid credentials")
+	// ErrAmbiguous - фильтр нашел несколько учетных записей
+	ErrAmbiguous = errors.New("ldap: filter matches more than one entry")
+)
+
+// rolePriority - при нескольких подходящих группах берется роль с большими правами
+var rolePriority = map[string]int{"student": 1, "ta": 2, "teacher": 3, "admin": 4}
+
+// Config - подключение к каталогу и сопоставление атрибутов
+type Config struct {
+	URL          string // ldap://host:389 или ldaps://host:636
+	BindDN       string // сервисная учетная запись для поиска, пусто - анонимный поиск
+	BindPassword string
+	BaseDN       string
+	UserFilter   string // %s заменяется экранированным логином, например (uid=%s)
+	LoginAttr    string // атрибут с логином для новой учетной записи
+	NameAttr     string
+	MailAttr     string
+	GroupAttr    string
+	GroupRoles   map[string]string // DN или имя группы в нижнем регистре -> user_type
+	DefaultRole  string            // роль, если ни одна группа не подошла
+	Timeout      time.Duration
+	TLSConfig    *tls.Config // для ldaps://
+}
+
+// Entry - найденная и проверенная учетная запись каталога
+type Entry struct {
+	DN       string
+	Login    string
+	FullName string
+	Email    string
+	Groups   []string
+	Role     string
+}
+
+// FromEnv читает LDAP_URL, LDAP_BIND_DN, LDAP_BIND_PASSWORD, LDAP_BASE_DN,
+// LDAP_USER_FILTER, LDAP_LOGIN_ATTR, LDAP_NAME_ATTR, LDAP_MAIL_ATTR, LDAP_GROUP_ATTR,
+// LDAP_GROUP_ROLES, LDAP_DEFAULT_ROLE и LDAP_TLS_SKIP_VERIFY.
+// LDAP_GROUP_ROLES - пары "роль:группа" через точку с запятой, например
+// "teacher:cn=teachers,ou=groups,dc=uni,dc=ru;ta:cn=assistants,ou=groups,dc=uni,dc=ru".
+// Если LDAP_URL не задан, возвращает nil - вход только по паролю VisualMath.
+func FromEnv() (*Config, error) {
+	if os.Getenv("LDAP_URL") == "" {
+		return nil, nil
+	}
+	c := &Config{
+		URL:          os.Getenv("LDAP_URL"),
+		BindDN:       os.Getenv("LDAP_BIND_DN"),
+		BindPassword: os.Getenv("LDAP_BIND_PASSWORD"),
+		BaseDN:       os.Getenv("LDAP_BASE_DN"),
+		UserFilter:   envOr("LDAP_USER_FILTER", "(uid=%s)"),
+		LoginAttr:    envOr("LDAP_LOGIN_ATTR", "uid"),
+		NameAttr:     envOr("LDAP_NAME_ATTR", "cn"),
+		MailAttr:     envOr("LDAP_MAIL_ATTR", "mail"),
+		GroupAttr:    envOr("LDAP_GROUP_ATTR", "memberOf"),
+		DefaultRole:  envOr("LDAP_DEFAULT_ROLE", "student"),
+		GroupRoles:   map[string]string{},
+		Timeout:      10 * time.Second,
+	}
+	if os.Getenv("LDAP_TLS_SKIP_VERIFY") == "true" {
+		c.TLSConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+
+	for _, pair := range strings.Split(os.Getenv("LDAP_GROUP_ROLES"), ";") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		role, group, ok := strings.Cut(pair, ":")
+		role = strings.TrimSpace(role)
+		if !ok || rolePriority[role] == 0 {
+			return nil, fmt.Errorf("LDAP_GROUP_ROLES: bad pair %q, expected role:group", pair)
+		}
+		c.GroupRoles[normalizeGroup(group)] = role
+	}
+	if c.DefaultRole != "" && rolePriority[c.DefaultRole] == 0 {
+		return nil, fmt.Errorf("LDAP_DEFAULT_ROLE: unknown role %q", c.DefaultRole)
+	}
+	if _, err := parseFilter(strings.ReplaceAll(c.UserFilter, "%s", "x")); err != nil {
+		return nil, fmt.Errorf("LDAP_USER_FILTER: %v", err)
+	}
+	return c, nil
+}
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// Authenticate ищет пользователя по логину и проверяет пароль bind-запросом
+// от его имени. Пустой пароль отклоняется сразу: для LDAP это анонимный вход,
+// который сервер считает успешным.
+func (c *Config) Authenticate(login, password string) (*Entry, error) {
+	if strings.TrimSpace(login) == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	conn, err := c.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.close()
+
+	if err := conn.bind(c.BindDN, c.BindPassword); err != nil {
+		return nil, fmt.Errorf("ldap: service bind: %w", err)
+	}
+
+	attrs := []string{c.LoginAttr, c.NameAttr, c.MailAttr, c.GroupAttr}
+	filter := strings.ReplaceAll(c.UserFilter, "%s", EscapeFilter(login))
+	entries, err := conn.search(c.BaseDN, filter, attrs)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, ErrInvalidCredentials
+	}
+	if len(entries) > 1 {
+		return nil, ErrAmbiguous
+	}
+
+	found := entries[0]
+	if err := conn.bind(found.dn, password); err != nil {
+		return nil, err
+	}
+
+	entry := &Entry{
+		DN:       found.dn,
+		Login:    found.first(c.LoginAttr),
+		FullName: found.first(c.NameAttr),
+		Email:    found.first(c.MailAttr),
+		Groups:   found.attrs[strings.ToLower(c.GroupAttr)],
+	}
+	if entry.Login == "" {
+		entry.Login = login
+	}
+	entry.Role = c.role(entry.Groups)
+	return entry, nil
+}
+
+// role выбирает user_type по группам каталога
+func (c *Config) role(groups []string) string {
+	role := c.DefaultRole
+	for _, g := range groups {
+		mapped, ok := c.GroupRoles[normalizeGroup(g)]
+		if !ok {
+			// группу можно указать и коротким именем: cn=teachers,ou=... -> teachers
+			mapped, ok = c.GroupRoles[groupName(g)]
+		}
+		if ok && rolePriority[mapped] > rolePriority[role] {
+			role = mapped
+		}
+	}
+	return role
+}
+
+func normalizeGroup(dn string) string {
+	parts := strings.Split(dn, ",")
+	for i, p := range parts {
+		parts[i] = strings.ToLower(strings.TrimSpace(p))
+	}
+	return strings.Join(parts, ",")
+}
+
+// groupName - значение первого RDN: "cn=teachers,ou=groups" -> "teachers"
+func groupName(dn string) string {
+	first := strings.SplitN(dn, ",", 2)[0]
+	if _, value, ok := strings.Cut(first, "="); ok {
+		return strings.ToLower(strings.TrimSpace(value))
+	}
+	return normalizeGroup(dn)
+}
+
+// conn - соединение с сервером. Запросы выполняются строго по очереди.
+type conn struct {
+	nc        net.Conn
+	r         *bufio.Reader
+	messageID int
+	timeout   time.Duration
+}
+
+type searchEntry struct {
+	dn    string
+	attrs map[string][]string // имена атрибутов в нижнем регистре
+}
+
+func (e searchEntry) first(attr string) string {
+	if values := e.attrs[strings.ToLower(attr)]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func (c *Config) dial() (*conn, error) {
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return nil, fmt.Errorf("ldap: bad URL %q: %v", c.URL, err)
+	}
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+	dialer := &net.Dialer{Timeout: timeout}
+
+	var nc net.Conn
+	switch u.Scheme {
+	case "ldap":
+		host := u.Host
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "389")
+		}
+		nc, err = dialer.Dial("tcp", host)
+	case "ldaps":
+		host := u.Host
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "636")
+		}
+		config := c.TLSConfig
+		if config == nil {
+			config = &tls.Config{}
+		}
+		if config.ServerName == "" && !config.InsecureSkipVerify {
+			config = config.Clone()
+			config.ServerName = u.Hostname()
+		}
+		nc, err = tls.DialWithDialer(dialer, "tcp", host, config)
+	default:
+		return nil, fmt.Errorf("ldap: unsupported scheme %q", u.Scheme)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ldap: %v", err)
+	}
+	return &conn{nc: nc, r: bufio.NewReader(nc), timeout: timeout}, nil
+}
+
+func (c *conn) close() {
+	c.send(&packet{tag: opUnbindRequest})
+	c.nc.Close()
+}
+
+// send отправляет операцию в конверте LDAPMessage и возвращает ее messageID
+func (c *conn) send(op *packet) (int, error) {
+	c.messageID++
+	msg := newSequence(tagSequence, newInt(tagInteger, c.messageID), op)
+	c.nc.SetDeadline(time.Now().Add(c.timeout))
+	_, err := c.nc.Write(msg.bytes())
+	return c.messageID, err
+}
+
+// receive читает следующий ответ на запрос id и возвращает операцию из конверта
+func (c *conn) receive(id int) (*packet, error) {
+	for {
+		msg, err := readPacket(c.r)
+		if err != nil {
+			return nil, fmt.Errorf("ldap: %v", err)
+		}
+		if msg.tag != tagSequence || len(msg.children) < 2 {
+			return nil, errMalformed
+		}
+		if msg.child(0).int() == id {
+			return msg.child(1), nil
+		}
+	}
+}
+
+func (c *conn) bind(dn, password string) error {
+	id, err := c.send(newSequence(opBindRequest,
+		newInt(tagInteger, 3),
+		newString(tagOctetString, dn),
+		newString(classContext|0, password),
+	))
+	if err != nil {
+		return fmt.Errorf("ldap: %v", err)
+	}
+	resp, err := c.receive(id)
+	if err != nil {
+		return err
+	}
+	if resp.tag != opBindResponse {
+		return errMalformed
+	}
+	return result(resp)
+}
+
+func (c *conn) search(baseDN, filter string, attrs []string) ([]searchEntry, error) {
+	f, err := parseFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	attributes := newSequence(tagSequence)
+	for _, a := range attrs {
+		attributes.children = append(attributes.children, newString(tagOctetString, a))
+	}
+	id, err := c.send(newSequence(opSearchRequest,
+		newString(tagOctetString, baseDN),
+		newInt(tagEnumerated, 2), // wholeSubtree
+		newInt(tagEnumerated, 0), // neverDerefAliases
+		newInt(tagInteger, 2),    // больше двух записей не нужно: уже неоднозначно
+		newInt(tagInteger, int(c.timeout/time.Second)),
+		newBool(false),
+		f,
+		attributes,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("ldap: %v", err)
+	}
+
+	var entries []searchEntry
+	for {
+		resp, err := c.receive(id)
+		if err != nil {
+			return nil, err
+		}
+		switch resp.tag {
+		case opSearchEntry:
+			entry := searchEntry{dn: string(resp.child(0).value), attrs: map[string][]string{}}
+			for _, attr := range resp.child(1).children {
+				name := strings.ToLower(string(attr.child(0).value))
+				for _, v := range attr.child(1).children {
+					entry.attrs[name] = append(entry.attrs[name], string(v.value))
+				}
+			}
+			entries = append(entries, entry)
+		case opSearchReference:
+			// ссылки на другие серверы не обходим
+		case opSearchDone:
+			// sizeLimitExceeded (4) означает, что записей больше одной
+			if code := resp.child(0).int(); code == 4 {
+				return nil, ErrAmbiguous
+			}
+			if err := result(resp); err != nil {
+				return nil, err
+			}
+			return entries, nil
+		default:
+			return nil, errMalformed
+		}
+	}
+}
+
+// result превращает LDAPResult в ошибку
+func result(resp *packet) error {
+	switch code := resp.child(0).int(); code {
+	case resultSuccess:
+		return nil
+	case resultInvalidCredentials:
+		return ErrInvalidCredentials
+	default:
+		if msg := string(resp.child(2).value); msg != "" {
+			return fmt.Errorf("ldap: result code %d: %s", code, msg)
+		}
+		return fmt.Errorf("ldap: result code %d", code)
+	}
+}
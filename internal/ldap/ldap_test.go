@@ -0,0 +1,171 @@
+package ldap
+
+import (
+	"testing"
+	"time"
+)
+
+const (
+	serviceDN = "cn=service,dc=uni,dc=ru"
+	teachers  = "cn=teachers,ou=groups,dc=uni,dc=ru"
+	admins    = "cn=admins,ou=groups,dc=uni,dc=ru"
+)
+
+// startDirectory поднимает каталог с сервисной учетной записью и двумя пользователями
+func startDirectory(t *testing.T) *Config {
+	t.Helper()
+	srv, err := NewServer(
+		ServerEntry{DN: serviceDN, Password: "service"},
+		ServerEntry{
+			DN:       "uid=ivanov,ou=people,dc=uni,dc=ru",
+			Password: "secret",
+			Attrs: map[string][]string{
+				"uid":      {"ivanov"},
+				"cn":       {"Иванов Иван"},
+				"mail":     {"ivanov@uni.ru"},
+				"memberOf": {"CN=Teachers, OU=Groups, DC=uni, DC=ru"},
+			},
+		},
+		ServerEntry{
+			DN:       "uid=petrov,ou=people,dc=uni,dc=ru",
+			Password: "other",
+			Attrs: map[string][]string{
+				"uid":  {"petrov"},
+				"cn":   {"Петров Петр"},
+				"mail": {"petrov@uni.ru"},
+			},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(srv.Close)
+
+	return &Config{
+		URL:          srv.URL(),
+		BindDN:       serviceDN,
+		BindPassword: "service",
+		BaseDN:       "ou=people,dc=uni,dc=ru",
+		UserFilter:   "(uid=%s)",
+		LoginAttr:    "uid",
+		NameAttr:     "cn",
+		MailAttr:     "mail",
+		GroupAttr:    "memberOf",
+		GroupRoles:   map[string]string{normalizeGroup(teachers): "teacher"},
+		DefaultRole:  "student",
+		Timeout:      5 * time.Second,
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	c := startDirectory(t)
+
+	entry, err := c.Authenticate("ivanov", "secret")
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if entry.DN != "uid=ivanov,ou=people,dc=uni,dc=ru" || entry.Login != "ivanov" ||
+		entry.FullName != "Иванов Иван" || entry.Email != "ivanov@uni.ru" {
+		t.Errorf("unexpected entry %+v", entry)
+	}
+	// группа в каталоге записана в другом регистре и с пробелами
+	if entry.Role != "teacher" {
+		t.Errorf("role = %q, want teacher", entry.Role)
+	}
+
+	entry, err = c.Authenticate("petrov", "other")
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if entry.Role != "student" {
+		t.Errorf("role without groups = %q, want default student", entry.Role)
+	}
+}
+
+func TestAuthenticateRejects(t *testing.T) {
+	c := startDirectory(t)
+
+	cases := []struct {
+		name, login, password string
+	}{
+		{"wrong password", "ivanov", "wrong"},
+		{"other user's password", "ivanov", "other"},
+		// пустой пароль для LDAP - анонимный bind, сервер принял бы его
+		{"empty password", "ivanov", ""},
+		{"unknown user", "sidorov", "secret"},
+		{"empty login", "", "secret"},
+		// без экранирования фильтр (uid=*)(uid=*) нашел бы всех
+		{"filter injection", "*)(uid=*", "secret"},
+		{"wildcard", "*", "secret"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if entry, err := c.Authenticate(tc.login, tc.password); err != ErrInvalidCredentials {
+				t.Errorf("Authenticate(%q, %q) = %+v, %v; want ErrInvalidCredentials", tc.login, tc.password, entry, err)
+			}
+		})
+	}
+}
+
+func TestAuthenticateServiceBind(t *testing.T) {
+	c := startDirectory(t)
+	c.BindPassword = "wrong"
+
+	if _, err := c.Authenticate("ivanov", "secret"); err == nil {
+		t.Fatal("expected an error when the service account cannot bind")
+	}
+}
+
+func TestEscapeFilter(t *testing.T) {
+	cases := []struct{ in, want string }{
+		{"ivanov", "ivanov"},
+		{"*)(uid=*", `\2a\29\28uid=\2a`},
+		{`a\b`, `a\5cb`},
+		{"a\x00b", `a\00b`},
+	}
+	for _, tc := range cases {
+		if got := EscapeFilter(tc.in); got != tc.want {
+			t.Errorf("EscapeFilter(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+
+	// экранированное значение остается одним сравнением на равенство
+	f, err := parseFilter("(uid=" + EscapeFilter("*)(uid=*") + ")")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.tag != filterEquality || string(f.child(1).value) != "*)(uid=*" {
+		t.Errorf("escaped filter parsed as tag %#x value %q", f.tag, f.child(1).value)
+	}
+}
+
+func TestRole(t *testing.T) {
+	c := &Config{
+		DefaultRole: "student",
+		GroupRoles: map[string]string{
+			normalizeGroup(teachers): "teacher",
+			"assistants":             "ta",
+			normalizeGroup(admins):   "admin",
+		},
+	}
+
+	cases := []struct {
+		name   string
+		groups []string
+		want   string
+	}{
+		{"no groups", nil, "student"},
+		{"unmapped group", []string{"cn=library,ou=groups,dc=uni,dc=ru"}, "student"},
+		{"full DN", []string{teachers}, "teacher"},
+		{"DN case and spaces", []string{"CN=Teachers, OU=Groups, DC=uni, DC=ru"}, "teacher"},
+		{"short name", []string{"cn=Assistants,ou=staff,dc=uni,dc=ru"}, "ta"},
+		{"highest wins", []string{"cn=assistants,ou=staff", admins, teachers}, "admin"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := c.role(tc.groups); got != tc.want {
+				t.Errorf("role(%v) = %q, want %q", tc.groups, got, tc.want)
+			}
+		})
+	}
+}
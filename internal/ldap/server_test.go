@@ -0,0 +1,196 @@
+package ldap
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"sync"
+)
+
+// ServerEntry - учетная запись каталога в памяти
+type ServerEntry struct {
+	DN       string
+	Password string              // пустой - bind от имени записи запрещен
+	Attrs    map[string][]string // атрибуты, например uid, cn, mail, memberOf
+}
+
+// Server - LDAP-сервер в памяти для тестов входа без каталога университета.
+// Понимает простой bind, поиск по поддереву с фильтрами из parseFilter и unbind.
+type Server struct {
+	ln      net.Listener
+	entries []ServerEntry
+	wg      sync.WaitGroup
+}
+
+// NewServer запускает сервер на случайном порту 127.0.0.1
+func NewServer(entries ...ServerEntry) (*Server, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{ln: ln, entries: entries}
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// URL - адрес для Config.URL
+func (s *Server) URL() string {
+	return "ldap://" + s.ln.Addr().String()
+}
+
+// Close останавливает прием соединений и ждет завершения начатых
+func (s *Server) Close() {
+	s.ln.Close()
+	s.wg.Wait()
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		nc, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer nc.Close()
+			s.handle(nc)
+		}()
+	}
+}
+
+func (s *Server) handle(nc net.Conn) {
+	r := bufio.NewReader(nc)
+	reply := func(id int, op *packet) bool {
+		_, err := nc.Write(newSequence(tagSequence, newInt(tagInteger, id), op).bytes())
+		return err == nil
+	}
+
+	for {
+		msg, err := readPacket(r)
+		if err != nil || msg.tag != tagSequence {
+			return
+		}
+		id, op := msg.child(0).int(), msg.child(1)
+
+		switch op.tag {
+		case opBindRequest:
+			code := s.bind(string(op.child(1).value), string(op.child(2).value))
+			if !reply(id, ldapResult(opBindResponse, code)) {
+				return
+			}
+		case opSearchRequest:
+			base := normalizeGroup(string(op.child(0).value))
+			limit := op.child(3).int()
+			var attrs []string
+			for _, a := range op.child(7).children {
+				attrs = append(attrs, string(a.value))
+			}
+
+			code, sent := resultSuccess, 0
+			for _, e := range s.entries {
+				dn := normalizeGroup(e.DN)
+				if dn != base && !strings.HasSuffix(dn, ","+base) || !matchFilter(op.child(6), e.Attrs) {
+					continue
+				}
+				if limit > 0 && sent == limit {
+					code = 4 // sizeLimitExceeded
+					break
+				}
+				if !reply(id, searchResultEntry(e, attrs)) {
+					return
+				}
+				sent++
+			}
+			if !reply(id, ldapResult(opSearchDone, code)) {
+				return
+			}
+		case opUnbindRequest:
+			return
+		default:
+			return
+		}
+	}
+}
+
+func (s *Server) bind(dn, password string) int {
+	if dn == "" && password == "" {
+		return resultSuccess // анонимный bind
+	}
+	for _, e := range s.entries {
+		if normalizeGroup(e.DN) == normalizeGroup(dn) && e.Password != "" && e.Password == password {
+			return resultSuccess
+		}
+	}
+	return resultInvalidCredentials
+}
+
+func ldapResult(tag byte, code int) *packet {
+	return newSequence(tag,
+		newInt(tagEnumerated, code),
+		newString(tagOctetString, ""),
+		newString(tagOctetString, ""),
+	)
+}
+
+func searchResultEntry(e ServerEntry, attrs []string) *packet {
+	list := newSequence(tagSequence)
+	for name, values := range e.Attrs {
+		if len(attrs) > 0 && !containsFold(attrs, name) {
+			continue
+		}
+		set := newSequence(tagSet)
+		for _, v := range values {
+			set.children = append(set.children, newString(tagOctetString, v))
+		}
+		list.children = append(list.children, newSequence(tagSequence, newString(tagOctetString, name), set))
+	}
+	return newSequence(opSearchEntry, newString(tagOctetString, e.DN), list)
+}
+
+// matchFilter проверяет запись по фильтру из запроса; сравнение без учета регистра
+func matchFilter(f *packet, attrs map[string][]string) bool {
+	values := func(name string) []string {
+		for k, v := range attrs {
+			if strings.EqualFold(k, name) {
+				return v
+			}
+		}
+		return nil
+	}
+
+	switch f.tag {
+	case filterAnd:
+		for _, c := range f.children {
+			if !matchFilter(c, attrs) {
+				return false
+			}
+		}
+		return true
+	case filterOr:
+		for _, c := range f.children {
+			if matchFilter(c, attrs) {
+				return true
+			}
+		}
+		return false
+	case filterNot:
+		return !matchFilter(f.child(0), attrs)
+	case filterEquality:
+		return containsFold(values(string(f.child(0).value)), string(f.child(1).value))
+	case filterPresent:
+		return len(values(string(f.value))) > 0
+	}
+	return false
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
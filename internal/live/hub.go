@@ -0,0 +1,297 @@
+// Package live реализует рассылку событий живых лекций подключенным клиентам.
+package live
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrSessionClosed возвращается при подписке на завершенную сессию
+var ErrSessionClosed = errors.New("live: session is closed")
+
+// clientBuffer - сколько событий может накопиться у медленного клиента
+const clientBuffer = 16
+
+// Event описывает одно событие сессии
+type Event struct {
+	ID   int64       `json:"id"`
+	Type string      `json:"type"`
+	Data interface{} `json:"data"`
+
+	// Retain сохраняет событие, чтобы отправить его новым и переподключившимся клиентам
+	Retain bool `json:"-"`
+	// Role ограничивает получателей ролью клиента, пустая строка - всем
+	Role string `json:"-"`
+}
+
+// Client - подписчик сессии (вкладка браузера студента или преподавателя)
+type Client struct {
+	UserID int
+	Role   string
+	Events chan Event
+}
+
+// Presence отправляется всем клиентам при входе и выходе участников
+type Presence struct {
+	Online int `json:"online"`
+	Users  int `json:"users"`
+}
+
+// Hub хранит активные сессии, у каждой сессии своя горутина рассылки
+type Hub struct {
+	mu       sync.Mutex
+	sessions map[int]*session
+}
+
+// leave - запрос на отключение, в last возвращается признак последнего подключения пользователя
+type leave struct {
+	client *Client
+	last   chan bool
+}
+
+type session struct {
+	register   chan *Client
+	unregister chan leave
+	broadcast  chan Event
+	stop       chan struct{}
+	done       chan struct{}
+
+	// поля ниже принадлежат горутине run
+	clients  map[*Client]struct{}
+	retained map[string]Event
+	order    []string
+	seq      int64
+	stale    bool // во время рассылки отключен медленный клиент
+
+	// users читается из других горутин, поэтому защищен мьютексом
+	mu    sync.Mutex
+	users map[int]int
+}
+
+// NewHub создает пустой хаб
+func NewHub() *Hub {
+	return &Hub{sessions: make(map[int]*session)}
+}
+
+// Open запускает горутину рассылки для сессии, если она еще не запущена.
+// Переданные события сохраняются как текущее состояние сессии.
+func (h *Hub) Open(sessionID int, initial ...Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.sessions[sessionID]; ok {
+		return
+	}
+
+	s := &session{
+		register:   make(chan *Client),
+		unregister: make(chan leave),
+		broadcast:  make(chan Event),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+		clients:    make(map[*Client]struct{}),
+		retained:   make(map[string]Event),
+		users:      make(map[int]int),
+	}
+	for _, ev := range initial {
+		ev.Retain = true
+		s.retain(ev)
+	}
+	h.sessions[sessionID] = s
+	go s.run()
+}
+
+// IsOpen сообщает, запущена ли сессия в хабе
+func (h *Hub) IsOpen(sessionID int) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, ok := h.sessions[sessionID]
+	return ok
+}
+
+// Close отправляет клиентам событие завершения и останавливает сессию
+func (h *Hub) Close(sessionID int) {
+	h.mu.Lock()
+	s, ok := h.sessions[sessionID]
+	delete(h.sessions, sessionID)
+	h.mu.Unlock()
+
+	if !ok {
+		return
+	}
+	close(s.stop)
+	<-s.done
+}
+
+// Subscribe подключает клиента к сессии. Клиент сразу получает
+// сохраненное состояние, поэтому переподключение не теряет текущий модуль.
+func (h *Hub) Subscribe(sessionID, userID int, role string) (*Client, error) {
+	s := h.get(sessionID)
+	if s == nil {
+		return nil, ErrSessionClosed
+	}
+
+	c := &Client{UserID: userID, Role: role, Events: make(chan Event, clientBuffer)}
+	select {
+	case s.register <- c:
+		return c, nil
+	case <-s.done:
+		return nil, ErrSessionClosed
+	}
+}
+
+// Unsubscribe отключает клиента. Возвращает true, если у пользователя
+// больше не осталось открытых подключений к сессии.
+func (h *Hub) Unsubscribe(sessionID int, c *Client) bool {
+	s := h.get(sessionID)
+	if s == nil {
+		return true
+	}
+
+	req := leave{client: c, last: make(chan bool, 1)}
+	select {
+	case s.unregister <- req:
+		return <-req.last
+	case <-s.done:
+		return true
+	}
+}
+
+// Broadcast рассылает событие всем клиентам сессии. Сессию он не открывает:
+// если она не запущена в хабе, событие отбрасывается с ErrSessionClosed.
+func (h *Hub) Broadcast(sessionID int, ev Event) error {
+	s := h.get(sessionID)
+	if s == nil {
+		return ErrSessionClosed
+	}
+
+	select {
+	case s.broadcast <- ev:
+		return nil
+	case <-s.done:
+		return ErrSessionClosed
+	}
+}
+
+// Online возвращает ID пользователей, подключенных к сессии
+func (h *Hub) Online(sessionID int) []int {
+	s := h.get(sessionID)
+	if s == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids := make([]int, 0, len(s.users))
+	for id := range s.users {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func (h *Hub) get(sessionID int) *session {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.sessions[sessionID]
+}
+
+func (s *session) run() {
+	defer close(s.done)
+
+	for {
+		select {
+		case c := <-s.register:
+			s.clients[c] = struct{}{}
+			s.mu.Lock()
+			s.users[c.UserID]++
+			s.mu.Unlock()
+			for _, typ := range s.order {
+				s.send(c, s.retained[typ])
+			}
+			s.publish(Event{Type: "presence", Data: s.presence()})
+
+		case req := <-s.unregister:
+			if _, ok := s.clients[req.client]; ok {
+				s.drop(req.client)
+				s.publish(Event{Type: "presence", Data: s.presence()})
+			}
+			req.last <- !s.hasUser(req.client.UserID)
+
+		case ev := <-s.broadcast:
+			s.publish(ev)
+
+		case <-s.stop:
+			s.publish(Event{Type: "end"})
+			for c := range s.clients {
+				s.drop(c)
+			}
+			return
+		}
+
+		// остальные участники должны узнать, что медленные клиенты отключены
+		for s.stale {
+			s.stale = false
+			s.publish(Event{Type: "presence", Data: s.presence()})
+		}
+	}
+}
+
+// publish нумерует событие и рассылает его всем клиентам
+func (s *session) publish(ev Event) {
+	s.seq++
+	ev.ID = s.seq
+	if ev.Retain {
+		s.retain(ev)
+	}
+	for c := range s.clients {
+		s.send(c, ev)
+	}
+}
+
+// send не блокирует рассылку: клиент с переполненным буфером отключается
+// и получит актуальное состояние при переподключении
+func (s *session) send(c *Client, ev Event) {
+	if ev.Role != "" && ev.Role != c.Role {
+		return
+	}
+	select {
+	case c.Events <- ev:
+	default:
+		s.drop(c)
+		s.stale = true
+	}
+}
+
+func (s *session) drop(c *Client) {
+	if _, ok := s.clients[c]; !ok {
+		return
+	}
+	delete(s.clients, c)
+	close(c.Events)
+
+	s.mu.Lock()
+	s.users[c.UserID]--
+	if s.users[c.UserID] <= 0 {
+		delete(s.users, c.UserID)
+	}
+	s.mu.Unlock()
+}
+
+func (s *session) retain(ev Event) {
+	if _, ok := s.retained[ev.Type]; !ok {
+		s.order = append(s.order, ev.Type)
+	}
+	s.retained[ev.Type] = ev
+}
+
+func (s *session) hasUser(userID int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.users[userID] > 0
+}
+
+func (s *session) presence() Presence {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return Presence{Online: len(s.clients), Users: len(s.users)}
+}
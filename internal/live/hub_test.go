@@ -0,0 +1,198 @@
+package live
+
+import (
+	"testing"
+	"time"
+)
+
+// next ждет следующее событие клиента
+func next(t *testing.T, c *Client) Event {
+	t.Helper()
+	select {
+	case ev, ok := <-c.Events:
+		if !ok {
+			t.Fatal("events channel closed")
+		}
+		return ev
+	case <-time.After(time.Second):
+		t.Fatal("no event within a second")
+	}
+	return Event{}
+}
+
+// until пропускает события до первого подходящего
+func until(t *testing.T, c *Client, match func(Event) bool) Event {
+	t.Helper()
+	for {
+		if ev := next(t, c); match(ev) {
+			return ev
+		}
+	}
+}
+
+func presenceIs(online, users int) func(Event) bool {
+	return func(ev Event) bool {
+		p, ok := ev.Data.(Presence)
+		return ev.Type == "presence" && ok && p.Online == online && p.Users == users
+	}
+}
+
+// closed ждет закрытия канала клиента, пропуская оставшиеся события
+func closed(t *testing.T, c *Client) {
+	t.Helper()
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case _, ok := <-c.Events:
+			if !ok {
+				return
+			}
+		case <-timeout:
+			t.Fatal("events channel was not closed")
+		}
+	}
+}
+
+func mustSubscribe(t *testing.T, h *Hub, sessionID, userID int, role string) *Client {
+	t.Helper()
+	c, err := h.Subscribe(sessionID, userID, role)
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	return c
+}
+
+func TestSubscribeReceivesRetainedState(t *testing.T) {
+	h := NewHub()
+	h.Open(1, Event{Type: "module", Data: 0})
+	defer h.Close(1)
+
+	c := mustSubscribe(t, h, 1, 10, "student")
+	if ev := next(t, c); ev.Type != "module" || ev.Data != 0 {
+		t.Fatalf("first event = %+v, want retained module 0", ev)
+	}
+	if ev := next(t, c); !presenceIs(1, 1)(ev) {
+		t.Fatalf("second event = %+v, want presence 1/1", ev)
+	}
+}
+
+func TestResubscribeGetsCurrentModule(t *testing.T) {
+	h := NewHub()
+	h.Open(1, Event{Type: "module", Data: 0})
+	defer h.Close(1)
+
+	c := mustSubscribe(t, h, 1, 10, "student")
+	until(t, c, presenceIs(1, 1))
+	if err := h.Broadcast(1, Event{Type: "module", Data: 2, Retain: true}); err != nil {
+		t.Fatalf("Broadcast: %v", err)
+	}
+	until(t, c, func(ev Event) bool { return ev.Type == "module" })
+
+	if last := h.Unsubscribe(1, c); !last {
+		t.Fatal("Unsubscribe of the only connection should report the last one")
+	}
+	closed(t, c)
+	if online := h.Online(1); len(online) != 0 {
+		t.Fatalf("Online after disconnect = %v, want none", online)
+	}
+
+	// переподключение получает модуль, показанный в отсутствие клиента
+	if err := h.Broadcast(1, Event{Type: "module", Data: 3, Retain: true}); err != nil {
+		t.Fatalf("Broadcast: %v", err)
+	}
+	c = mustSubscribe(t, h, 1, 10, "student")
+	if ev := next(t, c); ev.Type != "module" || ev.Data != 3 {
+		t.Fatalf("after reconnect got %+v, want module 3", ev)
+	}
+	until(t, c, presenceIs(1, 1))
+}
+
+func TestPresenceCountsConnectionsAndUsers(t *testing.T) {
+	h := NewHub()
+	h.Open(1)
+	defer h.Close(1)
+
+	teacher := mustSubscribe(t, h, 1, 1, "teacher")
+	tab1 := mustSubscribe(t, h, 1, 10, "student")
+	tab2 := mustSubscribe(t, h, 1, 10, "student")
+	until(t, teacher, presenceIs(3, 2))
+
+	if last := h.Unsubscribe(1, tab1); last {
+		t.Fatal("user still has another tab open")
+	}
+	until(t, teacher, presenceIs(2, 2))
+	if last := h.Unsubscribe(1, tab2); !last {
+		t.Fatal("closing the second tab should report the last connection")
+	}
+	until(t, teacher, presenceIs(1, 1))
+}
+
+func TestRoleEventsReachOnlyThatRole(t *testing.T) {
+	h := NewHub()
+	h.Open(1)
+	defer h.Close(1)
+
+	teacher := mustSubscribe(t, h, 1, 1, "teacher")
+	student := mustSubscribe(t, h, 1, 10, "student")
+	until(t, teacher, presenceIs(2, 2))
+	until(t, student, presenceIs(2, 2))
+
+	h.Broadcast(1, Event{Type: "answers", Role: "teacher"})
+	h.Broadcast(1, Event{Type: "tick"})
+	if ev := next(t, teacher); ev.Type != "answers" {
+		t.Fatalf("teacher got %+v, want answers", ev)
+	}
+	if ev := next(t, student); ev.Type != "tick" {
+		t.Fatalf("student got %+v, want tick without teacher-only answers", ev)
+	}
+}
+
+func TestSlowSubscriberIsDroppedAndPresenceRepublished(t *testing.T) {
+	h := NewHub()
+	h.Open(1)
+	defer h.Close(1)
+
+	teacher := mustSubscribe(t, h, 1, 1, "teacher")
+	slow := mustSubscribe(t, h, 1, 10, "student")
+	until(t, teacher, presenceIs(2, 2))
+
+	// slow не читает события: в буфере уже presence, последний tick его переполняет
+	for i := 0; i < clientBuffer; i++ {
+		if err := h.Broadcast(1, Event{Type: "tick", Data: i}); err != nil {
+			t.Fatalf("Broadcast: %v", err)
+		}
+		until(t, teacher, func(ev Event) bool { return ev.Type == "tick" })
+	}
+	until(t, teacher, presenceIs(1, 1))
+	closed(t, slow)
+	if online := h.Online(1); len(online) != 1 || online[0] != 1 {
+		t.Fatalf("Online = %v, want only the teacher", online)
+	}
+}
+
+func TestCloseEndsSession(t *testing.T) {
+	h := NewHub()
+	h.Open(1)
+
+	c := mustSubscribe(t, h, 1, 10, "student")
+	until(t, c, presenceIs(1, 1))
+	h.Close(1)
+
+	if ev := next(t, c); ev.Type != "end" {
+		t.Fatalf("got %+v, want end", ev)
+	}
+	closed(t, c)
+
+	if h.IsOpen(1) {
+		t.Fatal("session is still open")
+	}
+	if _, err := h.Subscribe(1, 10, "student"); err != ErrSessionClosed {
+		t.Fatalf("Subscribe after close: %v, want ErrSessionClosed", err)
+	}
+	if err := h.Broadcast(1, Event{Type: "tick"}); err != ErrSessionClosed {
+		t.Fatalf("Broadcast after close: %v, want ErrSessionClosed", err)
+	}
+	if last := h.Unsubscribe(1, c); !last {
+		t.Fatal("Unsubscribe after close should report the last connection")
+	}
+}
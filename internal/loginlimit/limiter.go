@@ -0,0 +1,230 @@
+// Package loginlimit ограничивает попытки входа: скользящие окна по IP-адресу
+// и по учетной записи, растущая задержка после неудач и временная блокировка.
+package loginlimit
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Причины отказа
+const (
+	ReasonIP      = "ip"      // слишком много попыток с адреса
+	ReasonBackoff = "backoff" // задержка после неудачной попытки
+	ReasonLocked  = "locked"  // учетная запись временно заблокирована
+)
+
+// Config - пределы. Нулевой предел отключает соответствующую проверку.
+type Config struct {
+	IPLimit         int // попыток с одного адреса за IPWindow, включая удачные
+	IPWindow        time.Duration
+	AccountLimit    int // неудачных попыток за AccountWindow до блокировки
+	AccountWindow   time.Duration
+	BackoffAfter    int // после стольких неудач задержка удваивается с каждой следующей
+	BackoffBase     time.Duration
+	BackoffMax      time.Duration
+	LockoutDuration time.Duration
+	TrustProxy      bool // брать адрес из X-Forwarded-For (сервер за прокси)
+}
+
+// DefaultConfig - 30 попыток с адреса и 10 неудач на учетную запись за 15 минут,
+// задержка с третьей неудачи от 1 секунды до минуты, блокировка на 30 минут
+func DefaultConfig() Config {
+	return Config{
+		IPLimit:         30,
+		IPWindow:        15 * time.Minute,
+		AccountLimit:    10,
+		AccountWindow:   15 * time.Minute,
+		BackoffAfter:    3,
+		BackoffBase:     time.Second,
+		BackoffMax:      time.Minute,
+		LockoutDuration: 30 * time.Minute,
+	}
+}
+
+// ConfigFromEnv дополняет DefaultConfig переменными LOGIN_IP_LIMIT, LOGIN_IP_WINDOW,
+// LOGIN_ACCOUNT_LIMIT, LOGIN_ACCOUNT_WINDOW, LOGIN_BACKOFF_AFTER, LOGIN_BACKOFF_BASE,
+// LOGIN_BACKOFF_MAX, LOGIN_LOCKOUT_DURATION и LOGIN_TRUST_PROXY.
+// Длительности записываются как "15m", "30s".
+func ConfigFromEnv() (Config, error) {
+	c := DefaultConfig()
+	ints := map[string]*int{
+		"LOGIN_IP_LIMIT":      &c.IPLimit,
+		"LOGIN_ACCOUNT_LIMIT": &c.AccountLimit,
+		"LOGIN_BACKOFF_AFTER": &c.BackoffAfter,
+	}
+	for name, dst := range ints {
+		if v := os.Getenv(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return c, fmt.Errorf("%s: expected a non-negative number, got %q", name, v)
+			}
+			*dst = n
+		}
+	}
+	durations := map[string]*time.Duration{
+		"LOGIN_IP_WINDOW":        &c.IPWindow,
+		"LOGIN_ACCOUNT_WINDOW":   &c.AccountWindow,
+		"LOGIN_BACKOFF_BASE":     &c.BackoffBase,
+		"LOGIN_BACKOFF_MAX":      &c.BackoffMax,
+		"LOGIN_LOCKOUT_DURATION": &c.LockoutDuration,
+	}
+	for name, dst := range durations {
+		if v := os.Getenv(name); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d < 0 {
+				return c, fmt.Errorf("%s: expected a duration like 15m, got %q", name, v)
+			}
+			*dst = d
+		}
+	}
+	c.TrustProxy = os.Getenv("LOGIN_TRUST_PROXY") == "true"
+	return c, nil
+}
+
+// Retention - сколько хранить попытки, чтобы хватило на оба окна
+func (c Config) Retention() time.Duration {
+	if c.IPWindow > c.AccountWindow {
+		return c.IPWindow
+	}
+	return c.AccountWindow
+}
+
+// Decision - результат проверки перед попыткой входа
+type Decision struct {
+	Allowed    bool
+	Reason     string
+	RetryAfter time.Duration
+}
+
+// Limiter применяет Config к попыткам, сохраненным в Store
+type Limiter struct {
+	cfg   Config
+	store Store
+	now   func() time.Time
+}
+
+// New создает ограничитель; store - NewMemoryStore или NewSQLStore
+func New(cfg Config, store Store) *Limiter {
+	return &Limiter{cfg: cfg, store: store, now: time.Now}
+}
+
+// ClientIP - адрес клиента. X-Forwarded-For учитывается только при TrustProxy,
+// иначе его подделкой можно обойти ограничение.
+func (l *Limiter) ClientIP(r *http.Request) string {
+	if l.cfg.TrustProxy {
+		if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+			return strings.TrimSpace(strings.Split(fwd, ",")[0])
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Check вызывается до проверки пароля и учитывает попытку с адреса ip.
+// account - ключ учетной записи, например "user:5".
+func (l *Limiter) Check(ip, account string) (Decision, error) {
+	now := l.now()
+
+	until, err := l.store.LockedUntil(account)
+	if err != nil {
+		return Decision{}, err
+	}
+	if now.Before(until) {
+		return Decision{Reason: ReasonLocked, RetryAfter: until.Sub(now)}, nil
+	}
+
+	if l.cfg.IPLimit > 0 {
+		w, err := l.store.Count("ip:"+ip, now.Add(-l.cfg.IPWindow))
+		if err != nil {
+			return Decision{}, err
+		}
+		if w.Count >= l.cfg.IPLimit {
+			return Decision{Reason: ReasonIP, RetryAfter: w.First.Add(l.cfg.IPWindow).Sub(now)}, nil
+		}
+	}
+
+	if l.cfg.BackoffAfter > 0 {
+		w, err := l.store.Count(account, now.Add(-l.cfg.AccountWindow))
+		if err != nil {
+			return Decision{}, err
+		}
+		if w.Count >= l.cfg.BackoffAfter {
+			if wait := w.Last.Add(l.backoff(w.Count)).Sub(now); wait > 0 {
+				return Decision{Reason: ReasonBackoff, RetryAfter: wait}, nil
+			}
+		}
+	}
+
+	if l.cfg.IPLimit > 0 {
+		if err := l.store.Add("ip:"+ip, now); err != nil {
+			return Decision{}, err
+		}
+	}
+	return Decision{Allowed: true}, nil
+}
+
+// backoff - задержка после failures неудач: BackoffBase, 2x, 4x, ... до BackoffMax
+func (l *Limiter) backoff(failures int) time.Duration {
+	d := l.cfg.BackoffBase
+	for i := l.cfg.BackoffAfter; i < failures && d < l.cfg.BackoffMax; i++ {
+		d *= 2
+	}
+	if l.cfg.BackoffMax > 0 && d > l.cfg.BackoffMax {
+		d = l.cfg.BackoffMax
+	}
+	return d
+}
+
+// Failure учитывает неудачную попытку. Если учетная запись только что
+// заблокирована, возвращает время окончания блокировки.
+func (l *Limiter) Failure(account string) (time.Time, error) {
+	now := l.now()
+	if err := l.store.Add(account, now); err != nil {
+		return time.Time{}, err
+	}
+	if l.cfg.AccountLimit == 0 {
+		return time.Time{}, nil
+	}
+	w, err := l.store.Count(account, now.Add(-l.cfg.AccountWindow))
+	if err != nil || w.Count < l.cfg.AccountLimit {
+		return time.Time{}, err
+	}
+
+	until := now.Add(l.cfg.LockoutDuration)
+	if err := l.store.Lock(account, until); err != nil {
+		return time.Time{}, err
+	}
+	// после блокировки счет начинается заново
+	return until, l.store.Reset(account)
+}
+
+// Success сбрасывает неудачи учетной записи после входа
+func (l *Limiter) Success(account string) error {
+	return l.store.Reset(account)
+}
+
+// Unlock снимает блокировку, например по просьбе администратора
+func (l *Limiter) Unlock(account string) error {
+	if err := l.store.Lock(account, time.Time{}); err != nil {
+		return err
+	}
+	return l.store.Reset(account)
+}
+
+// LockedUntil - окончание блокировки; нулевое время, если ее нет
+func (l *Limiter) LockedUntil(account string) (time.Time, error) {
+	until, err := l.store.LockedUntil(account)
+	if err != nil || !until.After(l.now()) {
+		return time.Time{}, err
+	}
+	return until, nil
+}
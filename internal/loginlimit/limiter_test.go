@@ -0,0 +1,165 @@
+package loginlimit
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"visualmath/internal/storage"
+)
+
+// clock - время ограничителя, которое тест двигает сам
+type clock struct{ t time.Time }
+
+func (c *clock) now() time.Time          { return c.t }
+func (c *clock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+// eachStore запускает тест для хранения в памяти и в SQLite
+func eachStore(t *testing.T, cfg Config, test func(t *testing.T, l *Limiter, c *clock)) {
+	stores := map[string]func(t *testing.T) Store{
+		"memory": func(t *testing.T) Store { return NewMemoryStore(cfg.Retention()) },
+		"sqlite": func(t *testing.T) Store {
+			db := storage.Open(filepath.Join(t.TempDir(), "visualmath.db"), nil)
+			t.Cleanup(func() { db.Close() })
+			return NewSQLStore(db, cfg.Retention())
+		},
+	}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			c := &clock{t: time.Date(2026, 10, 19, 10, 0, 0, 0, time.UTC)}
+			l := New(cfg, store(t))
+			l.now = c.now
+			test(t, l, c)
+		})
+	}
+}
+
+func check(t *testing.T, l *Limiter, account string, reason string, retry time.Duration) {
+	t.Helper()
+	d, err := l.Check("10.0.0.1", account)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Allowed != (reason == "") || d.Reason != reason || d.RetryAfter != retry {
+		t.Fatalf("Check = %+v, want reason %q, retry after %v", d, reason, retry)
+	}
+}
+
+func fail(t *testing.T, l *Limiter, account string) time.Time {
+	t.Helper()
+	until, err := l.Failure(account)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return until
+}
+
+func TestIPWindowSlides(t *testing.T) {
+	cfg := Config{IPLimit: 3, IPWindow: time.Minute}
+	eachStore(t, cfg, func(t *testing.T, l *Limiter, c *clock) {
+		// удачные попытки с адреса тоже считаются
+		for i := 0; i < 3; i++ {
+			check(t, l, "user:1", "", 0)
+			c.advance(10 * time.Second)
+		}
+		// первая попытка была 30 секунд назад, окно - минута
+		check(t, l, "user:2", ReasonIP, 30*time.Second)
+
+		c.advance(30 * time.Second)
+		check(t, l, "user:2", "", 0)
+		// сразу после нее окно снова заполнено попытками в 10:00:10, 10:00:20 и 10:01:00
+		check(t, l, "user:2", ReasonIP, 10*time.Second)
+	})
+}
+
+func TestBackoffGrows(t *testing.T) {
+	cfg := Config{AccountWindow: time.Hour, BackoffAfter: 2, BackoffBase: time.Second, BackoffMax: 4 * time.Second}
+	eachStore(t, cfg, func(t *testing.T, l *Limiter, c *clock) {
+		fail(t, l, "user:1")
+		check(t, l, "user:1", "", 0)
+
+		for _, wait := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second} {
+			fail(t, l, "user:1")
+			check(t, l, "user:1", ReasonBackoff, wait)
+			// задержка касается только этой учетной записи
+			check(t, l, "user:2", "", 0)
+			c.advance(wait)
+			check(t, l, "user:1", "", 0)
+		}
+
+		if err := l.Success("user:1"); err != nil {
+			t.Fatal(err)
+		}
+		fail(t, l, "user:1")
+		check(t, l, "user:1", "", 0)
+	})
+}
+
+func TestBackoffForgetsOldFailures(t *testing.T) {
+	cfg := Config{AccountWindow: time.Minute, BackoffAfter: 2, BackoffBase: time.Second, BackoffMax: time.Minute}
+	eachStore(t, cfg, func(t *testing.T, l *Limiter, c *clock) {
+		fail(t, l, "user:1")
+		c.advance(time.Minute + time.Second)
+		// первая неудача вышла из окна
+		fail(t, l, "user:1")
+		check(t, l, "user:1", "", 0)
+	})
+}
+
+func TestLockout(t *testing.T) {
+	cfg := Config{AccountLimit: 3, AccountWindow: time.Hour, LockoutDuration: 10 * time.Minute}
+	eachStore(t, cfg, func(t *testing.T, l *Limiter, c *clock) {
+		for i := 0; i < 2; i++ {
+			if until := fail(t, l, "user:1"); !until.IsZero() {
+				t.Fatalf("locked after %d failures", i+1)
+			}
+		}
+		start := c.now()
+		until := fail(t, l, "user:1")
+		if !until.Equal(start.Add(10 * time.Minute)) {
+			t.Fatalf("locked until %v, want %v", until, start.Add(10*time.Minute))
+		}
+		check(t, l, "user:1", ReasonLocked, 10*time.Minute)
+		if got, err := l.LockedUntil("user:1"); err != nil || !got.Equal(until) {
+			t.Errorf("LockedUntil = %v, %v", got, err)
+		}
+
+		c.advance(4 * time.Minute)
+		check(t, l, "user:1", ReasonLocked, 6*time.Minute)
+		c.advance(6 * time.Minute)
+		check(t, l, "user:1", "", 0)
+		if got, _ := l.LockedUntil("user:1"); !got.IsZero() {
+			t.Errorf("LockedUntil after expiry = %v", got)
+		}
+
+		// после блокировки счет неудач начался заново
+		for i := 0; i < 2; i++ {
+			if until := fail(t, l, "user:1"); !until.IsZero() {
+				t.Fatalf("locked again after %d failures", i+1)
+			}
+		}
+		if until := fail(t, l, "user:1"); until.IsZero() {
+			t.Fatal("not locked after 3 more failures")
+		}
+
+		// администратор снимает блокировку досрочно
+		if err := l.Unlock("user:1"); err != nil {
+			t.Fatal(err)
+		}
+		check(t, l, "user:1", "", 0)
+		if until := fail(t, l, "user:1"); !until.IsZero() {
+			t.Error("unlock kept old failures")
+		}
+	})
+}
+
+func TestZeroLimitsDisableChecks(t *testing.T) {
+	eachStore(t, Config{AccountWindow: time.Hour}, func(t *testing.T, l *Limiter, c *clock) {
+		for i := 0; i < 50; i++ {
+			check(t, l, "user:1", "", 0)
+			if until := fail(t, l, "user:1"); !until.IsZero() {
+				t.Fatal("locked with AccountLimit 0")
+			}
+		}
+	})
+}
@@ -0,0 +1,192 @@
+package loginlimit
+
+import (
+	"database/sql"
+	"sync"
+	"time"
+)
+
+// Window - попытки по ключу за окно
+type Window struct {
+	Count int
+	First time.Time // самая ранняя попытка в окне
+	Last  time.Time
+}
+
+// Store хранит попытки входа и блокировки. Ключи: "ip:адрес", "user:id",
+// "index:слепой индекс" для логина, которого нет в базе.
+type Store interface {
+	Add(key string, at time.Time) error
+	// Count считает попытки позже since: попытка ровно на границе окна уже вышла из него
+	Count(key string, since time.Time) (Window, error)
+	Reset(key string) error
+	// Lock блокирует ключ до until; нулевое время снимает блокировку
+	Lock(key string, until time.Time) error
+	LockedUntil(key string) (time.Time, error)
+}
+
+// MemoryStore - хранение в памяти процесса, сбрасывается при перезапуске
+type MemoryStore struct {
+	mu        sync.Mutex
+	retention time.Duration
+	attempts  map[string][]time.Time
+	locks     map[string]time.Time
+	adds      int
+}
+
+// NewMemoryStore - попытки старше retention удаляются
+func NewMemoryStore(retention time.Duration) *MemoryStore {
+	return &MemoryStore{
+		retention: retention,
+		attempts:  map[string][]time.Time{},
+		locks:     map[string]time.Time{},
+	}
+}
+
+func (s *MemoryStore) Add(key string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.attempts[key] = append(prune(s.attempts[key], at.Add(-s.retention)), at)
+
+	// время от времени убираем ключи, по которым давно не было попыток
+	s.adds++
+	if s.adds%1000 == 0 {
+		for k, list := range s.attempts {
+			if list = prune(list, at.Add(-s.retention)); len(list) == 0 {
+				delete(s.attempts, k)
+			} else {
+				s.attempts[k] = list
+			}
+		}
+		for k, until := range s.locks {
+			if !until.After(at) {
+				delete(s.locks, k)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) Count(key string, since time.Time) (Window, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var w Window
+	for _, at := range s.attempts[key] {
+		if !at.After(since) {
+			continue
+		}
+		if w.Count == 0 {
+			w.First = at
+		}
+		w.Count++
+		w.Last = at
+	}
+	return w, nil
+}
+
+func (s *MemoryStore) Reset(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.attempts, key)
+	return nil
+}
+
+func (s *MemoryStore) Lock(key string, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if until.IsZero() {
+		delete(s.locks, key)
+	} else {
+		s.locks[key] = until
+	}
+	return nil
+}
+
+func (s *MemoryStore) LockedUntil(key string) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.locks[key], nil
+}
+
+// prune отбрасывает попытки раньше since; список упорядочен по времени
+func prune(list []time.Time, since time.Time) []time.Time {
+	i := 0
+	for i < len(list) && list[i].Before(since) {
+		i++
+	}
+	return list[i:]
+}
+
+// SQLStore хранит попытки в таблицах login_attempts и login_lockouts,
+// чтобы ограничения переживали перезапуск сервера
+type SQLStore struct {
+	db        *sql.DB
+	retention time.Duration
+}
+
+// NewSQLStore - попытки старше retention удаляются
+func NewSQLStore(db *sql.DB, retention time.Duration) *SQLStore {
+	return &SQLStore{db: db, retention: retention}
+}
+
+func (s *SQLStore) Add(key string, at time.Time) error {
+	at = at.UTC()
+	if _, err := s.db.Exec(`DELETE FROM login_attempts WHERE key = $1 AND at < $2`,
+		key, at.Add(-s.retention)); err != nil {
+		return err
+	}
+	_, err := s.db.Exec(`INSERT INTO login_attempts (key, at) VALUES ($1, $2)`, key, at)
+	return err
+}
+
+func (s *SQLStore) Count(key string, since time.Time) (Window, error) {
+	var w Window
+	var first, last sql.NullString
+	err := s.db.QueryRow(`
+        SELECT COUNT(*), MIN(at), MAX(at) FROM login_attempts WHERE key = $1 AND at > $2
+    `, key, since.UTC()).Scan(&w.Count, &first, &last)
+	if err != nil {
+		return w, err
+	}
+	// агрегаты SQLite возвращают время строкой
+	w.First, w.Last = parseTime(first.String), parseTime(last.String)
+	return w, nil
+}
+
+func (s *SQLStore) Reset(key string) error {
+	_, err := s.db.Exec(`DELETE FROM login_attempts WHERE key = $1`, key)
+	return err
+}
+
+func (s *SQLStore) Lock(key string, until time.Time) error {
+	if until.IsZero() {
+		_, err := s.db.Exec(`DELETE FROM login_lockouts WHERE key = $1`, key)
+		return err
+	}
+	_, err := s.db.Exec(`
+        INSERT INTO login_lockouts (key, locked_until) VALUES ($1, $2)
+        ON CONFLICT (key) DO UPDATE SET locked_until = excluded.locked_until
+    `, key, until.UTC())
+	return err
+}
+
+func (s *SQLStore) LockedUntil(key string) (time.Time, error) {
+	var until time.Time
+	err := s.db.QueryRow(`SELECT locked_until FROM login_lockouts WHERE key = $1`, key).Scan(&until)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	return until, err
+}
+
+// parseTime разбирает время в формате, в котором go-sqlite3 сохраняет time.Time
+func parseTime(s string) time.Time {
+	for _, layout := range []string{"2006-01-02 15:04:05.999999999-07:00", time.RFC3339Nano} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,64 @@
+// Package mail отправляет служебные письма через SMTP
+package mail
+
+import (
+	"fmt"
+	"log"
+	"mime"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+)
+
+// Mailer - настройки SMTP. Если Host не задан, письма только пишутся в лог,
+// чтобы локально можно было взять ссылку из консоли.
+type Mailer struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+// FromEnv читает SMTP_HOST, SMTP_PORT, SMTP_USER, SMTP_PASSWORD и SMTP_FROM
+func FromEnv() *Mailer {
+	m := &Mailer{
+		Host:     os.Getenv("SMTP_HOST"),
+		Port:     os.Getenv("SMTP_PORT"),
+		Username: os.Getenv("SMTP_USER"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+		From:     os.Getenv("SMTP_FROM"),
+	}
+	if m.Port == "" {
+		m.Port = "587"
+	}
+	if m.From == "" {
+		m.From = m.Username
+	}
+	return m
+}
+
+// Send отправляет текстовое письмо
+func (m *Mailer) Send(to, subject, body string) error {
+	if m == nil || m.Host == "" {
+		log.Printf("📧 Письмо для %s: %s\n%s", to, subject, body)
+		return nil
+	}
+
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", m.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", to)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	msg.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+	return smtp.SendMail(m.Host+":"+m.Port, auth, m.From, []string{to}, []byte(msg.String()))
+}
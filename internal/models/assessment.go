@@ -0,0 +1,90 @@
+package models
+
+import "time"
+
+// TestAttempt - попытка прохождения модуля question или test
+type TestAttempt struct {
+	ID           int        `json:"id"`
+	StudentID    int        `json:"student_id"`
+	LectureID    int        `json:"lecture_id"`
+	ModuleID     int        `json:"module_id"`
+	Score        float64    `json:"score"`
+	MaxScore     float64    `json:"max_score"`
+	Percent      float64    `json:"percent"`
+	Mode         string     `json:"mode"`   // fixed, adaptive
+	Status       string     `json:"status"` // graded, pending_review
+	AssignmentID int        `json:"assignment_id,omitempty"`
+	LateDays     int        `json:"late_days,omitempty"`  // дней опоздания к сроку задания
+	Penalty      float64    `json:"penalty,omitempty"`    // доля балла, снятая за опоздание
+	Ability      *float64   `json:"ability,omitempty"`    // оценка способности по шкале IRT
+	AbilitySE    *float64   `json:"ability_se,omitempty"` // ее стандартная ошибка
+	StartedAt    time.Time  `json:"started_at"`
+	FinishedAt   *time.Time `json:"finished_at,omitempty"`
+}
+
+// QuestionResponse - ответ студента на один вопрос попытки
+type QuestionResponse struct {
+	SourceModuleID int    `json:"source_module_id"`
+	QuestionIndex  int    `json:"question_index"`
+	Answer         int    `json:"answer"` // -1 - вопрос пропущен
+	IsCorrect      bool   `json:"is_correct"`
+	Confidence     int    `json:"confidence,omitempty"` // уверенность студента 1-5, 0 - не указана
+	Text           string `json:"text,omitempty"`       // развернутый ответ в LaTeX/Markdown
+}
+
+// QuestionStats - психометрические показатели вопроса
+type QuestionStats struct {
+	SourceModuleID int       `json:"source_module_id"`
+	QuestionIndex  int       `json:"question_index"`
+	Question       Question  `json:"question"`
+	Responses      int       `json:"responses"`
+	Difficulty     float64   `json:"difficulty"`     // p-value: доля верных ответов
+	PointBiserial  float64   `json:"point_biserial"` // корреляция с баллом за остальные вопросы
+	UpperCorrect   float64   `json:"upper_correct"`  // доля верных в верхних 27% по баллу
+	LowerCorrect   float64   `json:"lower_correct"`  // доля верных в нижних 27%
+	AnswerCounts   []int     `json:"answer_counts"`  // сколько раз выбран каждый вариант
+	AnswerShares   []float64 `json:"answer_shares"`
+	Skipped        int       `json:"skipped"`
+	Flags          []string  `json:"flags"`
+
+	// Ответы в опросах на живых лекциях: в матрицу попыток не входят,
+	// потому что у опроса нет суммарного балла студента
+	PollResponses  int     `json:"poll_responses"`
+	PollDifficulty float64 `json:"poll_difficulty"` // доля верных ответов в опросах
+	PollCounts     []int   `json:"poll_counts"`
+}
+
+// TestAnalysis - анализ заданий модуля question или test
+type TestAnalysis struct {
+	ModuleID      int             `json:"module_id"`
+	Title         string          `json:"title"`
+	Students      int             `json:"students"`
+	MeanPercent   float64         `json:"mean_percent"`
+	CronbachAlpha *float64        `json:"cronbach_alpha"` // nil, если данных недостаточно
+	Questions     []QuestionStats `json:"questions"`
+}
+
+// ItemParameters - откалиброванные параметры вопроса в 2PL-модели
+type ItemParameters struct {
+	SourceModuleID int     `json:"source_module_id"`
+	QuestionIndex  int     `json:"question_index"`
+	Discrimination float64 `json:"discrimination"`
+	Difficulty     float64 `json:"difficulty"`
+	Responses      int     `json:"responses"`
+}
+
+// ReviewItem - вопрос в очереди повторения студента
+type ReviewItem struct {
+	ID             int        `json:"id"`
+	SourceModuleID int        `json:"source_module_id"`
+	QuestionIndex  int        `json:"question_index"`
+	ModuleTitle    string     `json:"module_title"`
+	Question       string     `json:"question"`
+	Answers        []string   `json:"answers"`
+	Easiness       float64    `json:"easiness"`
+	IntervalDays   int        `json:"interval_days"`
+	Repetitions    int        `json:"repetitions"`
+	Lapses         int        `json:"lapses"`
+	DueAt          time.Time  `json:"due_at"`
+	LastReviewedAt *time.Time `json:"last_reviewed_at,omitempty"`
+}
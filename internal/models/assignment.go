@@ -0,0 +1,31 @@
+package models
+
+import "time"
+
+// Assignment - домашнее задание: лекция или тест для выбранных групп со сроками сдачи
+type Assignment struct {
+	ID          int        `json:"id"`
+	Title       string     `json:"title"`
+	Description string     `json:"description"`
+	LectureID   int        `json:"lecture_id,omitempty"`
+	ModuleID    int        `json:"module_id,omitempty"` // модуль test
+	TargetTitle string     `json:"target_title"`        // название лекции или теста
+	Groups      []GroupRef `json:"groups"`
+	OpensAt     time.Time  `json:"opens_at"`
+	DueAt       time.Time  `json:"due_at"`
+	ClosesAt    *time.Time `json:"closes_at,omitempty"` // после этой даты работы не принимаются
+	LatePenalty float64    `json:"late_penalty"`        // процентов балла за каждый день опоздания
+	MaxAttempts int        `json:"max_attempts"`        // 0 - без ограничений
+	AuthorID    int        `json:"author_id"`
+	CreatedAt   time.Time  `json:"created_at"`
+	Archived    bool       `json:"archived"` // задание семестра, перенесенного в архив
+}
+
+// StudentAssignment - задание с состоянием для конкретного студента
+type StudentAssignment struct {
+	Assignment
+	Status       string   `json:"status"` // upcoming, open, overdue, pending_review, completed, late, missed
+	AttemptsUsed int      `json:"attempts_used"`
+	Score        *float64 `json:"score,omitempty"` // лучший балл с учетом штрафа
+	LateDays     int      `json:"late_days,omitempty"`
+}
@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// AttendanceRecord - строка отчета о посещаемости
+type AttendanceRecord struct {
+	UserID      int        `json:"user_id"`
+	FullName    string     `json:"full_name"`
+	Login       string     `json:"login"`
+	GroupID     int        `json:"group_id,omitempty"`
+	GroupNumber string     `json:"group_number"` // название группы
+	Present     bool       `json:"present"`
+	CheckedInAt *time.Time `json:"checked_in_at,omitempty"`
+}
@@ -0,0 +1,32 @@
+package models
+
+import "time"
+
+// Course - учебный курс (предмет) с преподавателями и лекциями по порядку
+type Course struct {
+	ID           int             `json:"id"`
+	Name         string          `json:"name"`
+	Description  string          `json:"description"`
+	Term         string          `json:"term"`
+	Archived     bool            `json:"archived"` // курс прошлого семестра, только для чтения
+	ArchivedAt   *time.Time      `json:"archived_at,omitempty"`
+	PreviousID   int             `json:"previous_course_id,omitempty"`
+	Teachers     []CourseTeacher `json:"teachers"`
+	Lectures     []CourseLecture `json:"lectures,omitempty"` // только в карточке курса
+	LectureCount int             `json:"lecture_count"`
+	ModuleCount  int             `json:"module_count"`
+}
+
+// CourseTeacher - преподаватель курса
+type CourseTeacher struct {
+	ID       int    `json:"id"`
+	FullName string `json:"full_name"`
+}
+
+// CourseLecture - лекция в программе курса
+type CourseLecture struct {
+	ID        int    `json:"id"`
+	Title     string `json:"title"`
+	Position  int    `json:"position"`
+	Published bool   `json:"published"`
+}
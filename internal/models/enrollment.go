@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+// CourseInvite - код приглашения на курс
+type CourseInvite struct {
+	ID               int        `json:"id"`
+	CourseID         int        `json:"course_id"`
+	Code             string     `json:"code"`
+	Link             string     `json:"link"`
+	Group            *GroupRef  `json:"group,omitempty"`      // записаться могут только студенты этой группы
+	ExpiresAt        *time.Time `json:"expires_at,omitempty"` // nil - бессрочный
+	MaxUses          int        `json:"max_uses"`             // 0 - без ограничений
+	Uses             int        `json:"uses"`
+	RequiresApproval bool       `json:"requires_approval"` // запись ждет подтверждения преподавателя
+	Revoked          bool       `json:"revoked"`
+	CreatedAt        time.Time  `json:"created_at"`
+}
+
+// Enrollment - запись студента на курс
+type Enrollment struct {
+	CourseID   int       `json:"course_id"`
+	CourseName string    `json:"course_name"`
+	StudentID  int       `json:"student_id"`
+	Login      string    `json:"login"`
+	FullName   string    `json:"full_name"`
+	GroupName  string    `json:"group_name"`
+	Status     string    `json:"status"` // pending, active
+	EnrolledAt time.Time `json:"enrolled_at"`
+}
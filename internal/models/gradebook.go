@@ -0,0 +1,41 @@
+package models
+
+import "time"
+
+// GradebookColumn - столбец журнала: лекция целиком или отдельный тест из нее
+type GradebookColumn struct {
+	Kind         string `json:"kind"` // lecture, test
+	LectureID    int    `json:"lecture_id"`
+	ModuleID     int    `json:"module_id,omitempty"`
+	Title        string `json:"title"`
+	PassingScore int    `json:"passing_score,omitempty"`
+	Adaptive     bool   `json:"adaptive,omitempty"`
+}
+
+// GradebookCell - результат студента по столбцу журнала
+type GradebookCell struct {
+	Score       *float64   `json:"score"`
+	Passed      *bool      `json:"passed,omitempty"` // только для тестов
+	Completed   bool       `json:"completed"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	Attempts    int        `json:"attempts"`
+	Ability     *float64   `json:"ability,omitempty"` // для адаптивных тестов
+	Pending     bool       `json:"pending,omitempty"` // есть попытка, ожидающая проверки
+	Late        bool       `json:"late,omitempty"`    // сдано после срока задания
+}
+
+// GradebookRow - строка журнала: студент и его результаты по столбцам
+type GradebookRow struct {
+	StudentID   int             `json:"student_id"`
+	FullName    string          `json:"full_name"`
+	Login       string          `json:"login"`
+	GroupID     int             `json:"group_id,omitempty"`
+	GroupNumber string          `json:"group_number"` // название группы
+	Cells       []GradebookCell `json:"cells"`
+}
+
+type Gradebook struct {
+	Policy  string            `json:"policy"` // best, last
+	Columns []GradebookColumn `json:"columns"`
+	Rows    []GradebookRow    `json:"rows"`
+}
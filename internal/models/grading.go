@@ -0,0 +1,44 @@
+package models
+
+import "time"
+
+// CriterionScore - баллы за критерий рубрики, Criterion - номер критерия
+type CriterionScore struct {
+	Criterion int     `json:"criterion"`
+	Points    float64 `json:"points"`
+	Comment   string  `json:"comment,omitempty"`
+}
+
+// InlineComment - комментарий к фрагменту ответа, символы [Start, End) текста
+type InlineComment struct {
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+	Text  string `json:"text"`
+}
+
+// FreeResponse - развернутый ответ студента и результат его проверки
+type FreeResponse struct {
+	ID             int               `json:"id"` // ID ответа в question_responses
+	AttemptID      int               `json:"attempt_id"`
+	StudentID      int               `json:"student_id"`
+	StudentName    string            `json:"student_name"`
+	GroupNumber    string            `json:"group_number"`
+	LectureID      int               `json:"lecture_id"`
+	ModuleID       int               `json:"module_id"`
+	ModuleTitle    string            `json:"module_title"`
+	SourceModuleID int               `json:"source_module_id"`
+	QuestionIndex  int               `json:"question_index"`
+	Question       string            `json:"question"`
+	Rubric         []RubricCriterion `json:"rubric,omitempty"`
+	Text           string            `json:"text"`
+	SubmittedAt    time.Time         `json:"submitted_at"`
+	Status         string            `json:"status"` // pending, graded, released
+	Points         float64           `json:"points"` // вес вопроса в попытке
+	Score          *float64          `json:"score"`
+	MaxScore       float64           `json:"max_score"`
+	Criteria       []CriterionScore  `json:"criteria"`
+	Comments       []InlineComment   `json:"comments"`
+	Feedback       string            `json:"feedback"`
+	GradedAt       *time.Time        `json:"graded_at,omitempty"`
+	ReleasedAt     *time.Time        `json:"released_at,omitempty"`
+}
@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+// Group - учебная группа
+type Group struct {
+	ID          int       `json:"id"`
+	Name        string    `json:"name"`
+	Faculty     string    `json:"faculty"`
+	Year        int       `json:"year"`                 // год набора, 0 - не указан
+	CuratorID   *int      `json:"curator_id,omitempty"` // преподаватель-куратор
+	CuratorName string    `json:"curator_name,omitempty"`
+	Students    int       `json:"students"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// GroupMember - студент группы
+type GroupMember struct {
+	UserID   int    `json:"user_id"`
+	Login    string `json:"login"`
+	FullName string `json:"full_name"`
+	Email    string `json:"email"`
+}
+
+// GroupRef - ссылка на группу
+type GroupRef struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
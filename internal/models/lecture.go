@@ -33,7 +33,6 @@ type LectureModule struct {
 type LectureRequest struct {
 	Title       string   `json:"title"`
 	CourseID    int      `json:"course_id"`
-	CourseName  string   `json:"course_name"`
 	Description string   `json:"description"`
 	ModuleIDs   []int    `json:"module_ids"` // ID модулей в порядке
 	Published   bool     `json:"published"`
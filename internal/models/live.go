@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+// LiveSession - живая лекция, которую ведет преподаватель
+type LiveSession struct {
+	ID              int        `json:"id"`
+	LectureID       int        `json:"lecture_id"`
+	TeacherID       int        `json:"teacher_id"`
+	JoinCode        string     `json:"join_code"`
+	CurrentModuleID int        `json:"current_module_id"`
+	StartedAt       time.Time  `json:"started_at"`
+	EndedAt         *time.Time `json:"ended_at,omitempty"`
+}
+
+// LiveParticipant - студент, подключавшийся к живой лекции
+type LiveParticipant struct {
+	UserID     int        `json:"user_id"`
+	FullName   string     `json:"full_name"`
+	JoinedAt   time.Time  `json:"joined_at"`
+	LeftAt     *time.Time `json:"left_at,omitempty"`
+	Reconnects int        `json:"reconnects"`
+	Online     bool       `json:"online"`
+}
@@ -12,10 +12,12 @@ type Module struct {
     CourseName string    `json:"course_name"`
     AuthorID   int       `json:"author_id"`
     AuthorName string    `json:"author_name"`
+    Description string   `json:"description"`
     ModuleType string    `json:"module_type"` // text, visual, question, test
     Content    json.RawMessage `json:"content"`
     CreatedAt  time.Time `json:"created_at"`
     Published  bool      `json:"published" db:"published"`
+    Skills     []string  `json:"skills"`
 }
 
 type TextModuleContent struct {
@@ -24,9 +26,19 @@ type TextModuleContent struct {
 }
 
 type Question struct {
-    Question string   `json:"question"`
-    Answers  []string `json:"answers"`
-    Correct  int      `json:"correct"`
+    Question    string   `json:"question"`
+    Answers     []string `json:"answers"`
+    Correct     int      `json:"correct"`
+    Explanation string   `json:"explanation,omitempty"`
+    Skills      []string `json:"skills,omitempty"` // если не указаны, берутся навыки модуля
+    Type        string   `json:"type,omitempty"`   // choice (по умолчанию), free - развернутый ответ
+    Rubric      []RubricCriterion `json:"rubric,omitempty"` // критерии проверки развернутого ответа
+}
+
+// RubricCriterion - критерий проверки развернутого ответа
+type RubricCriterion struct {
+    Title  string  `json:"title"`
+    Points float64 `json:"points"`
 }
 
 type TestConfig struct {
@@ -35,4 +47,15 @@ type TestConfig struct {
     PassingScore   int  `json:"passing_score"`
     ShuffleQuestions bool `json:"shuffle_questions"`
     ShowResults     bool `json:"show_results"`
-}
\ No newline at end of file
+    ShuffleAnswers  bool `json:"shuffle_answers"`
+    AllowRetake     bool `json:"allow_retake"`
+    Questions       []TestQuestion `json:"questions"`
+    Adaptive        bool    `json:"adaptive"` // подбор вопросов по уровню студента (2PL IRT)
+    StopSE          float64 `json:"stop_se"`  // адаптивный тест заканчивается, когда ошибка оценки ниже порога
+}
+
+// TestQuestion - ссылка теста на модуль-вопросник и вес его вопросов
+type TestQuestion struct {
+    ID     int     `json:"id"` // ID модуля типа question
+    Points float64 `json:"points"`
+}
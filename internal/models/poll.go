@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+// Poll - вопрос из модуля типа question, запущенный во время живой лекции
+type Poll struct {
+	ID            int        `json:"id"`
+	SessionID     int        `json:"session_id"`
+	LectureID     int        `json:"lecture_id"`
+	ModuleID      int        `json:"module_id"`
+	QuestionIndex int        `json:"question_index"`
+	Question      Question   `json:"question"`
+	OpenedAt      time.Time  `json:"opened_at"`
+	ClosedAt      *time.Time `json:"closed_at,omitempty"`
+	Revealed      bool       `json:"revealed"`
+}
+
+// PollResults - распределение ответов по вариантам
+type PollResults struct {
+	PollID  int   `json:"poll_id"`
+	Counts  []int `json:"counts"`
+	Total   int   `json:"total"`
+	Correct int   `json:"correct"`
+}
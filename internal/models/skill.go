@@ -0,0 +1,50 @@
+package models
+
+import (
+	"time"
+
+	"visualmath/internal/bkt"
+)
+
+// Skill - навык, которым помечаются модули и вопросы, с параметрами BKT
+type Skill struct {
+	ID      int    `json:"id"`
+	Name    string `json:"name"`
+	Modules int    `json:"modules"` // число модулей с этим навыком
+	bkt.Params
+}
+
+// ModuleLink - ссылка на модуль, где разбирается навык
+type ModuleLink struct {
+	ID    int    `json:"id"`
+	Title string `json:"title"`
+}
+
+// SkillMastery - владение навыком студентом
+type SkillMastery struct {
+	SkillID   int          `json:"skill_id"`
+	Name      string       `json:"name"`
+	PKnown    float64      `json:"p_known"`
+	Attempts  int          `json:"attempts"`
+	Correct   int          `json:"correct"`
+	Mastered  bool         `json:"mastered"`
+	UpdatedAt time.Time    `json:"updated_at"`
+	Modules   []ModuleLink `json:"modules"`
+}
+
+// SkillHeatmapRow - студент и вероятности владения навыками в порядке SkillHeatmap.Skills.
+// nil - студент еще не отвечал на вопросы этого навыка.
+type SkillHeatmapRow struct {
+	StudentID   int        `json:"student_id"`
+	FullName    string     `json:"full_name"`
+	Login       string     `json:"login"`
+	GroupID     int        `json:"group_id,omitempty"`
+	GroupNumber string     `json:"group_number"` // название группы
+	Mastery     []*float64 `json:"mastery"`
+}
+
+// SkillHeatmap - владение навыками студентами группы
+type SkillHeatmap struct {
+	Skills []Skill           `json:"skills"`
+	Rows   []SkillHeatmapRow `json:"rows"`
+}
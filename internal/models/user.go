@@ -1,15 +1,119 @@
 package models
 
-import "time"
+import (
+    "encoding/json"
+    "time"
+)
 
 type User struct {
-    ID            int       `json:"id"`
-    Login         string    `json:"login"`
-    PasswordHash  string    `json:"-"`
-    FullName      string    `json:"full_name"`
-    UserType      string    `json:"user_type"`
-    GroupNumber   string    `json:"group_number"`
-    Email         string    `json:"email"`
-    EmailVerified bool      `json:"email_verified"`
-    CreatedAt     time.Time `json:"created_at"`
-}
\ No newline at end of file
+    ID            int        `json:"id"`
+    Login         string     `json:"login"`
+    PasswordHash  string     `json:"-"`
+    FullName      string     `json:"full_name"`
+    UserType      string     `json:"user_type"`
+    GroupID       int        `json:"group_id,omitempty"`
+    GroupNumber   string     `json:"group_number"` // название группы
+    Email         string     `json:"email"`
+    EmailVerified bool       `json:"email_verified"`
+    Active        bool       `json:"active"` // отключенные учетные записи не могут войти
+    DeactivatedAt *time.Time `json:"deactivated_at,omitempty"`
+    AuthSource    string     `json:"auth_source"` // local или ldap - пароль хранится в каталоге
+    TOTPEnabled   bool       `json:"two_factor_enabled"`
+    CreatedAt     time.Time  `json:"created_at"`
+}
+// ImportRow - строка списка студентов при импорте из CSV
+type ImportRow struct {
+    Row      int      `json:"row"` // номер строки в файле, считая заголовок
+    FullName string   `json:"full_name"`
+    Group    string   `json:"group"`
+    Email    string   `json:"email"`
+    Login    string   `json:"login"`
+    NewGroup bool     `json:"new_group,omitempty"` // группа будет создана
+    Errors   []string `json:"errors,omitempty"`
+}
+
+// AuditEntry - запись журнала действий администраторов
+type AuditEntry struct {
+    ID           int             `json:"id"`
+    ActorID      int             `json:"actor_id"`
+    ActorName    string          `json:"actor_name"`
+    Action       string          `json:"action"` // user.type, user.deactivate, user.merge, ...
+    TargetUserID int             `json:"target_user_id,omitempty"`
+    TargetName   string          `json:"target_name,omitempty"` // пусто, если пользователь удален
+    Details      json.RawMessage `json:"details"`
+    CreatedAt    time.Time       `json:"created_at"`
+}
+
+// PermissionScope - курс и/или группа, которыми ограничены права пользователя
+type PermissionScope struct {
+    ID         int       `json:"id"`
+    CourseID   int       `json:"course_id,omitempty"` // 0 - любой курс
+    CourseName string    `json:"course_name,omitempty"`
+    GroupID    int       `json:"group_id,omitempty"` // 0 - любая группа
+    GroupName  string    `json:"group_name,omitempty"`
+    CreatedAt  time.Time `json:"created_at"`
+}
+
+// APIToken - персональный токен API. Сам токен показывается один раз при создании.
+type APIToken struct {
+    ID         int        `json:"id"`
+    Name       string     `json:"name"`
+    Prefix     string     `json:"prefix"` // начало токена, чтобы узнать его в списке
+    Scopes     []string   `json:"scopes"`
+    ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+    LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+    RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+    CreatedAt  time.Time  `json:"created_at"`
+}
+
+// LoginEvent - блокировка входа после неудачных попыток или ее снятие
+type LoginEvent struct {
+    ID          int        `json:"id"`
+    UserID      int        `json:"user_id,omitempty"` // 0 - такого логина нет
+    Login       string     `json:"login,omitempty"`
+    LoginIndex  string     `json:"login_index,omitempty"` // слепой индекс несуществующего логина
+    IP          string     `json:"ip,omitempty"`
+    Event       string     `json:"event"` // lockout, unlock
+    LockedUntil *time.Time `json:"locked_until,omitempty"`
+    CreatedAt   time.Time  `json:"created_at"`
+}
+
+// Profile - своя учетная запись в личном кабинете
+type Profile struct {
+    User
+    PendingEmail   string          `json:"pending_email,omitempty"` // новый адрес ждет подтверждения по ссылке
+    AvatarURL      string          `json:"avatar_url,omitempty"`
+    OAuthProviders []OAuthProvider `json:"oauth_providers"`
+}
+
+// OAuthProvider - подключенный вход через VK или Google
+type OAuthProvider struct {
+    Provider    string    `json:"provider"` // vk, google
+    Email       string    `json:"email,omitempty"`
+    ConnectedAt time.Time `json:"connected_at"`
+}
+
+// Session - активная сессия браузера
+type Session struct {
+    ID         int       `json:"id"`
+    UserAgent  string    `json:"user_agent"`
+    IP         string    `json:"ip"`
+    Current    bool      `json:"current"` // сессия, из которой сделан запрос
+    LastSeenAt time.Time `json:"last_seen_at"`
+    ExpiresAt  time.Time `json:"expires_at"`
+    CreatedAt  time.Time `json:"created_at"`
+}
+
+// DeletionRequest - запрос пользователя на удаление персональных данных
+type DeletionRequest struct {
+    ID        int        `json:"id"`
+    UserID    int        `json:"user_id"`
+    Login     string     `json:"login,omitempty"`
+    FullName  string     `json:"full_name,omitempty"`
+    Reason    string     `json:"reason"`
+    Status    string     `json:"status"` // pending, approved, rejected, cancelled
+    DecidedBy int        `json:"decided_by,omitempty"`
+    DecidedAt *time.Time `json:"decided_at,omitempty"`
+    Comment   string     `json:"comment,omitempty"` // причина отказа
+    CreatedAt time.Time  `json:"created_at"`
+}
@@ -0,0 +1,139 @@
+package pii
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Key - ключ с идентификатором, который записывается в зашифрованное значение
+type Key struct {
+	ID     string
+	Secret []byte
+}
+
+func (k Key) validate() error {
+	if k.ID == "" || strings.ContainsAny(k.ID, ": \t") {
+		return fmt.Errorf("pii: bad key id %q", k.ID)
+	}
+	if len(k.Secret) != KeySize {
+		return fmt.Errorf("pii: key %q must be %d bytes, got %d", k.ID, KeySize, len(k.Secret))
+	}
+	return nil
+}
+
+// GenerateKey возвращает новый случайный ключ в base64 для PII_KEYS или файла ключей
+func GenerateKey() (string, error) {
+	b := make([]byte, KeySize)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
+
+// FromEnv читает ключи из PII_KEY_FILE или из PII_KEYS и PII_INDEX_KEY.
+// PII_KEYS - пары "id:base64" через запятую, первая - текущий ключ, например
+// "2026b:...,2026a:..."; PII_INDEX_KEY - одна пара "id:base64".
+// Файл ключей - строки "data <id> <base64>" и "index <id> <base64>",
+// пустые строки и строки с # пропускаются; первая строка data - текущий ключ.
+// Если ключи не заданы, возвращает nil - данные хранятся открытым текстом.
+func FromEnv() (*Keyring, error) {
+	file := os.Getenv("PII_KEY_FILE")
+	if file != "" {
+		if os.Getenv("PII_KEYS") != "" || os.Getenv("PII_INDEX_KEY") != "" {
+			return nil, errors.New("PII_KEY_FILE and PII_KEYS/PII_INDEX_KEY are mutually exclusive")
+		}
+		return LoadFile(file)
+	}
+
+	list, index := os.Getenv("PII_KEYS"), os.Getenv("PII_INDEX_KEY")
+	if list == "" && index == "" {
+		return nil, nil
+	}
+	if list == "" || index == "" {
+		return nil, errors.New("PII_KEYS and PII_INDEX_KEY must be set together")
+	}
+	var keys []Key
+	for _, pair := range strings.Split(list, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, err := parsePair(pair)
+		if err != nil {
+			return nil, fmt.Errorf("PII_KEYS: %v", err)
+		}
+		keys = append(keys, key)
+	}
+	indexKey, err := parsePair(strings.TrimSpace(index))
+	if err != nil {
+		return nil, fmt.Errorf("PII_INDEX_KEY: %v", err)
+	}
+	return New(keys, indexKey)
+}
+
+// LoadFile читает ключи из файла (формат описан у FromEnv)
+func LoadFile(path string) (*Keyring, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var keys []Key
+	var index *Key
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("%s:%d: expected \"data|index <id> <base64>\"", path, n)
+		}
+		key, err := decodeKey(fields[1], fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, n, err)
+		}
+		switch fields[0] {
+		case "data":
+			keys = append(keys, key)
+		case "index":
+			if index != nil {
+				return nil, fmt.Errorf("%s:%d: only one index key is allowed", path, n)
+			}
+			index = &key
+		default:
+			return nil, fmt.Errorf("%s:%d: unknown key kind %q", path, n, fields[0])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 || index == nil {
+		return nil, fmt.Errorf("%s: both data and index keys are required", path)
+	}
+	return New(keys, *index)
+}
+
+func parsePair(pair string) (Key, error) {
+	id, secret, ok := strings.Cut(pair, ":")
+	if !ok {
+		return Key{}, errors.New("bad key, expected id:base64")
+	}
+	return decodeKey(id, secret)
+}
+
+func decodeKey(id, secret string) (Key, error) {
+	b, err := base64.StdEncoding.DecodeString(secret)
+	if err != nil {
+		return Key{}, fmt.Errorf("key %q is not valid base64", id)
+	}
+	key := Key{ID: id, Secret: b}
+	return key, key.validate()
+}
@@ -0,0 +1,160 @@
+// Package pii шифрует персональные данные пользователей (ФИО, логин, почту)
+// перед записью в базу: AES-256-GCM со случайным nonce для значений и HMAC-SHA256
+// для слепых индексов, по которым ищутся логин и почта.
+//
+// Зашифрованное значение хранится как "pii:<ключ>:<base64(nonce|шифротекст)>",
+// поэтому при смене ключа старые записи расшифровываются прежним ключом,
+// пока миграция не перешифрует их текущим.
+package pii
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const prefix = "pii:"
+
+// KeySize - длина ключей шифрования и индекса, байт
+const KeySize = 32
+
+// ErrUnknownKey - значение зашифровано ключом, которого нет в настройках
+var ErrUnknownKey = errors.New("pii: value is encrypted with an unknown key")
+
+// Keyring - ключи шифрования и ключ слепого индекса. Первый ключ шифрования
+// текущий, остальные нужны только для чтения старых записей. Пустой Keyring
+// (nil) хранит данные открытым текстом, как до появления шифрования.
+type Keyring struct {
+	current  string
+	keys     map[string]cipher.AEAD
+	indexID  string
+	indexKey []byte
+}
+
+// New собирает Keyring из ключей шифрования (первый - текущий) и ключа индекса
+func New(keys []Key, index Key) (*Keyring, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("pii: no encryption keys")
+	}
+	if err := index.validate(); err != nil {
+		return nil, fmt.Errorf("index key: %v", err)
+	}
+	k := &Keyring{
+		current:  keys[0].ID,
+		keys:     map[string]cipher.AEAD{},
+		indexID:  index.ID,
+		indexKey: index.Secret,
+	}
+	for _, key := range keys {
+		if err := key.validate(); err != nil {
+			return nil, err
+		}
+		if _, ok := k.keys[key.ID]; ok {
+			return nil, fmt.Errorf("pii: duplicate key id %q", key.ID)
+		}
+		block, err := aes.NewCipher(key.Secret)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		k.keys[key.ID] = aead
+	}
+	return k, nil
+}
+
+// Enabled - заданы ли ключи
+func (k *Keyring) Enabled() bool {
+	return k != nil
+}
+
+// CurrentKey - идентификатор ключа, которым шифруются новые значения
+func (k *Keyring) CurrentKey() string {
+	if k == nil {
+		return ""
+	}
+	return k.current
+}
+
+// Encrypt шифрует значение текущим ключом. Без ключей возвращает его как есть.
+func (k *Keyring) Encrypt(plain string) (string, error) {
+	if k == nil {
+		return plain, nil
+	}
+	aead := k.keys[k.current]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(plain), nil)
+	return prefix + k.current + ":" + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt расшифровывает значение. Значения без префикса "pii:" записаны до
+// включения шифрования и возвращаются как есть.
+func (k *Keyring) Decrypt(value string) (string, error) {
+	id, data, ok := split(value)
+	if !ok {
+		return value, nil
+	}
+	if k == nil {
+		return "", ErrUnknownKey
+	}
+	aead, found := k.keys[id]
+	if !found {
+		return "", fmt.Errorf("%w %q", ErrUnknownKey, id)
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(data)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", errors.New("pii: malformed value")
+	}
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return "", fmt.Errorf("pii: cannot decrypt value with key %q", id)
+	}
+	return string(plain), nil
+}
+
+// Stale - значение нужно перешифровать: оно записано открытым текстом или не
+// текущим ключом. Без ключей устаревшими считаются зашифрованные значения.
+func (k *Keyring) Stale(value string) bool {
+	id, _, ok := split(value)
+	if k == nil {
+		return ok
+	}
+	return !ok || id != k.current
+}
+
+// Index - слепой индекс для поиска по равенству. Регистр и пробелы по краям
+// не учитываются, так что "Ivanov@Uni.ru " и "ivanov@uni.ru" совпадают.
+// Без ключей индекс - само нормализованное значение.
+func (k *Keyring) Index(value string) string {
+	value = Normalize(value)
+	if k == nil {
+		return "plain:" + value
+	}
+	mac := hmac.New(sha256.New, k.indexKey)
+	mac.Write([]byte(value))
+	return k.indexID + ":" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Normalize приводит логин или адрес к виду, по которому строится индекс
+func Normalize(value string) string {
+	return strings.ToLower(strings.TrimSpace(value))
+}
+
+func split(value string) (id, data string, ok bool) {
+	if !strings.HasPrefix(value, prefix) {
+		return "", "", false
+	}
+	return strings.Cut(value[len(prefix):], ":")
+}
@@ -0,0 +1,168 @@
+package pii
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func key(id string, b byte) Key {
+	return Key{ID: id, Secret: bytes.Repeat([]byte{b}, KeySize)}
+}
+
+func keyring(t *testing.T, index Key, keys ...Key) *Keyring {
+	t.Helper()
+	k, err := New(keys, index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func TestRoundTrip(t *testing.T) {
+	k := keyring(t, key("i1", 9), key("k1", 1))
+	for _, plain := range []string{"Иванов Иван Иванович", "ivanov@uni.ru", ""} {
+		a, err := k.Encrypt(plain)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := k.Encrypt(plain)
+		if !strings.HasPrefix(a, "pii:k1:") || (plain != "" && strings.Contains(a, plain)) {
+			t.Errorf("Encrypt(%q) = %q", plain, a)
+		}
+		// nonce случайный: одно значение шифруется по-разному
+		if a == b {
+			t.Errorf("Encrypt(%q) returned the same ciphertext twice", plain)
+		}
+		if got, err := k.Decrypt(a); err != nil || got != plain {
+			t.Errorf("Decrypt(Encrypt(%q)) = %q, %v", plain, got, err)
+		}
+	}
+
+	// записи до включения шифрования читаются как есть
+	if got, err := k.Decrypt("ivanov"); err != nil || got != "ivanov" {
+		t.Errorf("Decrypt(plain) = %q, %v", got, err)
+	}
+
+	var none *Keyring
+	if got, _ := none.Encrypt("ivanov"); got != "ivanov" {
+		t.Errorf("nil keyring Encrypt = %q", got)
+	}
+	sealed, _ := k.Encrypt("ivanov")
+	if _, err := none.Decrypt(sealed); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("nil keyring Decrypt of encrypted value: %v, want ErrUnknownKey", err)
+	}
+}
+
+func TestDecryptRejectsDamagedValue(t *testing.T) {
+	k := keyring(t, key("i1", 9), key("k1", 1))
+	sealed, _ := k.Encrypt("ivanov")
+	damaged := sealed[:len(sealed)-2] + "AA"
+	if damaged == sealed {
+		damaged = sealed[:len(sealed)-2] + "BB"
+	}
+	for _, value := range []string{damaged, "pii:k1:", "pii:k1:!!!"} {
+		if _, err := k.Decrypt(value); err == nil {
+			t.Errorf("Decrypt(%q) succeeded", value)
+		}
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	old := keyring(t, key("i1", 9), key("k1", 1))
+	sealed, _ := old.Encrypt("ivanov")
+
+	// новый ключ первый, прежний остается для чтения
+	rotated := keyring(t, key("i1", 9), key("k2", 2), key("k1", 1))
+	if got, err := rotated.Decrypt(sealed); err != nil || got != "ivanov" {
+		t.Fatalf("Decrypt with previous key = %q, %v", got, err)
+	}
+	if !rotated.Stale(sealed) {
+		t.Error("value encrypted with previous key is not stale")
+	}
+	resealed, _ := rotated.Encrypt("ivanov")
+	if !strings.HasPrefix(resealed, "pii:k2:") || rotated.Stale(resealed) {
+		t.Errorf("re-encrypted value %q", resealed)
+	}
+
+	// прежний ключ убран из настроек раньше перешифровки
+	dropped := keyring(t, key("i1", 9), key("k2", 2))
+	if _, err := dropped.Decrypt(sealed); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Decrypt without the key: %v, want ErrUnknownKey", err)
+	}
+}
+
+func TestStale(t *testing.T) {
+	k := keyring(t, key("i1", 9), key("k2", 2), key("k1", 1))
+	current, _ := k.Encrypt("ivanov")
+	previous, _ := keyring(t, key("i1", 9), key("k1", 1)).Encrypt("ivanov")
+	var none *Keyring
+
+	cases := []struct {
+		name  string
+		k     *Keyring
+		value string
+		want  bool
+	}{
+		{"current key", k, current, false},
+		{"previous key", k, previous, true},
+		{"plain text", k, "ivanov", true},
+		// без ключей перешифровать нужно только зашифрованное
+		{"no keys, plain text", none, "ivanov", false},
+		{"no keys, encrypted", none, current, true},
+	}
+	for _, tc := range cases {
+		if got := tc.k.Stale(tc.value); got != tc.want {
+			t.Errorf("%s: Stale = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestIndex(t *testing.T) {
+	k := keyring(t, key("i1", 9), key("k1", 1))
+	a := k.Index("ivanov@uni.ru")
+	if !strings.HasPrefix(a, "i1:") || strings.Contains(a, "ivanov") {
+		t.Errorf("Index = %q", a)
+	}
+	for _, variant := range []string{"Ivanov@Uni.RU", "  ivanov@uni.ru\t", "IVANOV@UNI.RU "} {
+		if got := k.Index(variant); got != a {
+			t.Errorf("Index(%q) = %q, want %q", variant, got, a)
+		}
+	}
+	if k.Index("petrov@uni.ru") == a {
+		t.Error("different values share an index")
+	}
+	// индекс не зависит от ключей шифрования, только от ключа индекса
+	if got := keyring(t, key("i1", 9), key("k2", 2)).Index("ivanov@uni.ru"); got != a {
+		t.Errorf("index changed with the encryption key: %q", got)
+	}
+	if got := keyring(t, key("i2", 8), key("k1", 1)).Index("ivanov@uni.ru"); got == a || !strings.HasPrefix(got, "i2:") {
+		t.Errorf("index with another index key = %q", got)
+	}
+
+	var none *Keyring
+	if got := none.Index(" Ivanov@Uni.ru "); got != "plain:ivanov@uni.ru" {
+		t.Errorf("nil keyring Index = %q", got)
+	}
+}
+
+func TestNewRejectsBadKeys(t *testing.T) {
+	cases := []struct {
+		name  string
+		keys  []Key
+		index Key
+	}{
+		{"no keys", nil, key("i1", 9)},
+		{"short secret", []Key{{ID: "k1", Secret: []byte("short")}}, key("i1", 9)},
+		{"empty id", []Key{key("", 1)}, key("i1", 9)},
+		{"colon in id", []Key{key("k:1", 1)}, key("i1", 9)},
+		{"duplicate id", []Key{key("k1", 1), key("k1", 2)}, key("i1", 9)},
+		{"bad index key", []Key{key("k1", 1)}, Key{ID: "i1"}},
+	}
+	for _, tc := range cases {
+		if _, err := New(tc.keys, tc.index); err == nil {
+			t.Errorf("%s: New succeeded", tc.name)
+		}
+	}
+}
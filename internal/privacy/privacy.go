@@ -0,0 +1,93 @@
+// Package privacy обезличивает учетные записи (152-ФЗ): по одобренному
+// администратором запросу пользователя и по истечении срока хранения
+// неактивных учетных записей.
+package privacy
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// DeletedName - ФИО обезличенного пользователя в журналах и ведомостях
+const DeletedName = "Удаленный пользователь"
+
+// credentialTables - учетные данные и следы входа; удаляются полностью
+var credentialTables = []string{
+	"sessions",
+	"api_tokens",
+	"password_tokens",
+	"recovery_codes",
+	"user_avatars",
+	"oauth_connections",
+	"login_events",
+}
+
+// Anonymize заменяет ФИО, логин и почту пользователя заглушками и удаляет его
+// учетные данные. Попытки, баллы, посещаемость и владение навыками остаются,
+// чтобы не менялась статистика курсов и групп; тексты развернутых ответов
+// и отзывы на них стираются, в них могут быть персональные данные. Из журналов
+// блокировок, ограничения попыток и объединения учетных записей убираются
+// записи и поля, по которым можно узнать прежние логин и почту.
+func Anonymize(tx *sql.Tx, userID int, now time.Time) error {
+	// прежние слепые индексы нужны, чтобы найти записи по логину до его замены
+	var loginIndex sql.NullString
+	err := tx.QueryRow(`SELECT login_index FROM users WHERE id = $1 AND anonymized_at IS NULL`,
+		userID).Scan(&loginIndex)
+	if err != nil {
+		return err
+	}
+
+	res, err := tx.Exec(`
+        UPDATE users SET login = pii_encrypt('deleted-' || id), full_name = pii_encrypt($1),
+            email = pii_encrypt('deleted-' || id || '@invalid'),
+            login_index = pii_index('deleted-' || id), email_index = pii_index('deleted-' || id || '@invalid'),
+            password_hash = '', email_verified = FALSE, pending_email = NULL,
+            totp_secret = NULL, totp_enabled = FALSE, active = FALSE,
+            deactivated_at = COALESCE(deactivated_at, $2), anonymized_at = $2
+        WHERE id = $3 AND anonymized_at IS NULL
+    `, DeletedName, now.UTC(), userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+
+	for _, table := range credentialTables {
+		if _, err := tx.Exec(`DELETE FROM `+table+` WHERE user_id = $1`, userID); err != nil {
+			return fmt.Errorf("%s: %v", table, err)
+		}
+	}
+	// попытки входа с этим логином, пока его еще не было в users
+	if _, err := tx.Exec(`DELETE FROM login_events WHERE login_index = $1`, loginIndex); err != nil {
+		return fmt.Errorf("login_events: %v", err)
+	}
+	keys := []interface{}{fmt.Sprintf("user:%d", userID), "index:" + loginIndex.String}
+	for _, table := range []string{"login_attempts", "login_lockouts"} {
+		if _, err := tx.Exec(`DELETE FROM `+table+` WHERE key IN ($1, $2)`, keys...); err != nil {
+			return fmt.Errorf("%s: %v", table, err)
+		}
+	}
+	// объединенная с этой учетная запись принадлежала тому же человеку
+	if _, err := tx.Exec(`
+        UPDATE audit_log SET details = json_remove(details, '$.source_login', '$.source_email',
+            '$.source_login_index', '$.source_email_index')
+        WHERE target_user_id = $1 AND action = 'user.merge'
+    `, userID); err != nil {
+		return fmt.Errorf("audit_log: %v", err)
+	}
+
+	// личное расписание повторения в статистику не входит
+	if _, err := tx.Exec(`DELETE FROM review_items WHERE student_id = $1`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`
+        UPDATE response_reviews SET feedback = '', comments = '[]'
+        WHERE response_id IN (SELECT id FROM question_responses WHERE student_id = $1)
+    `, userID); err != nil {
+		return err
+	}
+	_, err = tx.Exec(`UPDATE question_responses SET text = '' WHERE student_id = $1`, userID)
+	return err
+}
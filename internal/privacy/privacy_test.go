@@ -0,0 +1,95 @@
+package privacy
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"visualmath/internal/storage"
+)
+
+func addUser(t *testing.T, db *sql.DB, login string) int {
+	t.Helper()
+	var id int
+	err := db.QueryRow(`
+        INSERT INTO users (login, password_hash, full_name, user_type, email, login_index, email_index)
+        VALUES (pii_encrypt($1), '', pii_encrypt($1), 'student', pii_encrypt($2), pii_index($1), pii_index($2))
+        RETURNING id
+    `, login, login+"@uni.ru").Scan(&id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func count(t *testing.T, db *sql.DB, query string, args ...interface{}) int {
+	t.Helper()
+	var n int
+	if err := db.QueryRow(query, args...).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestAnonymizeClearsLoginTraces(t *testing.T) {
+	db := storage.Open(filepath.Join(t.TempDir(), "visualmath.db"), nil)
+	defer db.Close()
+	id := addUser(t, db, "ivanov")
+	other := addUser(t, db, "petrov")
+
+	for _, stmt := range []string{
+		// блокировка до регистрации, когда логина еще не было в users
+		`INSERT INTO login_events (user_id, login, login_index, ip, event) VALUES (NULL, '', 'plain:ivanov', '', 'lockout')`,
+		`INSERT INTO login_events (user_id, login, login_index, ip, event) VALUES (NULL, '', 'plain:petrov', '', 'lockout')`,
+		`INSERT INTO login_attempts (key, at) VALUES ('index:plain:ivanov', CURRENT_TIMESTAMP)`,
+		`INSERT INTO login_attempts (key, at) VALUES ('index:plain:petrov', CURRENT_TIMESTAMP)`,
+		`INSERT INTO login_lockouts (key, locked_until) VALUES ('user:1', CURRENT_TIMESTAMP)`,
+		`INSERT INTO audit_log (actor_id, action, target_user_id, details)
+         VALUES (3, 'user.merge', 1, '{"source_id": 9, "source_login_index": "plain:ivanov2", "source_email_index": "plain:i@uni.ru"}')`,
+		`INSERT INTO audit_log (actor_id, action, target_user_id, details)
+         VALUES (3, 'user.merge', 2, '{"source_id": 8, "source_login_index": "plain:petrov2"}')`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Anonymize(tx, id, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := count(t, db, `SELECT COUNT(*) FROM login_events WHERE login_index = 'plain:ivanov'`); n != 0 {
+		t.Errorf("%d login events by the old login left", n)
+	}
+	if n := count(t, db, `SELECT (SELECT COUNT(*) FROM login_attempts) + (SELECT COUNT(*) FROM login_lockouts)`); n != 1 {
+		t.Errorf("%d limiter rows left, want only the other user's", n)
+	}
+	var details string
+	db.QueryRow(`SELECT details FROM audit_log WHERE target_user_id = $1`, id).Scan(&details)
+	if details != `{"source_id":9}` {
+		t.Errorf("merge details %s", details)
+	}
+
+	// чужие записи не затронуты
+	if n := count(t, db, `SELECT COUNT(*) FROM login_events WHERE login_index = 'plain:petrov'`); n != 1 {
+		t.Errorf("other user's login events: %d", n)
+	}
+	db.QueryRow(`SELECT details FROM audit_log WHERE target_user_id = $1`, other).Scan(&details)
+	if details != `{"source_id": 8, "source_login_index": "plain:petrov2"}` {
+		t.Errorf("other user's merge details %s", details)
+	}
+
+	tx, _ = db.Begin()
+	defer tx.Rollback()
+	if err := Anonymize(tx, id, time.Now()); err != sql.ErrNoRows {
+		t.Errorf("second Anonymize: %v, want sql.ErrNoRows", err)
+	}
+}
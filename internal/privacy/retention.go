@@ -0,0 +1,163 @@
+package privacy
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// RetentionConfig - срок хранения неактивных учетных записей
+type RetentionConfig struct {
+	InactiveYears int           // 0 - учетные записи не обезличиваются по сроку
+	Interval      time.Duration // как часто проверять
+}
+
+// RetentionFromEnv читает RETENTION_INACTIVE_YEARS и RETENTION_CHECK_INTERVAL
+// (по умолчанию раз в сутки, "24h")
+func RetentionFromEnv() (RetentionConfig, error) {
+	c := RetentionConfig{Interval: 24 * time.Hour}
+	if v := os.Getenv("RETENTION_INACTIVE_YEARS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return c, fmt.Errorf("RETENTION_INACTIVE_YEARS: expected a non-negative number, got %q", v)
+		}
+		c.InactiveYears = n
+	}
+	if v := os.Getenv("RETENTION_CHECK_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return c, fmt.Errorf("RETENTION_CHECK_INTERVAL: expected a duration like 24h, got %q", v)
+		}
+		c.Interval = d
+	}
+	return c, nil
+}
+
+// Cutoff - учетные записи без активности с этого момента подлежат обезличиванию
+func (c RetentionConfig) Cutoff(now time.Time) time.Time {
+	return now.AddDate(-c.InactiveYears, 0, 0)
+}
+
+// InactiveUser - учетная запись с истекшим сроком хранения
+type InactiveUser struct {
+	ID           int       `json:"id"`
+	Login        string    `json:"login"`
+	FullName     string    `json:"full_name"`
+	UserType     string    `json:"user_type"`
+	LastActivity time.Time `json:"last_activity"`
+}
+
+// InactiveUsers - учетные записи без входа, сессий, токенов и попыток после cutoff.
+// Администраторы не обезличиваются автоматически.
+func InactiveUsers(db *sql.DB, cutoff time.Time) ([]InactiveUser, error) {
+	rows, err := db.Query(`
+        SELECT id, login, full_name, user_type, last_activity FROM (
+            SELECT u.id, pii_decrypt(u.login) AS login, pii_decrypt(u.full_name) AS full_name, u.user_type, MAX(
+                COALESCE(u.last_login_at, u.created_at),
+                COALESCE((SELECT MAX(s.last_seen_at) FROM sessions s WHERE s.user_id = u.id), u.created_at),
+                COALESCE((SELECT MAX(t.last_used_at) FROM api_tokens t WHERE t.user_id = u.id), u.created_at),
+                COALESCE((SELECT MAX(a.started_at) FROM test_attempts a WHERE a.student_id = u.id), u.created_at)
+            ) AS last_activity
+            FROM users u
+            WHERE u.anonymized_at IS NULL AND u.user_type <> 'admin'
+        ) WHERE last_activity < $1
+        ORDER BY id
+    `, cutoff.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []InactiveUser{}
+	for rows.Next() {
+		var u InactiveUser
+		var last string
+		if err := rows.Scan(&u.ID, &u.Login, &u.FullName, &u.UserType, &last); err != nil {
+			return nil, err
+		}
+		// MAX от нескольких столбцов SQLite возвращает строкой
+		u.LastActivity = parseTime(last)
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// PurgeInactive обезличивает учетные записи с истекшим сроком хранения.
+// Каждая - в своей транзакции, с записью в журнале действий (actor_id = 0).
+func PurgeInactive(db *sql.DB, c RetentionConfig, now time.Time) (int, error) {
+	if c.InactiveYears == 0 {
+		return 0, nil
+	}
+	users, err := InactiveUsers(db, c.Cutoff(now))
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, u := range users {
+		details, _ := json.Marshal(map[string]interface{}{
+			"last_activity":  u.LastActivity,
+			"inactive_years": c.InactiveYears,
+		})
+		err := inTx(db, func(tx *sql.Tx) error {
+			if err := Anonymize(tx, u.ID, now); err != nil {
+				return err
+			}
+			_, err := tx.Exec(`
+                INSERT INTO audit_log (actor_id, action, target_user_id, details) VALUES (0, 'user.retention_purge', $1, $2)
+            `, u.ID, string(details))
+			return err
+		})
+		if err != nil {
+			return purged, fmt.Errorf("user %d: %v", u.ID, err)
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+// RunRetention проверяет сроки хранения сразу и затем с интервалом c.Interval.
+// Запускается в отдельной горутине.
+func RunRetention(db *sql.DB, c RetentionConfig) {
+	if c.InactiveYears == 0 {
+		return
+	}
+	ticker := time.NewTicker(c.Interval)
+	defer ticker.Stop()
+	for {
+		n, err := PurgeInactive(db, c, time.Now())
+		if err != nil {
+			log.Printf("Срок хранения учетных записей: %v", err)
+		}
+		if n > 0 {
+			log.Printf("🗑 Обезличено неактивных учетных записей: %d (без активности больше %d лет)", n, c.InactiveYears)
+		}
+		<-ticker.C
+	}
+}
+
+func inTx(db *sql.DB, fn func(tx *sql.Tx) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// parseTime разбирает время в форматах CURRENT_TIMESTAMP и go-sqlite3
+func parseTime(s string) time.Time {
+	for _, layout := range []string{"2006-01-02 15:04:05.999999999-07:00", "2006-01-02 15:04:05", time.RFC3339Nano} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
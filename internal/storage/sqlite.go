@@ -2,12 +2,72 @@ package storage
 
 import (
     "database/sql"
-    _ "github.com/mattn/go-sqlite3"
+    "fmt"
+    "github.com/mattn/go-sqlite3"
     "log"
+    "strings"
+
+    "visualmath/internal/pii"
 )
 
-func InitSQLite() *sql.DB {
-    db, err := sql.Open("sqlite3", "./visualmath.db")
+// driverName - sqlite3 с функциями шифрования персональных данных:
+// pii_encrypt(x), pii_decrypt(x), pii_index(x) и pii_stale(x)
+const driverName = "sqlite3_pii"
+
+// keyring - ключи, которыми пользуются SQL-функции всех соединений
+var keyring *pii.Keyring
+
+func init() {
+    sql.Register(driverName, &sqlite3.SQLiteDriver{ConnectHook: registerPIIFunctions})
+}
+
+// registerPIIFunctions делает шифрование доступным в запросах, чтобы ФИО, логин
+// и почта расшифровывались прямо в SELECT и JOIN, а поиск шел по слепому индексу
+func registerPIIFunctions(conn *sqlite3.SQLiteConn) error {
+    functions := []struct {
+        name string
+        impl interface{}
+        pure bool
+    }{
+        {"pii_encrypt", textFunc(keyring.Encrypt), false}, // случайный nonce
+        {"pii_decrypt", textFunc(keyring.Decrypt), true},
+        {"pii_index", textFunc(func(s string) (string, error) { return keyring.Index(s), nil }), true},
+        {"pii_stale", func(v interface{}) bool { s, ok := v.(string); return ok && keyring.Stale(s) }, true},
+    }
+    for _, f := range functions {
+        if err := conn.RegisterFunc(f.name, f.impl, f.pure); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// textFunc оборачивает функцию над строкой для SQLite: NULL остается NULL
+func textFunc(fn func(string) (string, error)) func(interface{}) (interface{}, error) {
+    return func(v interface{}) (interface{}, error) {
+        switch v := v.(type) {
+        case string:
+            return fn(v)
+        case []byte:
+            if v == nil {
+                return nil, nil
+            }
+            return fn(string(v))
+        }
+        return nil, fmt.Errorf("pii: expected text, got %T", v)
+    }
+}
+
+// InitSQLite открывает базу ./visualmath.db и применяет миграции. keys - ключи шифрования
+// персональных данных, nil - данные пользователей хранятся открытым текстом.
+func InitSQLite(keys *pii.Keyring) *sql.DB {
+    return Open("./visualmath.db", keys)
+}
+
+// Open - то же для базы по пути path, например временной в тестах
+func Open(path string, keys *pii.Keyring) *sql.DB {
+    keyring = keys
+    db, err := sql.Open(driverName, path)
     if err != nil {
         log.Fatal(err)
     }
@@ -19,7 +79,7 @@ func InitSQLite() *sql.DB {
             login TEXT UNIQUE NOT NULL,
             password_hash TEXT NOT NULL,
             full_name TEXT NOT NULL,
-            user_type TEXT NOT NULL CHECK (user_type IN ('student', 'teacher', 'admin')),
+            user_type TEXT NOT NULL CHECK (user_type IN ('student', 'ta', 'teacher', 'admin')),
             group_number TEXT,
             email TEXT UNIQUE NOT NULL,
             email_verified BOOLEAN DEFAULT FALSE,
@@ -36,6 +96,423 @@ func InitSQLite() *sql.DB {
             ('Линейная алгебра и аналитическая геометрия'),
             ('Дискретная математика'),
             ('Экономика')`,
+
+        `CREATE TABLE IF NOT EXISTS modules (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            title TEXT NOT NULL,
+            course_id INTEGER REFERENCES courses(id),
+            course_name TEXT NOT NULL DEFAULT '',
+            author_id INTEGER REFERENCES users(id),
+            description TEXT NOT NULL DEFAULT '',
+            module_type TEXT NOT NULL CHECK (module_type IN ('text', 'visual', 'question', 'test')),
+            content TEXT NOT NULL DEFAULT '{}',
+            published BOOLEAN NOT NULL DEFAULT FALSE,
+            created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+        )`,
+
+        `CREATE TABLE IF NOT EXISTS lectures (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            title TEXT NOT NULL,
+            course_id INTEGER REFERENCES courses(id),
+            course_name TEXT NOT NULL DEFAULT '',
+            author_id INTEGER REFERENCES users(id),
+            description TEXT NOT NULL DEFAULT '',
+            published BOOLEAN NOT NULL DEFAULT FALSE,
+            allow_back BOOLEAN NOT NULL DEFAULT TRUE,
+            created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+        )`,
+
+        `CREATE TABLE IF NOT EXISTS lecture_modules (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            lecture_id INTEGER NOT NULL REFERENCES lectures(id) ON DELETE CASCADE,
+            module_id INTEGER NOT NULL REFERENCES modules(id) ON DELETE CASCADE,
+            position INTEGER NOT NULL
+        )`,
+
+        // Каждое прохождение модуля - отдельная запись, чтобы считать лучший и последний результат
+        `CREATE TABLE IF NOT EXISTS student_progress (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            student_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+            lecture_id INTEGER NOT NULL REFERENCES lectures(id) ON DELETE CASCADE,
+            module_id INTEGER NOT NULL REFERENCES modules(id) ON DELETE CASCADE,
+            completed BOOLEAN NOT NULL DEFAULT FALSE,
+            score REAL NOT NULL DEFAULT 0,
+            started_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+            completed_at DATETIME
+        )`,
+
+        `CREATE INDEX IF NOT EXISTS idx_lecture_modules_lecture ON lecture_modules(lecture_id, position)`,
+        `CREATE INDEX IF NOT EXISTS idx_progress_student ON student_progress(student_id, lecture_id)`,
+
+        `CREATE TABLE IF NOT EXISTS live_sessions (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            lecture_id INTEGER NOT NULL,
+            teacher_id INTEGER NOT NULL REFERENCES users(id),
+            join_code TEXT UNIQUE NOT NULL,
+            current_module_id INTEGER NOT NULL DEFAULT 0,
+            started_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+            ended_at DATETIME
+        )`,
+
+        `CREATE TABLE IF NOT EXISTS live_participants (
+            session_id INTEGER NOT NULL REFERENCES live_sessions(id) ON DELETE CASCADE,
+            user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+            joined_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+            left_at DATETIME,
+            reconnects INTEGER NOT NULL DEFAULT 0,
+            PRIMARY KEY (session_id, user_id)
+        )`,
+
+        `CREATE TABLE IF NOT EXISTS polls (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            session_id INTEGER NOT NULL REFERENCES live_sessions(id) ON DELETE CASCADE,
+            lecture_id INTEGER NOT NULL,
+            module_id INTEGER NOT NULL,
+            question_index INTEGER NOT NULL DEFAULT 0,
+            question TEXT NOT NULL,
+            opened_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+            closed_at DATETIME,
+            revealed BOOLEAN NOT NULL DEFAULT FALSE
+        )`,
+
+        `CREATE TABLE IF NOT EXISTS poll_answers (
+            poll_id INTEGER NOT NULL REFERENCES polls(id) ON DELETE CASCADE,
+            student_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+            answer INTEGER NOT NULL,
+            is_correct BOOLEAN NOT NULL,
+            answered_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+            PRIMARY KEY (poll_id, student_id)
+        )`,
+
+        `CREATE TABLE IF NOT EXISTS attendance (
+            session_id INTEGER NOT NULL REFERENCES live_sessions(id) ON DELETE CASCADE,
+            user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+            checked_in_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+            PRIMARY KEY (session_id, user_id)
+        )`,
+
+        // Попытка прохождения модуля question или test
+        `CREATE TABLE IF NOT EXISTS test_attempts (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            student_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+            lecture_id INTEGER NOT NULL DEFAULT 0,
+            module_id INTEGER NOT NULL REFERENCES modules(id) ON DELETE CASCADE,
+            score REAL NOT NULL DEFAULT 0,
+            max_score REAL NOT NULL DEFAULT 0,
+            started_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+            finished_at DATETIME
+        )`,
+
+        // Ответ на отдельный вопрос. Вопрос определяется модулем-вопросником
+        // и номером в нем, так что один вопрос можно анализировать во всех тестах
+        `CREATE TABLE IF NOT EXISTS question_responses (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            attempt_id INTEGER NOT NULL REFERENCES test_attempts(id) ON DELETE CASCADE,
+            student_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+            source_module_id INTEGER NOT NULL,
+            question_index INTEGER NOT NULL,
+            answer INTEGER NOT NULL DEFAULT -1, -- -1: вопрос пропущен
+            is_correct BOOLEAN NOT NULL DEFAULT FALSE,
+            answered_at DATETIME DEFAULT CURRENT_TIMESTAMP
+        )`,
+
+        `CREATE INDEX IF NOT EXISTS idx_attempts_module ON test_attempts(module_id, student_id)`,
+        `CREATE INDEX IF NOT EXISTS idx_responses_attempt ON question_responses(attempt_id)`,
+        `CREATE INDEX IF NOT EXISTS idx_responses_question ON question_responses(source_module_id, question_index)`,
+
+        // Параметры 2PL-модели вопросов для адаптивных тестов
+        `CREATE TABLE IF NOT EXISTS item_parameters (
+            source_module_id INTEGER NOT NULL,
+            question_index INTEGER NOT NULL,
+            discrimination REAL NOT NULL,
+            difficulty REAL NOT NULL,
+            responses INTEGER NOT NULL DEFAULT 0,
+            calibrated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+            PRIMARY KEY (source_module_id, question_index)
+        )`,
+
+        // Очередь повторения вопросов по алгоритму SM-2
+        `CREATE TABLE IF NOT EXISTS review_items (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            student_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+            source_module_id INTEGER NOT NULL,
+            question_index INTEGER NOT NULL,
+            easiness REAL NOT NULL DEFAULT 2.5,
+            interval_days INTEGER NOT NULL DEFAULT 0,
+            repetitions INTEGER NOT NULL DEFAULT 0,
+            lapses INTEGER NOT NULL DEFAULT 0,
+            due_at DATETIME NOT NULL,
+            last_reviewed_at DATETIME,
+            created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+            UNIQUE (student_id, source_module_id, question_index)
+        )`,
+        `CREATE INDEX IF NOT EXISTS idx_review_due ON review_items(student_id, due_at)`,
+
+        // Навыки и параметры BKT для каждого из них
+        `CREATE TABLE IF NOT EXISTS skills (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            name TEXT UNIQUE NOT NULL,
+            p_init REAL NOT NULL DEFAULT 0.2,
+            p_learn REAL NOT NULL DEFAULT 0.15,
+            p_slip REAL NOT NULL DEFAULT 0.1,
+            p_guess REAL NOT NULL DEFAULT 0.2,
+            created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+        )`,
+
+        // Навыки модуля. from_questions - навык указан только у отдельных вопросов модуля
+        `CREATE TABLE IF NOT EXISTS module_skills (
+            module_id INTEGER NOT NULL REFERENCES modules(id) ON DELETE CASCADE,
+            skill_id INTEGER NOT NULL REFERENCES skills(id) ON DELETE CASCADE,
+            from_questions BOOLEAN NOT NULL DEFAULT FALSE,
+            PRIMARY KEY (module_id, skill_id)
+        )`,
+
+        // Вероятность владения навыком по модели BKT
+        `CREATE TABLE IF NOT EXISTS skill_mastery (
+            student_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+            skill_id INTEGER NOT NULL REFERENCES skills(id) ON DELETE CASCADE,
+            p_known REAL NOT NULL,
+            attempts INTEGER NOT NULL DEFAULT 0,
+            correct INTEGER NOT NULL DEFAULT 0,
+            updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+            PRIMARY KEY (student_id, skill_id)
+        )`,
+        `CREATE INDEX IF NOT EXISTS idx_module_skills_skill ON module_skills(skill_id)`,
+
+        // Проверка развернутого ответа преподавателем. Оценка видна студенту после публикации
+        `CREATE TABLE IF NOT EXISTS response_reviews (
+            response_id INTEGER PRIMARY KEY REFERENCES question_responses(id) ON DELETE CASCADE,
+            points REAL NOT NULL DEFAULT 1,
+            status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'graded', 'released')),
+            score REAL,
+            max_score REAL NOT NULL DEFAULT 1,
+            criteria TEXT NOT NULL DEFAULT '[]',
+            comments TEXT NOT NULL DEFAULT '[]',
+            feedback TEXT NOT NULL DEFAULT '',
+            grader_id INTEGER REFERENCES users(id),
+            graded_at DATETIME,
+            released_at DATETIME
+        )`,
+        `CREATE INDEX IF NOT EXISTS idx_response_reviews_status ON response_reviews(status)`,
+
+        // Домашние задания: лекция или тест для групп со сроками и штрафом за опоздание
+        `CREATE TABLE IF NOT EXISTS assignments (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            title TEXT NOT NULL,
+            description TEXT NOT NULL DEFAULT '',
+            lecture_id INTEGER NOT NULL DEFAULT 0,
+            module_id INTEGER NOT NULL DEFAULT 0,
+            opens_at DATETIME NOT NULL,
+            due_at DATETIME NOT NULL,
+            closes_at DATETIME,
+            late_penalty REAL NOT NULL DEFAULT 0,
+            max_attempts INTEGER NOT NULL DEFAULT 0,
+            author_id INTEGER REFERENCES users(id),
+            created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+        )`,
+
+        `CREATE TABLE IF NOT EXISTS course_teachers (
+            course_id INTEGER NOT NULL REFERENCES courses(id) ON DELETE CASCADE,
+            teacher_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+            PRIMARY KEY (course_id, teacher_id)
+        )`,
+
+        `CREATE TABLE IF NOT EXISTS groups (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            name TEXT UNIQUE NOT NULL,
+            faculty TEXT NOT NULL DEFAULT '',
+            year INTEGER NOT NULL DEFAULT 0,
+            curator_id INTEGER REFERENCES users(id),
+            created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+        )`,
+
+        `CREATE TABLE IF NOT EXISTS assignment_groups (
+            assignment_id INTEGER NOT NULL REFERENCES assignments(id) ON DELETE CASCADE,
+            group_id INTEGER NOT NULL REFERENCES groups(id),
+            PRIMARY KEY (assignment_id, group_id)
+        )`,
+
+        `CREATE TABLE IF NOT EXISTS course_invites (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            course_id INTEGER NOT NULL REFERENCES courses(id) ON DELETE CASCADE,
+            code TEXT UNIQUE NOT NULL,
+            group_id INTEGER REFERENCES groups(id),
+            expires_at TIMESTAMP,
+            max_uses INTEGER DEFAULT 0,
+            uses INTEGER DEFAULT 0,
+            requires_approval BOOLEAN DEFAULT FALSE,
+            revoked BOOLEAN DEFAULT FALSE,
+            created_by INTEGER REFERENCES users(id),
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+        )`,
+
+        `CREATE TABLE IF NOT EXISTS enrollments (
+            course_id INTEGER NOT NULL REFERENCES courses(id) ON DELETE CASCADE,
+            student_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+            status TEXT NOT NULL DEFAULT 'active',
+            invite_id INTEGER REFERENCES course_invites(id),
+            approved_by INTEGER REFERENCES users(id),
+            enrolled_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            PRIMARY KEY (course_id, student_id)
+        )`,
+        `CREATE INDEX IF NOT EXISTS idx_enrollments_student ON enrollments(student_id, status)`,
+
+        // Одноразовые ссылки для установки пароля. Хранится только хэш токена.
+        `CREATE TABLE IF NOT EXISTS password_tokens (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+            token_hash TEXT UNIQUE NOT NULL,
+            purpose TEXT NOT NULL DEFAULT 'invite',
+            expires_at DATETIME NOT NULL,
+            used_at DATETIME,
+            created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+        )`,
+
+        // Журнал действий администраторов. target_user_id без внешнего ключа:
+        // запись остается и после слияния или удаления пользователя.
+        `CREATE TABLE IF NOT EXISTS audit_log (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            actor_id INTEGER NOT NULL,
+            action TEXT NOT NULL,
+            target_user_id INTEGER,
+            details TEXT NOT NULL DEFAULT '{}',
+            created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+        )`,
+        `CREATE INDEX IF NOT EXISTS idx_audit_target ON audit_log(target_user_id, created_at)`,
+
+        // Права ролей: module.edit, grade.write, progress.read, ... Администратору разрешено все.
+        `CREATE TABLE IF NOT EXISTS role_permissions (
+            role TEXT NOT NULL,
+            permission TEXT NOT NULL,
+            PRIMARY KEY (role, permission)
+        )`,
+        // Набор по умолчанию - только в пустую таблицу, чтобы не вернуть отозванные права
+        `INSERT INTO role_permissions (role, permission)
+         SELECT column1, column2 FROM (VALUES
+            ('teacher', 'module.edit'), ('teacher', 'course.manage'), ('teacher', 'assignment.manage'),
+            ('teacher', 'group.manage'), ('teacher', 'live.run'), ('teacher', 'grade.write'),
+            ('teacher', 'progress.read'),
+            ('ta', 'grade.write'), ('ta', 'progress.read'))
+         WHERE NOT EXISTS (SELECT 1 FROM role_permissions)`,
+
+        // Области действия прав пользователя: курс и/или группа, NULL - любые.
+        // Если у пользователя есть области, права его роли действуют только в них.
+        `CREATE TABLE IF NOT EXISTS permission_scopes (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+            course_id INTEGER REFERENCES courses(id) ON DELETE CASCADE,
+            group_id INTEGER REFERENCES groups(id) ON DELETE CASCADE,
+            created_by INTEGER REFERENCES users(id),
+            created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+            UNIQUE (user_id, course_id, group_id)
+        )`,
+
+        // Персональные токены API (vm_pat_...). Хранится только хэш, prefix - для списка.
+        // scopes - через пробел, например "modules:read modules:write".
+        `CREATE TABLE IF NOT EXISTS api_tokens (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+            name TEXT NOT NULL,
+            token_hash TEXT UNIQUE NOT NULL,
+            prefix TEXT NOT NULL,
+            scopes TEXT NOT NULL,
+            expires_at DATETIME,
+            last_used_at DATETIME,
+            revoked_at DATETIME,
+            created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+        )`,
+        `CREATE INDEX IF NOT EXISTS idx_api_tokens_user ON api_tokens(user_id)`,
+
+        // Одноразовые коды восстановления двухфакторной аутентификации (только хэши)
+        `CREATE TABLE IF NOT EXISTS recovery_codes (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+            code_hash TEXT NOT NULL,
+            used_at DATETIME,
+            created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+        )`,
+        `CREATE INDEX IF NOT EXISTS idx_recovery_codes_user ON recovery_codes(user_id)`,
+
+        // Роли, которым администратор сделал двухфакторную аутентификацию обязательной
+        `CREATE TABLE IF NOT EXISTS two_factor_roles (
+            role TEXT PRIMARY KEY,
+            created_by INTEGER REFERENCES users(id),
+            created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+        )`,
+
+        // Попытки входа и блокировки для LOGIN_LIMIT_STORE=sqlite. key - "ip:адрес",
+        // "user:id" или "index:слепой индекс логина" для несуществующих учетных записей.
+        `CREATE TABLE IF NOT EXISTS login_attempts (
+            key TEXT NOT NULL,
+            at DATETIME NOT NULL
+        )`,
+        `CREATE INDEX IF NOT EXISTS idx_login_attempts_key ON login_attempts(key, at)`,
+        `CREATE TABLE IF NOT EXISTS login_lockouts (
+            key TEXT PRIMARY KEY,
+            locked_until DATETIME NOT NULL
+        )`,
+
+        // Журнал блокировок входа, ведется при любом хранилище попыток
+        `CREATE TABLE IF NOT EXISTS login_events (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            user_id INTEGER,
+            login TEXT NOT NULL, -- пустой: открытые логины хранились до шифрования
+            ip TEXT NOT NULL,
+            event TEXT NOT NULL, -- lockout, unlock
+            locked_until DATETIME,
+            created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+        )`,
+        `CREATE INDEX IF NOT EXISTS idx_login_events_user ON login_events(user_id, created_at)`,
+
+        // Сессии браузера (cookie vm_session); хранится хэш токена и CSRF-токен
+        `CREATE TABLE IF NOT EXISTS sessions (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+            token_hash TEXT UNIQUE NOT NULL,
+            csrf_token TEXT NOT NULL,
+            user_agent TEXT NOT NULL DEFAULT '',
+            ip TEXT NOT NULL DEFAULT '',
+            last_seen_at DATETIME NOT NULL,
+            expires_at DATETIME NOT NULL,
+            revoked_at DATETIME,
+            created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+        )`,
+        `CREATE INDEX IF NOT EXISTS idx_sessions_user ON sessions(user_id)`,
+
+        // Связи с OAuth-провайдерами (см. schema.sql), заполняются входом через VK и Google
+        `CREATE TABLE IF NOT EXISTS oauth_connections (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+            provider TEXT NOT NULL, -- vk, google
+            provider_user_id TEXT NOT NULL,
+            email TEXT,
+            full_name TEXT,
+            avatar_url TEXT,
+            created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+            UNIQUE (provider, provider_user_id)
+        )`,
+        `CREATE INDEX IF NOT EXISTS idx_oauth_user ON oauth_connections(user_id)`,
+
+        // Аватары пользователей, не больше 1 МБ
+        `CREATE TABLE IF NOT EXISTS user_avatars (
+            user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+            content_type TEXT NOT NULL,
+            data BLOB NOT NULL,
+            updated_at DATETIME NOT NULL
+        )`,
+
+        // Запросы на удаление персональных данных; выполняются после одобрения администратором
+        `CREATE TABLE IF NOT EXISTS deletion_requests (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+            reason TEXT NOT NULL DEFAULT '',
+            status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'approved', 'rejected', 'cancelled')),
+            decided_by INTEGER,
+            decided_at DATETIME,
+            comment TEXT NOT NULL DEFAULT '',
+            created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+        )`,
+        `CREATE INDEX IF NOT EXISTS idx_deletion_requests_status ON deletion_requests(status, created_at)`,
     }
     
     for _, query := range queries {
@@ -44,6 +521,336 @@ func InitSQLite() *sql.DB {
             log.Printf("Warning: %v", err)
         }
     }
+
+    // Столбцы, добавленные в уже существующие таблицы
+    columns := []struct{ table, column, definition string }{
+        {"test_attempts", "mode", "TEXT NOT NULL DEFAULT 'fixed'"}, // fixed, adaptive
+        {"test_attempts", "ability", "REAL"},
+        {"test_attempts", "ability_se", "REAL"},
+        {"test_attempts", "current_source_module_id", "INTEGER NOT NULL DEFAULT 0"},
+        {"test_attempts", "current_question_index", "INTEGER NOT NULL DEFAULT -1"},
+        {"question_responses", "confidence", "INTEGER NOT NULL DEFAULT 0"}, // 1-5, 0 - не указана
+        {"question_responses", "text", "TEXT NOT NULL DEFAULT ''"},
+        {"test_attempts", "status", "TEXT NOT NULL DEFAULT 'graded'"}, // graded, pending_review
+        {"test_attempts", "manual_score", "REAL NOT NULL DEFAULT 0"},  // баллы за развернутые ответы
+        {"student_progress", "status", "TEXT NOT NULL DEFAULT 'completed'"}, // completed, pending_review
+        {"student_progress", "attempt_id", "INTEGER NOT NULL DEFAULT 0"},
+        {"test_attempts", "assignment_id", "INTEGER NOT NULL DEFAULT 0"},
+        {"test_attempts", "late_days", "INTEGER NOT NULL DEFAULT 0"},
+        {"test_attempts", "penalty", "REAL NOT NULL DEFAULT 0"}, // доля балла, снятая за опоздание
+        {"student_progress", "assignment_id", "INTEGER NOT NULL DEFAULT 0"},
+        {"student_progress", "late_days", "INTEGER NOT NULL DEFAULT 0"},
+        {"student_progress", "penalty", "REAL NOT NULL DEFAULT 0"},
+        {"users", "group_id", "INTEGER REFERENCES groups(id)"},
+        {"courses", "description", "TEXT NOT NULL DEFAULT ''"},
+        {"lectures", "course_position", "INTEGER NOT NULL DEFAULT 0"}, // порядок лекции в курсе
+        {"courses", "term", "TEXT NOT NULL DEFAULT ''"}, // семестр, например "Осень 2026"
+        {"courses", "archived", "BOOLEAN NOT NULL DEFAULT FALSE"},
+        {"courses", "archived_at", "DATETIME"},
+        {"courses", "previous_course_id", "INTEGER NOT NULL DEFAULT 0"}, // курс прошлого семестра
+        {"assignments", "archived", "BOOLEAN NOT NULL DEFAULT FALSE"},
+        {"users", "active", "BOOLEAN NOT NULL DEFAULT TRUE"},
+        {"users", "deactivated_at", "DATETIME"},
+        {"users", "auth_source", "TEXT NOT NULL DEFAULT 'local'"}, // local, ldap
+        {"users", "totp_secret", "TEXT"}, // задается при подключении, действует после подтверждения кодом
+        {"users", "totp_enabled", "BOOLEAN NOT NULL DEFAULT FALSE"},
+        {"users", "totp_last_step", "INTEGER NOT NULL DEFAULT 0"}, // последний принятый шаг, повтор кода отклоняется
+        {"users", "pending_email", "TEXT"}, // новый адрес до подтверждения по ссылке из письма
+        {"users", "last_login_at", "DATETIME"},
+        {"users", "anonymized_at", "DATETIME"}, // персональные данные удалены, статистика сохранена
+        {"users", "login_index", "TEXT"}, // слепые индексы для поиска по зашифрованным логину и почте
+        {"users", "email_index", "TEXT"},
+        {"login_events", "login_index", "TEXT"}, // слепой индекс логина, которого нет в users
+    }
+    for _, c := range columns {
+        if err := ensureColumn(db, c.table, c.column, c.definition); err != nil {
+            log.Printf("Warning: %v", err)
+        }
+    }
+
+    if err := migrateGroups(db); err != nil {
+        log.Printf("Warning: %v", err)
+    }
+    if err := migrateCourses(db); err != nil {
+        log.Printf("Warning: %v", err)
+    }
+    if err := migrateUserTypes(db); err != nil {
+        log.Printf("Warning: %v", err)
+    }
+    if _, err := db.Exec(`CREATE INDEX IF NOT EXISTS idx_users_group ON users(group_id)`); err != nil {
+        log.Printf("Warning: %v", err)
+    }
+    // Без ключей зашифрованные данные не прочитать - работать дальше нельзя
+    n, err := migrateUserEncryption(db)
+    if err != nil {
+        log.Fatalf("Шифрование персональных данных: %v", err)
+    }
+    if n > 0 && keys.Enabled() {
+        log.Printf("🔐 Перешифровано учетных записей: %d (ключ %q)", n, keys.CurrentKey())
+        // прежние значения остаются в освободившихся страницах файла, пока его не перезаписать
+        if _, err := db.Exec(`VACUUM`); err != nil {
+            log.Printf("Warning: %v", err)
+        }
+    } else if n > 0 {
+        log.Printf("Пересчитаны индексы логина и почты учетных записей: %d", n)
+    }
+    // Уникальность логина и почты теперь держится на индексах: шифротексты всегда разные
+    if err := uniqueUserIndexes(db); err != nil {
+        log.Fatalf("Уникальность логина и почты: %v", err)
+    }
+    if err := migrateLoginLog(db); err != nil {
+        log.Fatalf("Шифрование персональных данных: %v", err)
+    }
     
     return db
+}
+
+// migrateGroups создает группы из текстовых номеров users.group_number и
+// переводит на них студентов и адресатов заданий. Перенесенный номер очищается,
+// чтобы исключенный из группы студент не вернулся в нее при следующем запуске.
+func migrateGroups(db *sql.DB) error {
+    tx, err := db.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    statements := []string{
+        `INSERT OR IGNORE INTO groups (name)
+         SELECT DISTINCT TRIM(group_number) FROM users
+         WHERE group_id IS NULL AND TRIM(COALESCE(group_number, '')) != ''`,
+        `UPDATE users SET group_id = (SELECT id FROM groups WHERE name = TRIM(users.group_number))
+         WHERE group_id IS NULL AND TRIM(COALESCE(group_number, '')) != ''`,
+        `UPDATE users SET group_number = NULL WHERE group_id IS NOT NULL AND group_number IS NOT NULL`,
+    }
+
+    // Задания, созданные до появления групп, ссылались на номер группы
+    legacy, err := hasColumn(tx, "assignment_groups", "group_number")
+    if err != nil {
+        return err
+    }
+    if legacy {
+        statements = append(statements,
+            `INSERT OR IGNORE INTO groups (name)
+             SELECT DISTINCT TRIM(group_number) FROM assignment_groups WHERE TRIM(group_number) != ''`,
+            `ALTER TABLE assignment_groups RENAME TO assignment_groups_legacy`,
+            `CREATE TABLE assignment_groups (
+                assignment_id INTEGER NOT NULL REFERENCES assignments(id) ON DELETE CASCADE,
+                group_id INTEGER NOT NULL REFERENCES groups(id),
+                PRIMARY KEY (assignment_id, group_id)
+            )`,
+            `INSERT OR IGNORE INTO assignment_groups (assignment_id, group_id)
+             SELECT l.assignment_id, g.id FROM assignment_groups_legacy l
+             JOIN groups g ON g.name = TRIM(l.group_number)`,
+            `DROP TABLE assignment_groups_legacy`,
+        )
+    }
+
+    for _, stmt := range statements {
+        if _, err := tx.Exec(stmt); err != nil {
+            return err
+        }
+    }
+    return tx.Commit()
+}
+
+// migrateCourses связывает модули и лекции с курсами по названию из course_name.
+// Недостающие курсы создаются, лекции получают порядок в курсе по дате создания.
+func migrateCourses(db *sql.DB) error {
+    tx, err := db.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    for _, table := range []string{"modules", "lectures"} {
+        statements := []string{
+            `INSERT OR IGNORE INTO courses (name)
+             SELECT DISTINCT TRIM(course_name) FROM ` + table + `
+             WHERE course_id IS NULL AND TRIM(course_name) != ''`,
+            `UPDATE ` + table + ` SET course_id = (SELECT id FROM courses WHERE name = TRIM(` + table + `.course_name))
+             WHERE course_id IS NULL AND TRIM(course_name) != ''`,
+        }
+        for _, stmt := range statements {
+            if _, err := tx.Exec(stmt); err != nil {
+                return err
+            }
+        }
+    }
+
+    _, err = tx.Exec(`
+        UPDATE lectures SET course_position = (
+            SELECT COUNT(*) FROM lectures p
+            WHERE p.course_id = lectures.course_id AND (p.created_at < lectures.created_at
+                OR (p.created_at = lectures.created_at AND p.id <= lectures.id)))
+        WHERE course_id IS NOT NULL AND course_position = 0`)
+    if err != nil {
+        return err
+    }
+    return tx.Commit()
+}
+
+// migrateUserTypes пересоздает таблицу users, если ограничение user_type в старой
+// базе еще не знает роль ассистента 'ta'. SQLite не умеет менять CHECK, поэтому
+// данные копируются в новую таблицу, которая затем занимает место старой. Старую
+// таблицу нельзя переименовывать: внешние ключи других таблиц переехали бы за ней.
+func migrateUserTypes(db *sql.DB) error {
+    tx, err := db.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    var ddl string
+    if err := tx.QueryRow(`SELECT sql FROM sqlite_master WHERE type = 'table' AND name = 'users'`).Scan(&ddl); err != nil {
+        return err
+    }
+    const oldCheck = "IN ('student', 'teacher', 'admin')"
+    if !strings.Contains(ddl, oldCheck) {
+        return nil
+    }
+    ddl = strings.Replace(ddl, oldCheck, "IN ('student', 'ta', 'teacher', 'admin')", 1)
+    ddl = strings.Replace(ddl, "CREATE TABLE users", "CREATE TABLE users_new", 1)
+
+    statements := []string{
+        ddl,
+        `INSERT INTO users_new SELECT * FROM users`,
+        `DROP TABLE users`,
+        `ALTER TABLE users_new RENAME TO users`,
+    }
+    for _, stmt := range statements {
+        if _, err := tx.Exec(stmt); err != nil {
+            return err
+        }
+    }
+    return tx.Commit()
+}
+
+// migrateUserEncryption шифрует текущим ключом ФИО, логины и почту, записанные
+// открытым текстом или прежним ключом, и пересчитывает слепые индексы после
+// смены ключа индекса. Если ключи не заданы, а в базе есть зашифрованные
+// значения, возвращает ошибку. Возвращает число измененных учетных записей.
+func migrateUserEncryption(db *sql.DB) (int64, error) {
+    tx, err := db.Begin()
+    if err != nil {
+        return 0, err
+    }
+    defer tx.Rollback()
+
+    res, err := tx.Exec(`
+        UPDATE users SET
+            login = pii_encrypt(pii_decrypt(login)),
+            full_name = pii_encrypt(pii_decrypt(full_name)),
+            email = pii_encrypt(pii_decrypt(email)),
+            pending_email = pii_encrypt(pii_decrypt(pending_email)),
+            login_index = pii_index(pii_decrypt(login)),
+            email_index = pii_index(pii_decrypt(email))
+        WHERE pii_stale(login) OR pii_stale(full_name) OR pii_stale(email) OR pii_stale(pending_email)
+            OR login_index IS NOT pii_index(pii_decrypt(login))
+            OR email_index IS NOT pii_index(pii_decrypt(email))
+    `)
+    if err != nil {
+        return 0, err
+    }
+    n, _ := res.RowsAffected()
+    return n, tx.Commit()
+}
+
+// migrateLoginLog убирает логины и почту, записанные открытым текстом вне users:
+// в журнал блокировок, ключи ограничения попыток и журнал объединения учетных
+// записей. Вместо них остаются слепые индексы; счетчики попыток по логинам
+// просто сбрасываются.
+func migrateLoginLog(db *sql.DB) error {
+    tx, err := db.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    statements := []string{
+        `UPDATE login_events SET login_index = pii_index(login) WHERE login != '' AND user_id IS NULL`,
+        `UPDATE login_events SET login = '' WHERE login != ''`,
+        `DELETE FROM login_attempts WHERE key LIKE 'login:%'`,
+        `DELETE FROM login_lockouts WHERE key LIKE 'login:%'`,
+        `UPDATE audit_log SET details = json_set(json_remove(details, '$.source_login', '$.source_email'),
+             '$.source_login_index', pii_index(json_extract(details, '$.source_login')),
+             '$.source_email_index', pii_index(json_extract(details, '$.source_email')))
+         WHERE action = 'user.merge' AND json_extract(details, '$.source_login') IS NOT NULL`,
+    }
+    for _, stmt := range statements {
+        if _, err := tx.Exec(stmt); err != nil {
+            return err
+        }
+    }
+    return tx.Commit()
+}
+
+// uniqueUserIndexes создает уникальные индексы по login_index и email_index.
+// Если индекс не создается из-за совпадающих логинов или адресов, которые
+// раньше различались только регистром или пробелами, ошибка перечисляет
+// номера таких учетных записей: без индекса дубликаты появлялись бы снова.
+func uniqueUserIndexes(db *sql.DB) error {
+    for _, column := range []string{"login_index", "email_index"} {
+        _, err := db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_users_` + column + ` ON users(` + column + `)`)
+        if err == nil {
+            continue
+        }
+        rows, qerr := db.Query(`
+            SELECT GROUP_CONCAT(id, ', ') FROM users
+            WHERE ` + column + ` IS NOT NULL
+            GROUP BY ` + column + ` HAVING COUNT(*) > 1
+        `)
+        if qerr != nil {
+            return err
+        }
+        defer rows.Close()
+        var groups []string
+        for rows.Next() {
+            var ids string
+            if err := rows.Scan(&ids); err != nil {
+                return err
+            }
+            groups = append(groups, "["+ids+"]")
+        }
+        if err := rows.Err(); err != nil {
+            return err
+        }
+        if len(groups) == 0 {
+            return err
+        }
+        return fmt.Errorf("одинаковый %s у учетных записей (id) %s: исправьте его у лишних записей или удалите их и перезапустите сервер",
+            strings.TrimSuffix(column, "_index"), strings.Join(groups, ", "))
+    }
+    return nil
+}
+
+func hasColumn(tx *sql.Tx, table, column string) (bool, error) {
+    var n int
+    err := tx.QueryRow(`SELECT COUNT(*) FROM pragma_table_info($1) WHERE name = $2`, table, column).Scan(&n)
+    return n > 0, err
+}
+
+// ensureColumn добавляет столбец в таблицу, если его там еще нет
+func ensureColumn(db *sql.DB, table, column, definition string) error {
+    rows, err := db.Query(`SELECT name FROM pragma_table_info($1)`, table)
+    if err != nil {
+        return err
+    }
+    defer rows.Close()
+
+    for rows.Next() {
+        var name string
+        if err := rows.Scan(&name); err != nil {
+            return err
+        }
+        if name == column {
+            return nil
+        }
+    }
+    if err := rows.Err(); err != nil {
+        return err
+    }
+
+    _, err = db.Exec(`ALTER TABLE ` + table + ` ADD COLUMN ` + column + ` ` + definition)
+    return err
 }
\ No newline at end of file
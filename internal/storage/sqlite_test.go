@@ -0,0 +1,47 @@
+package storage
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUniqueUserIndexesReportsDuplicates(t *testing.T) {
+	db := Open(filepath.Join(t.TempDir(), "visualmath.db"), nil)
+	defer db.Close()
+
+	// база, где индекс еще не создан, а логины различались только регистром
+	if _, err := db.Exec(`DROP INDEX idx_users_login_index`); err != nil {
+		t.Fatal(err)
+	}
+	for i, login := range []string{"ivanov", "Ivanov", "petrov", "PETROV ", "sidorov"} {
+		email := fmt.Sprintf("student%d@uni.ru", i)
+		if _, err := db.Exec(`
+            INSERT INTO users (login, password_hash, full_name, user_type, email, login_index, email_index)
+            VALUES ($1, '', $1, 'student', $2, pii_index($1), pii_index($2))
+        `, login, email); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := uniqueUserIndexes(db)
+	if err == nil {
+		t.Fatal("duplicate logins accepted")
+	}
+	for _, want := range []string{"login", "[1, 2]", "[3, 4]"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %q", err, want)
+		}
+	}
+	if strings.Contains(err.Error(), "5") {
+		t.Errorf("error %q lists a unique account", err)
+	}
+
+	if _, err := db.Exec(`DELETE FROM users WHERE id IN (2, 4)`); err != nil {
+		t.Fatal(err)
+	}
+	if err := uniqueUserIndexes(db); err != nil {
+		t.Fatalf("after removing duplicates: %v", err)
+	}
+}
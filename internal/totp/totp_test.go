@@ -0,0 +1,86 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// ключ из приложения B RFC 6238 для SHA-1: ASCII "12345678901234567890"
+var rfcSecret = encoding.EncodeToString([]byte("12345678901234567890"))
+
+// Векторы RFC 6238 даны для 8 цифр; шестизначный код - их последние 6 цифр
+func TestCodeRFC6238(t *testing.T) {
+	cases := []struct {
+		unix int64
+		code string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+	for _, tc := range cases {
+		got, err := Code(rfcSecret, Step(time.Unix(tc.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := tc.code[2:]; got != want {
+			t.Errorf("T=%d: code %s, want %s", tc.unix, got, want)
+		}
+	}
+}
+
+func TestCodeSecretFormat(t *testing.T) {
+	want, _ := Code(rfcSecret, 1)
+	// приложения показывают ключ строчными буквами и с дополнением
+	for _, secret := range []string{strings.ToLower(rfcSecret), rfcSecret + "===="} {
+		if got, err := Code(secret, 1); err != nil || got != want {
+			t.Errorf("Code(%q) = %q, %v; want %q", secret, got, err, want)
+		}
+	}
+	if _, err := Code("not base32!", 1); err == nil {
+		t.Error("bad secret accepted")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step := Step(now)
+	code := func(step int64) string {
+		c, err := Code(rfcSecret, step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	cases := []struct {
+		name  string
+		code  string
+		after int64
+		want  int64 // 0 - код отклонен
+	}{
+		{"current step", code(step), 0, step},
+		{"with spaces", " " + code(step)[:3] + " " + code(step)[3:] + " ", 0, step},
+		{"previous step", code(step - 1), 0, step - 1},
+		{"next step", code(step + 1), 0, step + 1},
+		{"outside skew", code(step - 2), 0, 0},
+		{"outside skew ahead", code(step + 2), 0, 0},
+		{"wrong code", "000000", 0, 0},
+		{"too short", code(step)[:5], 0, 0},
+		// повтор: шаг уже принят
+		{"replay", code(step), step, 0},
+		{"older than accepted", code(step - 1), step, 0},
+		// после кода предыдущего шага текущий еще действует
+		{"after previous", code(step), step - 1, step},
+	}
+	for _, tc := range cases {
+		got, ok := Validate(rfcSecret, tc.code, now, tc.after)
+		if ok != (tc.want != 0) || got != tc.want {
+			t.Errorf("%s: Validate = %d, %v; want %d", tc.name, got, ok, tc.want)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+// CSRF-токен для страниц с входом через cookie сессии.
+// Сервер принимает изменяющие запросы (POST, PUT, DELETE...) только с заголовком
+// X-CSRF-Token, равным токену сессии; токен лежит в доступной скриптам cookie vm_csrf.
+
+(function () {
+    const originalFetch = window.fetch;
+
+    function csrfToken() {
+        const cookie = document.cookie.split('; ').find(c => c.startsWith('vm_csrf='));
+        return cookie ? decodeURIComponent(cookie.slice('vm_csrf='.length)) : '';
+    }
+
+    window.fetch = function (input, init) {
+        init = init || {};
+        const request = input instanceof Request ? input : null;
+        const method = (init.method || (request ? request.method : 'GET')).toUpperCase();
+        const url = new URL(request ? request.url : input, window.location.href);
+        const token = csrfToken();
+
+        // токен отправляется только своему сайту
+        if (token && url.origin === window.location.origin &&
+            !['GET', 'HEAD', 'OPTIONS'].includes(method)) {
+            const headers = new Headers(init.headers || (request ? request.headers : undefined));
+            headers.set('X-CSRF-Token', token);
+            init = Object.assign({}, init, { headers: headers });
+        }
+        return originalFetch.call(this, input, init);
+    };
+})();
@@ -2,17 +2,12 @@
 
 class Dashboard {
     constructor() {
-        this.token = localStorage.getItem('token');
         this.user = JSON.parse(localStorage.getItem('user') || '{}');
         this.init();
     }
     
     init() {
-        if (!this.token) {
-            this.redirectToLogin();
-            return;
-        }
-        
+        // Вход проверяет сервер: без сессии /api/user/profile вернет ошибку
         this.loadUserData();
         this.setupEventListeners();
         this.showSectionsByRole();
@@ -20,11 +15,7 @@ class Dashboard {
     
     async loadUserData() {
         try {
-            const response = await fetch('/api/user/profile', {
-                headers: {
-                    'Authorization': `Bearer ${this.token}`
-                }
-            });
+            const response = await fetch('/api/user/profile');
             
             if (response.ok) {
                 const userData = await response.json();
@@ -255,14 +246,14 @@ class Dashboard {
             title: formData.get('title'),
             course_id: parseInt(formData.get('course_id')),
             module_type: formData.get('module_type'),
-            content: this.getModuleContent(formData)
+            content: this.getModuleContent(formData),
+            published: formData.has('published')
         };
         
         try {
             const response = await fetch('/api/modules', {
                 method: 'POST',
                 headers: {
-                    'Authorization': `Bearer ${this.token}`,
                     'Content-Type': 'application/json'
                 },
                 body: JSON.stringify(moduleData)
@@ -312,10 +303,14 @@ class Dashboard {
         }
     }
     
-    logout() {
-        localStorage.removeItem('token');
-        localStorage.removeItem('user');
-        this.redirectToLogin();
+    async logout() {
+        try {
+            await fetch('/api/logout', { method: 'POST' });
+        } finally {
+            localStorage.removeItem('token');
+            localStorage.removeItem('user');
+            this.redirectToLogin();
+        }
     }
     
     redirectToLogin() {
@@ -327,11 +322,7 @@ class Dashboard {
         const sortBy = document.getElementById('lectureSort').value;
         
         try {
-            const response = await fetch(`/api/lectures?search=${encodeURIComponent(searchText)}&sort=${sortBy}`, {
-                headers: {
-                    'Authorization': `Bearer ${this.token}`
-                }
-            });
+            const response = await fetch(`/api/lectures?search=${encodeURIComponent(searchText)}&sort=${sortBy}`);
             
             if (response.ok) {
                 const lectures = await response.json();